@@ -0,0 +1,58 @@
+package photocp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// comparisonJSON is the machine-readable form of a pkg.ComparisonResult written by PrintComparison.
+type comparisonJSON struct {
+	FileA         string `json:"fileA"`
+	FileB         string `json:"fileB"`
+	AreDuplicates bool   `json:"areDuplicates"`
+	Reason        string `json:"reason"`
+	HashType      string `json:"hashType"`
+	HashA         string `json:"hashA"`
+	HashB         string `json:"hashB"`
+}
+
+// PrintComparison compares the files at pathA and pathB with pkg.AreFilesPotentiallyDuplicate, the
+// comparison a run uses for a source and its target, and writes the result to w, as JSON if asJSON is set.
+// Both files must exist; a run would report a missing second file as target_not_found instead.
+func PrintComparison(w io.Writer, pathA, pathB string, asJSON bool) error {
+	for _, path := range []string{pathA, pathB} {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("cannot compare %s: %w", path, err)
+		}
+	}
+	result, err := pkg.AreFilesPotentiallyDuplicate(pathA, pathB)
+	if err != nil {
+		return fmt.Errorf("failed to compare %s and %s: %w", pathA, pathB, err)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(comparisonJSON{
+			FileA:         pathA,
+			FileB:         pathB,
+			AreDuplicates: result.AreDuplicates,
+			Reason:        result.Reason.String(),
+			HashType:      result.HashType,
+			HashA:         result.Hash1,
+			HashB:         result.Hash2,
+		})
+	}
+
+	verdict := "not duplicates"
+	if result.AreDuplicates {
+		verdict = "duplicates"
+	}
+	_, err = fmt.Fprintf(w, "%s\n%s\n  Result:    %s\n  Reason:    %s\n  Hash type: %s\n  Hash A:    %s\n  Hash B:    %s\n",
+		pathA, pathB, verdict, result.Reason, result.HashType, result.Hash1, result.Hash2)
+	return err
+}
@@ -0,0 +1,24 @@
+package photocp
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// PrintFormats writes the image extensions photocp sorts to w, each with whether a decoder for it is
+// compiled in, followed by the recognized video extensions.
+func PrintFormats(w io.Writer) {
+	fmt.Fprintln(w, "Image formats:")
+	for _, ext := range pkg.ImageExtensions() {
+		handling := "no decoder: compared by file hash, dated by EXIF or modification time"
+		if pkg.HasImageDecoder(ext) {
+			handling = "decoded: resolution and pixel hash"
+		}
+		fmt.Fprintf(w, "  %-6s %s\n", ext, handling)
+	}
+	fmt.Fprintln(w, "\nVideo formats (recognized by pkg.ScanMediaDirectory, not sorted by photocp):")
+	fmt.Fprintf(w, "  %s\n", strings.Join(pkg.VideoExtensions(), " "))
+}
@@ -1,31 +1,48 @@
 package photocp
 
 import (
+	"archive/zip"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
+	"iter"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
-	"time" // time.Time is used for photoDate variable type and other time operations
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	_ "github.com/vegidio/heif-go" // Register HEIF/HEVC decoder
 	_ "image/gif"                  // Register GIF decoder
 	_ "image/jpeg"                 // Register JPEG decoder
 	_ "image/png"                  // Register PNG decoder
 
+	_ "golang.org/x/image/webp" // Register WebP decoder
+
 	"github.com/user/photo-sorter/pkg"
 )
 
-// scanSourceDirectory scans the source directory for image files.
-func scanSourceDirectory(sourceDir string, verbose bool) ([]string, error) {
-	// This message should always print, using fmt for cleaner output.
-	fmt.Printf("Scanning source directory: %s\n", sourceDir)
-	imageFiles, scanErr := pkg.ScanSourceDirectory(sourceDir)
+// Options holds the settings that control a photo sorting run.
+type Options = pkg.Options
+
+// Option sets fields of an Options, as passed to pkg.NewOptions.
+type Option = pkg.Option
+
+// scanSourceDirectory scans the source directory for image files, descending at most maxDepth levels (0 = unlimited).
+// A cancelled ctx stops the scan and its error is returned.
+func scanSourceDirectory(ctx context.Context, sourceDir string, maxDepth int, sniffExtensionless bool, logger pkg.Logger) ([]string, error) {
+	logger.Info("Scanning source directory", "dir", sourceDir)
+	imageFiles, scanErr := pkg.ScanSourceDirectoryContext(ctx, sourceDir, maxDepth, sniffExtensionless)
 	if scanErr != nil {
-		// This warning is conditional on verbose.
-		if verbose {
-			log.Printf("Warning during scanning source directory '%s': %v. Attempting to continue with any found files.\n", sourceDir, scanErr)
+		if ctx.Err() != nil {
+			return nil, scanErr
 		}
+		logger.Warn("Error while scanning source directory, continuing with any found files", "dir", sourceDir, "error", scanErr)
 		if imageFiles == nil { // If the error was critical and no files could be read
 			// This is a critical error, always show.
 			return nil, fmt.Errorf("critical error: No files could be read from source directory '%s'", sourceDir)
@@ -34,286 +51,316 @@ func scanSourceDirectory(sourceDir string, verbose bool) ([]string, error) {
 	return imageFiles, nil
 }
 
-// ensureTargetDirectory ensures the target base directory exists, creating it if necessary.
-func ensureTargetDirectory(targetBaseDir string, verbose bool) error {
-	if _, err := os.Stat(targetBaseDir); os.IsNotExist(err) {
-		fmt.Printf("Target directory %s does not exist, attempting to create it.\n", targetBaseDir)
-		if errMkdir := os.MkdirAll(targetBaseDir, 0755); errMkdir != nil {
-			// This is a critical error, always show.
-			return fmt.Errorf("failed to create target base directory '%s': %w", targetBaseDir, errMkdir)
-		}
-	} else if err != nil {
-		// This is a critical error, always show.
-		return fmt.Errorf("error accessing target base directory '%s': %w", targetBaseDir, err)
-	}
-	return nil
-}
-
-// determinePhotoDateAndDateSource tries to get the date from EXIF, falling back to file modification time.
-func determinePhotoDateAndDateSource(currentSourceFilepath string, verbose bool) (photoDate time.Time, dateSource string, err error) {
-	exifDate, dateErr := pkg.GetPhotoCreationDate(currentSourceFilepath)
-	if dateErr == nil {
-		photoDate = exifDate
-		dateSource = "EXIF"
-	} else {
-		fileInfoStat, statErr := os.Stat(currentSourceFilepath)
-		if statErr != nil {
-			if verbose {
-				log.Printf("  - Error getting file info for %s: %v. Skipping this file.\n", currentSourceFilepath, statErr)
-			}
-			return time.Time{}, "", fmt.Errorf("error getting file info: %w", statErr)
-		}
-		photoDate = fileInfoStat.ModTime()
-		dateSource = "FileModTime"
-	}
-	if verbose {
-		log.Printf("  - Determined date (%s) for %s: %s\n", dateSource, currentSourceFilepath, photoDate.Format("2006-01-02 15:04:05"))
-	}
-	return photoDate, dateSource, nil
+// zipSource makes the image entries of a ZIP archive available for sorting. Each entry is extracted
+// to a staging directory just before it is sorted and removed afterwards, so the archive is never
+// unpacked as a whole. Entries are identified by their display path: the archive path joined with
+// the entry name, which is what the report shows.
+type zipSource struct {
+	archive  *zip.ReadCloser
+	entries  map[string]*zip.File // Image entries by display path
+	stageDir string
 }
 
-// determineTargetPath creates the target directory path and filename.
-func determineTargetPath(targetBaseDir string, photoDate time.Time, sourceFilePath string, verbose bool) (exactTargetPath string, targetMonthDir string, err error) {
-	targetMonthDir, err = pkg.CreateTargetDirectory(targetBaseDir, photoDate)
+// openZipSource opens the archive at zipPath and returns it with the display paths of its image entries.
+func openZipSource(zipPath string, logger pkg.Logger) (*zipSource, []string, error) {
+	logger.Info("Scanning source archive", "archive", zipPath)
+	names, err := pkg.ScanZipSource(zipPath)
 	if err != nil {
-		if verbose {
-			log.Printf("  - Error creating/accessing target month directory for %s (date: %s): %v. Skipping.\n", sourceFilePath, photoDate, err)
-		}
-		return "", "", fmt.Errorf("error creating target month directory: %w", err)
+		return nil, nil, err
 	}
-
-	originalExtension := filepath.Ext(sourceFilePath)
-	baseNameWithoutExt := photoDate.In(time.UTC).Format("2006-01-02-150405")
-	targetFileName := baseNameWithoutExt + originalExtension
-	exactTargetPath = filepath.Join(targetMonthDir, targetFileName)
-
-	if verbose {
-		log.Printf("  - Proposed target path: %s\n", exactTargetPath)
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open ZIP archive '%s': %w", zipPath, err)
 	}
-	return exactTargetPath, targetMonthDir, nil
-}
-
-// checkAndCopyIfTargetEmpty checks if the target path is empty and copies the file if it is.
-// Returns true if copied, false if target existed or copy error. Error is returned for system/copy errors.
-func checkAndCopyIfTargetEmpty(sourceFilePath string, exactTargetPath string, verbose bool) (copied bool, err error) {
-	_, statErr := os.Stat(exactTargetPath)
-	if statErr == nil { // File exists
-		if verbose {
-			log.Printf("  - File already exists at target path: %s\n", exactTargetPath)
-		}
-		return false, nil // Not copied by this function, target exists
-	} else if !os.IsNotExist(statErr) { // Other stat error
-		if verbose {
-			log.Printf("  - Error checking target path %s: %v. Skipping source file %s.\n", exactTargetPath, statErr, sourceFilePath)
-		}
-		return false, fmt.Errorf("error checking target path %s: %w", exactTargetPath, statErr)
+	stageDir, err := os.MkdirTemp("", "photo-sorter-zip-")
+	if err != nil {
+		archive.Close()
+		return nil, nil, fmt.Errorf("failed to create staging directory for '%s': %w", zipPath, err)
 	}
 
-	// Target does not exist (os.IsNotExist(statErr) is true)
-	if verbose {
-		log.Printf("  - Target path %s is empty. Copying %s directly.\n", exactTargetPath, sourceFilePath)
+	src := &zipSource{archive: archive, entries: make(map[string]*zip.File), stageDir: stageDir}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
 	}
-	if copyErr := pkg.CopyFile(sourceFilePath, exactTargetPath); copyErr != nil {
-		if verbose {
-			log.Printf("  - Error copying file %s to %s: %v.\n", sourceFilePath, exactTargetPath, copyErr)
+	for _, entry := range archive.File {
+		if wanted[entry.Name] {
+			src.entries[filepath.Join(zipPath, filepath.FromSlash(entry.Name))] = entry
 		}
-		return false, fmt.Errorf("error copying file %s to %s: %w", sourceFilePath, exactTargetPath, copyErr)
 	}
-	if verbose {
-		log.Printf("  - Successfully copied %s to %s\n", sourceFilePath, exactTargetPath)
+	displayPaths := make([]string, 0, len(names))
+	for _, name := range names {
+		displayPaths = append(displayPaths, filepath.Join(zipPath, filepath.FromSlash(name)))
 	}
-	return true, nil // Copied successfully
+	return src, displayPaths, nil
 }
 
-// handleTargetConflict deals with situations where a file already exists at the target path.
-func handleTargetConflict(currentSourceFilepath string, exactTargetPath string, currentWidth int, currentHeight int, verbose bool) (copied bool, finalTargetPath string, duplicateInfo *pkg.DuplicateInfo, usedFileHash bool, err error) {
-	if verbose {
-		log.Printf("    - Comparing source %s with existing target %s\n", currentSourceFilepath, exactTargetPath)
+// stage extracts the entry with the given display path into the staging directory, keeping its
+// path within the archive, and returns the extracted file's path.
+func (z *zipSource) stage(displayPath string) (string, error) {
+	entry, ok := z.entries[displayPath]
+	if !ok {
+		return "", fmt.Errorf("no image entry %s in ZIP archive", displayPath)
 	}
-	compResult, errComp := pkg.AreFilesPotentiallyDuplicate(currentSourceFilepath, exactTargetPath)
-	currentUsedFileHash := compResult.HashType == pkg.HashTypeFile && pkg.IsImageExtension(currentSourceFilepath)
-
-	if errComp != nil {
-		if verbose {
-			log.Printf("      - Error comparing source %s with target %s: %v. Assuming target is kept.\n", currentSourceFilepath, exactTargetPath, errComp)
-		}
-		dupInfo := pkg.DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: "Comparison error, existing target kept"}
-		return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil // Not an error that stops processing other files, but report duplicate.
+	stagedPath := filepath.Join(z.stageDir, filepath.FromSlash(entry.Name))
+	if err := pkg.ExtractZipEntry(entry, stagedPath); err != nil {
+		return "", err
 	}
+	return stagedPath, nil
+}
 
-	if !compResult.AreDuplicates {
-		if verbose {
-			log.Printf("      - Source %s and target %s are deemed different by content comparison, but share the same target path. Discarding source to protect existing target.\n", currentSourceFilepath, exactTargetPath)
-		}
-		dupInfo := pkg.DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: "Content different, but name collision; existing target preserved"}
-		return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil
+// Close closes the archive and removes the staging directory.
+func (z *zipSource) Close() error {
+	removeErr := os.RemoveAll(z.stageDir)
+	if err := z.archive.Close(); err != nil {
+		return err
 	}
+	return removeErr
+}
 
-	// Files are duplicates
-	if verbose {
-		log.Printf("      - Duplicate found: Source %s and Target %s. Reason: %s\n", currentSourceFilepath, exactTargetPath, compResult.Reason)
-	}
-	targetResolutionBetterOrEqual := true
+// sameDirectory reports whether dir1 and dir2 are the same existing directory, e.g. through a symlink.
+func sameDirectory(dir1, dir2 string) bool {
+	info1, err1 := os.Stat(dir1)
+	info2, err2 := os.Stat(dir2)
+	return err1 == nil && err2 == nil && info1.IsDir() && os.SameFile(info1, info2)
+}
 
-	if compResult.Reason == pkg.ReasonPixelHashMatch {
-		targetWidth, targetHeight, errResTarget := pkg.GetImageResolution(exactTargetPath)
-		if errResTarget != nil {
-			if verbose {
-				log.Printf("      - Warning: Could not get resolution for target %s: %v. Source might replace if it has resolution.\n", exactTargetPath, errResTarget)
-			}
-			if currentWidth*currentHeight > 0 { // Source has valid resolution
-				targetResolutionBetterOrEqual = false
-			} else { // Source also has resolution error or 0x0
-				dupInfo := pkg.DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: compResult.Reason + " (existing target kept - resolution error for target, source has no resolution or also error)"}
-				if verbose {
-					log.Printf("      - Target %s kept (pixel hash match, resolution error for target and source has no resolution).\n", exactTargetPath)
-				}
-				return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil
-			}
-		} else { // Target resolution is available
-			if verbose {
-				log.Printf("      - Target resolution: %dx%d\n", targetWidth, targetHeight)
-			}
-			if currentWidth*currentHeight > targetWidth*targetHeight {
-				targetResolutionBetterOrEqual = false
-			}
+// sourceRoot returns the directory of sourceDirs that contains path, or the first one if none does.
+func sourceRoot(path string, sourceDirs []string) string {
+	for _, dir := range sourceDirs {
+		if strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return dir
 		}
 	}
+	return sourceDirs[0]
+}
 
-	if !targetResolutionBetterOrEqual { // Source is better resolution
-		if verbose {
-			log.Printf("      - Source %s (%dx%d) is better than target %s. Replacing target.\n", currentSourceFilepath, currentWidth, currentHeight, exactTargetPath)
-		}
-		dupInfo := pkg.DuplicateInfo{
-			KeptFile:      currentSourceFilepath, // Source is kept, will be copied to exactTargetPath
-			DiscardedFile: exactTargetPath,
-			Reason:        compResult.Reason + " (source is better resolution)",
-		}
-		if copyErr := pkg.CopyFile(currentSourceFilepath, exactTargetPath); copyErr != nil {
-			if verbose {
-				log.Printf("      - Error overwriting target file %s with source %s: %v. Original target remains.\n", exactTargetPath, currentSourceFilepath, copyErr)
+// removeEmptiedSourceDirs removes the source directories that became empty because the files in
+// movedFiles were moved out of them, without removing any of sourceDirs. Failures are logged.
+func removeEmptiedSourceDirs(sourceDirs []string, movedFiles map[string]string, logger pkg.Logger) {
+	dirsByRoot := make(map[string][]string)
+	for sourcePath := range movedFiles {
+		// The innermost source directory, so that a source nested in another one is kept.
+		root := ""
+		for _, dir := range sourceDirs {
+			if strings.HasPrefix(sourcePath, filepath.Clean(dir)+string(filepath.Separator)) && len(dir) > len(root) {
+				root = dir
 			}
-			// If overwrite fails, the original target was kept. Adjust DuplicateInfo.
-			dupInfo.KeptFile = exactTargetPath
-			dupInfo.DiscardedFile = currentSourceFilepath
-			dupInfo.Reason = "Attempted replacement failed, original target kept"
-			return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil // Not an error for runApplicationLogic, but a handled duplicate.
 		}
-		if verbose {
-			log.Printf("      - Successfully overwrote %s with %s\n", exactTargetPath, currentSourceFilepath)
+		if root != "" {
+			dirsByRoot[root] = append(dirsByRoot[root], filepath.Dir(sourcePath))
 		}
-		// Successfully replaced, so copied is true, finalTargetPath is exactTargetPath
-		return true, exactTargetPath, &dupInfo, currentUsedFileHash, nil
 	}
-
-	// Target is better or same resolution, or not a pixel hash match (e.g. file hash match, where resolution is not the primary factor for replacement)
-	reasonSuffix := ""
-	if compResult.Reason == pkg.ReasonPixelHashMatch { // Only add resolution suffix if it was a pixel hash match and target was kept due to resolution
-		reasonSuffix = " (existing target kept - resolution)"
-	} else {
-		reasonSuffix = " (existing target kept)"
-	}
-	dupInfo := pkg.DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: compResult.Reason + reasonSuffix}
-	if verbose {
-		log.Printf("      - Target %s kept (source %s discarded). Reason: %s\n", exactTargetPath, currentSourceFilepath, compResult.Reason+reasonSuffix)
+	for root, dirs := range dirsByRoot {
+		removed, err := pkg.RemoveEmptyDirs(root, dirs)
+		if err != nil {
+			logger.Warn("Could not remove all emptied source directories", "dir", root, "error", err)
+		}
+		if len(removed) > 0 {
+			logger.Info("Removed emptied source directories", "dir", root, "count", len(removed))
+		}
 	}
-	return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil
 }
 
-// processSingleFile handles the logic for processing one image file.
-// It returns whether the file was copied, the path it was copied to (if applicable),
-// any duplicate information, if file hash was used, and any error.
-func processSingleFile(currentSourceFilepath string, targetBaseDir string, verbose bool, existingTargetFiles map[string]string) (copied bool, finalTargetPath string, duplicateInfo *pkg.DuplicateInfo, usedFileHash bool, err error) {
-	if verbose {
-		log.Printf("\nProcessing: %s\n", currentSourceFilepath)
+// ensureTargetDirectory ensures the target base directory exists, creating it if necessary,
+// with dirMode, and that files can be created in it. An unwritable target yields pkg.ErrTargetNotWritable.
+func ensureTargetDirectory(targetBaseDir string, dirMode fs.FileMode, logger pkg.Logger) error {
+	if _, err := os.Stat(targetBaseDir); os.IsNotExist(err) {
+		logger.Info("Target directory does not exist, creating it", "dir", targetBaseDir)
+		if errMkdir := pkg.MkdirAll(targetBaseDir, dirMode); errMkdir != nil {
+			// This is a critical error, always show.
+			return fmt.Errorf("failed to create target base directory '%s': %w", targetBaseDir, errMkdir)
+		}
+	} else if err != nil {
+		// This is a critical error, always show.
+		return fmt.Errorf("error accessing target base directory '%s': %w", targetBaseDir, err)
 	}
 
-	// 1.a Determine photoDate and dateSource
-	photoDate, _, err := determinePhotoDateAndDateSource(currentSourceFilepath, verbose)
+	// Probe with a temporary file so a read-only target fails here instead of once per copied file.
+	probe, err := os.CreateTemp(targetBaseDir, ".photocp-write-test-*")
 	if err != nil {
-		// The error is already logged by determinePhotoDateAndDateSource if verbose.
-		// Return the error to be handled by the caller.
-		return false, "", nil, false, err
+		return fmt.Errorf("%w: '%s': %v", pkg.ErrTargetNotWritable, targetBaseDir, err)
 	}
-
-	// 1.b Determine target path
-	var exactTargetPath string // Declare exactTargetPath
-	exactTargetPath, _, err = determineTargetPath(targetBaseDir, photoDate, currentSourceFilepath, verbose)
-	if err != nil {
-		// Error is already logged by determineTargetPath if verbose.
-		return false, "", nil, false, err
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("%w: '%s': failed to remove write test file: %v", pkg.ErrTargetNotWritable, targetBaseDir, err)
 	}
+	return nil
+}
 
-	currentWidth, currentHeight, errRes := pkg.GetImageResolution(currentSourceFilepath)
-	if errRes != nil {
-		if verbose {
-			log.Printf("  - Warning: Could not get resolution for %s: %v. Proceeding with 0x0 resolution.\n", currentSourceFilepath, errRes)
+// sourceSize returns the total size in bytes of imageFiles, the uncompressed size for entries of zipSrc.
+// Files that cannot be stat'ed are left out; they fail later when they are processed.
+func sourceSize(imageFiles []string, zipSrc *zipSource) uint64 {
+	var total uint64
+	for _, path := range imageFiles {
+		if zipSrc != nil {
+			if entry, ok := zipSrc.entries[path]; ok {
+				total += entry.UncompressedSize64
+			}
+			continue
 		}
-		currentWidth = 0
-		currentHeight = 0
-		// Not returning an error here as we proceed with 0x0 resolution
-	} else {
-		if verbose {
-			log.Printf("  - Source resolution: %dx%d\n", currentWidth, currentHeight)
+		if info, err := os.Stat(path); err == nil {
+			total += uint64(info.Size())
 		}
 	}
+	return total
+}
 
-	// 2. Check if target is empty and copy if so
-	wasCopied, copyErr := checkAndCopyIfTargetEmpty(currentSourceFilepath, exactTargetPath, verbose)
-	if copyErr != nil {
-		// Propagate error from checkAndCopyIfTargetEmpty
-		return false, "", nil, false, copyErr
-	}
-	if wasCopied {
-		// File was successfully copied to an empty target path
-		return true, exactTargetPath, nil, false, nil
-	}
+// streamProgressInterval is how often progress is logged when the number of files is not known in advance.
+const streamProgressInterval = 1000
 
-	// Conflict: File exists at exactTargetPath. Call conflict resolution.
-	return handleTargetConflict(currentSourceFilepath, exactTargetPath, currentWidth, currentHeight, verbose)
+// streamSourceFiles returns the image files of sourceDirs as they are found by pkg.WalkSourceImagesContext,
+// skipping files already yielded through an overlapping source and files in a targetBaseDir inside a
+// source directory, which would otherwise include the files sorted so far (see pkg.ExcludeNestedTarget).
+// Once the sequence is exhausted or abandoned, *walkErr holds the error that stopped a walk, if any.
+func streamSourceFiles(ctx context.Context, sourceDirs []string, targetBaseDir string, opts Options, walkErr *error) iter.Seq[string] {
+	errStop := errors.New("stop walking")
+	return func(yield func(string) bool) {
+		seen := make(map[string]bool)
+		for _, dir := range sourceDirs {
+			opts.LoggerOrDefault().Info("Scanning source directory", "dir", dir)
+			nested := pkg.WithinDirectory(targetBaseDir, dir)
+			if nested {
+				opts.LoggerOrDefault().Info("Target directory is inside the source directory, not scanning it", "source", dir, "target", targetBaseDir)
+			}
+			err := pkg.WalkSourceImagesContext(ctx, dir, opts.MaxDepth, opts.SniffExtensionless, func(path string) error {
+				if seen[path] || (nested && pkg.WithinDirectory(path, targetBaseDir)) {
+					return nil
+				}
+				seen[path] = true
+				if !yield(path) {
+					return errStop
+				}
+				return nil
+			})
+			if errors.Is(err, errStop) {
+				return
+			}
+			if err != nil {
+				*walkErr = err
+				return
+			}
+		}
+	}
 }
 
 // processImageFiles iterates over image files, processes them, and collects results.
-func processImageFiles(imageFiles []string, targetBaseDir string, verbose bool, existingTargetFiles map[string]string) (
-	copiedCount int,
-	duplicatesList []pkg.DuplicateInfo,
+// numImageFiles is the number of files, used for progress messages; it is 0 when they are streamed
+// from a scan that is still running.
+// Copy counts, duplicates, quarantined files, per-extension statistics and date sources are recorded in summary.
+// ctx is checked before each file; once it is cancelled the current file is finished and
+// the loop stops, setting summary.Interrupted.
+// sorted tells for each file that was sorted whether its content is now in the target (see sourceKept).
+func processImageFiles(ctx context.Context, imageFiles iter.Seq[string], numImageFiles int, sourceDirs []string, zipSrc *zipSource, targetBaseDir string, opts Options, summary *pkg.ReportSummary) (
 	sourceFilesThatUsedFileHash map[string]bool,
 	keptFileSourceToTargetMap map[string]string,
 	processingErrors []error,
+	sorted map[string]bool,
 ) {
+	logger := opts.LoggerOrDefault()
 	// Initialize return values
 	sourceFilesThatUsedFileHash = make(map[string]bool)
 	keptFileSourceToTargetMap = make(map[string]string)
-	duplicatesList = []pkg.DuplicateInfo{} // Ensure it's not nil
-	processingErrors = []error{}           // Ensure it's not nil
+	sorted = make(map[string]bool)
+	processingErrors = []error{} // Ensure it's not nil
+	if summary.CopiedByExtension == nil {
+		summary.CopiedByExtension = make(map[string]int)
+	}
+	if summary.DuplicatesByExtension == nil {
+		summary.DuplicatesByExtension = make(map[string]int)
+	}
+	if summary.DateSourceCounts == nil {
+		summary.DateSourceCounts = make(map[string]int)
+	}
+	if summary.DuplicatesByMethod == nil {
+		summary.DuplicatesByMethod = make(map[pkg.Reason]int)
+	}
 
-	numImageFiles := len(imageFiles)
 	progressInterval := numImageFiles / 10
 	if progressInterval == 0 && numImageFiles > 0 {
 		progressInterval = 1
 	}
-	if numImageFiles < 10 {
+	if numImageFiles > 0 && numImageFiles < 10 {
 		progressInterval = 1
 	}
+	if numImageFiles == 0 {
+		progressInterval = streamProgressInterval
+	}
 
-	for i, currentSourceFilepath := range imageFiles {
-		copied, finalTargetPath, dupInfo, usedFH, processErr := processSingleFile(currentSourceFilepath, targetBaseDir, verbose, existingTargetFiles)
+	i := -1
+	var hashedFiles iter.Seq2[string, *pkg.FileHashes]
+	if zipSrc == nil {
+		hashedFiles = prefetchHashes(imageFiles, opts)
+	} else {
+		hashedFiles = func(yield func(string, *pkg.FileHashes) bool) {
+			for path := range imageFiles {
+				if !yield(path, nil) {
+					return
+				}
+			}
+		}
+	}
+	for currentSourceFilepath, srcHashes := range hashedFiles {
+		i++
+		if ctx.Err() != nil {
+			logger.Warn("Interrupted, stopping", "processed", i, "total", numImageFiles)
+			summary.Interrupted = true
+			return
+		}
+
+		outcome, sortPath, processErr := sortSourceFile(ctx, currentSourceFilepath, srcHashes, zipSrc, targetBaseDir, opts)
+		copied, finalTargetPath, dupInfo := outcome.Copied, outcome.TargetPath, outcome.Duplicate
+		sorted[currentSourceFilepath] = sourceKept(outcome, processErr)
 
 		if processErr != nil {
 			processingErrors = append(processingErrors, processErr)
-			// Error for this specific file is logged verbosely within processSingleFile if verbose.
+			logger.Warn("Error processing file", "source", currentSourceFilepath, "error", processErr)
 			// Continue processing other files.
+			if opts.QuarantineDir != "" && sortPath != "" {
+				quarantineRoot := sourceRoot(currentSourceFilepath, sourceDirs)
+				if zipSrc != nil {
+					quarantineRoot = zipSrc.stageDir
+				}
+				quarantinePath, qErr := pkg.QuarantineFileWithModes(sortPath, quarantineRoot, opts.QuarantineDir, opts.DirModeOrDefault(), opts.FileModeOrDefault())
+				if qErr != nil {
+					processingErrors = append(processingErrors, qErr)
+				} else {
+					logger.Info("Quarantined file", "source", currentSourceFilepath, "quarantinePath", quarantinePath)
+					summary.Quarantined = append(summary.Quarantined, pkg.QuarantineInfo{SourceFile: currentSourceFilepath, QuarantinePath: quarantinePath, Reason: processErr.Error()})
+				}
+			}
 		}
 
-		if usedFH {
+		if outcome.CorruptReason != "" {
+			summary.Corrupt = append(summary.Corrupt, pkg.CorruptInfo{SourceFile: currentSourceFilepath, Reason: outcome.CorruptReason})
+		}
+		if outcome.CorrectedExtension != "" {
+			summary.ExtensionCorrections = append(summary.ExtensionCorrections, pkg.ExtensionCorrection{SourceFile: currentSourceFilepath, Extension: outcome.CorrectedExtension})
+		}
+		if outcome.SkipReason != "" {
+			skipped := pkg.SkippedInfo{SourceFile: currentSourceFilepath, Reason: outcome.SkipReason}
+			summary.Skipped = append(summary.Skipped, skipped)
+			writeMachineLog(opts, pkg.SkipLogLine(skipped))
+		}
+		if outcome.DateSource != "" {
+			summary.DateSourceCounts[pkg.DateSourceCategory(outcome.DateSource)]++
+		}
+		if outcome.UsedFileHash || outcome.PixelHashUnsupported {
 			sourceFilesThatUsedFileHash[currentSourceFilepath] = true
 		}
+		extension := strings.ToLower(pkg.SourceExtension(currentSourceFilepath, opts))
+		if zipSrc != nil && sortPath != "" {
+			os.Remove(sortPath) // Staged entries are only needed while they are sorted
+		}
+		summary.CopiedSidecarsCount += len(outcome.Sidecars)
+		if !copied && zipSrc == nil && outcome.SkipReason != pkg.AlreadyInPlaceSkipReason && outcome.SkipReason != pkg.AlreadyLinkedSkipReason {
+			recordOrphanedSidecars(currentSourceFilepath, notCopiedReason(outcome, processErr), opts, summary)
+		}
 		if copied {
-			copiedCount++
+			summary.CopiedFilesCount++
+			summary.CopiedByExtension[extension]++
 			if finalTargetPath == "" {
-				if verbose {
-					log.Printf("Internal error: file %s reported as copied but no finalTargetPath returned.", currentSourceFilepath)
-				}
+				logger.Error("File reported as copied but no target path returned", "source", currentSourceFilepath)
 				// Optionally, add to processingErrors or handle as a specific type of error
 			} else {
 				keptFileSourceToTargetMap[currentSourceFilepath] = finalTargetPath
@@ -321,98 +368,710 @@ func processImageFiles(imageFiles []string, targetBaseDir string, verbose bool,
 		}
 
 		if dupInfo != nil {
-			duplicatesList = append(duplicatesList, *dupInfo)
+			summary.Duplicates = append(summary.Duplicates, *dupInfo)
+			summary.DuplicatesByMethod[dupInfo.DetectionMethod()]++
+			writeMachineLog(opts, pkg.DuplicateLogLine(*dupInfo))
+			if !dupInfo.Replaced { // The source was copied and is already counted in CopiedByExtension
+				summary.DuplicatesByExtension[extension]++
+			}
 		}
 
-		if !verbose && progressInterval > 0 && (i+1)%progressInterval == 0 && (i+1) != numImageFiles {
-			fmt.Printf("Processed %d of %d files...\n", i+1, numImageFiles)
+		writeFileEvent(opts, pkg.NewFileEvent(currentSourceFilepath, outcome, processErr))
+
+		if numImageFiles == 0 && (i+1)%progressInterval == 0 {
+			logger.Info("Progress", "processed", i+1)
+		} else if numImageFiles > 0 && (i+1)%progressInterval == 0 && (i+1) != numImageFiles {
+			logger.Info("Progress", "processed", i+1, "total", numImageFiles)
 		}
 	}
 
-	if !verbose && numImageFiles > 0 {
-		fmt.Println("All files processed.")
+	if i >= 0 {
+		logger.Info("All files processed", "total", i+1)
 	}
 	return
 }
 
-// generateFinalReport updates duplicate information and generates the text report.
-func generateFinalReport(reportFilePath string, duplicatesList []pkg.DuplicateInfo, copiedFilesCount int, processedFilesCount int, filesToCopyCount int, pixelHashUnsupportedCount int, keptFileSourceToTargetMap map[string]string, verbose bool) error {
+// sourceKept reports whether the content of a source sorted with the given outcome and processing
+// error is in the target: it was copied, was already there, or was discarded as a duplicate of a target.
+func sourceKept(outcome pkg.FileOutcome, err error) bool {
+	switch {
+	case err != nil:
+		return false
+	case outcome.Copied:
+		return true
+	case outcome.Duplicate != nil:
+		return outcome.Duplicate.Reason != pkg.ReasonNameCollision && outcome.Duplicate.Reason != pkg.ReasonError
+	}
+	switch outcome.SkipReason {
+	case pkg.AlreadyInPlaceSkipReason, pkg.AlreadyLinkedSkipReason, pkg.ExistingSizeMatchSkipReason:
+		return true
+	}
+	return false
+}
+
+// sortSourceFile sorts the source file with the given path, extracting it first if it is an entry of zipSrc.
+// It returns the path that was actually sorted, which is empty if the entry could not be extracted.
+// Source paths in the outcome refer to sourcePath rather than to the extracted file.
+// The hashes of the source are taken from srcHashes, if it is not nil. Copies are not retried once ctx
+// is cancelled.
+func sortSourceFile(ctx context.Context, sourcePath string, srcHashes *pkg.FileHashes, zipSrc *zipSource, targetBaseDir string, opts Options) (outcome pkg.FileOutcome, sortPath string, err error) {
+	if zipSrc == nil {
+		if srcHashes == nil {
+			srcHashes = pkg.NewFileHashes(sourcePath)
+		}
+		outcome, err = pkg.SortFileWithHashesContext(ctx, srcHashes, targetBaseDir, opts)
+		return outcome, sourcePath, err
+	}
+	sortPath, err = zipSrc.stage(sourcePath)
+	if err != nil {
+		return outcome, "", err
+	}
+	outcome, err = pkg.SortFileContext(ctx, sortPath, targetBaseDir, opts)
+	if outcome.Duplicate != nil {
+		dupInfo := *outcome.Duplicate
+		if dupInfo.KeptFile == sortPath {
+			dupInfo.KeptFile = sourcePath
+		}
+		if dupInfo.DiscardedFile == sortPath {
+			dupInfo.DiscardedFile = sourcePath
+		}
+		outcome.Duplicate = &dupInfo
+	}
+	return outcome, sortPath, err
+}
+
+// writeMachineLog writes line to opts.MachineLog, if it is set.
+func writeMachineLog(opts Options, line string) {
+	if opts.MachineLog != nil {
+		fmt.Fprintln(opts.MachineLog, line)
+	}
+}
+
+// writeFileEvent writes event to opts.JSONLines, if it is set. Failures are only logged.
+func writeFileEvent(opts Options, event pkg.FileEvent) {
+	if opts.JSONLines == nil {
+		return
+	}
+	if err := pkg.WriteFileEvent(opts.JSONLines, event); err != nil {
+		opts.LoggerOrDefault().Warn("Failed to write JSON Lines event", "source", event.Path, "error", err)
+	}
+}
+
+// loadTargetIndex loads the target index at indexPath and refreshes entries whose files changed,
+// or builds a new index when none exists yet.
+func loadTargetIndex(indexPath string, targetBaseDir string, logger pkg.Logger) (*pkg.TargetIndex, error) {
+	targetIndex := &pkg.TargetIndex{}
+	if err := targetIndex.Load(indexPath); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		logger.Info("Building target index", "index", indexPath)
+		return pkg.BuildTargetIndex(targetBaseDir)
+	}
+	if stale := targetIndex.Stale(targetBaseDir); len(stale) > 0 {
+		logger.Info("Target index has stale entries, refreshing them", "index", indexPath, "stale", len(stale))
+	}
+	if err := targetIndex.Refresh(targetBaseDir); err != nil {
+		return nil, err
+	}
+	return targetIndex, nil
+}
+
+// updateTargetIndex adds the files copied during the run to targetIndex and saves it to indexPath with fileMode.
+func updateTargetIndex(targetIndex *pkg.TargetIndex, indexPath string, targetBaseDir string, keptFileSourceToTargetMap map[string]string, fileMode fs.FileMode, logger pkg.Logger) error {
+	for _, targetPath := range keptFileSourceToTargetMap {
+		if err := targetIndex.Add(targetBaseDir, targetPath); err != nil {
+			return err
+		}
+	}
+	if err := targetIndex.SaveWithMode(indexPath, fileMode); err != nil {
+		return err
+	}
+	logger.Debug("Updated target index", "index", indexPath, "entries", len(targetIndex.Entries))
+	return nil
+}
+
+// appendNewKnownHashes appends the hashes of knownHashes that the known hashes file at path does not
+// list yet, i.e. those of the files copied by the run, in sorted order.
+func appendNewKnownHashes(path string, knownHashes map[string]bool, logger pkg.Logger) error {
+	listed, err := pkg.LoadKnownHashes(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var added []string
+	for hash := range knownHashes {
+		if !listed[hash] {
+			added = append(added, hash)
+		}
+	}
+	slices.Sort(added)
+	if err := pkg.AppendKnownHashes(path, added); err != nil {
+		return err
+	}
+	logger.Debug("Updated known hashes file", "file", path, "added", len(added))
+	return nil
+}
+
+// loadRunFiles loads the known hashes and date overrides files named by opts into opts.
+func loadRunFiles(opts *Options) error {
+	logger := opts.LoggerOrDefault()
+	if opts.KnownHashesFile != "" {
+		knownHashes, err := pkg.LoadKnownHashes(opts.KnownHashesFile)
+		if err != nil {
+			return err
+		}
+		logger.Info("Loaded known hashes", "count", len(knownHashes), "file", opts.KnownHashesFile)
+		opts.KnownHashes = knownHashes
+	}
+	if opts.DateOverridesFile != "" {
+		overrides, err := pkg.LoadDateOverrides(opts.DateOverridesFile, logger)
+		if err != nil {
+			return err
+		}
+		logger.Info("Loaded date overrides", "count", len(overrides), "file", opts.DateOverridesFile)
+		opts.DateOverrides = overrides
+	}
+	return nil
+}
+
+// reportPath returns where the report of a run started at now is written: opts.ReportPath, or
+// ReportFileName or a timestamped name (with opts.TimestampReport) in targetBaseDir.
+// A timestamped name gets a "-N" suffix if a report of a run in the same second exists.
+func reportPath(targetBaseDir string, opts Options, now time.Time) string {
+	if opts.ReportPath != "" {
+		return opts.ReportPath
+	}
+	if !opts.TimestampReport {
+		return filepath.Join(targetBaseDir, pkg.ReportFileName)
+	}
+	name := pkg.TimestampedReportName(now)
+	path := filepath.Join(targetBaseDir, name)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(targetBaseDir, fmt.Sprintf("%s-%d.txt", strings.TrimSuffix(name, ".txt"), i))
+	}
+}
+
+// generateFinalReport updates duplicate information and generates the text report. If the report
+// cannot be written, it is written to a temporary file instead and nil is returned, unless
+// opts.StrictReport is set.
+func generateFinalReport(reportFilePath string, summary pkg.ReportSummary, keptFileSourceToTargetMap map[string]string, opts Options) error {
 	// Update KeptFile paths in duplicates report
-	for i, dup := range duplicatesList {
+	for i, dup := range summary.Duplicates {
 		if targetPath, ok := keptFileSourceToTargetMap[dup.KeptFile]; ok {
-			duplicatesList[i].KeptFile = targetPath
+			summary.Duplicates[i].KeptFile = targetPath
 		}
 	}
 
-	fmt.Println("\n--- Photo Sorting Process Completed ---")
+	summary.Verbose = opts.Verbose
+	summary.DirMode, summary.FileMode = opts.DirModeOrDefault(), opts.FileModeOrDefault()
+	opts.Logger.Info("Photo sorting process completed", "report", reportFilePath)
+	err := writeReportFile(reportFilePath, summary, opts)
+	if err == nil || opts.StrictReport {
+		return err
+	}
+
+	// The files are sorted already, so a report that cannot be written should not fail the run.
+	opts.Logger.Warn("Failed to write report, writing it to a temporary file instead", "report", reportFilePath, "error", err)
+	fallback, tempErr := os.CreateTemp("", "photo-sorter-report-*.txt")
+	if tempErr != nil {
+		opts.Logger.Warn("Failed to create temporary report file, no report was written", "error", tempErr)
+		return nil
+	}
+	fallback.Close()
+	if err := writeReportFile(fallback.Name(), summary, opts); err != nil {
+		opts.Logger.Warn("Failed to write temporary report file, no report was written", "report", fallback.Name(), "error", err)
+		return nil
+	}
+	opts.Logger.Warn("Report written to temporary file", "report", fallback.Name())
+	return nil
+}
+
+// writeReportFile writes the report for summary to reportFilePath, creating its directory if needed.
+// With pkg.ReportFormatHTML, the HTML duplicate report is written next to it.
+func writeReportFile(reportFilePath string, summary pkg.ReportSummary, opts Options) error {
+	if err := pkg.MkdirAll(filepath.Dir(reportFilePath), opts.DirModeOrDefault()); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
 	// filesToCopyCount is essentially copiedFilesCount at this stage, as copying happens file-by-file.
 	// If a separate "selection" phase existed, filesToCopyCount might differ.
 	// For GenerateReport, it expects total files considered for copying, which is copiedFilesCount.
-	return pkg.GenerateReport(reportFilePath, duplicatesList, copiedFilesCount, processedFilesCount, copiedFilesCount, pixelHashUnsupportedCount)
+	var err error
+	if opts.GroupDuplicates {
+		err = pkg.GenerateGroupedReport(reportFilePath, summary)
+	} else {
+		err = pkg.GenerateSummaryReport(reportFilePath, summary)
+	}
+	if err != nil || opts.ReportFormat != pkg.ReportFormatHTML {
+		return err
+	}
+	return pkg.GenerateHTMLReport(pkg.HTMLReportPath(reportFilePath), summary.Duplicates, opts)
+}
+
+// Exit codes of the photocp command, as chosen by ExitCode.
+const (
+	ExitOK          = 0   // Every file was processed
+	ExitFatal       = 1   // The run failed, e.g. because of invalid options or an inaccessible directory
+	ExitFileErrors  = 2   // The run completed, but some files could not be processed
+	ExitInterrupted = 130 // The run was interrupted before all files were processed
+)
+
+// ExitCode maps the result of RunApplicationLogicContext to the exit code of the photocp command.
+func ExitCode(summary pkg.ReportSummary, err error) int {
+	switch {
+	case summary.Interrupted:
+		return ExitInterrupted
+	case err != nil:
+		return ExitFatal
+	case summary.ProcessingErrorCount > 0:
+		return ExitFileErrors
+	}
+	return ExitOK
 }
 
 // RunApplicationLogic is the core processing function for the photo sorter.
 // It scans the source directory, processes each image file, handles duplicates,
 // and copies files to the target directory, generating a report of its actions.
-// It is exported for use in tests.
+// sourceDir may also be a .zip archive, whose image entries are sorted without unpacking it first.
+// It is exported for use in tests and keeps its original signature; new callers should use
+// RunApplicationLogicWithOptions, e.g. with options built by pkg.NewOptions.
 func RunApplicationLogic(sourceDir string, targetBaseDir string, verbose bool) (processedFilesCount int, copiedFilesCount int, filesToCopyCount int, duplicatesList []pkg.DuplicateInfo, pixelHashUnsupportedCount int, err error) {
-	reportFilePath := filepath.Join(targetBaseDir, "report.txt")
-	fmt.Printf("Photo Sorter Initializing...\nSource: %s\nTarget: %s\nReport: %s\n", sourceDir, targetBaseDir, reportFilePath)
+	summary, err := RunApplicationLogicWithOptions(sourceDir, targetBaseDir, Options{Verbose: verbose})
+	return summary.ProcessedFilesCount, summary.CopiedFilesCount, summary.FilesToCopyCount, summary.Duplicates, summary.PixelHashUnsupportedCount, err
+}
+
+// RunApplicationLogicWithOptions behaves like RunApplicationLogic but accepts the full
+// set of run options and returns the complete ReportSummary of the run.
+func RunApplicationLogicWithOptions(sourceDir string, targetBaseDir string, opts Options) (summary pkg.ReportSummary, err error) {
+	return RunApplicationLogicContext(context.Background(), sourceDir, targetBaseDir, opts)
+}
 
-	// existingTargetFiles is declared for processSingleFile, but might remain unused if os.Stat is preferred.
-	existingTargetFiles := make(map[string]string)
+// RunApplicationLogicContext behaves like RunApplicationLogicWithOptions but stops processing
+// further files once ctx is cancelled. The file being processed at that moment is finished
+// and the report is still written; summary.Interrupted is set and ctx.Err() is returned.
+// If ctx is cancelled while the source directory is scanned, nothing is sorted and no report is written.
+func RunApplicationLogicContext(ctx context.Context, sourceDir string, targetBaseDir string, opts Options) (summary pkg.ReportSummary, err error) {
+	return RunApplicationLogicSources(ctx, []string{sourceDir}, targetBaseDir, opts)
+}
 
-	if err := ensureTargetDirectory(targetBaseDir, verbose); err != nil {
-		return 0, 0, 0, nil, 0, err
+// RunApplicationLogicSources behaves like RunApplicationLogicContext but sorts the files of all
+// sourceDirs together into targetBaseDir, as a single run with a single report: files are deduplicated
+// across all sources as well as against the target. A directory listed twice, or inside another
+// listed directory, is scanned once. .zip archives and sorting in place need a single source.
+// With opts.FromList, the listed files are sorted instead, and sourceDirs may be empty (see listSourceDir).
+func RunApplicationLogicSources(ctx context.Context, sourceDirs []string, targetBaseDir string, opts Options) (summary pkg.ReportSummary, err error) {
+	// Resolve the logger once so every helper shares it.
+	opts.Logger = opts.LoggerOrDefault()
+	logger := opts.Logger
+	if err := opts.Validate(); err != nil {
+		return summary, err
+	}
+	if opts.MaxOpenFiles > 0 && opts.OpenFiles == nil {
+		opts.OpenFiles = pkg.NewOpenFileLimiter(opts.MaxOpenFiles)
 	}
+	var listedFiles []string
+	var listSkipped []pkg.SkippedInfo
+	if opts.FromList != "" {
+		if slices.ContainsFunc(sourceDirs, pkg.IsZipSource) {
+			return summary, fmt.Errorf("a source list cannot be combined with a .zip archive source")
+		}
+		var listErr error
+		listedFiles, listSkipped, listErr = pkg.ReadSourceList(opts.FromList, opts.SniffExtensionless)
+		if listErr != nil {
+			return summary, listErr
+		}
+		if len(sourceDirs) == 0 {
+			sourceDirs = []string{listSourceDir(listedFiles, opts.FromList)}
+		}
+	}
+	if len(sourceDirs) == 0 {
+		return summary, fmt.Errorf("no source directory given")
+	}
+	sourceDir := strings.Join(sourceDirs, ", ") // For log messages
+	if len(sourceDirs) > 1 {
+		for _, dir := range sourceDirs {
+			if pkg.IsZipSource(dir) {
+				return summary, fmt.Errorf("the .zip archive '%s' cannot be combined with other sources", dir)
+			}
+			if sameDirectory(dir, targetBaseDir) {
+				return summary, fmt.Errorf("source directory '%s' is the target directory, which needs a single source", dir)
+			}
+		}
+	}
+	reportFilePath := reportPath(targetBaseDir, opts, time.Now())
+	logger.Info("Photo Sorter initializing", "source", sourceDir, "target", targetBaseDir, "report", reportFilePath)
 
-	imageFiles, scanErr := scanSourceDirectory(sourceDir, verbose)
-	if scanErr != nil {
-		return 0, 0, 0, nil, 0, scanErr
+	if err := loadRunFiles(&opts); err != nil {
+		return summary, err
+	}
+
+	if err := ensureTargetDirectory(targetBaseDir, opts.DirModeOrDefault(), logger); err != nil {
+		return summary, err
+	}
+	inPlace := len(sourceDirs) == 1 && sameDirectory(sourceDirs[0], targetBaseDir)
+	if opts.PreserveSubdir && inPlace {
+		// The date directories of earlier runs would be preserved as subdirectories again.
+		return summary, fmt.Errorf("preserving source subdirectories cannot be combined with sorting a directory in place")
+	}
+	if opts.AutoRotate && inPlace {
+		// Sorting in place moves the files, which would keep the sources as they are.
+		return summary, fmt.Errorf("auto-rotation cannot be combined with sorting a directory in place")
+	}
+	if opts.ConvertHeicToJpeg && inPlace {
+		// Sorting in place moves the files, which would delete the HEIC originals after conversion.
+		return summary, fmt.Errorf("HEIC conversion cannot be combined with sorting a directory in place")
+	}
+	if opts.SourceDirs == nil {
+		opts.SourceDirs = sourceDirs
+	}
+	if !opts.Move && inPlace {
+		// Copying would leave every photo in the tree twice, so organize it in place.
+		logger.Info("Source and target are the same directory, moving files into place", "dir", targetBaseDir)
+		opts.Move = true
+	}
+
+	indexPath := filepath.Join(targetBaseDir, pkg.IndexFileName)
+	var targetIndex *pkg.TargetIndex
+	if _, statErr := os.Stat(indexPath); opts.MaintainIndex || statErr == nil {
+		var indexErr error
+		targetIndex, indexErr = loadTargetIndex(indexPath, targetBaseDir, logger)
+		if indexErr != nil {
+			return summary, indexErr
+		}
+	}
+
+	if opts.StreamScan && (inPlace || pkg.IsZipSource(sourceDirs[0]) || opts.FromList != "" || opts.CollapseBursts) {
+		// Moving files into the tree being walked would make the walk find them again.
+		logger.Info("Scanning the whole source before sorting", "source", sourceDir)
+		opts.StreamScan = false
+	}
+	if opts.StreamScan {
+		return runStreaming(ctx, sourceDirs, targetBaseDir, opts, reportFilePath, targetIndex, indexPath)
+	}
+
+	var zipSrc *zipSource
+	var imageFiles []string
+	if len(sourceDirs) == 1 && pkg.IsZipSource(sourceDirs[0]) {
+		var openErr error
+		zipSrc, imageFiles, openErr = openZipSource(sourceDirs[0], logger)
+		if openErr != nil {
+			return summary, openErr
+		}
+		defer zipSrc.Close()
+		opts.SourceDirs = []string{zipSrc.stageDir} // Entries are sorted from their staged copies
+	} else if opts.FromList != "" {
+		logger.Info("Reading the source files from a list", "list", opts.FromList, "images", len(listedFiles), "skipped", len(listSkipped))
+		imageFiles = listedFiles
+	} else {
+		for _, dir := range sourceDirs {
+			dirFiles, scanErr := scanSourceDirectory(ctx, dir, opts.MaxDepth, opts.SniffExtensionless, logger)
+			if scanErr != nil {
+				if ctx.Err() != nil {
+					logger.Warn("Interrupted while scanning the source directory", "dir", dir)
+					summary.Interrupted = true
+				}
+				return summary, scanErr
+			}
+			dirFiles, nested := pkg.ExcludeNestedTarget(dirFiles, dir, targetBaseDir)
+			if nested {
+				logger.Info("Target directory is inside the source directory, not scanning it", "source", dir, "target", targetBaseDir)
+			}
+			imageFiles = append(imageFiles, dirFiles...)
+		}
+	}
+	// Files are processed in lexicographic order of their paths, so when several sources compete for the
+	// same target, the first of them in that order is kept regardless of how the filesystem lists them.
+	// Files found through overlapping source directories are only sorted once.
+	sort.Strings(imageFiles)
+	imageFiles = slices.Compact(imageFiles)
+
+	// Sorting in place only renames files, so it needs no space. Otherwise assume every file is copied,
+	// even though moves within a filesystem and duplicates take none.
+	if !opts.IgnoreSpaceCheck && !inPlace && len(imageFiles) > 0 {
+		if err := pkg.CheckFreeSpace(targetBaseDir, sourceSize(imageFiles, zipSrc), opts.AvailableSpace, logger); err != nil {
+			return summary, err
+		}
 	}
 
-	processedFilesCount = len(imageFiles)
-	// Initialize duplicatesList to ensure it's not nil if no files are processed.
-	duplicatesList = []pkg.DuplicateInfo{}
+	summary.ProcessedFilesCount = len(imageFiles)
+	// Initialize the lists to ensure they are not nil if no files are processed.
+	summary.Duplicates = []pkg.DuplicateInfo{}
+	summary.Quarantined = []pkg.QuarantineInfo{}
+	summary.Skipped = []pkg.SkippedInfo{}
+	summary.CopiedByExtension = make(map[string]int)
+	summary.DuplicatesByExtension = make(map[string]int)
+	if zipSrc == nil && opts.FromList == "" {
+		addScanSkips(ctx, sourceDirs, targetBaseDir, opts, &summary)
+	}
+	for _, s := range listSkipped {
+		logger.Warn("Skipping listed file", "file", s.SourceFile, "reason", s.Reason)
+		recordSkipped(s, opts, &summary)
+	}
 
-	if processedFilesCount == 0 {
-		fmt.Println("No image files found in source directory.")
+	if summary.ProcessedFilesCount == 0 {
+		logger.Info("No image files found in source directory", "dir", sourceDir)
+		if targetIndex != nil {
+			if err := updateTargetIndex(targetIndex, indexPath, targetBaseDir, nil, opts.FileModeOrDefault(), logger); err != nil {
+				return summary, err
+			}
+		}
 		// Attempt to generate an empty report.
-		// Use existing (empty) duplicatesList, and 0 for counts.
 		// keptFileSourceToTargetMap would be empty/nil here.
-		err = generateFinalReport(reportFilePath, duplicatesList, 0, 0, 0, 0, make(map[string]string), verbose)
+		err = generateFinalReport(reportFilePath, summary, make(map[string]string), opts)
 		if err != nil {
-			return 0, 0, 0, duplicatesList, 0, fmt.Errorf("failed to generate empty report: %w", err)
+			return summary, fmt.Errorf("failed to generate empty report: %w", err)
 		}
-		return 0, 0, 0, duplicatesList, 0, nil
+		return summary, nil
 	}
 
-	fmt.Printf("Found %d image file(s) to process.\n", processedFilesCount)
+	logger.Info("Found image files to process", "count", summary.ProcessedFilesCount)
 
 	var processingErrors []error
 	var sourceFilesThatUsedFileHash map[string]bool
 	var keptFileSourceToTargetMap map[string]string
 
-	copiedFilesCount, duplicatesList, sourceFilesThatUsedFileHash, keptFileSourceToTargetMap, processingErrors = processImageFiles(imageFiles, targetBaseDir, verbose, existingTargetFiles)
+	processingStart := time.Now()
+	var sourceDuplicates, indexedDuplicates []pkg.DuplicateInfo
+	if zipSrc == nil { // Archive entries are only staged one at a time, so they cannot be hashed up front
+		imageFiles, sourceDuplicates = pkg.GroupSourceDuplicatesPreferring(ctx, imageFiles, opts.PreferExtensionOrder, logger)
+		if len(sourceDuplicates) > 0 {
+			logger.Info("Found identical source files, sorting one of each", "duplicates", len(sourceDuplicates))
+		}
+		if opts.PreferExtensionOrder != nil && targetIndex != nil && !inPlace { // In place, the index lists the sources themselves
+			imageFiles, indexedDuplicates = targetIndex.ExtensionDuplicates(targetBaseDir, imageFiles, opts.PreferExtensionOrder, logger)
+			if len(indexedDuplicates) > 0 {
+				logger.Info("Found source files already in the target with another extension", "duplicates", len(indexedDuplicates))
+			}
+		}
+		if opts.CollapseBursts {
+			var collapsed []pkg.DuplicateInfo
+			imageFiles, collapsed = pkg.CollapseBursts(imageFiles, opts.BurstWindow, opts.OpenFiles, logger)
+			if len(collapsed) > 0 {
+				logger.Info("Collapsed bursts, sorting the best shot of each", "collapsed", len(collapsed))
+			}
+			sourceDuplicates = append(sourceDuplicates, collapsed...)
+		}
+	}
+	sourceFilesThatUsedFileHash, keptFileSourceToTargetMap, processingErrors, sorted := processImageFiles(ctx, slices.Values(imageFiles), len(imageFiles), sourceDirs, zipSrc, targetBaseDir, opts, &summary)
+	for _, dup := range indexedDuplicates {
+		recordSourceDuplicate(dup, opts, &summary)
+	}
+	// Sources set aside for another source are only reported once that source is in the target. If it
+	// is not, e.g. because it could not be copied, the next of them is sorted in its place.
+	groups := newSourceGroups(sourceDuplicates)
+	record := func(dup pkg.DuplicateInfo) { recordSourceDuplicate(dup, opts, &summary) }
+	for promoted := groups.settle(sorted, record); len(promoted) > 0 && ctx.Err() == nil; promoted = groups.settle(sorted, record) {
+		logger.Info("Sorting set aside source files in place of ones that were not kept", "count", len(promoted))
+		usedFileHash, kept, errs, promotedSorted := processImageFiles(ctx, slices.Values(promoted), len(promoted), sourceDirs, zipSrc, targetBaseDir, opts, &summary)
+		maps.Copy(sourceFilesThatUsedFileHash, usedFileHash)
+		maps.Copy(keptFileSourceToTargetMap, kept)
+		processingErrors = append(processingErrors, errs...)
+		sorted = promotedSorted
+	}
+	if zipSrc == nil {
+		cleanUpSources(sourceDirs, keptFileSourceToTargetMap, opts)
+	}
+	return finishRun(ctx, summary, processingStart, sourceFilesThatUsedFileHash, keptFileSourceToTargetMap, processingErrors, reportFilePath, targetIndex, indexPath, targetBaseDir, opts)
+}
+
+// sourceGroups holds the sources that were set aside before sorting as duplicates of another source,
+// e.g. identical files or the other shots of a burst, by the path of that source.
+type sourceGroups map[string][]pkg.DuplicateInfo
+
+// newSourceGroups groups duplicates by their KeptFile, keeping their order.
+func newSourceGroups(duplicates []pkg.DuplicateInfo) sourceGroups {
+	groups := make(sourceGroups)
+	for _, dup := range duplicates {
+		groups[dup.KeptFile] = append(groups[dup.KeptFile], dup)
+	}
+	return groups
+}
+
+// settle passes the duplicates set aside for each source in sorted whose content is in the target
+// to record, along with those set aside for them in turn. For each source in sorted whose content is
+// not, the first of its duplicates is returned to be sorted in its place, and the others are set aside
+// for that one. Duplicates of sources that were not sorted, e.g. because the run was interrupted, are
+// neither recorded nor returned.
+func (g sourceGroups) settle(sorted map[string]bool, record func(pkg.DuplicateInfo)) (promoted []string) {
+	var keep func(path string)
+	keep = func(path string) {
+		dups := g[path]
+		delete(g, path)
+		for _, dup := range dups {
+			record(dup)
+			keep(dup.DiscardedFile)
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(sorted)) {
+		dups, ok := g[path]
+		if !ok {
+			continue
+		}
+		if sorted[path] {
+			keep(path)
+			continue
+		}
+		delete(g, path)
+		next := dups[0].DiscardedFile
+		rest := slices.Clone(dups[1:])
+		for i := range rest {
+			rest[i].KeptFile = next
+		}
+		g[next] = append(rest, g[next]...)
+		promoted = append(promoted, next)
+	}
+	return promoted
+}
+
+// recordSourceDuplicate records dup, a source that was discarded before it was sorted, in summary and
+// the machine log and JSON lines of opts.
+func recordSourceDuplicate(dup pkg.DuplicateInfo, opts Options, summary *pkg.ReportSummary) {
+	summary.Duplicates = append(summary.Duplicates, dup)
+	summary.DuplicatesByMethod[dup.DetectionMethod()]++
+	writeMachineLog(opts, pkg.DuplicateLogLine(dup))
+	writeFileEvent(opts, pkg.DuplicateFileEvent(dup))
+	summary.DuplicatesByExtension[strings.ToLower(pkg.SourceExtension(dup.DiscardedFile, opts))]++
+	recordOrphanedSidecars(dup.DiscardedFile, duplicateReason(dup), opts, summary)
+}
+
+// recordSkipped records s, a source that was skipped before it was sorted, in summary and the machine
+// log and JSON lines of opts.
+func recordSkipped(s pkg.SkippedInfo, opts Options, summary *pkg.ReportSummary) {
+	summary.Skipped = append(summary.Skipped, s)
+	writeMachineLog(opts, pkg.SkipLogLine(s))
+	writeFileEvent(opts, pkg.SkipFileEvent(s))
+}
+
+// addScanSkips adds the files that the scan of sourceDirs passes over to summary.Skipped if
+// opts.ReportIncludeSkipped is set, except those in a targetBaseDir inside a source directory.
+// Errors are only logged, as the scan for images reports them.
+func addScanSkips(ctx context.Context, sourceDirs []string, targetBaseDir string, opts Options, summary *pkg.ReportSummary) {
+	if !opts.ReportIncludeSkipped {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, dir := range sourceDirs {
+		skipped, err := pkg.ScanSkippedFiles(ctx, dir, opts.MaxDepth, opts.SniffExtensionless)
+		if err != nil {
+			opts.LoggerOrDefault().Warn("Could not list the skipped files of the source directory", "dir", dir, "error", err)
+			continue
+		}
+		nested := pkg.WithinDirectory(targetBaseDir, dir)
+		for _, s := range skipped {
+			if nested && pkg.WithinDirectory(s.SourceFile, targetBaseDir) {
+				continue
+			}
+			if !seen[s.SourceFile] { // Overlapping sources list a file only once
+				seen[s.SourceFile] = true
+				recordSkipped(s, opts, summary)
+			}
+		}
+	}
+}
+
+// cleanUpSources removes the source directories emptied by a move, unless opts.KeepEmptySourceDirs is set.
+func cleanUpSources(sourceDirs []string, movedFiles map[string]string, opts Options) {
+	if opts.Move && !opts.KeepEmptySourceDirs {
+		removeEmptiedSourceDirs(sourceDirs, movedFiles, opts.LoggerOrDefault())
+	}
+}
+
+// runStreaming sorts the files of sourceDirs while they are scanned (see Options.StreamScan) and
+// finishes the run like RunApplicationLogicSources.
+func runStreaming(ctx context.Context, sourceDirs []string, targetBaseDir string, opts Options, reportFilePath string, targetIndex *pkg.TargetIndex, indexPath string) (summary pkg.ReportSummary, err error) {
+	summary.Duplicates = []pkg.DuplicateInfo{}
+	summary.Quarantined = []pkg.QuarantineInfo{}
+	summary.Skipped = []pkg.SkippedInfo{}
+	summary.CopiedByExtension = make(map[string]int)
+	summary.DuplicatesByExtension = make(map[string]int)
+	addScanSkips(ctx, sourceDirs, targetBaseDir, opts, &summary)
+
+	var walkErr error
+	files := streamSourceFiles(ctx, sourceDirs, targetBaseDir, opts, &walkErr)
+	// Sources already in the target under another extension are recorded once sorting is done, as the
+	// files may be iterated by a hash prefetching goroutine.
+	var extensionDuplicate func(string) (pkg.DuplicateInfo, bool)
+	if opts.PreferExtensionOrder != nil && targetIndex != nil {
+		extensionDuplicate = targetIndex.ExtensionDuplicateFunc(targetBaseDir, opts.PreferExtensionOrder, opts.LoggerOrDefault())
+	}
+	var indexedDuplicates []pkg.DuplicateInfo
+	counted := func(yield func(string) bool) {
+		for path := range files {
+			summary.ProcessedFilesCount++
+			if extensionDuplicate != nil {
+				if dup, ok := extensionDuplicate(path); ok {
+					indexedDuplicates = append(indexedDuplicates, dup)
+					continue
+				}
+			}
+			if !yield(path) {
+				return
+			}
+		}
+	}
+	processingStart := time.Now()
+	sourceFilesThatUsedFileHash, keptFileSourceToTargetMap, processingErrors, _ := processImageFiles(ctx, counted, 0, sourceDirs, nil, targetBaseDir, opts, &summary)
+	for _, dup := range indexedDuplicates {
+		recordSourceDuplicate(dup, opts, &summary)
+	}
+	if walkErr != nil {
+		if ctx.Err() == nil {
+			return summary, walkErr
+		}
+		summary.Interrupted = true
+	}
+	if summary.ProcessedFilesCount == 0 {
+		opts.LoggerOrDefault().Info("No image files found in source directory", "dir", strings.Join(sourceDirs, ", "))
+	}
+	cleanUpSources(sourceDirs, keptFileSourceToTargetMap, opts)
+	return finishRun(ctx, summary, processingStart, sourceFilesThatUsedFileHash, keptFileSourceToTargetMap, processingErrors, reportFilePath, targetIndex, indexPath, targetBaseDir, opts)
+}
+
+// finishRun records the results of processImageFiles in summary, saves the known hashes and the target
+// index and writes the report.
+func finishRun(ctx context.Context, summary pkg.ReportSummary, processingStart time.Time, sourceFilesThatUsedFileHash map[string]bool, keptFileSourceToTargetMap map[string]string, processingErrors []error, reportFilePath string, targetIndex *pkg.TargetIndex, indexPath string, targetBaseDir string, opts Options) (pkg.ReportSummary, error) {
+	logger := opts.LoggerOrDefault()
+	summary.ElapsedSeconds = time.Since(processingStart).Seconds()
+	if summary.ElapsedSeconds > 0 {
+		summary.FilesPerSecond = float64(summary.ProcessedFilesCount) / summary.ElapsedSeconds
+	}
 
 	// Log any non-critical processing errors encountered during the loop
-	if len(processingErrors) > 0 && verbose {
-		log.Printf("Encountered %d non-critical errors during file processing:", len(processingErrors))
+	summary.ProcessingErrorCount = len(processingErrors)
+	if len(processingErrors) > 0 {
+		logger.Warn("Encountered non-critical errors during file processing", "count", len(processingErrors))
 		for _, procErr := range processingErrors {
-			log.Printf("  - %v", procErr)
+			logger.Debug("Processing error", "error", procErr)
 		}
 	}
 
-	pixelHashUnsupportedCount = len(sourceFilesThatUsedFileHash)
-	filesToCopyCount = copiedFilesCount // As copying is done file-by-file
+	// Accumulate rather than assign, so that RunWatch's report also counts the files of its initial sort.
+	summary.PixelHashUnsupportedCount += len(sourceFilesThatUsedFileHash)
+	summary.FilesToCopyCount = summary.CopiedFilesCount // As copying is done file-by-file
 
-	err = generateFinalReport(reportFilePath, duplicatesList, copiedFilesCount, processedFilesCount, filesToCopyCount, pixelHashUnsupportedCount, keptFileSourceToTargetMap, verbose)
-	if err != nil {
+	if opts.KnownHashes != nil && opts.UpdateKnownHashes {
+		if err := appendNewKnownHashes(opts.KnownHashesFile, opts.KnownHashes, logger); err != nil {
+			return summary, err
+		}
+	}
+
+	if targetIndex != nil {
+		if err := updateTargetIndex(targetIndex, indexPath, targetBaseDir, keptFileSourceToTargetMap, opts.FileModeOrDefault(), logger); err != nil {
+			return summary, err
+		}
+	}
+
+	if err := generateFinalReport(reportFilePath, summary, keptFileSourceToTargetMap, opts); err != nil {
 		// Return all collected information up to this point, plus the report generation error
-		return processedFilesCount, copiedFilesCount, filesToCopyCount, duplicatesList, pixelHashUnsupportedCount, fmt.Errorf("failed to generate final report: %w", err)
+		return summary, fmt.Errorf("failed to generate final report: %w", err)
 	}
 
-	return processedFilesCount, copiedFilesCount, filesToCopyCount, duplicatesList, pixelHashUnsupportedCount, nil
+	if summary.Interrupted {
+		return summary, ctx.Err()
+	}
+	return summary, nil
 }
 
 // displayHelpInfo prints usage, options, and license information.
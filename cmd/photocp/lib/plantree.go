@@ -0,0 +1,37 @@
+package photocp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// PrintPlanTree plans sorting sourceDirs into targetBaseDir with opts (see pkg.PlanImport) and writes
+// the target directory tree the run would fill, with the number of files per directory, to w and,
+// unless treeFile is empty, to treeFile. Nothing is copied and no target directory is created.
+// Each source directory is planned on its own, so name collisions between them are not foreseen.
+func PrintPlanTree(w io.Writer, sourceDirs []string, targetBaseDir string, opts Options, treeFile string) error {
+	var plan pkg.ImportPlan
+	for _, sourceDir := range sourceDirs {
+		dirPlan, err := pkg.PlanImport(sourceDir, targetBaseDir, opts)
+		if err != nil {
+			return fmt.Errorf("failed to plan %s: %w", sourceDir, err)
+		}
+		plan.Entries = append(plan.Entries, dirPlan.Entries...)
+	}
+
+	var tree bytes.Buffer
+	if err := plan.WriteTree(&tree, targetBaseDir); err != nil {
+		return err
+	}
+	if treeFile != "" {
+		if err := os.WriteFile(treeFile, tree.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write plan tree to %s: %w", treeFile, err)
+		}
+	}
+	_, err := w.Write(tree.Bytes())
+	return err
+}
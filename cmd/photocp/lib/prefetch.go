@@ -0,0 +1,79 @@
+package photocp
+
+import (
+	"iter"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// prefetchedHashes are the hashes of a source file that a hash worker computes; done is closed when they are.
+type prefetchedHashes struct {
+	hashes *pkg.FileHashes
+	done   chan struct{}
+}
+
+// prefetchHashes yields the paths of files in order, each with its hashes. With opts.HashWorkers above 1,
+// that many goroutines compute the hashes of up to twice as many upcoming files (see FileHashes.Precompute)
+// while the caller sorts earlier ones. Otherwise the hashes are nil and computed as the file is sorted.
+// files is iterated on a goroutine of its own, which has finished when the returned sequence stops.
+func prefetchHashes(files iter.Seq[string], opts Options) iter.Seq2[string, *pkg.FileHashes] {
+	workers := opts.HashWorkers
+	return func(yield func(string, *pkg.FileHashes) bool) {
+		if workers <= 1 {
+			for path := range files {
+				if !yield(path, nil) {
+					return
+				}
+			}
+			return
+		}
+
+		jobs := make(chan prefetchedHashes)
+		queue := make(chan prefetchedHashes, 2*workers) // In file order, so results are yielded in order
+		stop := make(chan struct{})
+		producerDone := make(chan struct{})
+		for range workers {
+			go func() {
+				for job := range jobs {
+					job.hashes.Precompute()
+					close(job.done)
+				}
+			}()
+		}
+		go func() {
+			defer close(producerDone)
+			defer close(queue)
+			defer close(jobs)
+			for path := range files {
+				job := prefetchedHashes{hashes: pkg.NewFileHashes(path), done: make(chan struct{})}
+				// As sortFile sets them, so that Precompute computes the hashes the comparisons need.
+				job.hashes.ExifPrefilter = opts.ExifPrefilter
+				job.hashes.FastPixelHash = opts.FastPixelHash
+				job.hashes.ExifSignatureFields = opts.ExifSignatureFields
+				job.hashes.CompareAlgorithm = opts.CompareAlgorithm
+				job.hashes.OpenFiles = opts.OpenFiles
+				select {
+				case queue <- job:
+				case <-stop:
+					return
+				}
+				select {
+				case jobs <- job:
+				case <-stop:
+					return
+				}
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-producerDone // files may update state that the caller reads once the sequence stops
+		}()
+
+		for job := range queue {
+			<-job.done
+			if !yield(job.hashes.Path, job.hashes) {
+				return
+			}
+		}
+	}
+}
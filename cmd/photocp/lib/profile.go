@@ -0,0 +1,57 @@
+package photocp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// StartProfiles starts writing a CPU profile to cpuProfile and prepares a heap profile for memProfile.
+// Either path may be empty to skip that profile. The returned stop function stops the CPU profile and
+// writes the heap profile; call it once the profiled work is done, whether or not it succeeded.
+func StartProfiles(cpuProfile, memProfile string) (stop func() error, err error) {
+	var cpuFile *os.File
+	if cpuProfile != "" {
+		cpuFile, err = os.Create(cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile '%s': %w", cpuProfile, err)
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+	}
+
+	return func() error {
+		var errs []error
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to write CPU profile '%s': %w", cpuProfile, err))
+			}
+		}
+		if memProfile != "" {
+			errs = append(errs, writeHeapProfile(memProfile))
+		}
+		return errors.Join(errs...)
+	}, nil
+}
+
+// writeHeapProfile writes a heap profile reflecting all allocations made so far to path.
+func writeHeapProfile(path string) error {
+	memFile, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create memory profile '%s': %w", path, err)
+	}
+	runtime.GC() // Get up-to-date statistics
+	if err := pprof.WriteHeapProfile(memFile); err != nil {
+		memFile.Close()
+		return fmt.Errorf("failed to write memory profile '%s': %w", path, err)
+	}
+	if err := memFile.Close(); err != nil {
+		return fmt.Errorf("failed to write memory profile '%s': %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package photocp
+
+import (
+	"fmt"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// recordOrphanedSidecars adds the sidecars of the source at sourcePath, which was not copied, to
+// summary.OrphanedSidecars if opts.CopySidecars is set. reason tells why the source was not copied.
+func recordOrphanedSidecars(sourcePath string, reason string, opts Options, summary *pkg.ReportSummary) {
+	if !opts.CopySidecars {
+		return
+	}
+	for _, sidecar := range pkg.SidecarFiles(sourcePath) {
+		summary.OrphanedSidecars = append(summary.OrphanedSidecars, pkg.OrphanedSidecar{SidecarFile: sidecar, SourceFile: sourcePath, Reason: reason})
+	}
+}
+
+// notCopiedReason describes why a source with the given outcome and processing error was not copied.
+func notCopiedReason(outcome pkg.FileOutcome, err error) string {
+	switch {
+	case err != nil:
+		return fmt.Sprintf("Image not sorted - %v", err)
+	case outcome.Duplicate != nil:
+		return duplicateReason(*outcome.Duplicate)
+	case outcome.SkipReason != "":
+		return outcome.SkipReason
+	}
+	return "Image not copied"
+}
+
+// duplicateReason describes a source discarded as the duplicate dup.
+func duplicateReason(dup pkg.DuplicateInfo) string {
+	return fmt.Sprintf("%s, kept %s", dup.ReasonText(), dup.KeptFile)
+}
@@ -0,0 +1,79 @@
+package photocp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// SourceDirsFlag collects the values of a repeatable -sourceDir flag. Each value may also be a
+// comma-separated list of sources, unless it is the path of an existing file or directory.
+type SourceDirsFlag []string
+
+// String implements flag.Value.
+func (f *SourceDirsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+// Set implements flag.Value, adding the sources in value.
+func (f *SourceDirsFlag) Set(value string) error {
+	if _, err := os.Stat(strings.TrimSpace(value)); err == nil { // e.g. "Holiday, Rome"
+		*f = append(*f, strings.TrimSpace(value))
+		return nil
+	}
+	for _, dir := range strings.Split(value, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			*f = append(*f, dir)
+		}
+	}
+	return nil
+}
+
+// ExpandSourceDirs expands the glob patterns among sourceDirs, e.g. /media/*/DCIM for the DCIM folders
+// of all mounted cards, into the paths they match. Other entries, and existing paths that only look like
+// patterns, e.g. "Photos [2023]", are returned unchanged. A pattern that matches nothing is an error.
+func ExpandSourceDirs(sourceDirs []string) ([]string, error) {
+	var expanded []string
+	for _, dir := range sourceDirs {
+		if _, err := os.Stat(dir); err == nil || !strings.ContainsAny(dir, "*?[") {
+			expanded = append(expanded, dir)
+			continue
+		}
+		matches, err := filepath.Glob(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source pattern '%s': %w", dir, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("source pattern '%s' matches nothing", dir)
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// listSourceDir returns the deepest directory that contains all of files, which stands in for the source
+// directory of a source list given without one, e.g. for -preserveSubdir and for cleaning up after -move.
+// Without files, it returns the directory of listPath.
+func listSourceDir(files []string, listPath string) string {
+	root := ""
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if root == "" {
+			root = dir
+			continue
+		}
+		for dir != root && !pkg.WithinDirectory(dir, root) && filepath.Dir(root) != root {
+			root = filepath.Dir(root)
+		}
+	}
+	if root == "" {
+		return filepath.Dir(listPath)
+	}
+	return root
+}
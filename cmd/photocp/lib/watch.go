@@ -0,0 +1,241 @@
+package photocp
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// DefaultWatchSettle is how long the size of a new file must stay the same before RunWatch sorts it.
+const DefaultWatchSettle = 2 * time.Second
+
+// RunWatch sorts sourceDirs into targetBaseDir like RunApplicationLogicSources, then keeps watching the
+// source directories for new and renamed image files until ctx is cancelled. A file is sorted with
+// pkg.SortFile once its size and modification time have not changed for settle (DefaultWatchSettle if 0),
+// so files that are still being written are left alone. Files modified while the initial sort ran are
+// picked up as well. When watching stops, the report is written again, covering the initial sort and
+// every file sorted since. Cancelling ctx ends watching normally; summary.Interrupted is only set when the
+// initial sort was interrupted. .zip archives and sorting in place cannot be watched.
+func RunWatch(ctx context.Context, sourceDirs []string, targetBaseDir string, opts Options, settle time.Duration) (summary pkg.ReportSummary, err error) {
+	if settle <= 0 {
+		settle = DefaultWatchSettle
+	}
+	for _, dir := range sourceDirs {
+		if pkg.IsZipSource(dir) {
+			return summary, fmt.Errorf("the .zip archive '%s' cannot be watched", dir)
+		}
+		if sameDirectory(dir, targetBaseDir) {
+			return summary, fmt.Errorf("source directory '%s' is the target directory, which cannot be watched", dir)
+		}
+	}
+
+	watchStart := time.Now()
+	summary, err = RunApplicationLogicSources(ctx, sourceDirs, targetBaseDir, opts)
+	if err != nil || summary.Interrupted {
+		return summary, err
+	}
+	opts.Logger = opts.LoggerOrDefault()
+	logger := opts.Logger
+	if err := loadRunFiles(&opts); err != nil {
+		return summary, err
+	}
+	if opts.SourceDirs == nil {
+		opts.SourceDirs = sourceDirs
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return summary, fmt.Errorf("failed to watch the source directories: %w", err)
+	}
+	defer fsWatcher.Close()
+	w := &sourceWatcher{fsWatcher: fsWatcher, sourceDirs: sourceDirs, targetBaseDir: targetBaseDir, opts: opts, settle: settle, pending: make(map[string]*pendingFile)}
+	for _, dir := range sourceDirs {
+		if err := w.addTree(dir); err != nil {
+			return summary, err
+		}
+		w.catchUp(ctx, dir, watchStart) // Files that arrived while the initial sort ran
+	}
+	logger.Info("Watching for new files", "source", strings.Join(sourceDirs, ", "), "settle", settle)
+
+	indexPath := filepath.Join(targetBaseDir, pkg.IndexFileName)
+	var targetIndex *pkg.TargetIndex
+	if _, statErr := os.Stat(indexPath); opts.MaintainIndex || statErr == nil {
+		if targetIndex, err = loadTargetIndex(indexPath, targetBaseDir, logger); err != nil {
+			return summary, err
+		}
+	}
+
+	// The elapsed time and throughput of the report cover the initial sort as well.
+	processingStart := time.Now().Add(-time.Duration(summary.ElapsedSeconds * float64(time.Second)))
+	counted := func(yield func(string) bool) {
+		for path := range w.files(ctx) {
+			summary.ProcessedFilesCount++
+			if !yield(path) {
+				return
+			}
+		}
+	}
+	// Watching ends when ctx is cancelled, which is not an interruption of the files being sorted.
+	sourceFilesThatUsedFileHash, keptFileSourceToTargetMap, processingErrors, _ := processImageFiles(context.WithoutCancel(ctx), counted, 0, sourceDirs, nil, targetBaseDir, opts, &summary)
+	logger.Info("Stopped watching for new files", "source", strings.Join(sourceDirs, ", "))
+	cleanUpSources(sourceDirs, keptFileSourceToTargetMap, opts)
+	return finishRun(ctx, summary, processingStart, sourceFilesThatUsedFileHash, keptFileSourceToTargetMap, processingErrors, reportPath(targetBaseDir, opts, time.Now()), targetIndex, indexPath, targetBaseDir, opts)
+}
+
+// pendingFile is a file seen by a sourceWatcher that has not been sorted yet.
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // When size or modTime last changed
+}
+
+// sourceWatcher collects the files that appear in the source directories of RunWatch.
+type sourceWatcher struct {
+	fsWatcher     *fsnotify.Watcher
+	sourceDirs    []string
+	targetBaseDir string
+	opts          Options
+	settle        time.Duration
+	pending       map[string]*pendingFile
+}
+
+// addTree watches dir and its subdirectories down to opts.MaxDepth, except the target directory.
+func (w *sourceWatcher) addTree(dir string) error {
+	root := sourceRoot(dir, w.sourceDirs)
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil // Unreadable entries are skipped, as by the scan of the source
+		}
+		if sameDirectory(path, w.targetBaseDir) || (w.opts.MaxDepth > 0 && dirDepth(root, path) >= w.opts.MaxDepth) {
+			return filepath.SkipDir
+		}
+		if err := w.fsWatcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch '%s': %w", path, err)
+		}
+		return nil
+	})
+}
+
+// catchUp tracks the images in dir that were modified at or after since, except those in the target directory.
+func (w *sourceWatcher) catchUp(ctx context.Context, dir string, since time.Time) {
+	maxDepth := w.opts.MaxDepth
+	if maxDepth > 0 {
+		if maxDepth -= dirDepth(sourceRoot(dir, w.sourceDirs), dir); maxDepth <= 0 {
+			return
+		}
+	}
+	err := pkg.WalkSourceImagesContext(ctx, dir, maxDepth, w.opts.SniffExtensionless, func(path string) error {
+		if pkg.WithinDirectory(path, w.targetBaseDir) {
+			return nil
+		}
+		if info, err := os.Stat(path); err == nil && !info.ModTime().Before(since) {
+			w.track(path)
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		w.opts.LoggerOrDefault().Warn("Could not scan for new files", "dir", dir, "error", err)
+	}
+}
+
+// track adds path to the pending files, restarting its settle time.
+func (w *sourceWatcher) track(path string) {
+	w.pending[path] = &pendingFile{size: -1, since: time.Now()}
+}
+
+// handle tracks the file created, renamed into place or written by event. New directories are watched
+// and the files already in them tracked.
+func (w *sourceWatcher) handle(ctx context.Context, event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return // The old name of a renamed file is gone, and removals and permission changes need nothing
+	}
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		w.track(event.Name)
+		return
+	}
+	if err := w.addTree(event.Name); err != nil {
+		w.opts.LoggerOrDefault().Warn("Could not watch new directory", "dir", event.Name, "error", err)
+		return
+	}
+	w.catchUp(ctx, event.Name, time.Time{})
+}
+
+// ready returns the pending images whose size and modification time have not changed for w.settle,
+// in sorted order, and stops tracking them. Pending files that are gone or are not images are dropped.
+func (w *sourceWatcher) ready() []string {
+	now := time.Now()
+	var paths []string
+	for path, file := range w.pending {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			delete(w.pending, path)
+			continue
+		}
+		if info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+			file.size, file.modTime, file.since = info.Size(), info.ModTime(), now
+			continue
+		}
+		if now.Sub(file.since) < w.settle {
+			continue
+		}
+		delete(w.pending, path)
+		if pkg.IsSourceImage(path, w.opts.SniffExtensionless) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// files yields the new images in the source directories as they become ready, until ctx is cancelled.
+func (w *sourceWatcher) files(ctx context.Context) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		ticker := time.NewTicker(w.settle / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-w.fsWatcher.Events:
+				if !ok {
+					return
+				}
+				w.handle(ctx, event)
+			case err, ok := <-w.fsWatcher.Errors:
+				if !ok {
+					return
+				}
+				w.opts.LoggerOrDefault().Warn("Error while watching the source", "error", err)
+			case <-ticker.C:
+				for _, path := range w.ready() {
+					if ctx.Err() != nil || !yield(path) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// dirDepth returns the number of path components of dir below root; root itself has depth 0.
+func dirDepth(root, dir string) int {
+	relPath, err := filepath.Rel(root, dir)
+	if err != nil || relPath == "." {
+		return 0
+	}
+	return strings.Count(relPath, string(filepath.Separator)) + 1
+}
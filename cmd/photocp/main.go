@@ -1,24 +1,114 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/user/photo-sorter/cmd/photocp/lib"
+	"github.com/user/photo-sorter/pkg"
 )
 
 func main() {
 	// --- Command-line flags ---
-	sourceDirFlag := flag.String("sourceDir", "", "Source directory containing photos to sort (e.g., common formats like JPG, PNG, GIF, HEIC, and various RAW types) (required)")
+	var sourceDirFlag photocp.SourceDirsFlag
+	flag.Var(&sourceDirFlag, "sourceDir", "Source directory containing photos to sort (e.g., common formats like JPG, PNG, GIF, WebP, HEIC, and various RAW types), or a .zip archive of them (required). Repeat it, or give a comma-separated list or a glob pattern such as '/media/*/DCIM', to sort several sources together.")
+	fromListFlag := flag.String("fromList", "", "File listing the source files to sort, one path per line, instead of scanning -sourceDir; listed files that are missing or not images are reported as skipped. -sourceDir is then optional (optional)")
 	targetDirFlag := flag.String("targetDir", "", "Target directory to store sorted photos (required)")
-	verboseFlag := flag.Bool("verbose", false, "Enable verbose output for detailed processing information.")
+	verboseFlag := flag.Bool("verbose", false, "Enable verbose output for detailed processing information (same as -logLevel debug).")
+	logLevelFlag := flag.String("logLevel", "info", "Minimum level of log messages: debug, info, warn or error.")
+	logJSONFlag := flag.Bool("logJSON", false, "Write log messages as JSON objects, one per line.")
+	jsonlFlag := flag.String("jsonl", "", "Write a JSON object with the fields path, target, action, reason and dateSource for every source file to this file as soon as it is sorted, or to standard output with - (everything else printed then goes to standard error; not with -machineLog) (optional)")
+	machineLogFlag := flag.Bool("machineLog", false, "Also print a single grep-able line for every duplicate (DUP action=... reason=... kept=... discarded=...) and skipped file (SKIP reason=... file=...), whatever the -logLevel.")
+	flattenFlag := flag.Bool("flatten", false, "Write all files directly into the target directory instead of YYYY/MM subfolders.")
+	structureFlag := flag.String("structure", "", "Target directory structure preset: year, year-month (default), year-month-day, week (ISO weeks, e.g. 2023/W43) or flat.")
+	monthNameFormatFlag := flag.String("monthNameFormat", "", "How month directories of the -structure preset are named: number (2023/10, the default), short (2023-Oct) or long (2023-October). Cannot be combined with -layout.")
+	layoutFlag := flag.String("layout", "", "Custom target directory layout as a Go time layout with '/' between levels, e.g. 2006/01-Jan. Cannot be combined with -structure or -flatten.")
+	filenameFormatFlag := flag.String("filenameFormat", pkg.DefaultFilenameFormat, "Go time layout used for target file names (e.g. 20060102_150405). Must not contain path separators.")
+	preserveSubdirFlag := flag.Bool("preserveSubdir", false, "Keep the directory of each source file relative to -sourceDir as a subfolder of its date directory, e.g. 2023/10/Birthday for Birthday/a.jpg (optional)")
+	targetPathTransformFlag := flag.String("targetPathTransform", "", "Sort files into directories chosen by a built-in rule instead of the date layout: camera (by EXIF make and model, e.g. Canon/EOS R5) or orientation (landscape, portrait or square). File names still follow -filenameFormat or -renameTemplate (optional)")
+	filePrefixFlag := flag.String("filePrefix", "", "Text added before the generated name of each target file, e.g. import2023_ (optional)")
+	fileSuffixFlag := flag.String("fileSuffix", "", "Text added after the generated name of each target file, before the extension and any -N version number (optional)")
+	renameTemplateFlag := flag.String("renameTemplate", "", "Template for target file names that replaces -filenameFormat, e.g. {date:2006-01-02}_{make}_{model}_{orig}{seq}. Tokens: {date} or {date:layout}, {make}, {model}, {orig} (source name without extension) and {seq} (where -N versions are numbered; only at the end). Must contain {date} or {orig} (optional)")
+	normalizeUnicodeFlag := flag.Bool("normalizeUnicode", false, "Convert target file names to Unicode NFC, so that names written decomposed (NFD) on macOS and composed on Linux map to the same target path.")
+	maxDepthFlag := flag.Int("maxDepth", 0, "Maximum directory depth to scan below the source directory (1 = only files directly in it, 0 = unlimited).")
+	maxOpenFilesFlag := flag.Int("maxOpenFiles", pkg.DefaultMaxOpenFiles, "Maximum number of files that hashing and copying hold open at once; 0 means no limit. Files that cannot be opened because the file descriptors are exhausted are retried.")
+	hashWorkersFlag := flag.Int("hashWorkers", 1, "Number of goroutines that hash upcoming source files while files are copied one at a time, e.g. the number of CPU cores (optional)")
+	streamScanFlag := flag.Bool("streamScan", false, "Start sorting while the source is still being scanned instead of scanning it completely first, for sources with millions of files. Files are then sorted in scan order, and the free space check and the grouping of identical source files are skipped.")
+	maxFilesPerDirFlag := flag.Int("maxFilesPerDir", 0, "Maximum number of files in a date directory; further files go into -2, -3, ... sibling directories (e.g. 2023/10-2). 0 means unlimited. Cannot be combined with a flat structure.")
+	quarantineDirFlag := flag.String("quarantineDir", "", "Directory to copy source files that fail processing into, preserving their relative source path (optional)")
+	knownHashesFlag := flag.String("knownHashes", "", "File with newline-delimited SHA-256 hashes of already archived files; matching sources are skipped (optional)")
+	updateKnownHashesFlag := flag.Bool("updateKnownHashes", false, "Append the hashes of newly copied files to the -knownHashes file.")
+	unknownDateDirFlag := flag.String("unknownDateDir", "", "Directory below the target directory (e.g. undated) for files without a date override or EXIF date; they keep their original name instead of being sorted by modification time (optional)")
+	minPlausibleYearFlag := flag.Int("minPlausibleYear", pkg.DefaultMinPlausibleYear, "Ignore EXIF, XMP and file name dates before this year or in the future, e.g. the 1970 of a camera whose clock was reset, and date such files by the next source (optional)")
+	dateStrategyFlag := flag.String("dateStrategy", string(pkg.DateStrategyExifFirst), "How to pick a photo's date from its EXIF date, a date in its file name and its modification time: exifFirst (EXIF, then modification time), filenameFirst (file name, then EXIF, then modification time), earliest or latest (of all available dates).")
+	dateOverridesFlag := flag.String("dateOverrides", "", "CSV file of filename,date rows whose dates replace the EXIF date and modification time of matching source files (optional)")
+	copyBufferSizeFlag := flag.String("copyBufferSize", "", "Size of the buffer used to copy files, e.g. 4m or 512k. Larger buffers can speed up copies to network targets (default: Go's io.Copy buffer).")
+	copyRetriesFlag := flag.Int("copyRetries", 0, "How many times to retry a copy that fails with a transient error, e.g. an I/O error on a network share.")
+	copyRetryDelayFlag := flag.Duration("copyRetryDelay", pkg.DefaultCopyRetryDelay, "Wait before the first copy retry; it doubles for each further retry.")
+	sniffExtensionlessFlag := flag.Bool("sniffExtensionless", false, "Also import files without an extension whose content is a JPEG, PNG, GIF, WebP or HEIC image, adding the detected extension.")
+	collapseBurstsFlag := flag.Bool("collapseBursts", false, "Of each burst of near-identical shots taken at most -burstWindow apart (by EXIF date), sort only the highest-resolution, sharpest one and report the others as burst_collapsed duplicates.")
+	burstWindowFlag := flag.Duration("burstWindow", pkg.DefaultBurstWindow, "Longest time between consecutive shots of a burst for -collapseBursts.")
+	autoRotateFlag := flag.Bool("autoRotate", false, "Write JPEG and PNG images upright according to their EXIF orientation instead of copying them, resetting the orientation. Other files are copied as usual. Cannot be combined with -move or -hardlink.")
+	convertHeicFlag := flag.Bool("convertHeicToJpeg", false, "Convert .heic/.heif sources to .jpg files in the target, keeping their EXIF data where possible. Other files are copied as usual. Cannot be combined with -move, so the HEIC originals are kept.")
+	dirModeFlag := flag.String("dirMode", "0755", "Octal permission mode of the directories created in the target directory, regardless of the umask.")
+	fileModeFlag := flag.String("fileMode", "0644", "Octal permission mode of the files copied to the target directory, regardless of the umask. Moved and hard-linked files keep their permissions.")
+	preserveTimesFlag := flag.Bool("preserveTimes", false, "Give copied files the modification and access times of their source files.")
+	preferExtensionOrderFlag := flag.String("preferExtensionOrder", "", "Comma-separated extensions in order of preference, e.g. jpg,jpeg,png: of identical source files, the one with the first extension is kept, and with an index, sources already in the target under an extension that is not less preferred are discarded (optional)")
+	indexFlag := flag.Bool("index", false, "Maintain a content index (index.json) in the target directory. An existing index is always kept up to date.")
+	conflictStrategyFlag := flag.String("conflictStrategy", pkg.ConflictKeepTarget, "What to do when a target name is taken by a different file: keepTarget (discard the source), keepSource (overwrite the target), version (copy the source to a -N name) or skip (discard the source without reporting it).")
+	preferNewerFlag := flag.Bool("preferNewer", false, "Replace an existing target with a duplicate source that has a later EXIF date or, failing that, is larger. Images with identical pixels count as duplicates even if their EXIF data differs.")
+	preferLargerFileFlag := flag.Bool("preferLargerFile", false, "Replace an existing target with a pixel-identical source of the same resolution if the source file is larger (usually less compressed).")
+	skipExistingTargetFastFlag := flag.Bool("skipExistingTargetFast", false, "Skip a source without comparing its content when its target path already holds a file of the same size. Faster re-imports, at the risk of skipping a different photo of the same name and size.")
+	noOverwriteFlag := flag.Bool("noOverwrite", false, "Never replace a file in the target directory, not even with a higher-resolution duplicate; such sources are copied as a -N version with -conflictStrategy version and discarded otherwise. Cannot be combined with -conflictStrategy keepSource or -preferNewer.")
+	moveFlag := flag.Bool("move", false, "Move files into the target directory instead of copying them; duplicates and skipped files stay in the source. Implied when -sourceDir and -targetDir are the same directory.")
+	cleanupSourceFlag := flag.Bool("cleanupSource", true, "With -move, remove the source directories that the run emptied by moving their files out. The source directory itself and directories that still contain anything are kept. Use -cleanupSource=false to keep them.")
+	hardlinkFlag := flag.Bool("hardlink", false, "Hard link files into the target directory instead of copying them, falling back to a copy across filesystems; other link failures are reported as errors. Ignored with -move.")
+	ignoreSpaceCheckFlag := flag.Bool("ignoreSpaceCheck", false, "Start even if the source files may not fit into the free space of the target filesystem.")
+	onCopyFlag := flag.String("onCopy", "", "Command to run after each file is copied, e.g. \"exiftool -overwrite_original -Artist=Me {dst}\". {src} and {dst} are replaced by the source and target paths. Not run for duplicates; failures are logged (optional)")
+	copySidecarsFlag := flag.Bool("copySidecars", false, "Copy the .xmp, .aae and .json sidecar files of each copied image next to its target, named after it, and list the sidecars of images that were not copied in the report (optional)")
+	reportPathFlag := flag.String("reportPath", "", "Write the report to this file instead of report.txt in the target directory; its directory is created if needed (optional)")
+	timestampReportFlag := flag.Bool("timestampReport", false, "Name the report in the target directory after the start of the run (report-20060102-150405.txt) instead of overwriting report.txt. Cannot be combined with -reportPath.")
+	histogramThresholdFlag := flag.Float64("histogramThreshold", 0, "Treat a source image as a duplicate of the different image at its target path if their color histograms are at least this similar (0-1, e.g. 0.9), so slightly cropped copies are not imported twice. 0 (the default) disables the check.")
+	fastPixelHashFlag := flag.Bool("fastPixelHash", false, "Tell images apart by the pixel hash of a downscaled copy before hashing all their pixels. Images whose downscaled copies match are still compared by their full pixel hash, so results are the same, only faster when most images differ (optional)")
+	exifSignatureFieldsFlag := flag.String("exifSignatureFields", strings.Join(pkg.DefaultExifSignatureFields, ","), "Comma-separated EXIF tags whose values make up the EXIF signature that images are first compared by (optional)")
+	compareAlgorithmFlag := flag.String("compareAlgorithm", pkg.CompareFull, "Which signals decide whether a source image duplicates its target: full (EXIF signature, then pixel hash, then file hash), pixelOnly, fileOnly or exifOnly.")
+	exifPrefilterFlag := flag.Bool("exifPrefilter", false, "Compare images with their target by EXIF signature and file size before decoding them; images that differ in either are not duplicates. Faster for large libraries, but misses pixel-identical duplicates of a different file size.")
+	strictReportFlag := flag.Bool("strictReport", false, "Fail the run if the report cannot be written. By default, the report is then written to a temporary file and the run succeeds.")
+	reportIncludeSkippedFlag := flag.Bool("reportIncludeSkipped", false, "Also list the source files that are not sorted because of their format (e.g. videos and documents) under \"Skipped files\" in the report.")
+	reportFormatFlag := flag.String("reportFormat", pkg.ReportFormatText, "Report format: text, or html to also write the duplicates with thumbnails of the kept and discarded images to report.html (optional)")
+	groupDuplicatesFlag := flag.Bool("groupDuplicates", false, "List duplicates in the report grouped by the file that was kept.")
+	watchFlag := flag.Bool("watch", false, "After sorting, keep watching -sourceDir for new files and sort each once it has stopped changing, until interrupted; the report is written on exit (optional)")
+	watchSettleFlag := flag.Duration("watchSettle", photocp.DefaultWatchSettle, "With -watch, how long a new file must stay unchanged before it is sorted (optional)")
+	dedupDirFlag := flag.String("dedup", "", "Find duplicates within this directory instead of importing; -sourceDir and -targetDir are not used.")
+	removeFlag := flag.Bool("remove", false, "With -dedup, delete the duplicates found (the highest-resolution copy is kept).")
+	cpuProfileFlag := flag.String("cpuprofile", "", "Write a CPU profile of the run to this file, for use with go tool pprof (optional)")
+	memProfileFlag := flag.String("memprofile", "", "Write a memory (heap) profile taken at the end of the run to this file, for use with go tool pprof (optional)")
+	listFormatsFlag := flag.Bool("listFormats", false, "List the supported image and video extensions, showing which images can be decoded for resolution and pixel hash comparison, and exit.")
+	planTreeFlag := flag.Bool("planTree", false, "Print the tree of target directories the run would fill, with the number of files that would land in each, and exit without copying anything (optional)")
+	planTreeFileFlag := flag.String("planTreeFile", "", "With -planTree, also write the tree to this file (optional)")
+	validateMimeFlag := flag.Bool("validateMime", false, "Check that the content of each source matches its extension, e.g. that a .jpg is not a PNG or a video, and handle mismatches per -mimeMismatch (optional)")
+	mimeMismatchFlag := flag.String("mimeMismatch", pkg.MimeMismatchFix, "With -validateMime, what to do with a source whose content does not match its extension: fix (give the target the extension of the content) or quarantine (fail the file, copying it to -quarantineDir if set)")
+	compareFlag := flag.Bool("compare", false, "Compare the two files given as arguments (photocp -compare <fileA> <fileB>) as a run would compare a source with its target, print the result and exit.")
+	jsonFlag := flag.Bool("json", false, "With -compare, print the result as JSON.")
 	helpFlg := flag.Bool("help", false, "Show help message and license information")
 	flag.Parse()
 
 	if *helpFlg {
-		fmt.Println("Usage: photocp -sourceDir <source_directory> -targetDir <target_directory> [-verbose]")
+		fmt.Println("Usage: photocp -sourceDir <source_directory> -targetDir <target_directory> [options]")
+		fmt.Println("       photocp -dedup <directory> [-remove]")
+		fmt.Println("       photocp -listFormats")
+		fmt.Println("       photocp -compare [-json] <fileA> <fileB>")
 		fmt.Println("\nOptions:")
 		flag.PrintDefaults() // Prints all defined flags, including -help
 		fmt.Println("\nLicense Information:")
@@ -35,6 +125,10 @@ func main() {
 		fmt.Println("    - Purpose: Used to decode HEIF/HEIC image files.")
 		fmt.Println("    - License: MIT License")
 		fmt.Println("    - Copyright: Copyright (c) Vinicius Egidio")
+		fmt.Println("  - x/image (golang.org/x/image)")
+		fmt.Println("    - Purpose: Used to decode WebP image files.")
+		fmt.Println("    - License: BSD 3-Clause License")
+		fmt.Println("    - Copyright: Copyright 2009 The Go Authors")
 		fmt.Println("\n  Indirect Dependencies:")
 		fmt.Println("    These libraries are included by direct dependencies or the testing framework.")
 		fmt.Println("  - go-spew (github.com/davecgh/go-spew)")
@@ -50,34 +144,354 @@ func main() {
 		os.Exit(0)
 	}
 
-	sourceDir := *sourceDirFlag
+	if *listFormatsFlag {
+		photocp.PrintFormats(os.Stdout)
+		return
+	}
+
+	if *compareFlag {
+		if flag.NArg() != 2 {
+			log.Fatal("Error: -compare expects exactly two files, e.g. photocp -compare a.jpg b.jpg")
+		}
+		if err := photocp.PrintComparison(os.Stdout, flag.Arg(0), flag.Arg(1), *jsonFlag); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+	if *jsonFlag {
+		log.Fatal("Error: -json can only be used with -compare.")
+	}
+
+	if *dedupDirFlag != "" {
+		runDedup(*dedupDirFlag, *removeFlag)
+		return
+	}
+	if *removeFlag {
+		log.Fatal("Error: -remove can only be used with -dedup.")
+	}
+	if *planTreeFileFlag != "" && !*planTreeFlag {
+		log.Fatal("Error: -planTreeFile can only be used with -planTree.")
+	}
+
+	sourceDirs, err := photocp.ExpandSourceDirs(sourceDirFlag)
+	if err != nil {
+		log.Fatalf("Error: invalid -sourceDir: %v", err)
+	}
 	targetBaseDir := *targetDirFlag
 	verbose := *verboseFlag
+	quarantineDir := *quarantineDirFlag
+	flatten := *flattenFlag
+	filenameFormat := *filenameFormatFlag
+	maxDepth := *maxDepthFlag
+	knownHashesFile := *knownHashesFlag
+	updateKnownHashes := *updateKnownHashesFlag
+	var copyBufferSize int64
 
 	// --- Validate Flags ---
-	if sourceDir == "" {
+	if len(sourceDirs) == 0 && *fromListFlag == "" {
 		log.Fatal("Error: -sourceDir flag is required.")
 	}
+	if *fromListFlag != "" && (*watchFlag || *planTreeFlag) {
+		log.Fatal("Error: -fromList cannot be combined with -watch or -planTree.")
+	}
 	if targetBaseDir == "" {
 		log.Fatal("Error: -targetDir flag is required.")
 	}
-
-	sourceInfo, err := os.Stat(sourceDir)
+	if err := pkg.ValidateFilenameFormat(filenameFormat); err != nil {
+		log.Fatalf("Error: invalid -filenameFormat: %v", err)
+	}
+	if err := pkg.ValidateRenameTemplate(*renameTemplateFlag); err != nil {
+		log.Fatalf("Error: invalid -renameTemplate: %v", err)
+	}
+	pathResolver, err := pkg.BuiltinPathResolver(*targetPathTransformFlag)
+	if err != nil {
+		log.Fatalf("Error: invalid -targetPathTransform: %v", err)
+	}
+	logLevel, err := pkg.ParseLogLevel(*logLevelFlag)
 	if err != nil {
-		if os.IsNotExist(err) {
-			log.Fatalf("Error: Source directory '%s' does not exist.", sourceDir)
+		log.Fatalf("Error: invalid -logLevel: %v", err)
+	}
+	if verbose && logLevel > slog.LevelDebug {
+		logLevel = slog.LevelDebug
+	}
+	logOutput := os.Stdout
+	var jsonLines io.Writer
+	if *jsonlFlag == "-" {
+		if *machineLogFlag {
+			log.Fatal("Error: -machineLog cannot be combined with -jsonl -, which keeps standard output for the JSON lines.")
+		}
+		// Everything else that is printed, such as the report path and the run summary, goes to
+		// standard error, so that standard output holds nothing but the JSON lines.
+		jsonLines, logOutput = os.Stdout, os.Stderr
+		os.Stdout = os.Stderr
+	} else if *jsonlFlag != "" {
+		jsonlFile, err := os.Create(*jsonlFlag)
+		if err != nil {
+			log.Fatalf("Error: invalid -jsonl: %v", err)
+		}
+		defer jsonlFile.Close()
+		jsonLines = jsonlFile
+	}
+	logger := pkg.NewLogger(logOutput, logLevel, *logJSONFlag)
+	var machineLog io.Writer
+	if *machineLogFlag {
+		machineLog = os.Stdout
+	}
+	if *structureFlag != "" && *layoutFlag != "" {
+		log.Fatal("Error: -structure and -layout cannot be used together.")
+	}
+	if *structureFlag != "" {
+		if _, err := pkg.StructureLayout(*structureFlag); err != nil {
+			log.Fatalf("Error: invalid -structure: %v", err)
+		}
+	}
+	if err := pkg.ValidateDirectoryLayout(*layoutFlag); err != nil {
+		log.Fatalf("Error: invalid -layout: %v", err)
+	}
+	if *monthNameFormatFlag != "" {
+		if *layoutFlag != "" {
+			log.Fatal("Error: -monthNameFormat cannot be combined with -layout; use Jan or January in the layout instead.")
+		}
+		if _, err := pkg.MonthNameLayout(pkg.DefaultDirectoryLayout, *monthNameFormatFlag); err != nil {
+			log.Fatalf("Error: invalid -monthNameFormat: %v", err)
+		}
+	}
+	if flatten && (*layoutFlag != "" || (*structureFlag != "" && *structureFlag != "flat")) {
+		log.Fatal("Error: -flatten cannot be combined with -layout or a -structure other than flat.")
+	}
+	if err := pkg.ValidateConflictStrategy(*conflictStrategyFlag); err != nil {
+		log.Fatalf("Error: invalid -conflictStrategy: %v", err)
+	}
+	if err := pkg.ValidateCompareAlgorithm(*compareAlgorithmFlag); err != nil {
+		log.Fatalf("Error: invalid -compareAlgorithm: %v", err)
+	}
+	if *burstWindowFlag <= 0 {
+		log.Fatal("Error: -burstWindow must be positive.")
+	}
+	dirMode, err := pkg.ParseFileMode(*dirModeFlag)
+	if err != nil {
+		log.Fatalf("Error: invalid -dirMode: %v", err)
+	}
+	fileMode, err := pkg.ParseFileMode(*fileModeFlag)
+	if err != nil {
+		log.Fatalf("Error: invalid -fileMode: %v", err)
+	}
+	if *maxOpenFilesFlag < 0 {
+		log.Fatal("Error: -maxOpenFiles must not be negative.")
+	}
+	if *hashWorkersFlag < 1 {
+		log.Fatal("Error: -hashWorkers must be at least 1.")
+	}
+	if *watchSettleFlag <= 0 {
+		log.Fatal("Error: -watchSettle must be positive.")
+	}
+	if err := pkg.ValidateReportFormat(*reportFormatFlag); err != nil {
+		log.Fatalf("Error: invalid -reportFormat: %v", err)
+	}
+	if err := pkg.ValidateMimeMismatchAction(*mimeMismatchFlag); err != nil {
+		log.Fatalf("Error: invalid -mimeMismatch: %v", err)
+	}
+	if err := pkg.ValidateDateStrategy(pkg.DateStrategy(*dateStrategyFlag)); err != nil {
+		log.Fatalf("Error: invalid -dateStrategy: %v", err)
+	}
+	if *minPlausibleYearFlag < 1 {
+		log.Fatalf("Error: invalid -minPlausibleYear: must be a positive year, got %d", *minPlausibleYearFlag)
+	}
+	if err := pkg.ValidateUnknownDateDir(*unknownDateDirFlag); err != nil {
+		log.Fatalf("Error: invalid -unknownDateDir: %v", err)
+	}
+	if *timestampReportFlag && *reportPathFlag != "" {
+		log.Fatal("Error: -timestampReport cannot be combined with -reportPath.")
+	}
+	if *copyRetriesFlag < 0 || *copyRetryDelayFlag < 0 {
+		log.Fatal("Error: -copyRetries and -copyRetryDelay must not be negative.")
+	}
+	if *convertHeicFlag && *moveFlag {
+		log.Fatal("Error: -convertHeicToJpeg cannot be combined with -move, which would delete the HEIC originals.")
+	}
+	if *histogramThresholdFlag < 0 || *histogramThresholdFlag > 1 {
+		log.Fatal("Error: -histogramThreshold must be between 0 and 1.")
+	}
+	if maxDepth < 0 {
+		log.Fatal("Error: -maxDepth must not be negative.")
+	}
+	if *maxFilesPerDirFlag < 0 {
+		log.Fatal("Error: -maxFilesPerDir must not be negative.")
+	}
+	if *maxFilesPerDirFlag > 0 && (flatten || *structureFlag == "flat") {
+		log.Fatal("Error: -maxFilesPerDir cannot be combined with a flat structure.")
+	}
+	if updateKnownHashes && knownHashesFile == "" {
+		log.Fatal("Error: -updateKnownHashes requires -knownHashes.")
+	}
+	if *copyBufferSizeFlag != "" {
+		var sizeErr error
+		copyBufferSize, sizeErr = pkg.ParseByteSize(*copyBufferSizeFlag)
+		if sizeErr != nil || copyBufferSize == 0 {
+			log.Fatalf("Error: invalid -copyBufferSize %q: expected a positive size such as 4m or 512k.", *copyBufferSizeFlag)
+		}
+	}
+
+	for _, sourceDir := range sourceDirs {
+		sourceInfo, err := os.Stat(sourceDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				log.Fatalf("Error: Source directory '%s' does not exist.", sourceDir)
+			}
+			log.Fatalf("Error: Could not stat source directory '%s': %v", sourceDir, err)
+		}
+		if !sourceInfo.IsDir() && !pkg.IsZipSource(sourceDir) {
+			log.Fatalf("Error: Source path '%s' is not a directory or .zip archive.", sourceDir)
 		}
-		log.Fatalf("Error: Could not stat source directory '%s': %v", sourceDir, err)
 	}
-	if !sourceInfo.IsDir() {
-		log.Fatalf("Error: Source path '%s' is not a directory.", sourceDir)
+
+	opts := photocp.Options{
+		Verbose:                verbose,
+		Logger:                 logger,
+		MachineLog:             machineLog,
+		JSONLines:              jsonLines,
+		FromList:               *fromListFlag,
+		CollapseBursts:         *collapseBurstsFlag,
+		BurstWindow:            *burstWindowFlag,
+		QuarantineDir:          quarantineDir,
+		Flatten:                flatten,
+		Structure:              *structureFlag,
+		Layout:                 *layoutFlag,
+		MonthNameFormat:        *monthNameFormatFlag,
+		FilenameFormat:         filenameFormat,
+		RenameTemplate:         *renameTemplateFlag,
+		FilePrefix:             *filePrefixFlag,
+		FileSuffix:             *fileSuffixFlag,
+		PathResolver:           pathResolver,
+		PreserveSubdir:         *preserveSubdirFlag,
+		NormalizeUnicode:       *normalizeUnicodeFlag,
+		MaxDepth:               maxDepth,
+		StreamScan:             *streamScanFlag,
+		HashWorkers:            *hashWorkersFlag,
+		MaxOpenFiles:           *maxOpenFilesFlag,
+		DirMode:                dirMode,
+		FileMode:               fileMode,
+		MaxFilesPerDir:         *maxFilesPerDirFlag,
+		KnownHashesFile:        knownHashesFile,
+		UpdateKnownHashes:      updateKnownHashes,
+		CopyBufferSize:         int(copyBufferSize),
+		CopyRetries:            *copyRetriesFlag,
+		CopyRetryDelay:         *copyRetryDelayFlag,
+		SniffExtensionless:     *sniffExtensionlessFlag,
+		ValidateMime:           *validateMimeFlag,
+		MimeMismatchAction:     *mimeMismatchFlag,
+		ReportPath:             *reportPathFlag,
+		TimestampReport:        *timestampReportFlag,
+		GroupDuplicates:        *groupDuplicatesFlag,
+		ReportIncludeSkipped:   *reportIncludeSkippedFlag,
+		StrictReport:           *strictReportFlag,
+		ReportFormat:           *reportFormatFlag,
+		ExifPrefilter:          *exifPrefilterFlag,
+		CompareAlgorithm:       *compareAlgorithmFlag,
+		ExifSignatureFields:    pkg.ParseExifSignatureFields(*exifSignatureFieldsFlag),
+		FastPixelHash:          *fastPixelHashFlag,
+		HistogramThreshold:     *histogramThresholdFlag,
+		MaintainIndex:          *indexFlag,
+		PreferExtensionOrder:   pkg.ParseExtensionOrder(*preferExtensionOrderFlag),
+		PreserveTimes:          *preserveTimesFlag,
+		ConvertHeicToJpeg:      *convertHeicFlag,
+		AutoRotate:             *autoRotateFlag,
+		ConflictStrategy:       *conflictStrategyFlag,
+		PreferNewer:            *preferNewerFlag,
+		PreferLargerFile:       *preferLargerFileFlag,
+		NoOverwrite:            *noOverwriteFlag,
+		SkipExistingTargetFast: *skipExistingTargetFastFlag,
+		DateStrategy:           pkg.DateStrategy(*dateStrategyFlag),
+		MinPlausibleYear:       *minPlausibleYearFlag,
+		DateOverridesFile:      *dateOverridesFlag,
+		UnknownDateDir:         *unknownDateDirFlag,
+		Move:                   *moveFlag,
+		KeepEmptySourceDirs:    !*cleanupSourceFlag,
+		Hardlink:               *hardlinkFlag,
+		OnCopy:                 *onCopyFlag,
+		CopySidecars:           *copySidecarsFlag,
+		IgnoreSpaceCheck:       *ignoreSpaceCheckFlag,
+	}
+
+	if *planTreeFlag {
+		if err := photocp.PrintPlanTree(os.Stdout, sourceDirs, targetBaseDir, opts, *planTreeFileFlag); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	// Cancel the run on Ctrl-C/SIGTERM: the file in progress is finished and the report is
+	// still written. A second signal exits immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		logger.Warn("Interrupt received, finishing the current file and writing the report (interrupt again to abort immediately)")
+		cancel()
+		<-sigCh
+		os.Exit(130)
+	}()
+
+	stopProfiles, err := photocp.StartProfiles(*cpuProfileFlag, *memProfileFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
 	}
 
 	// Call the extracted application logic
-	processed, copied, _, duplicates, pixelHashUnsupported, appErr := photocp.RunApplicationLogic(sourceDir, targetBaseDir, verbose)
-	if appErr != nil {
+	var summary pkg.ReportSummary
+	var appErr error
+	if *watchFlag {
+		summary, appErr = photocp.RunWatch(ctx, sourceDirs, targetBaseDir, opts, *watchSettleFlag)
+	} else {
+		summary, appErr = photocp.RunApplicationLogicSources(ctx, sourceDirs, targetBaseDir, opts)
+	}
+	// Profiles are written before any exit below, which would skip deferred calls.
+	if err := stopProfiles(); err != nil {
+		logger.Warn("Failed to write profiles", "error", err)
+	}
+	if appErr != nil && !errors.Is(appErr, context.Canceled) {
 		log.Fatalf("Application Error: %v", appErr)
 	}
-	fmt.Printf("Run Summary: Processed: %d, Copied: %d, Duplicates Found: %d, Pixel Hash Unsupported (Unique Files): %d\n",
-		processed, copied, len(duplicates), pixelHashUnsupported)
+	logger.Info("Run summary", "processed", summary.ProcessedFilesCount, "copied", summary.CopiedFilesCount,
+		"duplicates", len(summary.Duplicates)-summary.ReplacedCount(), "replaced", summary.ReplacedCount(), "skipped", len(summary.Skipped), "pixelHashUnsupported", summary.PixelHashUnsupportedCount,
+		"elapsed", time.Duration(summary.ElapsedSeconds*float64(time.Second)).Round(time.Millisecond),
+		"filesPerSecond", math.Round(summary.FilesPerSecond*10)/10)
+	if len(summary.Quarantined) > 0 {
+		logger.Info("Quarantined files", "count", len(summary.Quarantined), "dir", quarantineDir)
+	}
+	if summary.Interrupted {
+		logger.Warn("Run was interrupted; re-run the same command to resume. Files already copied will be detected as duplicates.")
+	} else if summary.ProcessingErrorCount > 0 {
+		logger.Warn("Some files could not be processed", "errors", summary.ProcessingErrorCount)
+	}
+	if code := photocp.ExitCode(summary, appErr); code != photocp.ExitOK {
+		os.Exit(code)
+	}
+}
+
+// runDedup reports (and optionally removes) duplicates within dir and exits on error.
+func runDedup(dir string, remove bool) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		log.Fatalf("Error: Could not stat directory '%s': %v", dir, err)
+	}
+	if !info.IsDir() {
+		log.Fatalf("Error: Path '%s' is not a directory.", dir)
+	}
+
+	fmt.Printf("Scanning %s for duplicates...\n", dir)
+	duplicates, dedupErr := pkg.DeduplicateDirectory(dir, remove)
+	for _, dup := range duplicates {
+		fmt.Printf("  - Duplicate: %s\n    Kept: %s\n    Reason: %s\n", dup.DiscardedFile, dup.KeptFile, dup.ReasonText())
+	}
+	action := "found"
+	if remove {
+		action = "removed"
+	}
+	fmt.Printf("Dedup Summary: %d duplicate(s) %s.\n", len(duplicates), action)
+	if dedupErr != nil {
+		log.Fatalf("Dedup Error: %v", dedupErr)
+	}
 }
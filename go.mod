@@ -1,15 +1,21 @@
 module github.com/user/photo-sorter
 
-go 1.24
+go 1.24.0
 
 toolchain go1.24.4
 
-require github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+require (
+	github.com/fsnotify/fsnotify v1.8.0
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+	github.com/stretchr/testify v1.10.0
+	github.com/vegidio/heif-go v0.0.0-20250601194807-dadc2edf3f24
+	golang.org/x/image v0.34.0
+	golang.org/x/text v0.32.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.10.0 // indirect
-	github.com/vegidio/heif-go v0.0.0-20250601194807-dadc2edf3f24 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package pkg
+
+import (
+	"os"
+	"time"
+)
+
+// fileAccessTime returns the modification time of info, as the access time
+// is not portably available on this platform.
+func fileAccessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
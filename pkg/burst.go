@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"slices"
+	"time"
+)
+
+// DefaultBurstWindow is the longest time between two consecutive shots of a burst, by their EXIF dates,
+// unless Options.BurstWindow sets another.
+const DefaultBurstWindow = 2 * time.Second
+
+// BurstMaxHashDistance is the largest HashDistance between the PerceptualHash of consecutive shots of a
+// burst: shots that differ in more bits show another subject, even if taken within the burst window.
+const BurstMaxHashDistance = 10
+
+// burstAnalysisMaxDim is the longest side of the downscaled copies that shots are hashed and rated on.
+const burstAnalysisMaxDim = 512
+
+// PerceptualHash decodes the image at path and returns its 64-bit difference hash: the image is reduced
+// to 9x8 gray cells, and each bit tells whether a cell is brighter than its right neighbor. Unlike a
+// pixel hash, it barely changes between near-identical images, such as the shots of a burst, so that
+// their HashDistance is small.
+func PerceptualHash(path string) (uint64, error) {
+	img, err := decodeImageFile(path, nil)
+	if err != nil {
+		return 0, err
+	}
+	return differenceHash(downscaleNearest(img, burstAnalysisMaxDim)), nil
+}
+
+// HashDistance returns the number of bits in which the perceptual hashes a and b differ, from 0 for
+// near-identical images to 64.
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// differenceHash implements PerceptualHash for a decoded image.
+func differenceHash(img image.Image) uint64 {
+	const cols, rows = 9, 8
+	bounds := img.Bounds()
+	var cells [rows][cols]float64
+	var counts [rows][cols]int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * rows / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			col := (x - bounds.Min.X) * cols / bounds.Dx()
+			cells[row][col] += grayLevel(img.At(x, y))
+			counts[row][col]++
+		}
+	}
+	var hash uint64
+	for row := 0; row < rows; row++ {
+		for col := 0; col+1 < cols; col++ {
+			// Images narrower than cols pixels leave cells empty, which count as black.
+			left, right := cells[row][col]/float64(max(1, counts[row][col])), cells[row][col+1]/float64(max(1, counts[row][col+1]))
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// sharpness returns the mean squared difference between the gray levels of neighboring pixels of img,
+// which is higher the more fine detail the image has, so that blurred shots of a burst rate lower.
+func sharpness(img image.Image) float64 {
+	bounds := img.Bounds()
+	var sum float64
+	var count int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			level := grayLevel(img.At(x, y))
+			if x+1 < bounds.Max.X {
+				d := grayLevel(img.At(x+1, y)) - level
+				sum += d * d
+				count++
+			}
+			if y+1 < bounds.Max.Y {
+				d := grayLevel(img.At(x, y+1)) - level
+				sum += d * d
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// grayLevel returns the luminance of c between 0 and 1.
+func grayLevel(c color.Color) float64 {
+	return float64(color.Gray16Model.Convert(c).(color.Gray16).Y) / 0xFFFF
+}
+
+// decodeImageFile decodes the image at path with the registered decoders, opening it under files.
+func decodeImageFile(path string, files *OpenFileLimiter) (image.Image, error) {
+	file, err := files.openLimited(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image %s: %w", path, err)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// burstShot is an image considered by CollapseBursts.
+type burstShot struct {
+	path      string
+	date      time.Time
+	analyzed  bool
+	ok        bool // Whether the image could be decoded
+	hash      uint64
+	pixels    int
+	sharpness float64
+}
+
+// analyze decodes the shot once, under files, to hash and rate it.
+func (s *burstShot) analyze(files *OpenFileLimiter) {
+	if s.analyzed {
+		return
+	}
+	s.analyzed = true
+	img, err := decodeImageFile(s.path, files)
+	if err != nil {
+		return
+	}
+	s.ok = true
+	s.pixels = img.Bounds().Dx() * img.Bounds().Dy()
+	small := downscaleNearest(img, burstAnalysisMaxDim)
+	s.hash, s.sharpness = differenceHash(small), sharpness(small)
+}
+
+// CollapseBursts finds the bursts among imageFiles: runs of two or more images whose EXIF dates (see
+// GetPhotoCreationDate) are at most window apart from the previous shot's and whose PerceptualHash
+// differs from it in at most BurstMaxHashDistance bits. Of each burst, only the shot with the most pixels
+// is kept, the sharpest of those (see Options.CollapseBursts). It returns imageFiles without the other
+// shots, in their order, and a ReasonBurstCollapsed duplicate for each of them. Images without an EXIF
+// date, or that cannot be decoded, are never part of a burst. A window of 0 means DefaultBurstWindow.
+// The images are opened under files (see Options.OpenFiles).
+func CollapseBursts(imageFiles []string, window time.Duration, files *OpenFileLimiter, logger Logger) (kept []string, collapsed []DuplicateInfo) {
+	if window <= 0 {
+		window = DefaultBurstWindow
+	}
+	var shots []*burstShot
+	for _, path := range imageFiles {
+		if date, err := getPhotoCreationDate(path, files); err == nil {
+			shots = append(shots, &burstShot{path: path, date: date})
+		}
+	}
+	slices.SortStableFunc(shots, func(a, b *burstShot) int { return a.date.Compare(b.date) })
+
+	discarded := make(map[string]bool)
+	collapse := func(burst []*burstShot) {
+		if len(burst) < 2 {
+			return
+		}
+		best := burst[0]
+		for _, shot := range burst[1:] {
+			if shot.pixels > best.pixels || shot.pixels == best.pixels && shot.sharpness > best.sharpness {
+				best = shot
+			}
+		}
+		for _, shot := range burst {
+			if shot == best {
+				continue
+			}
+			logger.Debug("Collapsing burst shot", "source", shot.path, "kept", best.path)
+			discarded[shot.path] = true
+			collapsed = append(collapsed, DuplicateInfo{KeptFile: best.path, DiscardedFile: shot.path, Reason: ReasonBurstCollapsed,
+				Detail: fmt.Sprintf("burst of %d shots, highest-resolution sharpest shot kept", len(burst))})
+		}
+	}
+	var burst []*burstShot
+	for i, shot := range shots {
+		// Only shots with a neighbor within the window are decoded.
+		if i == 0 || shot.date.Sub(shots[i-1].date) > window {
+			collapse(burst)
+			burst = nil
+			if i+1 == len(shots) || shots[i+1].date.Sub(shot.date) > window {
+				continue
+			}
+		}
+		shot.analyze(files)
+		switch {
+		case !shot.ok:
+			collapse(burst)
+			burst = nil
+		case len(burst) > 0 && HashDistance(burst[len(burst)-1].hash, shot.hash) > BurstMaxHashDistance:
+			collapse(burst)
+			burst = []*burstShot{shot}
+		default:
+			burst = append(burst, shot)
+		}
+	}
+	collapse(burst)
+
+	for _, path := range imageFiles {
+		if !discarded[path] {
+			kept = append(kept, path)
+		}
+	}
+	return kept, collapsed
+}
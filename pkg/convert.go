@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// HeicJPEGQuality is the JPEG quality of images converted by ConvertHeicToJPEG.
+const HeicJPEGQuality = 92
+
+// exifHeader precedes the TIFF data of an EXIF block, both in JPEG APP1 segments and in most HEIF files.
+const exifHeader = "Exif\x00\x00"
+
+// maxAPP1Payload is the largest EXIF block that fits into a JPEG APP1 segment, whose length field
+// also counts itself.
+const maxAPP1Payload = 0xFFFF - 2
+
+// IsHeicPath reports whether path has a .heic or .heif extension, in any case.
+func IsHeicPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".heic" || ext == ".heif"
+}
+
+// ConvertHeicToJPEG decodes the image at srcPath with the registered decoders and writes it to destPath
+// as a JPEG of HeicJPEGQuality, through a temporary file like CopyFile. The source's EXIF block, if it
+// can be found, is carried over as an APP1 segment. HEIF images can only be decoded if a HEIF decoder is
+// registered, e.g. by importing github.com/vegidio/heif-go. preserveTimes gives destPath the times of srcPath.
+func ConvertHeicToJPEG(srcPath, destPath string, preserveTimes bool) error {
+	return convertHeicToJPEG(srcPath, destPath, preserveTimes, defaultFileModes, nil)
+}
+
+// convertHeicToJPEG implements ConvertHeicToJPEG, giving destPath and the directories it creates modes.
+// The source is read, and the temporary target written, under files.
+func convertHeicToJPEG(srcPath, destPath string, preserveTimes bool, modes fileModes, files *OpenFileLimiter) error {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat source file %s: %w", srcPath, err)
+	}
+	file, err := files.openLimited(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file %s: %w", srcPath, err)
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read source file %s: %w", srcPath, err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode %s for conversion to JPEG: %w", srcPath, err)
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: HeicJPEGQuality}); err != nil {
+		return fmt.Errorf("failed to encode %s as JPEG: %w", srcPath, err)
+	}
+	jpegBytes := encoded.Bytes()
+	var out bytes.Buffer
+	out.Write(jpegBytes[:2]) // SOI
+	if exifBlock := findExifBlock(data); exifBlock != nil {
+		out.Write([]byte{0xFF, 0xE1})
+		binary.Write(&out, binary.BigEndian, uint16(len(exifBlock)+2))
+		out.Write(exifBlock)
+	}
+	out.Write(jpegBytes[2:])
+
+	var accessTime, modTime time.Time
+	if preserveTimes {
+		accessTime, modTime = fileAccessTime(srcInfo), srcInfo.ModTime()
+	}
+	release := files.acquire(1) // The temporary target
+	defer release()
+	return copyReader(&out, srcPath, destPath, nil, accessTime, modTime, modes)
+}
+
+// findExifBlock returns the first EXIF block in data, from its "Exif\0\0" header, that can be parsed and
+// fits into a JPEG APP1 segment. The block's end is not recorded in the file, so it may include trailing
+// bytes, which EXIF readers ignore as they follow offsets. It returns nil if there is none.
+func findExifBlock(data []byte) []byte {
+	for offset := 0; ; {
+		i := bytes.Index(data[offset:], []byte(exifHeader))
+		if i < 0 {
+			return nil
+		}
+		start := offset + i
+		offset = start + len(exifHeader)
+		block := data[start:min(len(data), start+maxAPP1Payload)]
+		tiffData := block[len(exifHeader):]
+		if !bytes.HasPrefix(tiffData, []byte("II*\x00")) && !bytes.HasPrefix(tiffData, []byte("MM\x00*")) {
+			continue
+		}
+		if _, err := exif.Decode(bytes.NewReader(tiffData)); err == nil {
+			return block
+		}
+	}
+}
@@ -1,44 +1,328 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// Default permissions of the directories and files that copies create, unless Options.DirMode and
+// Options.FileMode set others.
+const (
+	DefaultDirMode  fs.FileMode = 0755
+	DefaultFileMode fs.FileMode = 0644
+)
+
+// fileModes are the permissions that a copy gives the destination file and the directories it creates.
+type fileModes struct {
+	dir, file fs.FileMode
+}
+
+// defaultFileModes are the fileModes of the exported copy functions.
+var defaultFileModes = fileModes{dir: DefaultDirMode, file: DefaultFileMode}
+
+// ParseFileMode parses an octal permission mode such as "0775" or "775", as given to -dirMode and -fileMode.
+func ParseFileMode(value string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil || fs.FileMode(mode)&^fs.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid permission mode '%s': expected octal permission bits such as 0755", value)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// MkdirAll creates dir along with any missing parents like os.MkdirAll, but gives the directories it
+// creates exactly mode, whatever the umask.
+func MkdirAll(dir string, mode fs.FileMode) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); !errors.Is(err, fs.ErrNotExist) || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	for _, d := range missing {
+		if err := os.Chmod(d, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileWithMode writes data to path like os.WriteFile, but gives the file exactly mode, whatever the umask.
+func writeFileWithMode(path string, data []byte, mode fs.FileMode) error {
+	if err := os.WriteFile(path, data, mode); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
+
 // CopyFile copies a file from srcPath to destPath.
-// It ensures the destination directory exists.
+// It ensures the destination directory exists. The content is first written to a
+// temporary file in the destination directory, which is renamed to destPath once
+// the copy has completed, so an interrupted copy never leaves a half-written target.
 func CopyFile(srcPath, destPath string) error {
-	// Ensure destination directory exists
+	return CopyFileBuffer(srcPath, destPath, nil)
+}
+
+// CopyFileBuffer behaves like CopyFile but copies the content through buf, which callers
+// can reuse across files. Large buffers speed up copies to network filesystems.
+// A nil or empty buf uses the default io.Copy behavior.
+func CopyFileBuffer(srcPath, destPath string, buf []byte) error {
+	return copyFile(srcPath, destPath, buf, false, defaultFileModes, nil)
+}
+
+// CopyFilePreservingTimes behaves like CopyFileBuffer but also gives destPath the
+// modification and access times of srcPath.
+func CopyFilePreservingTimes(srcPath, destPath string, buf []byte) error {
+	return copyFile(srcPath, destPath, buf, true, defaultFileModes, nil)
+}
+
+// MoveFile moves srcPath to destPath, creating the destination directory. When destPath is on another
+// filesystem, the file is copied like CopyFilePreservingTimes and srcPath is removed once the copy is
+// complete. Other failures to rename the file, e.g. for lack of permission, are returned.
+func MoveFile(srcPath, destPath string) error {
+	return moveFile(srcPath, destPath, DefaultDirMode, func(src, dest string) error { return CopyFilePreservingTimes(src, dest, nil) })
+}
+
+// moveFile implements MoveFile, creating directories with dirMode and copying with copyFile, which must
+// preserve the file's times, when the file cannot be renamed across filesystems.
+func moveFile(srcPath, destPath string, dirMode fs.FileMode, copyFile func(srcPath, destPath string) error) error {
 	destDir := filepath.Dir(destPath)
-	if err := os.MkdirAll(destDir, 0755); err != nil {
+	if err := MkdirAll(destDir, dirMode); err != nil {
 		return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
 	}
+	if err := os.Rename(srcPath, destPath); err == nil {
+		return nil
+	} else if !errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("failed to move %s to %s: %w", srcPath, destPath, err)
+	}
+	if err := copyFile(srcPath, destPath); err != nil {
+		return err
+	}
+	if err := os.Remove(srcPath); err != nil {
+		return fmt.Errorf("copied %s to %s but failed to remove the source: %w", srcPath, destPath, err)
+	}
+	return nil
+}
+
+// LinkFile hard links destPath to srcPath, creating the destination directory, so that both names
+// share the file's content instead of duplicating it. If destPath already is a link to srcPath, there is
+// nothing to do. When destPath is on another filesystem or already exists, the file is copied like
+// CopyFilePreservingTimes instead. Other failures to link the file, e.g. on a filesystem without hard links,
+// are returned rather than silently taking the space of a copy.
+func LinkFile(srcPath, destPath string) error {
+	return linkFile(srcPath, destPath, DefaultDirMode, func(src, dest string) error { return CopyFilePreservingTimes(src, dest, nil) })
+}
+
+// linkFile implements LinkFile, creating directories with dirMode and copying with copyFile when the file
+// cannot be linked across filesystems or over an existing file.
+func linkFile(srcPath, destPath string, dirMode fs.FileMode, copyFile func(srcPath, destPath string) error) error {
+	destDir := filepath.Dir(destPath)
+	if err := MkdirAll(destDir, dirMode); err != nil {
+		return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
+	}
+	if err := os.Link(srcPath, destPath); err == nil || sameFile(srcPath, destPath) {
+		return nil
+	} else if !errors.Is(err, syscall.EXDEV) && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("failed to link %s to %s: %w", srcPath, destPath, err)
+	}
+	return copyFile(srcPath, destPath)
+}
+
+// CopyFileWithRetry behaves like CopyFileBuffer but retries transient failures, as RetryCopy does.
+func CopyFileWithRetry(srcPath, destPath string, buf []byte, retries int, delay time.Duration) error {
+	return RetryCopy(destPath, retries, delay, func() error { return CopyFileBuffer(srcPath, destPath, buf) })
+}
+
+// RetryCopy runs copy, which writes destPath, and runs it again up to retries more times while it
+// fails with an error that looks transient, such as an I/O error on a network share. Missing files
+// and permission errors are not retried. The first retry waits delay, and each further one twice as long.
+// If destPath did not exist before the first attempt, anything a failed attempt left there is removed.
+// The error of the last attempt is returned.
+func RetryCopy(destPath string, retries int, delay time.Duration, copy func() error) error {
+	return retryCopy(context.Background(), destPath, retries, delay, copy, nil)
+}
+
+// retryCopy implements RetryCopy, calling onRetry, if non-nil, before each retry. Once ctx is cancelled,
+// no further attempt is made, and a wait for a retry is cut short.
+func retryCopy(ctx context.Context, destPath string, retries int, delay time.Duration, copy func() error, onRetry func(attempt int, wait time.Duration, err error)) error {
+	_, statErr := os.Lstat(destPath)
+	destExisted := statErr == nil
+	for attempt := 1; ; attempt++ {
+		err := copy()
+		if err == nil {
+			return nil
+		}
+		if !destExisted {
+			os.Remove(destPath) // Partial output; it may not exist if the copy failed before writing
+		}
+		if attempt > retries || !isTransientCopyError(err) || ctx.Err() != nil {
+			return err
+		}
+		if onRetry != nil {
+			onRetry(attempt, delay, err)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// isTransientCopyError reports whether a failed copy might succeed when tried again.
+func isTransientCopyError(err error) bool {
+	return !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission) && !errors.Is(err, fs.ErrInvalid)
+}
 
-	sourceFile, err := os.Open(srcPath)
+// CopyReader behaves like CopyFileBuffer but streams the content from src, e.g. an archive entry.
+func CopyReader(src io.Reader, destPath string, buf []byte) error {
+	return copyReader(src, "reader", destPath, buf, time.Time{}, time.Time{}, defaultFileModes)
+}
+
+// copyFile implements CopyFileBuffer and CopyFilePreservingTimes, giving destPath and the directories
+// it creates modes, and holding its files open under files.
+func copyFile(srcPath, destPath string, buf []byte, preserveTimes bool, modes fileModes, files *OpenFileLimiter) error {
+	release := files.acquire(2) // The source and the temporary target
+	defer release()
+	sourceFile, err := openWithRetry(func() (*os.File, error) { return os.Open(srcPath) })
 	if err != nil {
 		return fmt.Errorf("failed to open source file %s: %w", srcPath, err)
 	}
 	defer sourceFile.Close()
+	// Stat before reading, which may update the source's access time.
+	srcInfo, err := sourceFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file %s: %w", srcPath, err)
+	}
 
-	destinationFile, err := os.Create(destPath)
+	var accessTime, modTime time.Time
+	if preserveTimes {
+		accessTime, modTime = fileAccessTime(srcInfo), srcInfo.ModTime()
+	}
+	return copyReader(sourceFile, srcPath, destPath, buf, accessTime, modTime, modes)
+}
+
+// copyReader writes src to destPath through a temporary file that is renamed into place once complete.
+// srcName names src in errors. Unless modTime is zero, destPath is given accessTime and modTime.
+// destPath and the directories created for it get modes.
+func copyReader(src io.Reader, srcName, destPath string, buf []byte, accessTime, modTime time.Time, modes fileModes) error {
+	// Ensure destination directory exists
+	destDir := filepath.Dir(destPath)
+	if err := MkdirAll(destDir, modes.dir); err != nil {
+		return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
+	}
+
+	tempFile, err := openWithRetry(func() (*os.File, error) { return os.CreateTemp(destDir, filepath.Base(destPath)+".*.tmp") })
 	if err != nil {
 		return fmt.Errorf("failed to create destination file %s: %w", destPath, err)
 	}
-	defer destinationFile.Close()
+	tempPath := tempFile.Name()
+	// Remove the temporary file on any failure; after a successful rename this is a no-op.
+	defer os.Remove(tempPath)
 
-	_, err = io.Copy(destinationFile, sourceFile)
-	if err != nil {
-		return fmt.Errorf("failed to copy content from %s to %s: %w", srcPath, destPath, err)
+	if err := writeAndSync(tempFile, src, buf); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to copy content from %s to %s: %w", srcName, destPath, err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to close destination file %s: %w", destPath, err)
+	}
+	// os.CreateTemp uses mode 0600; give the copy the permissions of a new file.
+	if err := os.Chmod(tempPath, modes.file); err != nil {
+		return fmt.Errorf("failed to set permissions on destination file %s: %w", destPath, err)
+	}
+	// Times are set once the content is synced and the file closed, so no later write
+	// can touch them; the rename below keeps them.
+	if !modTime.IsZero() {
+		if err := os.Chtimes(tempPath, accessTime, modTime); err != nil {
+			return fmt.Errorf("failed to set times on destination file %s: %w", destPath, err)
+		}
+	}
+	if err := os.Rename(tempPath, destPath); err != nil {
+		return fmt.Errorf("failed to move copied file into place at %s: %w", destPath, err)
 	}
 
-	// It's good practice to sync the destination file to disk.
-	err = destinationFile.Sync()
+	return nil
+}
+
+// writeAndSync copies src into dest, through buf if it is non-empty, and syncs dest to disk.
+func writeAndSync(dest *os.File, src io.Reader, buf []byte) error {
+	var err error
+	if len(buf) > 0 {
+		// Hide the ReaderFrom/WriterTo implementations of *os.File so io.CopyBuffer
+		// actually uses buf instead of delegating to them.
+		_, err = io.CopyBuffer(struct{ io.Writer }{dest}, struct{ io.Reader }{src}, buf)
+	} else {
+		_, err = io.Copy(dest, src)
+	}
 	if err != nil {
+		return err
+	}
+	// It's good practice to sync the destination file to disk.
+	if err := dest.Sync(); err != nil {
 		// This error might not be critical for the copy itself but indicates a flushing issue.
-		return fmt.Errorf("failed to sync destination file %s: %w", destPath, err)
+		return fmt.Errorf("failed to sync: %w", err)
 	}
-
 	return nil
 }
+
+// QuarantineFile copies srcPath into quarantineDir, preserving the file's path
+// relative to sourceDir. Files outside sourceDir are placed directly in quarantineDir.
+// It returns the path of the quarantined copy.
+func QuarantineFile(srcPath, sourceDir, quarantineDir string) (string, error) {
+	return QuarantineFileWithModes(srcPath, sourceDir, quarantineDir, DefaultDirMode, DefaultFileMode)
+}
+
+// QuarantineFileWithModes behaves like QuarantineFile but gives the copy fileMode and the directories
+// it creates dirMode, e.g. Options.FileModeOrDefault and Options.DirModeOrDefault.
+func QuarantineFileWithModes(srcPath, sourceDir, quarantineDir string, dirMode, fileMode fs.FileMode) (string, error) {
+	relPath, err := filepath.Rel(sourceDir, srcPath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		relPath = filepath.Base(srcPath)
+	}
+
+	quarantinePath := filepath.Join(quarantineDir, relPath)
+	if err := copyFile(srcPath, quarantinePath, nil, false, fileModes{dir: dirMode, file: fileMode}, nil); err != nil {
+		return "", fmt.Errorf("failed to quarantine %s: %w", srcPath, err)
+	}
+	return quarantinePath, nil
+}
+
+// ParseByteSize parses a human-readable size such as "4m", "512K", "1MiB" or "65536".
+// Suffixes k, m and g (optionally followed by "b" or "ib") are binary multiples.
+func ParseByteSize(size string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(size))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "b"), "i")
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return number * multiplier, nil
+}
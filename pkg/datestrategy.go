@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateStrategy decides which of a file's candidate dates (EXIF, XMP sidecar, file name, modification time)
+// is used as its photo date.
+type DateStrategy string
+
+// Date strategies accepted by ResolvePhotoDate.
+const (
+	DateStrategyExifFirst     DateStrategy = "exifFirst"     // EXIF, then XMP, falling back to the modification time (the default)
+	DateStrategyFilenameFirst DateStrategy = "filenameFirst" // File name, then EXIF, then XMP, then the modification time
+	DateStrategyEarliest      DateStrategy = "earliest"      // The earliest of all available dates
+	DateStrategyLatest        DateStrategy = "latest"        // The latest of all available dates
+)
+
+// DateSourceFilename is the date source of files dated by a date in their file name.
+const DateSourceFilename = "Filename"
+
+// DateSourceEXIF is the category of the date sources of files dated by an EXIF tag, which are
+// "EXIF " followed by the tag name (see DateSourceCategory).
+const DateSourceEXIF = "EXIF"
+
+// DateSourceCategories are the categories returned by DateSourceCategory, in the order of the report.
+var DateSourceCategories = []string{DateSourceEXIF, DateSourceFilename, DateSourceXMP, DateSourceFileModTime, DateSourceOverride}
+
+// DateSourceCategory returns the category of a date source as returned by ResolvePhotoDate:
+// DateSourceEXIF for all EXIF tags, and the date source itself otherwise.
+func DateSourceCategory(dateSource string) string {
+	if strings.HasPrefix(dateSource, DateSourceEXIF+" ") {
+		return DateSourceEXIF
+	}
+	return dateSource
+}
+
+// DefaultMinPlausibleYear is the earliest year of a plausible photo date unless Options.MinPlausibleYear
+// says otherwise. Digital cameras whose clock was reset date their photos to 1970 or 1980.
+const DefaultMinPlausibleYear = 1990
+
+// plausibleDateSlack is how far in the future a date may lie and still be plausible, as dates without
+// a time zone are taken as UTC wall-clock times and may be up to a day ahead of the current UTC time.
+const plausibleDateSlack = 24 * time.Hour
+
+// IsPlausibleDate reports whether date could be the date of a photo: not before January 1 of minYear
+// (DefaultMinPlausibleYear if minYear is 0) and not in the future.
+func IsPlausibleDate(date time.Time, minYear int) bool {
+	if minYear == 0 {
+		minYear = DefaultMinPlausibleYear
+	}
+	return date.Year() >= minYear && !date.After(time.Now().Add(plausibleDateSlack))
+}
+
+// ValidateDateStrategy returns an error if strategy is not one of the DateStrategy constants.
+// An empty strategy is accepted and means DateStrategyExifFirst.
+func ValidateDateStrategy(strategy DateStrategy) error {
+	switch strategy {
+	case "", DateStrategyExifFirst, DateStrategyFilenameFirst, DateStrategyEarliest, DateStrategyLatest:
+		return nil
+	}
+	return fmt.Errorf("unknown date strategy '%s' (expected %s, %s, %s or %s)", strategy, DateStrategyExifFirst, DateStrategyFilenameFirst, DateStrategyEarliest, DateStrategyLatest)
+}
+
+// filenameDatePattern matches a date, optionally followed by a time, embedded in a file name,
+// e.g. "IMG_20200505_050505", "2020-05-05 05.05.05" or "PXL_20200505".
+var filenameDatePattern = regexp.MustCompile(`(?:^|\D)((?:19|20)\d{2})[-_.]?(\d{2})[-_.]?(\d{2})(?:[-_ T.]?(\d{2})[-_.:]?(\d{2})[-_.:]?(\d{2}))?(?:\D|$)`)
+
+// DateFromFilename returns the date embedded in the base name of path, as a UTC wall-clock time.
+// ok is false if the name contains no valid date.
+func DateFromFilename(path string) (date time.Time, ok bool) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, m := range filenameDatePattern.FindAllStringSubmatch(name, -1) {
+		fields := make([]int, 6)
+		for i, s := range m[1:] {
+			fields[i], _ = strconv.Atoi(s) // A missing time is 00:00:00
+		}
+		date = time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], 0, time.UTC)
+		// time.Date normalizes out-of-range values, so a round trip rejects e.g. month 13 or 25:00
+		if date.Year() == fields[0] && int(date.Month()) == fields[1] && date.Day() == fields[2] &&
+			date.Hour() == fields[3] && date.Minute() == fields[4] && date.Second() == fields[5] {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ResolvePhotoDate gathers the candidate dates of the file at path (EXIF, XMP sidecar, file name and
+// modification time) and picks one according to strategy. It also returns the date source: "EXIF " and
+// the tag name, DateSourceXMP, DateSourceFilename or DateSourceFileModTime. An error is returned only for an invalid strategy or
+// if the file cannot be stat'ed.
+// EXIF, XMP and file name dates that are not plausible (see IsPlausibleDate) are ignored.
+// DateStrategyEarliest and DateStrategyLatest compare dates recorded without a time zone as local times,
+// like the modification time (see comparableInstant).
+func ResolvePhotoDate(path string, strategy DateStrategy) (time.Time, string, error) {
+	return ResolvePhotoDateWithMinYear(path, strategy, 0)
+}
+
+// ResolvePhotoDateWithMinYear behaves like ResolvePhotoDate, but takes EXIF, XMP and file name dates
+// before minYear as implausible instead of those before DefaultMinPlausibleYear. Implausible dates are
+// skipped like missing ones, so the next date source is used; the modification time is always accepted.
+func ResolvePhotoDateWithMinYear(path string, strategy DateStrategy, minYear int) (time.Time, string, error) {
+	return resolvePhotoDate(path, strategy, minYear, nil)
+}
+
+// resolvePhotoDate implements ResolvePhotoDateWithMinYear, reading the EXIF date under files.
+func resolvePhotoDate(path string, strategy DateStrategy, minYear int, files *OpenFileLimiter) (time.Time, string, error) {
+	if err := ValidateDateStrategy(strategy); err != nil {
+		return time.Time{}, "", err
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("error getting file info: %w", err)
+	}
+
+	type candidate struct {
+		date   time.Time
+		source string
+	}
+	var exifDate, xmpDate, filenameDate *candidate
+	if date, tag, err := getPhotoCreationDateWithTag(path, files); err == nil && IsPlausibleDate(date, minYear) {
+		exifDate = &candidate{date, "EXIF " + tag}
+	}
+	if date, err := GetDateFromXMP(path); err == nil && IsPlausibleDate(date, minYear) {
+		xmpDate = &candidate{date, DateSourceXMP}
+	}
+	if date, ok := DateFromFilename(path); ok && IsPlausibleDate(date, minYear) {
+		filenameDate = &candidate{date, DateSourceFilename}
+	}
+	modTime := &candidate{fileInfo.ModTime(), DateSourceFileModTime}
+
+	var order []*candidate
+	switch strategy {
+	case DateStrategyFilenameFirst:
+		order = []*candidate{filenameDate, exifDate, xmpDate}
+	case DateStrategyEarliest, DateStrategyLatest:
+		var best *candidate
+		for _, c := range []*candidate{exifDate, xmpDate, filenameDate, modTime} {
+			if c == nil {
+				continue
+			}
+			if best == nil || (strategy == DateStrategyEarliest && comparableInstant(c.date, c.source).Before(comparableInstant(best.date, best.source))) ||
+				(strategy == DateStrategyLatest && comparableInstant(c.date, c.source).After(comparableInstant(best.date, best.source))) {
+				best = c
+			}
+		}
+		return best.date, best.source, nil
+	default:
+		order = []*candidate{exifDate, xmpDate}
+	}
+	for _, c := range order {
+		if c != nil {
+			return c.date, c.source, nil
+		}
+	}
+	return modTime.date, modTime.source, nil
+}
+
+// comparableInstant returns date, taken from source, as an instant that the dates of other sources can be
+// compared with. EXIF dates without an offset tag, XMP dates and file name dates are wall-clock times held
+// as UTC; they are taken as local times, like the modification time. EXIF dates with an offset tag and
+// GPS dates, which are in UTC, are instants already.
+func comparableInstant(date time.Time, source string) time.Time {
+	if date.Location() != time.UTC || source == "EXIF "+DateTagGPS {
+		return date
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), time.Local)
+}
@@ -1,20 +1,26 @@
 package pkg
 
 import (
+	"bufio"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
-	_ "image/gif"  // Register GIF decoder
+	"image/color"
+	"image/gif"    // Also registers the GIF decoder
 	_ "image/jpeg" // Register JPEG decoder
 	_ "image/png"  // Register PNG decoder
 	"io"
 	"os"
-	// "path/filepath" // No longer directly needed here
+	"path/filepath"
 	"strings"
 
 	"github.com/rwcarlsen/goexif/exif"
 	mknote "github.com/rwcarlsen/goexif/mknote"
+	"github.com/rwcarlsen/goexif/tiff"
+	_ "golang.org/x/image/webp" // Register WebP decoder
 )
 
 // compareByExif attempts to compare two files using their EXIF signatures.
@@ -22,9 +28,10 @@ import (
 // conclusive: true if this comparison is enough to determine the outcome (e.g., EXIF mismatch).
 // err: any error encountered during EXIF processing (not ErrNoExif).
 // sig1, sig2: the EXIF signatures if obtained.
-func compareByExif(filePath1, filePath2 string) (match bool, conclusive bool, err error, sig1 string, sig2 string) {
-	exifSig1, errExif1 := getExifSignature(filePath1)
-	exifSig2, errExif2 := getExifSignature(filePath2)
+func compareByExif(h1, h2 *FileHashes) (match bool, conclusive bool, err error, sig1 string, sig2 string) {
+	filePath1, filePath2 := h1.Path, h2.Path
+	exifSig1, errExif1 := h1.ExifSignature()
+	exifSig2, errExif2 := h2.ExifSignature()
 
 	// Case 1: Both files have EXIF data.
 	if errExif1 == nil && errExif2 == nil {
@@ -61,6 +68,38 @@ func compareByExif(filePath1, filePath2 string) (match bool, conclusive bool, er
 	return false, false, nil, sig1, sig2
 }
 
+// prefilterByExif compares two images by EXIF signature and file size before anything is decoded,
+// for FileHashes.ExifPrefilter. Images that differ in either are not duplicates; images that match in
+// both are probable duplicates, confirmed by their file hash. decided is false if either image has no
+// EXIF signature, or if probable duplicates differ byte for byte, leaving the decision to the pixel hash.
+func prefilterByExif(h1, h2 *FileHashes, size1, size2 int64, result ComparisonResult) (_ ComparisonResult, decided bool, err error) {
+	sig1, errExif1 := h1.ExifSignature()
+	sig2, errExif2 := h2.ExifSignature()
+	if errExif1 != nil || errExif2 != nil {
+		return result, false, nil
+	}
+	result.Hash1, result.Hash2, result.HashType = sig1, sig2, HashTypeExif
+	if sig1 != sig2 {
+		result.Reason = ReasonExifMismatch
+		return result, true, nil
+	}
+	if size1 != size2 {
+		result.Reason = ReasonSizeMismatch
+		return result, true, nil
+	}
+	fileMatch, fileErr, fSig1, fSig2 := compareByFileHash(h1, h2)
+	if fileErr != nil {
+		result.Reason = ReasonError
+		return result, true, fileErr
+	}
+	if !fileMatch {
+		return result, false, nil
+	}
+	result.AreDuplicates, result.Reason = true, ReasonFileHashMatch
+	result.Hash1, result.Hash2, result.HashType = fSig1, fSig2, HashTypeFile
+	return result, true, nil
+}
+
 // compareByPixelHash attempts to compare two image files using their pixel data hashes.
 // match: true if pixel hashes were successfully computed for both and they are identical.
 // conclusive: true if this comparison is enough to determine the outcome (e.g., pixel hashes match or mismatch).
@@ -70,16 +109,17 @@ func compareByExif(filePath1, filePath2 string) (match bool, conclusive bool, er
 // attempted: true if pixel hashing was attempted.
 // err: any critical error encountered during pixel hashing (not ErrUnsupportedForPixelHashing).
 // hash1, hash2: the pixel hashes if obtained.
-func compareByPixelHash(filePath1, filePath2 string) (match bool, conclusive bool, attempted bool, err error, hash1 string, hash2 string) {
+func compareByPixelHash(h1, h2 *FileHashes) (match bool, conclusive bool, attempted bool, err error, hash1 string, hash2 string) {
 	attempted = true // Mark that we are attempting pixel hash comparison.
+	filePath1, filePath2 := h1.Path, h2.Path
 
-	pxHash1, errPx1 := CalculatePixelDataHash(filePath1)
+	pxHash1, errPx1 := h1.PixelHash()
 	if errPx1 != nil {
 		if strings.Contains(errPx1.Error(), ErrUnsupportedForPixelHashing.Error()) {
 			fmt.Printf("Info: Pixel hash unsupported for %s.\n", filePath1)
 			// Store "unsupported" for hash1 to indicate attempt? For now, leave empty.
 			// Try to hash filePath2 to see if it's also unsupported.
-			pxHash2, errPx2 := CalculatePixelDataHash(filePath2)
+			pxHash2, errPx2 := h2.PixelHash()
 			if errPx2 != nil && strings.Contains(errPx2.Error(), ErrUnsupportedForPixelHashing.Error()) {
 				// Both unsupported, not conclusive for pixel hash, no match here.
 				return false, false, true, nil, "", ""
@@ -95,7 +135,7 @@ func compareByPixelHash(filePath1, filePath2 string) (match bool, conclusive boo
 	}
 	hash1 = pxHash1 // Store successful hash for filePath1
 
-	pxHash2, errPx2 := CalculatePixelDataHash(filePath2)
+	pxHash2, errPx2 := h2.PixelHash()
 	if errPx2 != nil {
 		if strings.Contains(errPx2.Error(), ErrUnsupportedForPixelHashing.Error()) {
 			fmt.Printf("Info: Pixel hash for %s succeeded, but unsupported for %s.\n", filePath1, filePath2)
@@ -119,14 +159,15 @@ func compareByPixelHash(filePath1, filePath2 string) (match bool, conclusive boo
 // match: true if file hashes were successfully computed for both and they are identical.
 // err: any critical error encountered during file hashing.
 // hash1, hash2: the file hashes if obtained.
-func compareByFileHash(filePath1, filePath2 string) (match bool, err error, hash1 string, hash2 string) {
-	fHash1, errFf1 := CalculateFileHash(filePath1)
+func compareByFileHash(h1, h2 *FileHashes) (match bool, err error, hash1 string, hash2 string) {
+	filePath1, filePath2 := h1.Path, h2.Path
+	fHash1, errFf1 := h1.FileHash()
 	if errFf1 != nil {
 		return false, fmt.Errorf("error full file hashing for %s: %w", filePath1, errFf1), "", ""
 	}
 	hash1 = fHash1
 
-	fHash2, errFf2 := CalculateFileHash(filePath2)
+	fHash2, errFf2 := h2.FileHash()
 	if errFf2 != nil {
 		return false, fmt.Errorf("error full file hashing for %s: %w", filePath2, errFf2), hash1, ""
 	}
@@ -138,25 +179,87 @@ func compareByFileHash(filePath1, filePath2 string) (match bool, err error, hash
 	return false, nil, hash1, hash2 // No match
 }
 
+// Reason is the machine-readable outcome of a file comparison or of a duplicate decision.
+// Consumers can switch on it; DuplicateInfo.Detail carries the human-readable specifics.
+type Reason string
+
+const (
+	ReasonSizeMismatch          Reason = "size_mismatch"
+	ReasonExifMismatch          Reason = "exif_mismatch"
+	ReasonExifMatch             Reason = "exif_match" // Only with CompareExifOnly
+	ReasonPixelHashMatch        Reason = "pixel_hash_match"
+	ReasonPixelHashMismatch     Reason = "pixel_hash_mismatch"
+	ReasonFileHashMatch         Reason = "file_hash_match"
+	ReasonFileHashMismatch      Reason = "file_hash_mismatch"
+	ReasonError                 Reason = "error"
+	ReasonNotCompared           Reason = "not_compared" // e.g. if one file has EXIF, other doesn't, so EXIF isn't strictly a mismatch but a point of divergence
+	ReasonTargetNotFound        Reason = "target_not_found"
+	ReasonZeroByteSource        Reason = "zero_byte_source" // Exactly one of the two files is empty
+	ReasonPixelHashNotAttempted Reason = "pixel_hash_not_attempted"
+	ReasonNameCollision         Reason = "name_collision"   // A file with different content has the same target name
+	ReasonKnownHash             Reason = "known_hash"       // The file hash is listed in the known hashes file
+	ReasonSourceDuplicate       Reason = "source_duplicate" // Identical to another source file of the same run
+	ReasonHistogramMatch        Reason = "histogram_match"  // Different pixels, but similar colors (see Options.HistogramThreshold)
+	ReasonBurstCollapsed        Reason = "burst_collapsed"  // Another shot of the same burst was kept (see Options.CollapseBursts)
+)
+
+// String returns the reason's identifier, e.g. "pixel_hash_match".
+func (r Reason) String() string {
+	return string(r)
+}
+
+const (
+	HashTypePixel = "pixel_sha256"
+	HashTypeFile  = "file_sha256"
+	HashTypeExif  = "exif_signature" // Not a cryptographic hash, but a signature
+
+	HashTypeDownscaledPixel = "downscaled_pixel_sha256" // Only used to tell images apart (see Options.FastPixelHash)
+
+	HashTypeHistogram = "color_histogram" // No hashes are recorded for histogram comparisons
+)
+
+// Conflict strategies decide what happens to a source file whose target name is already taken
+// by a file with different content.
 const (
-	ReasonSizeMismatch          = "size_mismatch"
-	ReasonExifMismatch          = "exif_mismatch"
-	ReasonPixelHashMatch        = "pixel_hash_match"
-	ReasonPixelHashMismatch     = "pixel_hash_mismatch"
-	ReasonFileHashMatch         = "file_hash_match"
-	ReasonFileHashMismatch      = "file_hash_mismatch"
-	ReasonError                 = "error"
-	ReasonNotCompared           = "not_compared" // e.g. if one file has EXIF, other doesn't, so EXIF isn't strictly a mismatch but a point of divergence
-	ReasonTargetNotFound        = "target_not_found"
-	ReasonPixelHashNotAttempted = "pixel_hash_not_attempted"
-	HashTypePixel               = "pixel_sha256"
-	HashTypeFile                = "file_sha256"
-	HashTypeExif                = "exif_signature" // Not a cryptographic hash, but a signature
+	ConflictKeepTarget = "keepTarget" // Keep the existing target and discard the source (default)
+	ConflictKeepSource = "keepSource" // Overwrite the existing target with the source
+	ConflictVersion    = "version"    // Copy the source to the next free "-N" name
+	ConflictSkip       = "skip"       // Discard the source without recording a duplicate
 )
 
+// Compare algorithms select the stages of the duplicate detection of AreFilesPotentiallyDuplicateWithHashes
+// (see FileHashes.CompareAlgorithm). Whatever the algorithm, files that are not both images, and images
+// that the selected stage cannot decide, are compared by size and file hash.
+const (
+	CompareFull      = "full"      // EXIF signature, then pixel hash, then file hash (default)
+	ComparePixelOnly = "pixelOnly" // Pixel hash only, ignoring EXIF, so edited metadata does not tell images apart
+	CompareFileOnly  = "fileOnly"  // File hash only: only byte-identical files are duplicates
+	CompareExifOnly  = "exifOnly"  // EXIF signature only: images with equal signatures are duplicates
+)
+
+// ValidateCompareAlgorithm returns an error if algorithm is not one of the Compare* algorithms.
+// An empty algorithm is accepted and means CompareFull.
+func ValidateCompareAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "", CompareFull, ComparePixelOnly, CompareFileOnly, CompareExifOnly:
+		return nil
+	}
+	return fmt.Errorf("unknown compare algorithm '%s' (expected %s, %s, %s or %s)", algorithm, CompareFull, ComparePixelOnly, CompareFileOnly, CompareExifOnly)
+}
+
+// ValidateConflictStrategy returns an error if strategy is not one of the Conflict* strategies.
+// An empty strategy is accepted and means ConflictKeepTarget.
+func ValidateConflictStrategy(strategy string) error {
+	switch strategy {
+	case "", ConflictKeepTarget, ConflictKeepSource, ConflictVersion, ConflictSkip:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict strategy '%s' (expected %s, %s, %s or %s)", strategy, ConflictKeepTarget, ConflictKeepSource, ConflictVersion, ConflictSkip)
+}
+
 type ComparisonResult struct {
 	AreDuplicates bool
-	Reason        string
+	Reason        Reason
 	Hash1         string // Hash/Signature of filePath1
 	Hash2         string // Hash/Signature of filePath2
 	HashType      string // Type of hash/signature that led to the conclusion (or was last attempted for filePath1)
@@ -164,6 +267,115 @@ type ComparisonResult struct {
 	FilePath2     string
 }
 
+// FileHashes lazily computes and caches the EXIF signature, pixel data hash and file hash of one file,
+// so a source compared against several targets is only read and decoded once.
+// A FileHashes must not be reused after the file at Path changes.
+type FileHashes struct {
+	Path string
+	// ExifPrefilter makes comparisons of this file with another image use prefilterByExif:
+	// images with different EXIF signatures or sizes are not duplicates, and nothing is decoded
+	// for images that have the same signature and size and are identical byte for byte.
+	ExifPrefilter bool
+	// FastPixelHash makes comparisons of this file with another image reject images whose downscaled
+	// pixel hashes (see CalculateDownscaledPixelHash) differ before their full pixel hashes are computed.
+	FastPixelHash bool
+	// ExifSignatureFields are the EXIF tags of the file's EXIF signature (see ExifSignatureWithFields);
+	// nil means DefaultExifSignatureFields. Files are only compared by signatures of the same fields.
+	ExifSignatureFields []string
+	// CompareAlgorithm selects the stages that compare this file with another (see the Compare*
+	// algorithms); empty means CompareFull. ExifPrefilter only applies to CompareFull.
+	CompareAlgorithm string
+	// OpenFiles bounds the files held open at once while the hashes are computed (see Options.OpenFiles);
+	// nil means no limit. Comparisons open the other file under the same limit.
+	OpenFiles *OpenFileLimiter
+
+	exifSig, pixelHash, fileHash  string
+	exifErr, pixelErr, fileErr    error
+	exifDone, pixelDone, fileDone bool
+	fastPixelHash                 string
+	fastPixelErr                  error
+	fastPixelDone                 bool
+	// corruptErr is set by sortFile when the file is a damaged image (see ValidateImageIntegrity).
+	corruptErr error
+}
+
+// NewFileHashes returns an empty FileHashes for path; nothing is computed until it is asked for.
+func NewFileHashes(path string) *FileHashes {
+	return &FileHashes{Path: path}
+}
+
+// ExifSignature returns the EXIF signature of the file over its ExifSignatureFields, as
+// ExifSignatureWithFields does.
+func (h *FileHashes) ExifSignature() (string, error) {
+	if !h.exifDone {
+		h.exifSig, h.exifErr = exifSignatureWithFields(h.Path, h.ExifSignatureFields, h.OpenFiles)
+		h.exifDone = true
+	}
+	return h.exifSig, h.exifErr
+}
+
+// PixelHash returns the pixel data hash of the file, as CalculatePixelDataHash does.
+func (h *FileHashes) PixelHash() (string, error) {
+	if !h.pixelDone {
+		h.pixelHash, h.pixelErr = calculatePixelDataHash(h.Path, h.OpenFiles)
+		h.pixelDone = true
+	}
+	return h.pixelHash, h.pixelErr
+}
+
+// DownscaledPixelHash returns the downscaled pixel hash of the file, as CalculateDownscaledPixelHash
+// does with FastPixelHashMaxDim.
+func (h *FileHashes) DownscaledPixelHash() (string, error) {
+	if !h.fastPixelDone {
+		h.fastPixelHash, h.fastPixelErr = calculateDownscaledPixelHash(h.Path, FastPixelHashMaxDim, h.OpenFiles)
+		h.fastPixelDone = true
+	}
+	return h.fastPixelHash, h.fastPixelErr
+}
+
+// FileHash returns the SHA-256 hash of the file's content, as CalculateFileHash does.
+func (h *FileHashes) FileHash() (string, error) {
+	if !h.fileDone {
+		h.fileHash, h.fileErr = calculateFileHash(h.Path, h.OpenFiles)
+		h.fileDone = true
+	}
+	return h.fileHash, h.fileErr
+}
+
+// Precompute computes the hashes that comparisons of the file start with, so that they find them cached:
+// the file hash, the EXIF signature with ExifPrefilter or CompareExifOnly, and the pixel hash, or the
+// downscaled pixel hash with FastPixelHash, if PixelHashUpFront. Errors are cached as well and returned
+// when the hashes are asked for. Like all methods of FileHashes, it must not be called concurrently
+// on the same FileHashes; different ones may be computed in parallel.
+func (h *FileHashes) Precompute() {
+	h.FileHash()
+	if h.ExifPrefilter || h.CompareAlgorithm == CompareExifOnly {
+		h.ExifSignature()
+	}
+	if !h.PixelHashUpFront() {
+		return
+	}
+	if h.FastPixelHash {
+		h.DownscaledPixelHash()
+	} else {
+		h.PixelHash()
+	}
+}
+
+// PixelHashUpFront reports whether comparisons of the file always need its pixel hash: not with
+// CompareFileOnly or CompareExifOnly, which do not compare pixels, nor with ExifPrefilter, which only
+// decodes images that the EXIF signature, size and file hash cannot tell apart.
+func (h *FileHashes) PixelHashUpFront() bool {
+	return !h.ExifPrefilter && h.CompareAlgorithm != CompareFileOnly && h.CompareAlgorithm != CompareExifOnly
+}
+
+// pixelHashUnsupported reports whether the full or downscaled pixel hash was computed and is not
+// supported for the file.
+func (h *FileHashes) pixelHashUnsupported() bool {
+	return (h.pixelDone && errors.Is(h.pixelErr, ErrUnsupportedForPixelHashing)) ||
+		(h.fastPixelDone && errors.Is(h.fastPixelErr, ErrUnsupportedForPixelHashing))
+}
+
 // ErrUnsupportedForPixelHashing is returned when a file format is not supported for pixel data hashing.
 var ErrUnsupportedForPixelHashing = fmt.Errorf("file format not supported for pixel data hashing")
 
@@ -179,10 +391,49 @@ func getFileSize(filePath string) (int64, error) {
 	return fi.Size(), nil
 }
 
-// getExifSignature generates a signature string from key EXIF tags.
-// Returns ErrNoExif if EXIF data is not present or critical tags are missing.
-func getExifSignature(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+// DefaultExifSignatureFields are the EXIF tags of the EXIF signature unless configured otherwise:
+// the date, camera and dimensions. BurstExifSignatureFields also tell apart the frames of a burst.
+var DefaultExifSignatureFields = []string{"DateTimeOriginal", "Make", "Model", "ImageWidth", "ImageHeight"}
+
+// BurstExifSignatureFields add the sub-second time and exposure settings to DefaultExifSignatureFields,
+// which tell apart the frames of a burst taken within the same second. Images that differ only in these
+// tags are not compared by their pixels then, e.g. a copy whose editor dropped SubSecTimeOriginal.
+var BurstExifSignatureFields = []string{
+	"DateTimeOriginal", "SubSecTimeOriginal", "Make", "Model", "ImageWidth", "ImageHeight",
+	"FNumber", "ExposureTime", "ISOSpeedRatings",
+}
+
+// ParseExifSignatureFields splits a comma-separated list of EXIF tag names, e.g. "DateTimeOriginal,Model",
+// dropping blank entries. It returns nil, meaning DefaultExifSignatureFields, if no names are left.
+func ParseExifSignatureFields(value string) []string {
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// ExifSignature generates a signature string from the DefaultExifSignatureFields EXIF tags, a cheap
+// fingerprint that does not need the image to be decoded.
+// Returns ErrNoExif if EXIF data is not present or none of the tags is.
+func ExifSignature(filePath string) (string, error) {
+	return ExifSignatureWithFields(filePath, nil)
+}
+
+// ExifSignatureWithFields behaves like ExifSignature, but builds the signature from the given EXIF tags,
+// named as in goexif (e.g. "FNumber"), in order. nil fields means DefaultExifSignatureFields.
+func ExifSignatureWithFields(filePath string, fields []string) (string, error) {
+	return exifSignatureWithFields(filePath, fields, nil)
+}
+
+// exifSignatureWithFields implements ExifSignatureWithFields, opening the file under files.
+func exifSignatureWithFields(filePath string, fields []string, files *OpenFileLimiter) (string, error) {
+	if fields == nil {
+		fields = DefaultExifSignatureFields
+	}
+	file, err := files.openLimited(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open file for EXIF parsing %s: %w", filePath, err)
 	}
@@ -198,25 +449,24 @@ func getExifSignature(filePath string) (string, error) {
 		return "", fmt.Errorf("failed to decode EXIF for %s: %w", filePath, err)
 	}
 
-	tags := []exif.FieldName{
-		"DateTimeOriginal", "Make", "Model", "ImageWidth", "ImageHeight",
-		// Consider adding LensModel, FNumber, ExposureTime, ISOSpeedRatings if more granularity is needed
-	}
 	var signatureParts []string
-	// var tagValue string // Removed - will use directly from StringVal()
-
-	for _, tagName := range tags {
-		tag, errGet := x.Get(tagName)
+	for _, tagName := range fields {
+		tag, errGet := x.Get(exif.FieldName(tagName))
 		if errGet != nil {
 			signatureParts = append(signatureParts, "NA")
 			continue
 		}
-		valStr, errStr := tag.StringVal() // Use new variable valStr
+		if tag.Format() != tiff.StringVal {
+			// Numbers and rationals, e.g. ISOSpeedRatings or FNumber ("28/10")
+			signatureParts = append(signatureParts, strings.Trim(tag.String(), `"`))
+			continue
+		}
+		valStr, errStr := tag.StringVal()
 		if errStr != nil {
 			signatureParts = append(signatureParts, "ERR")
 			continue
 		}
-		signatureParts = append(signatureParts, strings.TrimSpace(valStr)) // Use valStr
+		signatureParts = append(signatureParts, strings.TrimSpace(valStr))
 	}
 
 	allNA := true
@@ -235,7 +485,12 @@ func getExifSignature(filePath string) (string, error) {
 
 // CalculateFileHash calculates the SHA-256 hash of a file's content.
 func CalculateFileHash(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+	return calculateFileHash(filePath, nil)
+}
+
+// calculateFileHash implements CalculateFileHash, opening the file under files.
+func calculateFileHash(filePath string, files *OpenFileLimiter) (string, error) {
+	file, err := files.openLimited(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open file %s for hashing: %w", filePath, err)
 	}
@@ -249,9 +504,79 @@ func CalculateFileHash(filePath string) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
+// LoadKnownHashes reads a newline-delimited list of SHA-256 file hashes into a set.
+// Only the first whitespace-separated field of each line is used, so `sha256sum` output is
+// accepted as well. Blank lines and lines starting with '#' are ignored. Hashes are lowercased.
+func LoadKnownHashes(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open known hashes file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hashes := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		hashes[strings.ToLower(fields[0])] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read known hashes file %s: %w", path, err)
+	}
+	return hashes, nil
+}
+
+// AppendKnownHashes appends hashes to the known hashes file at path, one per line, creating it if needed.
+// The existing lines, including comments and the file names of `sha256sum` output, are left as they are.
+func AppendKnownHashes(path string, hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open known hashes file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var sb strings.Builder
+	if info, err := file.Stat(); err == nil && info.Size() > 0 && !endsWithNewline(path, info.Size()) {
+		sb.WriteString("\n") // Do not run the first hash into an unterminated last line
+	}
+	for _, hash := range hashes {
+		sb.WriteString(hash)
+		sb.WriteString("\n")
+	}
+	if _, err := file.WriteString(sb.String()); err != nil {
+		return fmt.Errorf("failed to append to known hashes file %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// endsWithNewline reports whether the last of the size bytes of the file at path is a newline.
+func endsWithNewline(path string, size int64) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err != nil {
+		return true
+	}
+	return last[0] == '\n'
+}
+
 // GetImageResolution decodes the image configuration to get its width and height.
 func GetImageResolution(filePath string) (width int, height int, err error) {
-	file, err := os.Open(filePath)
+	return getImageResolution(filePath, nil)
+}
+
+// getImageResolution implements GetImageResolution, opening the file under files.
+func getImageResolution(filePath string, files *OpenFileLimiter) (width int, height int, err error) {
+	file, err := files.openLimited(filePath)
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to open image file %s for resolution: %w", filePath, err)
 	}
@@ -265,14 +590,80 @@ func GetImageResolution(filePath string) (width int, height int, err error) {
 	return config.Width, config.Height, nil
 }
 
-// CalculatePixelDataHash calculates the SHA-256 hash of an image's raw pixel data.
+// GetDisplayResolution returns the width and height of an image as it is displayed.
+// When the EXIF Orientation tag indicates a 90° or 270° rotation (values 5-8), the
+// stored dimensions returned by GetImageResolution are swapped. Images without an
+// orientation tag report their stored dimensions.
+func GetDisplayResolution(filePath string) (width int, height int, err error) {
+	return getDisplayResolution(filePath, nil)
+}
+
+// getDisplayResolution implements GetDisplayResolution, opening the file under files.
+func getDisplayResolution(filePath string, files *OpenFileLimiter) (width int, height int, err error) {
+	width, height, err = getImageResolution(filePath, files)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	orientation, errOrientation := getExifOrientation(filePath, files)
+	if errOrientation == nil && orientation >= 5 && orientation <= 8 {
+		return height, width, nil
+	}
+	return width, height, nil
+}
+
+// getExifOrientation reads the EXIF Orientation tag (1-8) of a file, opening it under files.
+func getExifOrientation(filePath string, files *OpenFileLimiter) (int, error) {
+	file, err := files.openLimited(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file for EXIF orientation %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	x, err := exif.Decode(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode EXIF for %s: %w", filePath, err)
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 0, err
+	}
+	return tag.Int(0)
+}
+
+// IsHigherResolution reports whether an image of width1 x height1 has more pixels than one of width2 x height2.
+func IsHigherResolution(width1, height1, width2, height2 int) bool {
+	return width1*height1 > width2*height2
+}
+
+// CalculatePixelDataHash calculates the SHA-256 hash of an image's raw pixel data (see ImagePixelHash).
 func CalculatePixelDataHash(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+	return calculatePixelDataHash(filePath, nil)
+}
+
+// calculatePixelDataHash implements CalculatePixelDataHash, opening the file under files.
+func calculatePixelDataHash(filePath string, files *OpenFileLimiter) (string, error) {
+	file, err := files.openLimited(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open file %s for pixel hashing: %w", filePath, err)
 	}
 	defer file.Close()
 
+	header := make([]byte, 32)
+	n, _ := file.ReadAt(header, 0)
+	if isAnimatedWebP(header[:n]) {
+		// golang.org/x/image/webp only decodes still images, so the frames are decoded one by one.
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s for pixel hashing: %w", filePath, err)
+		}
+		hash, err := animatedWebPPixelHash(data)
+		if err != nil {
+			return "", fmt.Errorf("%w: decoding WebP frames of %s: %v", ErrUnsupportedForPixelHashing, filePath, err)
+		}
+		return hash, nil
+	}
+
 	img, format, err := image.Decode(file)
 	if err != nil {
 		// Check if the error is due to an unknown format, which we class as "unsupported"
@@ -287,27 +678,187 @@ func CalculatePixelDataHash(filePath string) (string, error) {
 	// For now, assume if image.Decode succeeds, we try to hash.
 	// Consider adding: if format != "jpeg" && format != "png" && format != "gif" { return "", ErrUnsupported... }
 
-	hasher := sha256.New()
+	if format == "gif" {
+		// image.Decode only returns the first frame, which animations may share.
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to rewind %s for GIF decoding: %w", filePath, err)
+		}
+		animation, err := gif.DecodeAll(file)
+		if err != nil {
+			return "", fmt.Errorf("%w: decoding GIF frames of %s: %v", ErrUnsupportedForPixelHashing, filePath, err)
+		}
+		if len(animation.Image) > 1 {
+			return AnimationPixelHash(animation), nil
+		}
+	}
+	if format == "heic" && isHEIF(header[:n]) {
+		// Only the primary image of a burst or image sequence can be decoded, so its image count is hashed with it.
+		if info, err := file.Stat(); err == nil {
+			if count, err := heifImageCount(file, info.Size()); err == nil && count > 1 {
+				return multiImagePixelHash(img, count), nil
+			}
+		}
+	}
+	return ImagePixelHash(img), nil
+}
+
+// FastPixelHashMaxDim is the longest side of the downscaled copies compared with Options.FastPixelHash.
+const FastPixelHashMaxDim = 256
+
+// CalculateDownscaledPixelHash returns the pixel hash (see ImagePixelHash) of the image at filePath after
+// scaling it down by nearest-neighbor sampling so that neither side exceeds maxDim; smaller images are
+// hashed as they are. Only the first image of animations and multi-image files is hashed.
+// This hash is not an exact duplicate test: resized or slightly edited copies of an image can share it.
+// Images with different downscaled hashes differ, but a match must be confirmed with CalculatePixelDataHash.
+func CalculateDownscaledPixelHash(filePath string, maxDim int) (string, error) {
+	return calculateDownscaledPixelHash(filePath, maxDim, nil)
+}
+
+// calculateDownscaledPixelHash implements CalculateDownscaledPixelHash, opening the file under files.
+func calculateDownscaledPixelHash(filePath string, maxDim int, files *OpenFileLimiter) (string, error) {
+	if maxDim <= 0 {
+		return "", fmt.Errorf("invalid maximum dimension %d for downscaled pixel hashing", maxDim)
+	}
+	file, err := files.openLimited(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s for pixel hashing: %w", filePath, err)
+	}
+	defer file.Close()
+
+	img, format, err := image.Decode(file)
+	if err != nil {
+		if err == image.ErrFormat {
+			return "", fmt.Errorf("%w: format %s", ErrUnsupportedForPixelHashing, format)
+		}
+		return "", fmt.Errorf("%w: decoding image data for %s: %v", ErrUnsupportedForPixelHashing, filePath, err)
+	}
+	return ImagePixelHash(downscaleNearest(img, maxDim)), nil
+}
+
+// downscaleNearest scales img down so that neither side exceeds maxDim, keeping its aspect ratio, by
+// taking the pixel at the center of each destination pixel. img is returned as it is if it already fits.
+func downscaleNearest(img image.Image, maxDim int) image.Image {
 	bounds := img.Bounds()
-	pixelBytes := make([]byte, 4)
+	width, height := bounds.Dx(), bounds.Dy()
+	longest := max(width, height)
+	if longest <= maxDim {
+		return img
+	}
+	scaledWidth, scaledHeight := max(1, width*maxDim/longest), max(1, height*maxDim/longest)
+	scaled := image.NewNRGBA(image.Rect(0, 0, scaledWidth, scaledHeight))
+	for y := 0; y < scaledHeight; y++ {
+		srcY := bounds.Min.Y + (2*y+1)*height/(2*scaledHeight)
+		for x := 0; x < scaledWidth; x++ {
+			srcX := bounds.Min.X + (2*x+1)*width/(2*scaledWidth)
+			scaled.Set(x, y, img.At(srcX, srcY))
+		}
+	}
+	return scaled
+}
+
+// AnimationPixelHash returns the SHA-256 hash of all frames of a multi-frame GIF: the pixel hash
+// (see ImagePixelHash), bounds and delay of each frame, in order. Animations that share their first
+// frame but differ later, or only in timing, hash differently. Single-frame GIFs should be hashed
+// with ImagePixelHash, as CalculatePixelDataHash does, so they hash like other images.
+func AnimationPixelHash(animation *gif.GIF) string {
+	hasher := sha256.New()
+	for i, frame := range animation.Image {
+		delay := 0
+		if i < len(animation.Delay) {
+			delay = animation.Delay[i]
+		}
+		fmt.Fprintf(hasher, "%s %v %d\n", ImagePixelHash(frame), frame.Rect, delay)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
 
+// ImagePixelHash returns the SHA-256 hash of img's pixels in canonical 8-bit, non-premultiplied
+// RGBA form, row by row. The same pixels hash the same whatever color model the decoder produced.
+func ImagePixelHash(img image.Image) string {
+	canonical := canonicalNRGBA(img)
+	hasher := sha256.New()
+	rowBytes := canonical.Rect.Dx() * 4
+	for y := 0; y < canonical.Rect.Dy(); y++ {
+		offset := y * canonical.Stride
+		hasher.Write(canonical.Pix[offset : offset+rowBytes]) // hash.Hash never returns an error
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// canonicalNRGBA converts img to an 8-bit NRGBA image with the same bounds.
+// The common concrete image types are converted directly; other types, including
+// 16-bit ones, go through color.NRGBAModel, keeping the high byte of each channel.
+func canonicalNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		return nrgba
+	}
+
+	var pixelAt func(x, y int) color.NRGBA
+	switch src := img.(type) {
+	case *image.RGBA:
+		pixelAt = func(x, y int) color.NRGBA { return color.NRGBAModel.Convert(src.RGBAAt(x, y)).(color.NRGBA) }
+	case *image.Gray:
+		pixelAt = func(x, y int) color.NRGBA {
+			gray := src.GrayAt(x, y).Y
+			return color.NRGBA{R: gray, G: gray, B: gray, A: 0xff}
+		}
+	case *image.CMYK:
+		pixelAt = func(x, y int) color.NRGBA {
+			c := src.CMYKAt(x, y)
+			r, g, b := color.CMYKToRGB(c.C, c.M, c.Y, c.K)
+			return color.NRGBA{R: r, G: g, B: b, A: 0xff}
+		}
+	case *image.YCbCr:
+		// The 16-bit conversion is more precise than color.YCbCrToRGB.
+		pixelAt = func(x, y int) color.NRGBA { return color.NRGBAModel.Convert(src.YCbCrAt(x, y)).(color.NRGBA) }
+	case *image.Paletted:
+		palette := make([]color.NRGBA, len(src.Palette))
+		for i, c := range src.Palette {
+			palette[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+		}
+		pixelAt = func(x, y int) color.NRGBA {
+			index := int(src.ColorIndexAt(x, y))
+			if index >= len(palette) {
+				return color.NRGBA{} // Hash out of range indices as transparent black rather than panicking
+			}
+			return palette[index]
+		}
+	default:
+		pixelAt = func(x, y int) color.NRGBA { return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA) }
+	}
+
+	bounds := img.Bounds()
+	canonical := image.NewNRGBA(bounds)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
-			pixelBytes[0] = byte(r >> 8)
-			pixelBytes[1] = byte(g >> 8)
-			pixelBytes[2] = byte(b >> 8)
-			pixelBytes[3] = byte(a >> 8)
-			if _, errWrite := hasher.Write(pixelBytes); errWrite != nil {
-				return "", fmt.Errorf("failed to write pixel data to hasher for %s: %w", filePath, errWrite)
-			}
+			canonical.SetNRGBA(x, y, pixelAt(x, y))
 		}
 	}
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return canonical
 }
 
 // AreFilesPotentiallyDuplicate implements the multi-step duplicate detection logic.
 func AreFilesPotentiallyDuplicate(filePath1, filePath2 string) (ComparisonResult, error) {
+	return AreFilesPotentiallyDuplicateWithHashes(filePath1, filePath2, nil)
+}
+
+// AreFilesPotentiallyDuplicateWithHashes is AreFilesPotentiallyDuplicate, but takes the hashes of filePath1
+// from srcHashes, computing and caching them there as needed. Reusing srcHashes when comparing one source
+// against several targets avoids reading and decoding the source again for every target.
+// A nil srcHashes behaves like AreFilesPotentiallyDuplicate. With srcHashes.ExifPrefilter, images are
+// first compared by EXIF signature and size (see prefilterByExif). srcHashes.CompareAlgorithm selects
+// which of the EXIF, pixel hash and file hash stages compare images.
+func AreFilesPotentiallyDuplicateWithHashes(filePath1, filePath2 string, srcHashes *FileHashes) (ComparisonResult, error) {
+	if srcHashes == nil {
+		srcHashes = NewFileHashes(filePath1)
+	}
+	algorithm := srcHashes.CompareAlgorithm
+	if algorithm == "" {
+		algorithm = CompareFull
+	}
+	tgtHashes := NewFileHashes(filePath2)
+	tgtHashes.ExifSignatureFields = srcHashes.ExifSignatureFields
+	tgtHashes.OpenFiles = srcHashes.OpenFiles
 	result := ComparisonResult{
 		AreDuplicates: false,
 		Reason:        ReasonNotCompared,
@@ -322,8 +873,7 @@ func AreFilesPotentiallyDuplicate(filePath1, filePath2 string) (ComparisonResult
 	}
 
 	// Handle zero-byte files early. If both are zero bytes, they are duplicates.
-	// If one is zero and the other isn't, they'll be caught by size check if not images,
-	// or proceed to hashing if images (where pixel hash of empty image is not sensible, file hash will be different).
+	// If only one is zero bytes, it is an empty (corrupt) copy rather than a different file.
 	size1, errSize1 := getFileSize(filePath1)
 	if errSize1 != nil {
 		result.Reason = ReasonError
@@ -343,6 +893,10 @@ func AreFilesPotentiallyDuplicate(filePath1, filePath2 string) (ComparisonResult
 		result.Hash2 = "zero_bytes"
 		return result, nil
 	}
+	if size1 == 0 || size2 == 0 {
+		result.Reason = ReasonZeroByteSource
+		return result, nil
+	}
 
 	// 2. Determine if files are images
 	isImg1 := IsImageExtension(filePath1)
@@ -350,37 +904,74 @@ func AreFilesPotentiallyDuplicate(filePath1, filePath2 string) (ComparisonResult
 
 	pixelHashingAttemptedOrUnsupported := false
 
-	if isImg1 && isImg2 {
-		// 3.a EXIF Signature Check (for images)
-		exifMatch, exifConclusive, exifErr, exifSig1, exifSig2 := compareByExif(filePath1, filePath2)
-		result.Hash1 = exifSig1 // Store whatever EXIF sigs were found
-		result.Hash2 = exifSig2
-		result.HashType = HashTypeExif // Default to EXIF hash type if this stage is entered
-
-		if exifErr != nil {
-			// An actual error occurred during EXIF processing.
-			// Log it and treat EXIF comparison as inconclusive, then proceed to pixel hash.
-			// Alternatively, could return the error: result.Reason = ReasonError; return result, exifErr;
-			fmt.Printf("Warning: EXIF comparison error for %s, %s: %v. Proceeding to pixel hash.\n", filePath1, filePath2, exifErr)
-			result.Reason = ReasonNotCompared // EXIF check was inconclusive due to error
-		} else if exifConclusive {
-			if !exifMatch { // EXIF mismatch, conclusive
-				result.Reason = ReasonExifMismatch
-				// AreDuplicates remains false
+	if isImg1 && isImg2 && srcHashes.ExifPrefilter && algorithm == CompareFull {
+		prefiltered, decided, err := prefilterByExif(srcHashes, tgtHashes, size1, size2, result)
+		if decided {
+			return prefiltered, err
+		}
+	}
+
+	// With CompareExifOnly, images that both have an EXIF signature are decided by it alone.
+	// Images without one, or whose EXIF cannot be read, are compared by file hash.
+	if isImg1 && isImg2 && algorithm == CompareExifOnly {
+		exifMatch, exifConclusive, exifErr, exifSig1, exifSig2 := compareByExif(srcHashes, tgtHashes)
+		if exifErr == nil && (exifMatch || exifConclusive) {
+			result.Hash1, result.Hash2, result.HashType = exifSig1, exifSig2, HashTypeExif
+			result.AreDuplicates = exifMatch
+			result.Reason = ReasonExifMismatch
+			if exifMatch {
+				result.Reason = ReasonExifMatch
+			}
+			return result, nil
+		}
+	}
+
+	if isImg1 && isImg2 && (algorithm == CompareFull || algorithm == ComparePixelOnly) {
+		// 3.a EXIF Signature Check (for images, skipped with ComparePixelOnly)
+		if algorithm == CompareFull {
+			exifMatch, exifConclusive, exifErr, exifSig1, exifSig2 := compareByExif(srcHashes, tgtHashes)
+			result.Hash1 = exifSig1 // Store whatever EXIF sigs were found
+			result.Hash2 = exifSig2
+			result.HashType = HashTypeExif // Default to EXIF hash type if this stage is entered
+
+			if exifErr != nil {
+				// An actual error occurred during EXIF processing.
+				// Log it and treat EXIF comparison as inconclusive, then proceed to pixel hash.
+				// Alternatively, could return the error: result.Reason = ReasonError; return result, exifErr;
+				fmt.Printf("Warning: EXIF comparison error for %s, %s: %v. Proceeding to pixel hash.\n", filePath1, filePath2, exifErr)
+				result.Reason = ReasonNotCompared // EXIF check was inconclusive due to error
+			} else if exifConclusive {
+				if !exifMatch { // EXIF mismatch, conclusive
+					result.Reason = ReasonExifMismatch
+					// AreDuplicates remains false
+					return result, nil
+				}
+				// This case (exifConclusive and exifMatch) shouldn't happen based on compareByExif logic
+				// as a match is currently considered inconclusive. If it did, it means EXIF matched and is conclusive.
+				// For now, assume it implies proceeding.
+			}
+			// If EXIF matched (exifMatch is true, exifConclusive is false),
+			// or if EXIF was inconclusive (e.g., one or both missing EXIF, exifMatch is false, exifConclusive is false),
+			// we proceed to pixel hash.
+			// result.Reason will be updated by pixel/file hash if EXIF was not a mismatch.
+			// If EXIF matched, Hash1, Hash2, and HashType are already set.
+		}
+
+		// 3.b Downscaled Pixel Data Hash Comparison (for images, with FastPixelHash)
+		// Only a mismatch is conclusive; matches and unsupported files go on to the full pixel hash.
+		if srcHashes.FastPixelHash {
+			fastHash1, errFast1 := srcHashes.DownscaledPixelHash()
+			fastHash2, errFast2 := tgtHashes.DownscaledPixelHash()
+			if errFast1 == nil && errFast2 == nil && fastHash1 != fastHash2 {
+				result.Hash1, result.Hash2 = fastHash1, fastHash2
+				result.HashType = HashTypeDownscaledPixel
+				result.Reason = ReasonPixelHashMismatch
 				return result, nil
 			}
-			// This case (exifConclusive and exifMatch) shouldn't happen based on compareByExif logic
-			// as a match is currently considered inconclusive. If it did, it means EXIF matched and is conclusive.
-			// For now, assume it implies proceeding.
-		}
-		// If EXIF matched (exifMatch is true, exifConclusive is false),
-		// or if EXIF was inconclusive (e.g., one or both missing EXIF, exifMatch is false, exifConclusive is false),
-		// we proceed to pixel hash.
-		// result.Reason will be updated by pixel/file hash if EXIF was not a mismatch.
-		// If EXIF matched, Hash1, Hash2, and HashType are already set.
-
-		// 3.b Pixel Data Hash Comparison (for images)
-		pxMatch, pxConclusive, pxAttempted, pxErr, pxSig1, pxSig2 := compareByPixelHash(filePath1, filePath2)
+		}
+
+		// 3.c Pixel Data Hash Comparison (for images)
+		pxMatch, pxConclusive, pxAttempted, pxErr, pxSig1, pxSig2 := compareByPixelHash(srcHashes, tgtHashes)
 		pixelHashingAttemptedOrUnsupported = pxAttempted // Update based on whether pixel hash was attempted
 
 		if pxErr != nil {
@@ -424,6 +1015,7 @@ func AreFilesPotentiallyDuplicate(filePath1, filePath2 string) (ComparisonResult
 	// This section is reached if:
 	// - Files are not both images (isImg1 && isImg2 is false).
 	// - Files are both images, but pixel hashing was unsupported/failed for at least one, and we fell through.
+	// - The compare algorithm is CompareFileOnly, or CompareExifOnly could not decide.
 
 	// 4.a File Size Check (only if not both images AND pixel hashing wasn't attempted/unsupported for images)
 	// If pixelHashingAttemptedOrUnsupported is true, it means we tried the image path, which doesn't use size as a primary filter.
@@ -440,11 +1032,11 @@ func AreFilesPotentiallyDuplicate(filePath1, filePath2 string) (ComparisonResult
 		// HashType will be File.
 	}
 
-	// 3.c / 4.b Full File Content Hashing
+	// 3.d / 4.b Full File Content Hashing
 	// Reason would be ReasonNotCompared (if EXIF was inconclusive) or ReasonPixelHashNotAttempted (if pixel hash path led here)
 	// or if it's the non-image path and sizes matched.
 
-	fileMatch, fileErr, fSig1, fSig2 := compareByFileHash(filePath1, filePath2)
+	fileMatch, fileErr, fSig1, fSig2 := compareByFileHash(srcHashes, tgtHashes)
 	result.Hash1 = fSig1
 	result.Hash2 = fSig2
 	result.HashType = HashTypeFile // Set hash type for this stage
@@ -463,3 +1055,218 @@ func AreFilesPotentiallyDuplicate(filePath1, filePath2 string) (ComparisonResult
 	}
 	return result, nil
 }
+
+// dedupCandidate holds what DeduplicateDirectory needs to know about a file to group it and pick a keeper.
+type dedupCandidate struct {
+	path      string
+	fileHash  string
+	pixelHash string // Empty if the format is not supported for pixel hashing
+	width     int
+	height    int
+	size      int64
+}
+
+// preferredOver reports whether c should be kept instead of other: the higher resolution wins,
+// then the larger file, then the path that sorts first.
+func (c dedupCandidate) preferredOver(other dedupCandidate) bool {
+	if IsHigherResolution(c.width, c.height, other.width, other.height) {
+		return true
+	}
+	if IsHigherResolution(other.width, other.height, c.width, c.height) {
+		return false
+	}
+	if c.size != other.size {
+		return c.size > other.size
+	}
+	return c.path < other.path
+}
+
+// DeduplicateDirectory finds duplicate image files within dir and its subdirectories.
+// Files are grouped when they share a file hash or a pixel data hash; the highest-resolution
+// file of each group is kept and the others are returned as duplicates. Since pixel hashes
+// depend on the image dimensions, resized copies are not grouped together.
+// When remove is true, the duplicates are deleted. Removal failures do not stop the run
+// and are returned joined together.
+func DeduplicateDirectory(dir string, remove bool) ([]DuplicateInfo, error) {
+	var candidates []dedupCandidate
+	walkErr := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() || !IsImageExtension(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fileHash, err := CalculateFileHash(path)
+		if err != nil {
+			return err
+		}
+		candidate := dedupCandidate{path: path, fileHash: fileHash, size: info.Size()}
+		if pixelHash, err := CalculatePixelDataHash(path); err == nil {
+			candidate.pixelHash = pixelHash
+		}
+		if width, height, err := GetDisplayResolution(path); err == nil {
+			candidate.width, candidate.height = width, height
+		}
+		candidates = append(candidates, candidate)
+		return nil
+	})
+	if walkErr != nil {
+		return nil, fmt.Errorf("error scanning directory %s: %w", dir, walkErr)
+	}
+
+	// Union files that share either hash so that each group is a connected set of duplicates.
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	firstWithKey := make(map[string]int)
+	for i, candidate := range candidates {
+		keys := []string{"file:" + candidate.fileHash}
+		if candidate.pixelHash != "" {
+			keys = append(keys, "pixel:"+candidate.pixelHash)
+		}
+		for _, key := range keys {
+			if j, ok := firstWithKey[key]; ok {
+				parent[find(i)] = find(j)
+			} else {
+				firstWithKey[key] = i
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	var roots []int
+	for i := range candidates {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], i)
+	}
+
+	duplicates := []DuplicateInfo{}
+	var removeErrs []error
+	for _, root := range roots {
+		members := groups[root]
+		if len(members) < 2 {
+			continue
+		}
+		keeper := candidates[members[0]]
+		for _, i := range members[1:] {
+			if candidates[i].preferredOver(keeper) {
+				keeper = candidates[i]
+			}
+		}
+		for _, i := range members {
+			discarded := candidates[i]
+			if discarded.path == keeper.path {
+				continue
+			}
+			dup := DuplicateInfo{KeptFile: keeper.path, DiscardedFile: discarded.path, Reason: ReasonFileHashMatch, Detail: "identical file kept"}
+			if discarded.fileHash != keeper.fileHash {
+				dup.Reason, dup.Detail = ReasonPixelHashMatch, "highest resolution kept"
+			}
+			duplicates = append(duplicates, dup)
+			if remove {
+				if err := os.Remove(discarded.path); err != nil {
+					removeErrs = append(removeErrs, fmt.Errorf("failed to remove duplicate %s: %w", discarded.path, err))
+				}
+			}
+		}
+	}
+	return duplicates, errors.Join(removeErrs...)
+}
+
+// GroupSourceDuplicates groups imageFiles by file hash so that each set of identical source files is
+// sorted only once. It returns one representative per group, in scan order, and a duplicate entry
+// for every other member of a group. Identical files have the same resolution, so the first one found
+// is kept. Files that cannot be hashed and empty files are always returned as representatives.
+// Once ctx is cancelled, the files not hashed yet are returned as representatives without reading them.
+func GroupSourceDuplicates(ctx context.Context, imageFiles []string, logger Logger) (representatives []string, duplicates []DuplicateInfo) {
+	return GroupSourceDuplicatesPreferring(ctx, imageFiles, nil, logger)
+}
+
+// GroupSourceDuplicatesPreferring behaves like GroupSourceDuplicates, but keeps the member of each group
+// whose extension comes first in extensionOrder (see ParseExtensionOrder), e.g. photo.jpg rather than an
+// identical photo.jpeg for "jpg,jpeg". Members with unlisted extensions come after listed ones, and
+// among members of equal rank the first one found is kept. The representative takes the place of the
+// group's first member in scan order.
+func GroupSourceDuplicatesPreferring(ctx context.Context, imageFiles []string, extensionOrder []string, logger Logger) (representatives []string, duplicates []DuplicateInfo) {
+	var groups [][]string // Groups of identical files in scan order, or single files that were not hashed
+	groupByHash := make(map[string]int)
+	for _, path := range imageFiles {
+		if ctx.Err() != nil {
+			groups = append(groups, []string{path})
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			groups = append(groups, []string{path})
+			continue
+		}
+		hash, err := CalculateFileHash(path)
+		if err != nil {
+			logger.Debug("Could not hash source file, sorting it individually", "source", path, "error", err)
+			groups = append(groups, []string{path})
+			continue
+		}
+		if i, ok := groupByHash[hash]; ok {
+			groups[i] = append(groups[i], path)
+			continue
+		}
+		groupByHash[hash] = len(groups)
+		groups = append(groups, []string{path})
+	}
+
+	for _, group := range groups {
+		kept := group[0]
+		for _, path := range group[1:] {
+			if extensionRank(path, extensionOrder) < extensionRank(kept, extensionOrder) {
+				kept = path
+			}
+		}
+		representatives = append(representatives, kept)
+		for _, path := range group {
+			if path == kept {
+				continue
+			}
+			logger.Debug("Source file is identical to another source file", "source", path, "kept", kept)
+			duplicates = append(duplicates, DuplicateInfo{KeptFile: kept, DiscardedFile: path, Reason: ReasonSourceDuplicate, Detail: "identical source file sorted instead"})
+		}
+	}
+	return representatives, duplicates
+}
+
+// ParseExtensionOrder splits a comma-separated list of extensions in order of preference, e.g. "jpg,jpeg,png",
+// into lowercased extensions without leading dots, dropping blank entries. It returns nil if none are left.
+func ParseExtensionOrder(value string) []string {
+	var order []string
+	for _, ext := range strings.Split(value, ",") {
+		if ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")); ext != "" {
+			order = append(order, ext)
+		}
+	}
+	return order
+}
+
+// extensionRank returns the position of the extension of path in extensionOrder, as returned by
+// ParseExtensionOrder, or len(extensionOrder) if it is not listed.
+func extensionRank(path string, extensionOrder []string) int {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	for i, preferred := range extensionOrder {
+		if ext == preferred {
+			return i
+		}
+	}
+	return len(extensionOrder)
+}
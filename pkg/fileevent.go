@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Actions of FileEvent records.
+const (
+	FileActionCopy      = "copy"      // The source was copied to the target
+	FileActionReplace   = "replace"   // The source was copied over a duplicate at the target
+	FileActionDuplicate = "duplicate" // The source was discarded as a duplicate of the target
+	FileActionSkip      = "skip"      // The source was left alone, e.g. because it is empty
+	FileActionError     = "error"     // The source could not be sorted
+)
+
+// FileEvent is the record written to Options.JSONLines for each sorted source.
+type FileEvent struct {
+	Path       string `json:"path"`
+	Target     string `json:"target,omitempty"`
+	Action     string `json:"action"`
+	Reason     string `json:"reason,omitempty"` // The duplicate reason (e.g. pixel_hash_match), skip reason or error
+	DateSource string `json:"dateSource,omitempty"`
+}
+
+// NewFileEvent returns the FileEvent of the source at path that SortFile sorted with outcome and err.
+func NewFileEvent(path string, outcome FileOutcome, err error) FileEvent {
+	event := FileEvent{Path: path, Target: outcome.TargetPath, DateSource: outcome.DateSource}
+	switch {
+	case err != nil:
+		event.Action, event.Reason = FileActionError, err.Error()
+	case outcome.Duplicate != nil && outcome.Duplicate.Replaced:
+		event.Action, event.Reason = FileActionReplace, outcome.Duplicate.Reason.String()
+	case outcome.Copied:
+		event.Action = FileActionCopy
+	case outcome.Duplicate != nil:
+		event.Action, event.Reason = FileActionDuplicate, outcome.Duplicate.Reason.String()
+	default:
+		event.Action, event.Reason = FileActionSkip, outcome.SkipReason
+	}
+	return event
+}
+
+// DuplicateFileEvent returns the FileEvent of the source d.DiscardedFile, which was discarded as a
+// duplicate of d.KeptFile without being sorted, e.g. as one of several identical sources.
+func DuplicateFileEvent(d DuplicateInfo) FileEvent {
+	return FileEvent{Path: d.DiscardedFile, Target: d.KeptFile, Action: FileActionDuplicate, Reason: d.Reason.String()}
+}
+
+// SkipFileEvent returns the FileEvent of the source s.SourceFile, which was skipped without being
+// sorted, e.g. a listed file that does not exist or a video found by the scan.
+func SkipFileEvent(s SkippedInfo) FileEvent {
+	return FileEvent{Path: s.SourceFile, Action: FileActionSkip, Reason: s.Reason}
+}
+
+// WriteFileEvent writes event to w as a single line of JSON and flushes w if it buffers its output,
+// so that readers see each event as soon as it happens.
+func WriteFileEvent(w io.Writer, event FileEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if flusher, ok := w.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
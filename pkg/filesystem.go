@@ -1,19 +1,34 @@
 package pkg
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rwcarlsen/goexif/exif"
 	"github.com/rwcarlsen/goexif/tiff"
+	"golang.org/x/text/unicode/norm"
 )
 
 // ErrNoExifDate is returned when EXIF data is found but no suitable date tag is present.
 var ErrNoExifDate = fmt.Errorf("no EXIF date tag found")
 
+// ErrTargetNotWritable is returned before any file is processed when no file can be created in the
+// target directory, e.g. because it is on a read-only mount.
+var ErrTargetNotWritable = fmt.Errorf("target directory is not writable")
+
 var imageExtensions = map[string]bool{
 	".jpg":  true,
 	".jpeg": true,
@@ -21,6 +36,7 @@ var imageExtensions = map[string]bool{
 	".heic": true,
 	".heif": true,
 	".gif":  true,
+	".webp": true,
 	".raw":  true,
 	".cr2":  true,
 	".nef":  true,
@@ -32,95 +48,751 @@ var imageExtensions = map[string]bool{
 	// Add more extensions if needed
 }
 
+var videoExtensions = map[string]bool{
+	".mp4":  true,
+	".m4v":  true,
+	".mov":  true,
+	".avi":  true,
+	".mkv":  true,
+	".mts":  true,
+	".m2ts": true,
+	".3gp":  true,
+	".wmv":  true,
+	".webm": true,
+}
+
+// DefaultFilenameFormat is the Go time layout used for target file names (without extension).
+const DefaultFilenameFormat = "2006-01-02-150405"
+
+// ValidateFilenameFormat checks that layout is a usable Go time layout for target file names.
+// Layouts that would produce path separators, or that contain no date/time elements
+// (and would therefore give every file the same name), are rejected.
+func ValidateFilenameFormat(layout string) error {
+	if layout == "" {
+		return fmt.Errorf("filename format must not be empty")
+	}
+	if strings.ContainsAny(layout, `/\`) {
+		return fmt.Errorf("filename format '%s' must not contain path separators", layout)
+	}
+
+	// Render two times that differ in every component to check the output.
+	sample1 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(layout)
+	sample2 := time.Date(2019, 11, 25, 17, 38, 49, 0, time.UTC).Format(layout)
+	if strings.ContainsAny(sample1+sample2, `/\`) || strings.ContainsRune(sample1+sample2, filepath.Separator) {
+		return fmt.Errorf("filename format '%s' produces path separators", layout)
+	}
+	if sample1 == sample2 {
+		return fmt.Errorf("filename format '%s' does not contain any date or time elements", layout)
+	}
+	return nil
+}
+
 // ScanSourceDirectory recursively scans the source directory for image files.
-func ScanSourceDirectory(sourceDir string) ([]string, error) {
-	var imageFiles []string
+// maxDepth limits how deep the scan descends, measured relative to sourceDir:
+// 1 means only files directly in sourceDir, 2 adds their immediate subdirectories, and so on.
+// A maxDepth of 0 means unlimited.
+// When sniffExtensionless is true, files without an extension are included if SniffImageType recognizes them.
+// Files that photocp generates are skipped (see IsGeneratedFileName).
+func ScanSourceDirectory(sourceDir string, maxDepth int, sniffExtensionless bool) ([]string, error) {
+	return ScanSourceDirectoryContext(context.Background(), sourceDir, maxDepth, sniffExtensionless)
+}
 
+// ScanSourceDirectoryContext behaves like ScanSourceDirectory but stops walking once ctx is cancelled,
+// returning ctx.Err() and no files.
+func ScanSourceDirectoryContext(ctx context.Context, sourceDir string, maxDepth int, sniffExtensionless bool) ([]string, error) {
+	imageFiles, _, err := scanMedia(ctx, sourceDir, maxDepth, sniffExtensionless, false)
+	return imageFiles, err
+}
+
+// ScanMediaDirectory recursively scans dir like ScanSourceDirectory with unlimited depth, but also
+// collects video files (see IsVideoExtension). Images and videos are returned separately.
+func ScanMediaDirectory(dir string) (images, videos []string, err error) {
+	return scanMedia(context.Background(), dir, 0, false, true)
+}
+
+// WalkSourceImages walks sourceDir like ScanSourceDirectory with unlimited depth, but calls fn for
+// each image file as it is found instead of collecting them, so callers can start processing before
+// the scan is complete. An error returned by fn stops the walk and is returned unchanged.
+func WalkSourceImages(sourceDir string, fn func(path string) error) error {
+	return WalkSourceImagesContext(context.Background(), sourceDir, 0, false, fn)
+}
+
+// WalkSourceImagesContext behaves like WalkSourceImages with the maxDepth and sniffExtensionless
+// settings of ScanSourceDirectory, and stops walking once ctx is cancelled, returning ctx.Err().
+func WalkSourceImagesContext(ctx context.Context, sourceDir string, maxDepth int, sniffExtensionless bool, fn func(path string) error) error {
+	return walkMedia(ctx, sourceDir, maxDepth, sniffExtensionless, fn, nil, nil)
+}
+
+// Reasons recorded by ScanSkippedFiles for files that a scan of the source passes over.
+const (
+	UnsupportedFormatSkipReason = "Skipped (unsupported format)"
+	VideoSkipReason             = "Skipped (video, not sorted)"
+	GeneratedFileSkipReason     = "Skipped (generated by photocp)"
+)
+
+// ScanSkippedFiles walks sourceDir like ScanSourceDirectoryContext and returns the files it passes over
+// instead of the images: files of unsupported formats, videos and files photocp generates, each with
+// one of the reasons above. Files in directories beyond maxDepth are not visited and not listed.
+func ScanSkippedFiles(ctx context.Context, sourceDir string, maxDepth int, sniffExtensionless bool) ([]SkippedInfo, error) {
+	skipped := []SkippedInfo{}
+	err := walkMedia(ctx, sourceDir, maxDepth, sniffExtensionless, func(string) error { return nil }, nil, func(path, reason string) {
+		skipped = append(skipped, SkippedInfo{SourceFile: path, Reason: reason})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return skipped, nil
+}
+
+// classifySourceFile reports whether the file at path is an image that a scan of the source sorts, and
+// otherwise the reason it is passed over: GeneratedFileSkipReason, VideoSkipReason or UnsupportedFormatSkipReason.
+func classifySourceFile(path string, sniffExtensionless bool) (isImage bool, skipReason string) {
+	if IsGeneratedFileName(filepath.Base(path)) {
+		// Left behind by an earlier run into this directory, e.g. when sorting in place
+		return false, GeneratedFileSkipReason
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case imageExtensions[ext]:
+		return true, ""
+	case ext == "" && sniffExtensionless:
+		if _, ok := SniffImageType(path); ok {
+			return true, ""
+		}
+	case videoExtensions[ext]:
+		return false, VideoSkipReason
+	}
+	return false, UnsupportedFormatSkipReason
+}
+
+// Reasons recorded by ReadSourceList for listed paths that are not files.
+const (
+	MissingFileSkipReason = "Skipped (listed file not found)"
+	NotAFileSkipReason    = "Skipped (listed path is not a file)"
+)
+
+// ReadSourceList reads the newline-delimited list of source files at listPath (see Options.FromList) and
+// returns the images in it, in the order listed, like ScanSourceDirectory returns those of a directory.
+// The other paths are returned with the reason they are skipped: MissingFileSkipReason, NotAFileSkipReason
+// or one of the reasons of ScanSkippedFiles; paths that cannot be accessed are skipped with the error.
+// Blank lines and lines starting with '#' are ignored. All paths are returned absolute, with relative
+// ones taken relative to the current directory.
+func ReadSourceList(listPath string, sniffExtensionless bool) (imageFiles []string, skipped []SkippedInfo, err error) {
+	file, err := os.Open(listPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open source list %s: %w", listPath, err)
+	}
+	defer file.Close()
+
+	imageFiles, skipped = []string{}, []SkippedInfo{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		path, absErr := filepath.Abs(line)
+		if absErr != nil {
+			return nil, nil, fmt.Errorf("invalid path %q in source list %s: %w", line, listPath, absErr)
+		}
+		info, statErr := os.Stat(path)
+		switch {
+		case errors.Is(statErr, fs.ErrNotExist):
+			skipped = append(skipped, SkippedInfo{SourceFile: path, Reason: MissingFileSkipReason})
+		case statErr != nil:
+			skipped = append(skipped, SkippedInfo{SourceFile: path, Reason: fmt.Sprintf("Skipped (%v)", statErr)})
+		case !info.Mode().IsRegular():
+			skipped = append(skipped, SkippedInfo{SourceFile: path, Reason: NotAFileSkipReason})
+		default:
+			if isImage, skipReason := classifySourceFile(path, sniffExtensionless); isImage {
+				imageFiles = append(imageFiles, path)
+			} else {
+				skipped = append(skipped, SkippedInfo{SourceFile: path, Reason: skipReason})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read source list %s: %w", listPath, err)
+	}
+	return imageFiles, skipped, nil
+}
+
+// scanMedia implements ScanSourceDirectoryContext and ScanMediaDirectory. Videos are only collected
+// when includeVideos is true. Neither result is nil unless an error is returned.
+func scanMedia(ctx context.Context, sourceDir string, maxDepth int, sniffExtensionless bool, includeVideos bool) (imageFiles, videoFiles []string, err error) {
+	var onVideo func(string) error
+	if includeVideos {
+		onVideo = func(path string) error {
+			videoFiles = append(videoFiles, path)
+			return nil
+		}
+	}
+	err = walkMedia(ctx, sourceDir, maxDepth, sniffExtensionless, func(path string) error {
+		imageFiles = append(imageFiles, path)
+		return nil
+	}, onVideo, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Return empty slices instead of nil
+	if imageFiles == nil {
+		imageFiles = []string{}
+	}
+	if videoFiles == nil {
+		videoFiles = []string{}
+	}
+	return imageFiles, videoFiles, nil
+}
+
+// walkMedia walks sourceDir, calling onImage for each image file and onVideo, if not nil, for each
+// video file. Errors returned by the callbacks stop the walk and are returned unchanged.
+// onSkipped, if not nil, is called with the reason for each other file (see ScanSkippedFiles).
+func walkMedia(ctx context.Context, sourceDir string, maxDepth int, sniffExtensionless bool, onImage, onVideo func(path string) error, onSkipped func(path, reason string)) error {
 	// Check if the source directory exists and is readable
 	info, err := os.Stat(sourceDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("source directory '%s' does not exist", sourceDir)
+			return fmt.Errorf("source directory '%s' does not exist", sourceDir)
 		}
-		return nil, fmt.Errorf("error accessing source directory '%s': %w", sourceDir, err)
+		return fmt.Errorf("error accessing source directory '%s': %w", sourceDir, err)
 	}
 	if !info.IsDir() {
-		return nil, fmt.Errorf("source path '%s' is not a directory", sourceDir)
+		return fmt.Errorf("source path '%s' is not a directory", sourceDir)
 	}
 
+	var callbackErr error
 	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			// Skip files/directories that can't be read, but log the error
 			fmt.Printf("Warning: Error accessing path %q: %v\n", path, err)
 			return nil // Returning nil continues the walk
 		}
-		if !info.IsDir() {
-			ext := strings.ToLower(filepath.Ext(path))
-			if imageExtensions[ext] {
-				imageFiles = append(imageFiles, path)
+		if info.IsDir() {
+			// Prune directories whose files would lie beyond maxDepth.
+			if maxDepth > 0 && pathDepth(sourceDir, path) >= maxDepth {
+				return filepath.SkipDir
 			}
+			return nil
+		}
+		isImage, skipReason := classifySourceFile(path, sniffExtensionless)
+		var fn func(string) error
+		if isImage {
+			fn = onImage
+		} else if skipReason == VideoSkipReason {
+			fn = onVideo
+		}
+		if fn != nil {
+			callbackErr = fn(path)
+			return callbackErr
+		}
+		if onSkipped != nil {
+			onSkipped(path, skipReason)
 		}
 		return nil
 	})
 
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if callbackErr != nil {
+		return callbackErr
+	}
 	if err != nil {
 		// This error would be from filepath.Walk itself, not the callback.
-		return nil, fmt.Errorf("error walking through source directory '%s': %w", sourceDir, err)
+		return fmt.Errorf("error walking through source directory '%s': %w", sourceDir, err)
 	}
+	return nil
+}
 
-	if imageFiles == nil {
-		return []string{}, nil // Return empty slice instead of nil
+// generatedFileNames are the names of files that photocp writes next to the photos it sorts,
+// or that features built on it do.
+var generatedFileNames = map[string]bool{
+	ReportFileName: true,
+	IndexFileName:  true,
+	"report.json":  true,
+	"manifest.csv": true,
+}
+
+// timestampedReportPattern matches TimestampedReportName names, including their "-N" variants.
+var timestampedReportPattern = regexp.MustCompile(`^report-\d{8}-\d{6}(-\d+)?\.txt$`)
+
+// IsGeneratedFileName reports whether name is the base name of a file that photocp generates, such as
+// its report or target index. ScanSourceDirectory never returns such files, so sorting a directory that
+// an earlier run wrote into does not pick them up as inputs.
+func IsGeneratedFileName(name string) bool {
+	return generatedFileNames[name] || timestampedReportPattern.MatchString(name) ||
+		strings.HasPrefix(name, ".photocp-write-test-")
+}
+
+// WithinDirectory reports whether path lies below dir, comparing their absolute paths; dir itself does
+// not. Symbolic links are not resolved.
+func WithinDirectory(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	relPath, err := filepath.Rel(absDir, absPath)
+	return err == nil && relPath != "." && filepath.IsLocal(relPath)
+}
+
+// ExcludeNestedTarget returns files, found in sourceDir, without those below targetBaseDir if the target
+// directory lies below sourceDir (see WithinDirectory). Scanned as part of the source, a nested target
+// would have its sorted files sorted again, e.g. by the next run or while a streaming scan is under
+// way. nested reports whether targetBaseDir is below sourceDir. A target that is sourceDir itself is
+// sorted in place and not excluded.
+func ExcludeNestedTarget(files []string, sourceDir, targetBaseDir string) (kept []string, nested bool) {
+	if !WithinDirectory(targetBaseDir, sourceDir) {
+		return files, false
+	}
+	return slices.DeleteFunc(files, func(path string) bool { return WithinDirectory(path, targetBaseDir) }), true
+}
+
+// pathDepth returns the number of path components of path relative to root.
+// root itself has depth 0.
+func pathDepth(root, path string) int {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil || relPath == "." {
+		return 0
+	}
+	return len(strings.Split(relPath, string(filepath.Separator)))
+}
+
+// RemoveEmptyDirs removes those of dirs below root that are empty, deepest first, together with their
+// parent directories that become empty as a result, up to but excluding root. Directories that still
+// contain anything, and directories that are not below root, are left alone. It returns the removed
+// directories and the first error met; directories that cannot be removed are skipped.
+func RemoveEmptyDirs(root string, dirs []string) (removed []string, err error) {
+	root = filepath.Clean(root)
+	candidates := make(map[string]bool)
+	for _, dir := range dirs {
+		for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+			relPath, relErr := filepath.Rel(root, dir)
+			if relErr != nil || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+				break
+			}
+			candidates[dir] = true
+		}
+	}
+	ordered := make([]string, 0, len(candidates))
+	for dir := range candidates {
+		ordered = append(ordered, dir)
+	}
+	// Children before their parents, so that a parent is only tried once its children are gone.
+	sort.Slice(ordered, func(i, j int) bool {
+		if di, dj := pathDepth(root, ordered[i]), pathDepth(root, ordered[j]); di != dj {
+			return di > dj
+		}
+		return ordered[i] < ordered[j]
+	})
+
+	for _, dir := range ordered {
+		entries, readErr := os.ReadDir(dir)
+		if readErr != nil || len(entries) > 0 {
+			continue // Gone already, unreadable or not empty
+		}
+		if removeErr := os.Remove(dir); removeErr != nil {
+			if err == nil {
+				err = fmt.Errorf("failed to remove empty directory %s: %w", dir, removeErr)
+			}
+			continue
+		}
+		removed = append(removed, dir)
 	}
-	return imageFiles, nil
+	return removed, err
 }
 
 // CreateTargetDirectory creates the year/month directory structure within the target base directory.
 // Example: targetBaseDir/YYYY/MM
 func CreateTargetDirectory(targetBaseDir string, date time.Time) (string, error) {
-	yearDir := filepath.Join(targetBaseDir, date.Format("2006"))
-	monthDir := filepath.Join(yearDir, date.Format("01")) // 01 for MM
+	return CreateTargetDirectoryWithLayout(targetBaseDir, date, DefaultDirectoryLayout)
+}
+
+// CreateTargetDirectoryWithLayout creates the directory for date below targetBaseDir, named by
+// rendering layout (a Go time layout using "/" between directory levels) with date (see FormatDirectoryLayout).
+// An empty layout places files directly in targetBaseDir. Directories are created with DefaultDirMode.
+func CreateTargetDirectoryWithLayout(targetBaseDir string, date time.Time, layout string) (string, error) {
+	return CreateTargetDirectoryWithMode(targetBaseDir, date, layout, DefaultDirMode)
+}
 
-	// Create the year directory if it doesn't exist
-	if err := os.MkdirAll(monthDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create target directory %s: %w", monthDir, err)
+// CreateTargetDirectoryWithMode behaves like CreateTargetDirectoryWithLayout, but gives the directories
+// it creates exactly mode, whatever the umask (see MkdirAll), e.g. Options.DirModeOrDefault.
+func CreateTargetDirectoryWithMode(targetBaseDir string, date time.Time, layout string, mode fs.FileMode) (string, error) {
+	dir := targetBaseDir
+	if layout != "" {
+		dir = filepath.Join(targetBaseDir, filepath.FromSlash(FormatDirectoryLayout(date, layout)))
+	}
+
+	if err := MkdirAll(dir, mode); err != nil {
+		return "", fmt.Errorf("failed to create target directory %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// BucketDirectory returns the directory that a file named fileName goes into when a date directory
+// may hold at most maxFiles entries: dir itself or one of its overflow siblings "dir-2", "dir-3", ...
+// An existing directory that already contains fileName is returned, so that the file is compared with
+// that existing target; otherwise the first directory with room is returned. It is not created.
+func BucketDirectory(dir, fileName string, maxFiles int) (string, error) {
+	freeBucket := ""
+	for i := 1; ; i++ {
+		bucket := dir
+		if i > 1 {
+			bucket = fmt.Sprintf("%s-%d", dir, i)
+		}
+		entries, err := countDirEntries(bucket, maxFiles)
+		if os.IsNotExist(err) {
+			if freeBucket == "" {
+				freeBucket = bucket
+			}
+			return freeBucket, nil
+		} else if err != nil {
+			return "", fmt.Errorf("failed to read target directory %s: %w", bucket, err)
+		}
+		if _, err := os.Lstat(filepath.Join(bucket, fileName)); err == nil {
+			return bucket, nil
+		}
+		if freeBucket == "" && entries < maxFiles {
+			freeBucket = bucket
+		}
 	}
-	return monthDir, nil // Return the YYYY/MM path
 }
 
+// countDirEntries returns the number of entries in dir, counting at most limit of them.
+func countDirEntries(dir string, limit int) (int, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(limit)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return len(names), nil
+}
+
+// DefaultDirectoryLayout is the directory layout used below the target base directory: YYYY/MM.
+const DefaultDirectoryLayout = "2006/01"
+
+// ISO week tokens, which directory layouts may contain besides Go time layout elements (see
+// FormatDirectoryLayout). Go time layouts have no ISO week elements.
+const (
+	LayoutISOYear = "{isoyear}" // The ISO 8601 week-numbering year, e.g. 2022 for 2023-01-01
+	LayoutISOWeek = "{isoweek}" // The two-digit ISO 8601 week, 01 to 53
+)
+
+// FormatDirectoryLayout renders layout for date like date.Format, replacing LayoutISOYear and
+// LayoutISOWeek with the ISO week of date (see time.Time.ISOWeek). Days in early January may belong to
+// the last week of the previous ISO year and days in late December to week 01 of the next, so layouts
+// with an ISO week should take the year from LayoutISOYear rather than from "2006".
+func FormatDirectoryLayout(date time.Time, layout string) string {
+	formatted := date.Format(layout) // The tokens contain no layout elements, so they are left as they are
+	if !strings.Contains(layout, LayoutISOYear) && !strings.Contains(layout, LayoutISOWeek) {
+		return formatted
+	}
+	year, week := date.ISOWeek()
+	return strings.NewReplacer(LayoutISOYear, fmt.Sprintf("%04d", year), LayoutISOWeek, fmt.Sprintf("%02d", week)).Replace(formatted)
+}
+
+// structurePresets maps the names accepted by StructureLayout to directory layouts.
+var structurePresets = map[string]string{
+	"year":           "2006",
+	"year-month":     DefaultDirectoryLayout,
+	"year-month-day": "2006/01/02",
+	"week":           LayoutISOYear + "/W" + LayoutISOWeek,
+	"flat":           "",
+}
+
+// StructureLayout returns the directory layout of a named preset:
+// "year", "year-month" (the default), "year-month-day", "week" (ISO weeks, e.g. 2023/W43) or "flat".
+func StructureLayout(preset string) (string, error) {
+	layout, ok := structurePresets[preset]
+	if !ok {
+		return "", fmt.Errorf("unknown structure '%s' (expected year, year-month, year-month-day, week or flat)", preset)
+	}
+	return layout, nil
+}
+
+// Month name formats accepted by MonthNameLayout. Month names are always English, whatever the system locale.
+const (
+	MonthNameNumber = "number" // 10 (the default)
+	MonthNameShort  = "short"  // 2023-Oct
+	MonthNameLong   = "long"   // 2023-October
+)
+
+// monthNameTokens maps the month name formats to their Go time layout tokens.
+var monthNameTokens = map[string]string{
+	MonthNameNumber: "01",
+	MonthNameShort:  "Jan",
+	MonthNameLong:   "January",
+}
+
+// MonthNameLayout returns the directory layout of a structure preset with its month directory
+// rendered in format: MonthNameNumber, MonthNameShort or MonthNameLong. A named month is joined with
+// the year directory above it into a single directory, so that "2006/01" becomes "2006-January"
+// (e.g. 2023-October) and "2006/01/02" becomes "2006-January/02". MonthNameNumber and an empty format
+// leave the layout unchanged. Layouts without a month directory are returned as they are.
+func MonthNameLayout(layout, format string) (string, error) {
+	if format == "" {
+		return layout, nil
+	}
+	token, ok := monthNameTokens[format]
+	if !ok {
+		return "", fmt.Errorf("unknown month name format '%s' (expected %s, %s or %s)", format, MonthNameNumber, MonthNameShort, MonthNameLong)
+	}
+	var parts []string
+	for _, part := range strings.Split(layout, "/") {
+		if part == "01" && token != "01" {
+			if n := len(parts); n > 0 && parts[n-1] == "2006" {
+				parts[n-1] += "-" + token
+				continue
+			}
+			part = token
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// ValidateDirectoryLayout checks that layout is a usable directory layout: a relative path using
+// "/" between levels, whose rendered directories are never empty, "." or "..".
+func ValidateDirectoryLayout(layout string) error {
+	if layout == "" {
+		return nil // Flat
+	}
+	if strings.Contains(layout, `\`) {
+		return fmt.Errorf("directory layout '%s' must use '/' to separate directories", layout)
+	}
+	if strings.HasPrefix(layout, "/") || filepath.IsAbs(layout) {
+		return fmt.Errorf("directory layout '%s' must be relative", layout)
+	}
+	for _, sample := range []time.Time{time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC), time.Date(2019, 11, 25, 17, 38, 49, 0, time.UTC)} {
+		for _, part := range strings.Split(FormatDirectoryLayout(sample, layout), "/") {
+			if part == "" || part == "." || part == ".." {
+				return fmt.Errorf("directory layout '%s' produces an invalid directory name '%s'", layout, part)
+			}
+		}
+	}
+	return nil
+}
+
+// Names of the EXIF date tags reported by GetPhotoCreationDateWithTag, in order of priority.
+const (
+	DateTagDateTimeOriginal  = "DateTimeOriginal"
+	DateTagDateTimeDigitized = "DateTimeDigitized"
+	DateTagDateTime          = "DateTime"
+	DateTagGPS               = "GPSDateStamp"
+)
+
 // GetPhotoCreationDate extracts the creation date from a photo's EXIF data.
-// It looks for DateTimeOriginal, CreateDate, or DateTimeDigitized tags.
+// See GetPhotoCreationDateWithTag for the tags that are considered.
 // If no EXIF date is found, it returns ErrNoExifDate.
 // If the file cannot be opened or EXIF data cannot be decoded, other errors are returned.
 func GetPhotoCreationDate(photoPath string) (time.Time, error) {
-	file, err := os.Open(photoPath)
+	date, _, err := getPhotoCreationDateWithTag(photoPath, nil)
+	return date, err
+}
+
+// getPhotoCreationDate implements GetPhotoCreationDate, opening the file under files.
+func getPhotoCreationDate(photoPath string, files *OpenFileLimiter) (time.Time, error) {
+	date, _, err := getPhotoCreationDateWithTag(photoPath, files)
+	return date, err
+}
+
+// GetPhotoCreationDateWithTag behaves like GetPhotoCreationDate and also returns the name of
+// the tag the date was taken from. Tags are tried in order: DateTimeOriginal, DateTimeDigitized,
+// DateTime (the modification date), and finally GPSDateStamp combined with GPSTimeStamp.
+// The GPS date is in UTC. The other tags are returned in the time zone of their offset tag
+// (OffsetTimeOriginal, OffsetTimeDigitized or OffsetTime) if the camera wrote one, and as UTC
+// wall-clock times otherwise; their sub-second tags (e.g. SubSecTimeOriginal) add fractional seconds.
+func GetPhotoCreationDateWithTag(photoPath string) (time.Time, string, error) {
+	return getPhotoCreationDateWithTag(photoPath, nil)
+}
+
+// getPhotoCreationDateWithTag implements GetPhotoCreationDateWithTag, opening the file under files.
+func getPhotoCreationDateWithTag(photoPath string, files *OpenFileLimiter) (time.Time, string, error) {
+	file, err := files.openLimited(photoPath)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("failed to open file %s: %w", photoPath, err)
+		return time.Time{}, "", fmt.Errorf("failed to open file %s: %w", photoPath, err)
 	}
 	defer file.Close()
 
 	x, err := exif.Decode(file)
 	if err != nil {
+		// goexif cannot parse the containers of many RAW formats; look for their EXIF block directly.
+		if IsRawExtension(photoPath) {
+			if date, tag, rawErr := rawCreationDate(file.File); rawErr == nil || errors.Is(rawErr, ErrNoExifDate) {
+				return date, tag, rawErr
+			}
+		}
 		// If it's a "no EXIF data" error, we can return a more specific error
 		// or handle it as a non-critical issue (e.g., fallback to mod time).
 		// For now, let's check if it's a known "no EXIF" scenario.
 		// The exif library might return io.EOF or other parsing errors for non-EXIF files.
 		// We'll treat any decoding error as "EXIF data not usable".
-		return time.Time{}, fmt.Errorf("failed to decode EXIF data from %s: %w", photoPath, err)
+		return time.Time{}, "", fmt.Errorf("failed to decode EXIF data from %s: %w", photoPath, err)
+	}
+
+	loadOffsetTimeTags(x)
+	if date, tag, err := exifCaptureDate(x.Get); !errors.Is(err, ErrNoExifDate) {
+		return date, tag, err
+	}
+
+	// Last resort: the GPS receiver's UTC date and time
+	if dateTag, err := x.Get(exif.GPSDateStamp); err == nil {
+		date, err := parseGPSDateTime(dateTag, x)
+		return date, DateTagGPS, err
+	}
+
+	return time.Time{}, "", ErrNoExifDate // No suitable date tag found
+}
+
+// GetCameraInfo returns the camera make and model recorded in the EXIF data of the file at photoPath,
+// with surrounding whitespace removed. A missing tag is returned as an empty string; an error is
+// returned only if the file has no readable EXIF data.
+func GetCameraInfo(photoPath string) (cameraMake, cameraModel string, err error) {
+	file, err := os.Open(photoPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open file %s: %w", photoPath, err)
+	}
+	defer file.Close()
+
+	x, err := exif.Decode(file)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode EXIF data from %s: %w", photoPath, err)
+	}
+	tagString := func(field exif.FieldName) string {
+		tag, err := x.Get(field)
+		if err != nil {
+			return ""
+		}
+		value, err := tag.StringVal()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(value)
+	}
+	return tagString(exif.Make), tagString(exif.Model), nil
+}
+
+// parseGPSDateTime combines the GPSDateStamp tag ("YYYY:MM:DD") with the GPSTimeStamp
+// tag (hour, minute and second as rationals), if present, into a UTC time.
+func parseGPSDateTime(dateTag *tiff.Tag, x *exif.Exif) (time.Time, error) {
+	dateStr, err := dateTag.StringVal()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get string value from GPS date tag: %w", err)
+	}
+	date, err := time.Parse("2006:01:02", strings.TrimSpace(dateStr))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse GPS date string '%s': %w", dateStr, err)
 	}
 
-	// Preferred tag: DateTimeOriginal
-	dateTag, err := x.Get(exif.DateTimeOriginal)
-	if err == nil {
-		return parseExifDateTime(dateTag)
+	timeTag, err := x.Get(exif.GPSTimeStamp)
+	if err != nil || timeTag.Count < 3 {
+		return date, nil // Date only
+	}
+	var seconds float64
+	for i, unit := range []float64{3600, 60, 1} {
+		num, den, err := timeTag.Rat2(i)
+		if err != nil || den == 0 {
+			return time.Time{}, fmt.Errorf("invalid GPS time stamp: %v", err)
+		}
+		seconds += float64(num) / float64(den) * unit
+	}
+	return date.Add(time.Duration(seconds * float64(time.Second))), nil
+}
+
+// Field names of the EXIF 2.31 time zone tags, which goexif does not load.
+const (
+	exifOffsetTime          exif.FieldName = "OffsetTime"
+	exifOffsetTimeOriginal  exif.FieldName = "OffsetTimeOriginal"
+	exifOffsetTimeDigitized exif.FieldName = "OffsetTimeDigitized"
+)
+
+// offsetTimeFields maps the IDs of the time zone tags in the EXIF sub-IFD to their field names.
+var offsetTimeFields = map[uint16]exif.FieldName{
+	0x9010: exifOffsetTime,
+	0x9011: exifOffsetTimeOriginal,
+	0x9012: exifOffsetTimeDigitized,
+}
+
+// loadOffsetTimeTags loads the time zone tags of the EXIF sub-IFD of x, so that they can be read with
+// x.Get. Files without an EXIF sub-IFD, or with one that cannot be decoded, are left unchanged.
+func loadOffsetTimeTags(x *exif.Exif) {
+	pointer, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return
+	}
+	offset, err := pointer.Int64(0)
+	if err != nil {
+		return
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+	subDir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return
 	}
+	x.LoadTags(subDir, offsetTimeFields, false)
+}
 
-	// Fallback tag: DateTimeDigitized
-	dateTag, err = x.Get(exif.DateTimeDigitized)
-	if err == nil {
-		return parseExifDateTime(dateTag)
+// exifCaptureDate returns the date of the first of DateTimeOriginal, DateTimeDigitized and DateTime
+// that get finds, with its sub-second and time zone tags applied, and the name of that tag.
+// ErrNoExifDate is returned if none of them is present.
+func exifCaptureDate(get func(exif.FieldName) (*tiff.Tag, error)) (time.Time, string, error) {
+	for _, tags := range []struct{ date, subSec, offset exif.FieldName }{
+		{exif.DateTimeOriginal, exif.SubSecTimeOriginal, exifOffsetTimeOriginal},
+		{exif.DateTimeDigitized, exif.SubSecTimeDigitized, exifOffsetTimeDigitized},
+		{exif.DateTime, exif.SubSecTime, exifOffsetTime},
+	} {
+		dateTag, err := get(tags.date)
+		if err == nil {
+			date, err := parseExifDateTime(dateTag)
+			if err == nil {
+				date = applyExifTimeDetails(date, get, tags.subSec, tags.offset)
+			}
+			return date, string(tags.date), err
+		}
 	}
+	return time.Time{}, "", ErrNoExifDate
+}
 
-	return time.Time{}, ErrNoExifDate // No suitable date tag found
+// applyExifTimeDetails adds the fractional seconds of the subSec tag ("65" for 0.65 s) to the
+// EXIF wall-clock time date and places it in the time zone of the offset tag ("+09:00"). Missing or
+// malformed tags are ignored.
+func applyExifTimeDetails(date time.Time, get func(exif.FieldName) (*tiff.Tag, error), subSec, offset exif.FieldName) time.Time {
+	nanos := 0
+	if tag, err := get(subSec); err == nil {
+		if digits, err := tag.StringVal(); err == nil {
+			digits = strings.TrimSpace(digits)
+			if len(digits) > 9 {
+				digits = digits[:9]
+			}
+			if n, err := strconv.Atoi(digits + strings.Repeat("0", 9-len(digits))); err == nil && n >= 0 && digits != "" {
+				nanos = n
+			}
+		}
+	}
+	loc := date.Location()
+	if tag, err := get(offset); err == nil {
+		if value, err := tag.StringVal(); err == nil {
+			if zone, err := time.Parse("-07:00", strings.TrimSpace(value)); err == nil {
+				_, seconds := zone.Zone()
+				loc = time.FixedZone("", seconds)
+			}
+		}
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), nanos, loc)
 }
 
 // parseExifDateTime is a helper to parse EXIF datetime string.
@@ -160,6 +832,7 @@ func parseExifDateTime(tag *tiff.Tag) (time.Time, error) {
 // "2023-10-27-153000-1.jpg", etc.)
 // baseNameWithoutExt should not include the extension.
 // extension should include the dot (e.g., ".jpg").
+// Names are compared in Unicode NFC, so a decomposed entry matches a composed base name and vice versa.
 func FindPotentialTargetConflicts(targetMonthDir, baseNameWithoutExt, extension string) ([]string, error) {
 	var conflictingFiles []string
 
@@ -192,7 +865,7 @@ func FindPotentialTargetConflicts(targetMonthDir, baseNameWithoutExt, extension
 	// We need to escape baseNameWithoutExt and extension for regex, though typically they won't have special chars.
 	// For simplicity and given the controlled nature of these inputs, direct string matching is safer and clearer.
 
-	lcPrefix := strings.ToLower(baseNameWithoutExt)
+	lcPrefix := strings.ToLower(norm.NFC.String(baseNameWithoutExt))
 	lcSuffix := strings.ToLower(extension) // extension is already dot-prefixed
 
 	for _, entry := range entries {
@@ -200,9 +873,10 @@ func FindPotentialTargetConflicts(targetMonthDir, baseNameWithoutExt, extension
 			continue
 		}
 		entryName := entry.Name()
-		entryNameLower := strings.ToLower(entryName)
+		entryNameLower := strings.ToLower(norm.NFC.String(entryName))
 
-		if strings.HasPrefix(entryNameLower, lcPrefix) && strings.HasSuffix(entryNameLower, lcSuffix) {
+		// The prefix and suffix must not overlap, e.g. for a base name ending in the extension itself.
+		if len(entryNameLower) >= len(lcPrefix)+len(lcSuffix) && strings.HasPrefix(entryNameLower, lcPrefix) && strings.HasSuffix(entryNameLower, lcSuffix) {
 			// Check the part between prefix and suffix
 			middlePart := entryNameLower[len(lcPrefix) : len(entryNameLower)-len(lcSuffix)]
 
@@ -234,6 +908,41 @@ func FindPotentialTargetConflicts(targetMonthDir, baseNameWithoutExt, extension
 	return conflictingFiles, nil
 }
 
+// SniffImageType reads the first bytes of the file at path and matches them against the
+// JPEG, PNG, GIF, WebP and HEIC/HEIF magic numbers. It returns the extension to use for the
+// detected type (e.g. ".jpg") and whether the type was recognized.
+func SniffImageType(path string) (ext string, ok bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(file, header)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return ".jpg", true
+	case bytes.HasPrefix(header, []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}):
+		return ".png", true
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return ".gif", true
+	case len(header) == 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return ".webp", true
+	case len(header) == 12 && string(header[4:8]) == "ftyp":
+		// ISO base media file: the major brand identifies HEIF content.
+		switch string(header[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			return ".heic", true
+		case "mif1", "msf1":
+			return ".heif", true
+		}
+	}
+	return "", false
+}
+
 // IsImageExtension checks if the given filePath has a known image extension.
 // It uses the internal imageExtensions map.
 func IsImageExtension(filePath string) bool {
@@ -241,3 +950,24 @@ func IsImageExtension(filePath string) bool {
 	_, exists := imageExtensions[ext]
 	return exists
 }
+
+// IsVideoExtension checks if the given filePath has a known video extension, such as .mp4 or .mov.
+func IsVideoExtension(filePath string) bool {
+	return videoExtensions[strings.ToLower(filepath.Ext(filePath))]
+}
+
+// IsSourceImage reports whether a scan of the source would sort the file at path: it has an image
+// extension, or no extension and image content with sniffExtensionless, and is not generated by photocp.
+func IsSourceImage(path string, sniffExtensionless bool) bool {
+	if IsGeneratedFileName(filepath.Base(path)) {
+		return false
+	}
+	if IsImageExtension(path) {
+		return true
+	}
+	if filepath.Ext(path) != "" || !sniffExtensionless {
+		return false
+	}
+	_, ok := SniffImageType(path)
+	return ok
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"sort"
+	"strings"
+)
+
+// decoderProbes holds, per image extension, file headers of that format. A registered image decoder
+// recognizes at least one of them. Extensions without probes have no decoder.
+var decoderProbes = map[string][]string{
+	".jpg":  {"\xff\xd8\xff"},
+	".jpeg": {"\xff\xd8\xff"},
+	".png":  {"\x89PNG\r\n\x1a\n"},
+	".gif":  {"GIF89a", "GIF87a"},
+	".webp": {"RIFF\x00\x00\x00\x00WEBPVP8 "},
+	".heic": {"\x00\x00\x00\x18ftypheic", "\x00\x00\x00\x18ftypheix"},
+	".heif": {"\x00\x00\x00\x18ftypmif1", "\x00\x00\x00\x18ftypheic"},
+	".cr2":  {"II*\x00"},
+	".nef":  {"MM\x00*", "II*\x00"},
+	".arw":  {"II*\x00"},
+	".pef":  {"II*\x00", "MM\x00*"},
+	".dng":  {"II*\x00", "MM\x00*"},
+	".orf":  {"IIRO", "IIRS"},
+	".rw2":  {"IIU\x00"},
+}
+
+// ImageExtensions returns the extensions of the image files that are sorted, such as ".jpg", in
+// lexicographic order.
+func ImageExtensions() []string {
+	return sortedKeys(imageExtensions)
+}
+
+// VideoExtensions returns the extensions recognized by IsVideoExtension in lexicographic order.
+func VideoExtensions() []string {
+	return sortedKeys(videoExtensions)
+}
+
+// HasImageDecoder reports whether an image decoder for files with the extension ext (e.g. ".heic") is
+// registered with the image package, so that their resolution and pixel hash can be computed. Files
+// without one are compared by file hash and dated by EXIF or modification time only. The answer depends
+// on the decoders compiled into the program, e.g. whether it imports github.com/vegidio/heif-go.
+func HasImageDecoder(ext string) bool {
+	for _, header := range decoderProbes[strings.ToLower(ext)] {
+		// The header alone is too short to decode, so a registered decoder fails with its own error.
+		probe := append([]byte(header), make([]byte, 32)...)
+		if _, _, err := image.DecodeConfig(bytes.NewReader(probe)); !errors.Is(err, image.ErrFormat) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of set in lexicographic order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInsufficientSpace is returned before any file is processed when the source files would not fit
+// into the free space of the target filesystem.
+var ErrInsufficientSpace = fmt.Errorf("insufficient free space in target directory")
+
+// ErrFreeSpaceUnknown is returned by AvailableSpace on platforms where free space cannot be queried.
+var ErrFreeSpaceUnknown = fmt.Errorf("free space cannot be determined on this platform")
+
+// FreeSpaceMargin is the free space, in bytes, that CheckFreeSpace keeps in reserve on the target filesystem.
+const FreeSpaceMargin = 100 << 20 // 100 MiB
+
+// CheckFreeSpace returns an error wrapping ErrInsufficientSpace if required bytes exceed the space
+// available in dir minus FreeSpaceMargin. available reports the free space of dir's filesystem;
+// nil uses AvailableSpace. If the free space cannot be determined, the check is skipped with a warning.
+func CheckFreeSpace(dir string, required uint64, available func(dir string) (uint64, error), logger Logger) error {
+	if available == nil {
+		available = AvailableSpace
+	}
+	free, err := available(dir)
+	if err != nil {
+		if errors.Is(err, ErrFreeSpaceUnknown) {
+			logger.Debug("Free space check skipped", "dir", dir, "error", err)
+		} else {
+			logger.Warn("Could not determine free space, skipping check", "dir", dir, "error", err)
+		}
+		return nil
+	}
+	if free < FreeSpaceMargin || required > free-FreeSpaceMargin {
+		return fmt.Errorf("%w: '%s' has %d bytes free, the source files need %d bytes plus a margin of %d bytes",
+			ErrInsufficientSpace, dir, free, required, FreeSpaceMargin)
+	}
+	logger.Debug("Free space check passed", "dir", dir, "free", free, "required", required)
+	return nil
+}
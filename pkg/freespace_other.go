@@ -0,0 +1,8 @@
+//go:build !unix
+
+package pkg
+
+// AvailableSpace returns ErrFreeSpaceUnknown, as free space is not queried on this platform.
+func AvailableSpace(dir string) (uint64, error) {
+	return 0, ErrFreeSpaceUnknown
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package pkg
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// AvailableSpace returns the number of bytes available to unprivileged users on the filesystem of dir.
+func AvailableSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, fmt.Errorf("failed to get filesystem statistics for %s: %w", dir, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
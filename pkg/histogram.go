@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"fmt"
+	"image"
+	"os"
+)
+
+// histogramBinsPerChannel is the number of bins each of the R, G and B channels is quantized to,
+// giving ColorHistogram histograms of histogramBinsPerChannel³ bins.
+const histogramBinsPerChannel = 8
+
+// ColorHistogram decodes the image at path and returns its normalized RGB color histogram: the share
+// of its pixels in each of histogramBinsPerChannel³ color bins, summing to 1. Unlike a pixel hash, it
+// barely changes when an image is slightly cropped or re-encoded. Transparency is ignored.
+func ColorHistogram(path string) ([]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image %s for its histogram: %w", path, err)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image %s for its histogram: %w", path, err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, fmt.Errorf("image %s has no pixels", path)
+	}
+	histogram := make([]float64, histogramBinsPerChannel*histogramBinsPerChannel*histogramBinsPerChannel)
+	const shift = 16 - 3 // RGBA returns 16-bit channels; keep the top 3 bits for 8 bins
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			histogram[(r>>shift)*histogramBinsPerChannel*histogramBinsPerChannel+(g>>shift)*histogramBinsPerChannel+(b>>shift)]++
+		}
+	}
+	pixels := float64(bounds.Dx() * bounds.Dy())
+	for i := range histogram {
+		histogram[i] /= pixels
+	}
+	return histogram, nil
+}
+
+// HistogramSimilarity returns the intersection of the normalized histograms a and b: 1 for identical
+// color distributions and 0 for images without any colors in common. Histograms of different lengths
+// have a similarity of 0.
+func HistogramSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	similarity := 0.0
+	for i := range a {
+		similarity += min(a[i], b[i])
+	}
+	return similarity
+}
+
+// histogramsSimilar reports whether the images at path1 and path2 have color histograms with a
+// HistogramSimilarity of at least threshold. Images that cannot be decoded are never similar.
+func histogramsSimilar(path1, path2 string, threshold float64) (similarity float64, similar bool) {
+	h1, err1 := ColorHistogram(path1)
+	h2, err2 := ColorHistogram(path2)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	similarity = HistogramSimilarity(h1, h2)
+	return similarity, similarity >= threshold
+}
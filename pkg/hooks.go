@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// On-copy command placeholders, replaced by the source and target paths of the copied file.
+const (
+	OnCopySourcePlaceholder = "{src}"
+	OnCopyTargetPlaceholder = "{dst}"
+)
+
+// OnCopyArgs splits the on-copy command template into arguments at whitespace and replaces the
+// placeholders in every argument. A path containing spaces stays a single argument.
+func OnCopyArgs(template, srcPath, dstPath string) []string {
+	replacer := strings.NewReplacer(OnCopySourcePlaceholder, srcPath, OnCopyTargetPlaceholder, dstPath)
+	args := strings.Fields(template)
+	for i, arg := range args {
+		args[i] = replacer.Replace(arg)
+	}
+	return args
+}
+
+// RunOnCopy runs the on-copy command template for a file copied from srcPath to dstPath and returns
+// its combined output. The command is executed directly, not through a shell.
+func RunOnCopy(template, srcPath, dstPath string) ([]byte, error) {
+	args := OnCopyArgs(template, srcPath, dstPath)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("on-copy command is blank")
+	}
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("on-copy command %q for %s: %w", args[0], dstPath, err)
+	}
+	return output, nil
+}
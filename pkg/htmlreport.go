@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Report formats (see Options.ReportFormat).
+const (
+	ReportFormatText = "text" // Only the text report (default)
+	ReportFormatHTML = "html" // The text report and an HTML duplicate report with thumbnails
+)
+
+// HTMLReportFileName is the name of the HTML duplicate report written next to ReportFileName.
+const HTMLReportFileName = "report.html"
+
+// HTMLThumbnailSize is the longest side, in pixels, of the thumbnails in the HTML report.
+const HTMLThumbnailSize = 160
+
+// ValidateReportFormat returns an error if format is not one of the ReportFormat* formats.
+// An empty format is accepted and means ReportFormatText.
+func ValidateReportFormat(format string) error {
+	switch format {
+	case "", ReportFormatText, ReportFormatHTML:
+		return nil
+	}
+	return fmt.Errorf("unknown report format '%s' (expected %s or %s)", format, ReportFormatText, ReportFormatHTML)
+}
+
+// HTMLReportPath returns the path of the HTML report that goes with the text report at textReportPath:
+// the same path with a ".html" extension, e.g. report.html for report.txt.
+func HTMLReportPath(textReportPath string) string {
+	return strings.TrimSuffix(textReportPath, filepath.Ext(textReportPath)) + ".html"
+}
+
+// htmlReportFile is a kept or discarded file as shown in the HTML report.
+type htmlReportFile struct {
+	Path      string
+	Thumbnail template.URL // A data URI of a PNG thumbnail, or empty if the file is not a decodable image
+	Note      string       // Shown instead of the thumbnail, e.g. for a target that was replaced
+}
+
+// htmlReportEntry is a duplicate pair as shown in the HTML report.
+type htmlReportEntry struct {
+	Kept, Discarded htmlReportFile
+	Reason          string
+	Comparison      string
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Photo Sorting Report - Duplicates</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.5em; text-align: left; vertical-align: top; }
+td img { display: block; max-width: {{.ThumbnailSize}}px; max-height: {{.ThumbnailSize}}px; margin-bottom: 0.3em; }
+.path { font-family: monospace; font-size: 0.85em; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Photo Sorting Report - Duplicates</h1>
+<p>{{len .Entries}} duplicate(s)</p>
+{{if .Entries}}<table>
+<tr><th>Kept</th><th>Discarded</th><th>Reason</th></tr>
+{{range .Entries}}<tr>
+<td>{{if .Kept.Thumbnail}}<img src="{{.Kept.Thumbnail}}" alt="kept">{{end}}<span class="path">{{.Kept.Path}}</span></td>
+<td>{{if .Discarded.Thumbnail}}<img src="{{.Discarded.Thumbnail}}" alt="discarded">{{end}}{{if .Discarded.Note}}<em>{{.Discarded.Note}}</em><br>{{end}}<span class="path">{{.Discarded.Path}}</span></td>
+<td>{{.Reason}}{{if .Comparison}}<br><span class="path">{{.Comparison}}</span>{{end}}</td>
+</tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// GenerateHTMLReport writes an HTML page to reportPath that shows each duplicate pair side by side, with
+// thumbnails of the kept and discarded files that are images, to review the duplicates found visually.
+// Files that are not images or no longer exist are shown by name only, and so are targets that a
+// source replaced, as their path now holds the source. As in the text report,
+// opts.Verbose adds the comparison behind each duplicate.
+func GenerateHTMLReport(reportPath string, duplicates []DuplicateInfo, opts Options) error {
+	logger := opts.LoggerOrDefault()
+	thumbnails := make(map[string]template.URL) // Kept files are shown once per duplicate
+	file := func(path string) htmlReportFile {
+		thumbnail, ok := thumbnails[path]
+		if !ok {
+			var err error
+			if thumbnail, err = thumbnailDataURI(path, HTMLThumbnailSize); err != nil {
+				logger.Debug("No thumbnail for HTML report", "file", path, "error", err)
+			}
+			thumbnails[path] = thumbnail
+		}
+		return htmlReportFile{Path: path, Thumbnail: thumbnail}
+	}
+	entries := make([]htmlReportEntry, 0, len(duplicates))
+	for _, d := range duplicates {
+		entry := htmlReportEntry{Kept: file(d.KeptFile), Discarded: file(d.DiscardedFile), Reason: d.ReasonText()}
+		if d.Replaced {
+			entry.Discarded = htmlReportFile{Path: d.DiscardedFile, Note: "replaced, original not available"}
+		}
+		if opts.Verbose {
+			entry.Comparison = d.ComparisonText()
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := MkdirAll(filepath.Dir(reportPath), opts.DirModeOrDefault()); err != nil {
+		return fmt.Errorf("failed to create directory for HTML report '%s': %w", reportPath, err)
+	}
+	var page bytes.Buffer
+	data := struct {
+		ThumbnailSize int
+		Entries       []htmlReportEntry
+	}{HTMLThumbnailSize, entries}
+	if err := htmlReportTemplate.Execute(&page, data); err != nil {
+		return fmt.Errorf("failed to render HTML report: %w", err)
+	}
+	if err := writeFileWithMode(reportPath, page.Bytes(), opts.FileModeOrDefault()); err != nil {
+		return fmt.Errorf("failed to write HTML report '%s': %w", reportPath, err)
+	}
+	fmt.Printf("HTML report generated at %s\n", reportPath)
+	return nil
+}
+
+// thumbnailDataURI decodes the image at path, scales it down so that neither side exceeds maxDim and
+// returns it as a data URI of a PNG image.
+func thumbnailDataURI(path string, maxDim int) (template.URL, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("decoding %s: %w", path, err)
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, downscaleNearest(img, maxDim)); err != nil {
+		return "", fmt.Errorf("encoding thumbnail of %s: %w", path, err)
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(encoded.Bytes())), nil
+}
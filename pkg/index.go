@@ -0,0 +1,236 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IndexFileName is the name of the target index written to the root of the target directory.
+const IndexFileName = "index.json"
+
+// IndexEntry describes one image file in the target directory.
+// ModTime and Size are used to detect entries that are stale because the file changed.
+type IndexEntry struct {
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"`
+	FileHash  string    `json:"fileHash"`
+	PixelHash string    `json:"pixelHash,omitempty"` // Empty if the format is not supported for pixel hashing
+}
+
+// TargetIndex is a content-addressable index of the image files in a target directory.
+// Entries are keyed by their path relative to the target directory, using "/" separators.
+type TargetIndex struct {
+	Entries map[string]IndexEntry `json:"entries"`
+}
+
+// BuildTargetIndex hashes every image file in targetDir and its subdirectories.
+func BuildTargetIndex(targetDir string) (*TargetIndex, error) {
+	idx := &TargetIndex{Entries: make(map[string]IndexEntry)}
+	if err := idx.Refresh(targetDir); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Load replaces the index with the JSON index stored at path.
+func (idx *TargetIndex) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read target index %s: %w", path, err)
+	}
+	var loaded TargetIndex
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse target index %s: %w", path, err)
+	}
+	if loaded.Entries == nil {
+		loaded.Entries = make(map[string]IndexEntry)
+	}
+	*idx = loaded
+	return nil
+}
+
+// Save writes the index to path as JSON.
+func (idx *TargetIndex) Save(path string) error {
+	return idx.SaveWithMode(path, DefaultFileMode)
+}
+
+// SaveWithMode behaves like Save but gives the index file mode, e.g. Options.FileModeOrDefault.
+func (idx *TargetIndex) SaveWithMode(path string, mode fs.FileMode) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode target index: %w", err)
+	}
+	if err := writeFileWithMode(path, data, mode); err != nil {
+		return fmt.Errorf("failed to write target index %s: %w", path, err)
+	}
+	return nil
+}
+
+// Stale returns the sorted relative paths of entries whose file in targetDir no longer exists
+// or whose size or modification time differs from the index.
+func (idx *TargetIndex) Stale(targetDir string) []string {
+	var stale []string
+	for relPath, entry := range idx.Entries {
+		info, err := os.Stat(filepath.Join(targetDir, filepath.FromSlash(relPath)))
+		if err != nil || !entry.matches(info) {
+			stale = append(stale, relPath)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// Refresh brings the index up to date with targetDir: entries of removed files are dropped, and
+// only new files and files whose size or modification time changed are hashed.
+func (idx *TargetIndex) Refresh(targetDir string) error {
+	seen := make(map[string]bool)
+	err := filepath.Walk(targetDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !info.Mode().IsRegular() || !IsImageExtension(path) {
+			return nil
+		}
+		relPath, err := indexKey(targetDir, path)
+		if err != nil {
+			return err
+		}
+		seen[relPath] = true
+		if entry, ok := idx.Entries[relPath]; ok && entry.matches(info) {
+			return nil
+		}
+		return idx.add(relPath, path, info)
+	})
+	if err != nil {
+		return fmt.Errorf("error indexing target directory %s: %w", targetDir, err)
+	}
+	for relPath := range idx.Entries {
+		if !seen[relPath] {
+			delete(idx.Entries, relPath)
+		}
+	}
+	return nil
+}
+
+// Add hashes the file at path, which must lie within targetDir, and records it in the index.
+func (idx *TargetIndex) Add(targetDir, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s for indexing: %w", path, err)
+	}
+	relPath, err := indexKey(targetDir, path)
+	if err != nil {
+		return err
+	}
+	return idx.add(relPath, path, info)
+}
+
+// ExtensionDuplicates returns the sources among paths whose content is already in the index under another
+// extension, e.g. photo.jpeg when the target has an identical 2023-10-27-153000.jpg, as duplicates of that
+// file in targetDir, and the other sources as remaining (see ExtensionDuplicateFunc).
+func (idx *TargetIndex) ExtensionDuplicates(targetDir string, paths []string, extensionOrder []string, logger Logger) (remaining []string, duplicates []DuplicateInfo) {
+	extensionDuplicate := idx.ExtensionDuplicateFunc(targetDir, extensionOrder, logger)
+	for _, path := range paths {
+		if dup, ok := extensionDuplicate(path); ok {
+			duplicates = append(duplicates, dup)
+		} else {
+			remaining = append(remaining, path)
+		}
+	}
+	return remaining, duplicates
+}
+
+// ExtensionDuplicateFunc returns a func that reports whether the source at path is a duplicate of a file in
+// targetDir that the index lists with identical content and another extension. Only an indexed file whose
+// extension comes no later than the source's in extensionOrder (see ParseExtensionOrder) is kept, so a source
+// with a preferred extension is sorted as usual, and only if the file still exists as it was indexed.
+// Only sources with the size of an indexed file are hashed. Sources that cannot be read are not duplicates.
+// The index must not change while the func is used.
+func (idx *TargetIndex) ExtensionDuplicateFunc(targetDir string, extensionOrder []string, logger Logger) func(path string) (DuplicateInfo, bool) {
+	sizes := make(map[int64]bool, len(idx.Entries))
+	for _, entry := range idx.Entries {
+		sizes[entry.Size] = true
+	}
+	return func(path string) (DuplicateInfo, bool) {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() == 0 || !sizes[info.Size()] {
+			return DuplicateInfo{}, false
+		}
+		hash, err := CalculateFileHash(path)
+		if err != nil {
+			logger.Debug("Could not hash source file, sorting it individually", "source", path, "error", err)
+			return DuplicateInfo{}, false
+		}
+		for _, relPath := range idx.PathsForFileHash(hash) {
+			if strings.EqualFold(filepath.Ext(relPath), filepath.Ext(path)) || extensionRank(relPath, extensionOrder) > extensionRank(path, extensionOrder) {
+				continue
+			}
+			kept := filepath.Join(targetDir, filepath.FromSlash(relPath))
+			if keptInfo, err := os.Stat(kept); err != nil || !idx.Entries[relPath].matches(keptInfo) {
+				logger.Debug("Indexed file with the same content is gone or changed, ignoring it", "source", path, "indexed", kept)
+				continue
+			}
+			logger.Debug("Source file is already in the target with another extension", "source", path, "kept", kept)
+			return DuplicateInfo{KeptFile: kept, DiscardedFile: path, Reason: ReasonFileHashMatch, Detail: "identical file already in target with another extension"}, true
+		}
+		return DuplicateInfo{}, false
+	}
+}
+
+// PathsForFileHash returns the sorted relative paths of the indexed files with the given file hash.
+func (idx *TargetIndex) PathsForFileHash(hash string) []string {
+	return idx.pathsWhere(func(entry IndexEntry) bool { return entry.FileHash == hash })
+}
+
+// PathsForPixelHash returns the sorted relative paths of the indexed files with the given pixel data hash.
+func (idx *TargetIndex) PathsForPixelHash(hash string) []string {
+	return idx.pathsWhere(func(entry IndexEntry) bool { return hash != "" && entry.PixelHash == hash })
+}
+
+func (idx *TargetIndex) pathsWhere(match func(IndexEntry) bool) []string {
+	var paths []string
+	for relPath, entry := range idx.Entries {
+		if match(entry) {
+			paths = append(paths, relPath)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// add hashes path and stores it under relPath.
+func (idx *TargetIndex) add(relPath, path string, info os.FileInfo) error {
+	fileHash, err := CalculateFileHash(path)
+	if err != nil {
+		return err
+	}
+	entry := IndexEntry{Size: info.Size(), ModTime: info.ModTime(), FileHash: fileHash}
+	if pixelHash, err := CalculatePixelDataHash(path); err == nil {
+		entry.PixelHash = pixelHash
+	}
+	if idx.Entries == nil {
+		idx.Entries = make(map[string]IndexEntry)
+	}
+	idx.Entries[relPath] = entry
+	return nil
+}
+
+// matches reports whether info still describes the file the entry was built from.
+func (entry IndexEntry) matches(info os.FileInfo) bool {
+	return entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime())
+}
+
+// indexKey returns path relative to targetDir with "/" separators.
+func indexKey(targetDir, path string) (string, error) {
+	relPath, err := filepath.Rel(targetDir, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to make %s relative to %s: %w", path, targetDir, err)
+	}
+	return filepath.ToSlash(relPath), nil
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+)
+
+// ErrCorruptImage is returned by ValidateImageIntegrity for images in a supported format whose data
+// cannot be decoded, e.g. JPEGs cut short by an interrupted download.
+var ErrCorruptImage = errors.New("corrupt image")
+
+// ValidateImageIntegrity decodes the whole image at path. It returns nil if the image decodes, an error
+// wrapping ErrCorruptImage if it is empty or its data is damaged or truncated, and an error wrapping
+// image.ErrFormat if no decoder for its format is registered (e.g. for RAW files). Unlike
+// GetImageResolution, which only reads the header, it detects images that are cut short.
+func ValidateImageIntegrity(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open image %s: %w", path, err)
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		return fmt.Errorf("%w: %s is empty", ErrCorruptImage, path)
+	}
+	header := make([]byte, 32)
+	n, _ := file.ReadAt(header, 0)
+	if isAnimatedWebP(header[:n]) {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return fmt.Errorf("failed to read image %s: %w", path, err)
+		}
+		if _, err := animatedWebPPixelHash(data); err != nil {
+			return fmt.Errorf("%w: webp animation %s is damaged: %v", ErrCorruptImage, path, err)
+		}
+		return nil
+	}
+
+	_, format, err := image.Decode(file)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, image.ErrFormat):
+		return fmt.Errorf("no decoder for the format of %s: %w", path, err)
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF), errors.Is(err, jpeg.FormatError("short Huffman data")):
+		// The JPEG decoder reports data that ends inside an entropy-coded segment as short Huffman data.
+		return fmt.Errorf("%w: %s image data of %s is truncated", ErrCorruptImage, format, path)
+	}
+	return fmt.Errorf("%w: %s image data of %s is damaged: %v", ErrCorruptImage, format, path, err)
+}
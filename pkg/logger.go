@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Logger receives the diagnostic output of a sorting run. Messages are constant strings;
+// details are passed as alternating key/value pairs, as with log/slog.
+// *slog.Logger satisfies this interface.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// NewLogger returns a log/slog-backed Logger writing records of at least level to w,
+// as JSON objects when jsonFormat is true and as key=value text otherwise.
+func NewLogger(w io.Writer, level slog.Level, jsonFormat bool) Logger {
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	if jsonFormat {
+		return slog.New(slog.NewJSONHandler(w, handlerOpts))
+	}
+	return slog.New(slog.NewTextHandler(w, handlerOpts))
+}
+
+// ParseLogLevel parses a level name: "debug", "info", "warn" (or "warning") or "error", case-insensitively.
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", name)
+}
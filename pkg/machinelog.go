@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Kinds of machine log lines (see Options.MachineLog).
+const (
+	MachineLogDuplicate = "DUP"  // A duplicate decision: DUP action=... reason=... kept=... discarded=...
+	MachineLogSkip      = "SKIP" // A skipped source: SKIP reason=... file=...
+)
+
+// Actions of MachineLogDuplicate lines.
+const (
+	MachineLogActionDiscard = "discard" // The discarded file was left alone
+	MachineLogActionReplace = "replace" // The kept source was copied over the discarded target
+)
+
+// DuplicateLogLine formats d as a MachineLogDuplicate line with the fields action, reason (e.g.
+// pixel_hash_match), kept and discarded, in this order.
+func DuplicateLogLine(d DuplicateInfo) string {
+	action := MachineLogActionDiscard
+	if d.Replaced {
+		action = MachineLogActionReplace
+	}
+	return machineLogLine(MachineLogDuplicate, "action", action, "reason", d.Reason.String(), "kept", d.KeptFile, "discarded", d.DiscardedFile)
+}
+
+// SkipLogLine formats s as a MachineLogSkip line with the fields reason (e.g. "Skipped (empty file)")
+// and file, in this order.
+func SkipLogLine(s SkippedInfo) string {
+	return machineLogLine(MachineLogSkip, "reason", s.Reason, "file", s.SourceFile)
+}
+
+// machineLogLine joins kind and the key=value pairs of keysAndValues with spaces. Values that are
+// empty or contain spaces, quotes, '=' or non-printable characters are quoted as Go strings.
+func machineLogLine(kind string, keysAndValues ...string) string {
+	var line strings.Builder
+	line.WriteString(kind)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		value := keysAndValues[i+1]
+		if value == "" || strings.ContainsFunc(value, func(r rune) bool { return r == '"' || r == '=' || unicode.IsSpace(r) || !unicode.IsPrint(r) }) {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&line, " %s=%s", keysAndValues[i], value)
+	}
+	return line.String()
+}
+
+// ParseMachineLogLine splits a line written by DuplicateLogLine or SkipLogLine into its kind and fields.
+func ParseMachineLogLine(line string) (kind string, fields map[string]string, err error) {
+	kind, rest, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+	if kind != MachineLogDuplicate && kind != MachineLogSkip {
+		return "", nil, fmt.Errorf("not a machine log line: %q", line)
+	}
+	fields = make(map[string]string)
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok || key == "" || strings.Contains(key, " ") {
+			return "", nil, fmt.Errorf("malformed field in machine log line: %q", line)
+		}
+		if strings.HasPrefix(value, `"`) {
+			quoted, unquoteErr := strconv.QuotedPrefix(value)
+			if unquoteErr != nil {
+				return "", nil, fmt.Errorf("malformed value of %s in machine log line: %q", key, line)
+			}
+			fields[key], _ = strconv.Unquote(quoted)
+			value = value[len(quoted):]
+		} else {
+			end := strings.IndexByte(value, ' ')
+			if end < 0 {
+				end = len(value)
+			}
+			fields[key] = value[:end]
+			value = value[end:]
+		}
+		if value != "" && !strings.HasPrefix(value, " ") {
+			return "", nil, fmt.Errorf("malformed value of %s in machine log line: %q", key, line)
+		}
+		rest = strings.TrimPrefix(value, " ")
+	}
+	return kind, fields, nil
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Actions for a source file whose content does not match its extension (see Options.ValidateMime).
+const (
+	MimeMismatchFix        = "fix"        // Give the target the extension of the content (default)
+	MimeMismatchQuarantine = "quarantine" // Fail the file with ErrExtensionMismatch, so it is quarantined
+)
+
+// ErrExtensionMismatch is returned for source files whose content does not match their extension
+// when Options.ValidateMime is set with MimeMismatchQuarantine.
+var ErrExtensionMismatch = errors.New("content does not match file extension")
+
+// extensionAliases maps extensions to the extension SniffMediaType returns for the same type.
+var extensionAliases = map[string]string{
+	".jpeg": ".jpg",
+	".jpe":  ".jpg",
+	".jfif": ".jpg",
+	".heif": ".heic",
+	".hif":  ".heic",
+	".m4v":  ".mp4",
+}
+
+// videoBrands maps the major brands of ISO base media files that are videos to their extension.
+var videoBrands = map[string]string{
+	"qt  ": ".mov",
+	"isom": ".mp4",
+	"iso2": ".mp4",
+	"mp41": ".mp4",
+	"mp42": ".mp4",
+	"avc1": ".mp4",
+	"M4V ": ".mp4",
+}
+
+// ValidateMimeMismatchAction returns an error if action is not one of the MimeMismatch* actions.
+// An empty action is accepted and means MimeMismatchFix.
+func ValidateMimeMismatchAction(action string) error {
+	switch action {
+	case "", MimeMismatchFix, MimeMismatchQuarantine:
+		return nil
+	}
+	return fmt.Errorf("unknown MIME mismatch action '%s' (expected %s or %s)", action, MimeMismatchFix, MimeMismatchQuarantine)
+}
+
+// SniffMediaType behaves like SniffImageType, but also recognizes MP4 and QuickTime videos.
+func SniffMediaType(path string) (ext string, ok bool) {
+	if ext, ok := SniffImageType(path); ok {
+		return ext, true
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+	header := make([]byte, 12)
+	if n, _ := file.Read(header); n < len(header) || string(header[4:8]) != "ftyp" {
+		return "", false
+	}
+	ext, ok = videoBrands[string(header[8:12])]
+	return ext, ok
+}
+
+// ExtensionMismatch sniffs the content of the file at path (see SniffMediaType) and reports whether it
+// is of a different type than the extension of path says, returning the extension of the sniffed type.
+// Aliases such as ".jpeg" for ".jpg" match. Files of types that cannot be sniffed, e.g. RAW files,
+// never mismatch.
+func ExtensionMismatch(path string) (sniffedExt string, mismatch bool) {
+	sniffedExt, ok := SniffMediaType(path)
+	if !ok {
+		return "", false
+	}
+	canonical := func(ext string) string {
+		ext = strings.ToLower(ext)
+		if alias, ok := extensionAliases[ext]; ok {
+			return alias
+		}
+		return ext
+	}
+	if canonical(filepath.Ext(path)) == canonical(sniffedExt) {
+		return "", false
+	}
+	return sniffedExt, true
+}
+
+// correctedExtension returns the extension of the content of the source at path if opts.ValidateMime
+// fixes extensions and it does not match the extension of path.
+func correctedExtension(path string, opts Options) (string, bool) {
+	if !opts.ValidateMime || opts.MimeMismatchAction == MimeMismatchQuarantine {
+		return "", false
+	}
+	return ExtensionMismatch(path)
+}
+
+// checkExtension returns an error wrapping ErrExtensionMismatch if opts.ValidateMime rejects sources
+// whose content does not match their extension and the source at path is one.
+func checkExtension(path string, opts Options) error {
+	if !opts.ValidateMime || opts.MimeMismatchAction != MimeMismatchQuarantine {
+		return nil
+	}
+	if sniffedExt, mismatch := ExtensionMismatch(path); mismatch {
+		return fmt.Errorf("%w: %s has %s content", ErrExtensionMismatch, path, sniffedExt)
+	}
+	return nil
+}
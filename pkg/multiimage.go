@@ -0,0 +1,301 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"os"
+
+	"golang.org/x/image/webp"
+)
+
+// ImageCount returns the number of images in the file at path: the frames of an animated GIF or WebP,
+// the master images of a HEIF file (see heifImageCount), and 1 for other files. Files with more than
+// one image are pixel hashed over all of them (or, for HEIF, their count), so that a burst or an
+// animation is not taken for a duplicate of a still that shows its first image.
+func ImageCount(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+	header := make([]byte, 32)
+	n, _ := file.ReadAt(header, 0)
+	header = header[:n]
+
+	switch {
+	case isAnimatedWebP(header):
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		frames, err := webpFrameChunks(data)
+		return len(frames), err
+	case bytes.HasPrefix(header, []byte("GIF8")):
+		animation, err := gif.DecodeAll(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode GIF frames of %s: %w", path, err)
+		}
+		return len(animation.Image), nil
+	case isHEIF(header):
+		info, err := file.Stat()
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		return heifImageCount(file, info.Size())
+	}
+	return 1, nil
+}
+
+// isAnimatedWebP reports whether header, the start of a file, is that of a WebP with the animation flag set.
+func isAnimatedWebP(header []byte) bool {
+	const animationBit = 1 << 1
+	return len(header) >= 21 && string(header[0:4]) == "RIFF" && string(header[8:16]) == "WEBPVP8X" && header[20]&animationBit != 0
+}
+
+// heifBrands are the major brands of HEIF still images and image sequences.
+var heifBrands = map[string]bool{"heic": true, "heix": true, "hevc": true, "hevx": true, "heim": true, "heis": true, "mif1": true, "msf1": true}
+
+// isHEIF reports whether header, the start of a file, is that of a HEIF file.
+func isHEIF(header []byte) bool {
+	return len(header) >= 12 && string(header[4:8]) == "ftyp" && heifBrands[string(header[8:12])]
+}
+
+// webpChunks calls fn with the FourCC and payload of each chunk in data, which starts after a RIFF
+// header or ANMF frame header, in order. It stops at the first chunk that runs past the end of data.
+func webpChunks(data []byte, fn func(fourCC string, payload []byte)) {
+	for len(data) >= 8 {
+		size := binary.LittleEndian.Uint32(data[4:8])
+		if uint64(size) > uint64(len(data)-8) {
+			return
+		}
+		fn(string(data[:4]), data[8:8+size])
+		data = data[8+size:]
+		if size%2 == 1 && len(data) > 0 { // Chunks are padded to an even size
+			data = data[1:]
+		}
+	}
+}
+
+// webpFrameChunks returns the payloads of the ANMF (animation frame) chunks of the animated WebP data.
+func webpFrameChunks(data []byte) ([][]byte, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("animated WebP is truncated")
+	}
+	var frames [][]byte
+	webpChunks(data[12:], func(fourCC string, payload []byte) {
+		if fourCC == "ANMF" && len(payload) >= 16 {
+			frames = append(frames, payload)
+		}
+	})
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("animated WebP has no frames")
+	}
+	return frames, nil
+}
+
+// uint24 decodes the little-endian 24-bit integers of WebP headers.
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// animatedWebPPixelHash returns the pixel hash of the animated WebP data. Each frame is decoded on its
+// own, as golang.org/x/image/webp cannot decode animations, and hashed with its placement, duration
+// and blending flags, as AnimationPixelHash does for GIFs. An animation of a single frame hashes like
+// that frame as a still image.
+func animatedWebPPixelHash(data []byte) (string, error) {
+	frames, err := webpFrameChunks(data)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	for i, frame := range frames {
+		x, y := 2*uint24(frame[0:3]), 2*uint24(frame[3:6])
+		width, height := uint24(frame[6:9])+1, uint24(frame[9:12])+1
+		img, err := decodeWebPFrame(frame)
+		if err != nil {
+			return "", fmt.Errorf("decoding frame %d: %w", i+1, err)
+		}
+		if len(frames) == 1 {
+			return ImagePixelHash(img), nil
+		}
+		fmt.Fprintf(hasher, "%s %v %d %d\n", ImagePixelHash(img), image.Rect(x, y, x+width, y+height), uint24(frame[12:15]), frame[15])
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// decodeWebPFrame decodes the image of an ANMF chunk payload by wrapping its bitstream, and alpha
+// data if any, in a still WebP file.
+func decodeWebPFrame(frame []byte) (image.Image, error) {
+	const alphaBit = 1 << 4
+	var flags byte
+	webpChunks(frame[16:], func(fourCC string, _ []byte) {
+		if fourCC == "ALPH" {
+			flags |= alphaBit
+		}
+	})
+	var still bytes.Buffer
+	still.WriteString("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00")
+	still.Write([]byte{flags, 0, 0, 0})
+	still.Write(frame[6:12]) // Canvas width and height minus one, as for the frame
+	still.Write(frame[16:])
+	data := still.Bytes()
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+	return webp.Decode(bytes.NewReader(data))
+}
+
+// multiImagePixelHash returns the pixel hash of a file whose primary image img is one of count images,
+// for formats whose other images cannot be decoded: the count is hashed with the primary image.
+func multiImagePixelHash(img image.Image, count int) string {
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s images=%d\n", ImagePixelHash(img), count)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// heifMaxMetaSize limits the size of the HEIF meta box that heifImageCount reads.
+const heifMaxMetaSize = 16 << 20
+
+// heifImageItemTypes are the item types of HEIF items that are images.
+var heifImageItemTypes = map[string]bool{"hvc1": true, "av01": true, "grid": true, "iden": true, "iovl": true, "jpeg": true, "unci": true}
+
+// heifImageCount returns the number of master images in the HEIF file r of the given size: the image
+// items of its meta box that are neither tiles of a grid image ("dimg" references), thumbnails ("thmb")
+// nor auxiliary images such as depth or alpha maps ("auxl"). An image sequence track (a moov box)
+// counts as one more image. Files without image items count as one image.
+func heifImageCount(r io.ReaderAt, size int64) (int, error) {
+	var meta []byte
+	sequence := false
+	for offset := int64(0); offset+8 <= size; {
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return 0, fmt.Errorf("reading HEIF box at %d: %w", offset, err)
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch boxSize {
+		case 0: // The box extends to the end of the file
+			boxSize = size - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, fmt.Errorf("reading HEIF box at %d: %w", offset, err)
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if boxSize < headerSize || offset+boxSize > size {
+			return 0, fmt.Errorf("invalid HEIF box size %d at %d", boxSize, offset)
+		}
+		switch string(header[4:8]) {
+		case "meta":
+			if boxSize-headerSize > heifMaxMetaSize {
+				return 0, fmt.Errorf("HEIF meta box of %d bytes is too large", boxSize)
+			}
+			meta = make([]byte, boxSize-headerSize)
+			if _, err := r.ReadAt(meta, offset+headerSize); err != nil {
+				return 0, fmt.Errorf("reading HEIF meta box: %w", err)
+			}
+		case "moov":
+			sequence = true
+		}
+		offset += boxSize
+	}
+
+	count := 0
+	if len(meta) >= 4 {
+		images, notMaster := heifMetaItems(meta[4:]) // Skip the version and flags of the full box
+		for id := range images {
+			if !notMaster[id] {
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		count = 1
+	}
+	if sequence {
+		count++
+	}
+	return count, nil
+}
+
+// heifMetaItems returns the IDs of the image items listed in the iinf box of the HEIF meta box payload,
+// and those of the items that its iref box marks as tiles, thumbnails or auxiliary images.
+func heifMetaItems(meta []byte) (images, notMaster map[uint32]bool) {
+	images, notMaster = make(map[uint32]bool), make(map[uint32]bool)
+	isobmffBoxes(meta, func(boxType string, payload []byte) {
+		if len(payload) < 4 {
+			return
+		}
+		version, body := payload[0], payload[4:]
+		switch boxType {
+		case "iinf":
+			countSize := 2
+			if version > 0 {
+				countSize = 4
+			}
+			if len(body) < countSize {
+				return
+			}
+			isobmffBoxes(body[countSize:], func(entryType string, entry []byte) {
+				if entryType != "infe" || len(entry) < 4 || entry[0] < 2 {
+					return // Item info entries before version 2 have no item type
+				}
+				id, rest, ok := readItemID(entry[4:], entry[0] >= 3)
+				if ok && len(rest) >= 6 && heifImageItemTypes[string(rest[2:6])] { // Skip item_protection_index
+					images[id] = true
+				}
+			})
+		case "iref":
+			isobmffBoxes(body, func(refType string, ref []byte) {
+				from, rest, ok := readItemID(ref, version > 0)
+				if !ok || len(rest) < 2 {
+					return
+				}
+				switch refType {
+				case "thmb", "auxl":
+					notMaster[from] = true
+				case "dimg": // The derived image is made from the referenced ones, e.g. the tiles of a grid
+					rest = rest[2:]
+					for {
+						var to uint32
+						if to, rest, ok = readItemID(rest, version > 0); !ok {
+							break
+						}
+						notMaster[to] = true
+					}
+				}
+			})
+		}
+	})
+	return images, notMaster
+}
+
+// readItemID reads a 16-bit HEIF item ID, or a 32-bit one if wide is set, from the start of b.
+func readItemID(b []byte, wide bool) (id uint32, rest []byte, ok bool) {
+	if wide {
+		if len(b) < 4 {
+			return 0, nil, false
+		}
+		return binary.BigEndian.Uint32(b), b[4:], true
+	}
+	if len(b) < 2 {
+		return 0, nil, false
+	}
+	return uint32(binary.BigEndian.Uint16(b)), b[2:], true
+}
+
+// isobmffBoxes calls fn with the type and payload of each box in data, in order. It stops at the first
+// box that runs past the end of data.
+func isobmffBoxes(data []byte, fn func(boxType string, payload []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		if size < 8 || size > uint64(len(data)) {
+			return
+		}
+		fn(string(data[4:8]), data[8:size])
+		data = data[size:]
+	}
+}
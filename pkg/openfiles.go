@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrTooManyOpenFiles is returned, wrapped, when a file cannot be opened because the process or the
+// system is still out of file descriptors after openFileRetries retries.
+var ErrTooManyOpenFiles = errors.New("too many open files")
+
+// DefaultMaxOpenFiles is the default limit of the -maxOpenFiles flag (see Options.MaxOpenFiles), well
+// below the common descriptor limits of 1024 and, on macOS, 256.
+const DefaultMaxOpenFiles = 128
+
+// openFileRetries is how often opening a file is retried while file descriptors are exhausted; the first
+// retry waits openFileRetryDelay, and each further one twice as long.
+const (
+	openFileRetries    = 8
+	openFileRetryDelay = 10 * time.Millisecond
+)
+
+// OpenFileLimiter is a counting semaphore of the files that a run holds open at once, across goroutines
+// (see Options.OpenFiles). Hashing, date reading, decoding, rotating, converting and copying files wait
+// for a free slot; a copy holds two files. A nil *OpenFileLimiter does not limit them.
+type OpenFileLimiter struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	limit int // 0 means unlimited
+	open  int
+}
+
+// NewOpenFileLimiter returns an OpenFileLimiter that lets n files be open at once; opening further ones
+// waits for others to be closed. n <= 0 means no limit.
+func NewOpenFileLimiter(n int) *OpenFileLimiter {
+	l := &OpenFileLimiter{limit: max(n, 0)}
+	l.freed = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits until n more files may be opened and returns the function that frees them again.
+// Acquiring all files of an operation at once keeps operations from deadlocking on each other; an
+// operation needing more files than the limit runs once nothing else is open.
+func (l *OpenFileLimiter) acquire(n int) (release func()) {
+	if l == nil {
+		return func() {}
+	}
+	l.mu.Lock()
+	for l.limit > 0 && l.open > 0 && l.open+n > l.limit {
+		l.freed.Wait()
+	}
+	l.open += n
+	l.mu.Unlock()
+	return sync.OnceFunc(func() {
+		l.mu.Lock()
+		l.open -= n
+		l.freed.Broadcast()
+		l.mu.Unlock()
+	})
+}
+
+// IsTooManyOpenFiles reports whether err is due to the process (EMFILE) or the system (ENFILE) running
+// out of file descriptors, which passes once other files are closed.
+func IsTooManyOpenFiles(err error) bool {
+	return errors.Is(err, ErrTooManyOpenFiles) || errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// openWithRetry calls open, which opens a file, again while it fails because file descriptors are
+// exhausted, waiting for other files to be closed. If they stay exhausted, the last error is returned
+// wrapped in ErrTooManyOpenFiles.
+func openWithRetry(open func() (*os.File, error)) (*os.File, error) {
+	delay := openFileRetryDelay
+	for attempt := 0; ; attempt++ {
+		file, err := open()
+		if err == nil || !IsTooManyOpenFiles(err) {
+			return file, err
+		}
+		if attempt == openFileRetries {
+			return nil, fmt.Errorf("%w: %w", ErrTooManyOpenFiles, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// limitedFile is a file opened by openLimited, which frees its OpenFileLimiter slot when it is closed.
+type limitedFile struct {
+	*os.File
+	release func()
+}
+
+// Close closes the file and frees its slot.
+func (f *limitedFile) Close() error {
+	defer f.release()
+	return f.File.Close()
+}
+
+// openLimited opens path for reading like os.Open once l allows it, retrying while file descriptors are
+// exhausted (see openWithRetry).
+func (l *OpenFileLimiter) openLimited(path string) (*limitedFile, error) {
+	release := l.acquire(1)
+	file, err := openWithRetry(func() (*os.File, error) { return os.Open(path) })
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &limitedFile{File: file, release: release}, nil
+}
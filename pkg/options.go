@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"io"
+	"io/fs"
+	"time"
+)
+
+// DefaultCopyRetryDelay is the CopyRetryDelay set by NewOptions.
+const DefaultCopyRetryDelay = 500 * time.Millisecond
+
+// Option sets one or more fields of an Options; see NewOptions.
+type Option func(*Options)
+
+// NewOptions returns Options with the defaults of the photocp command, modified by opts in order.
+// Unlike the zero Options, whose empty fields also mean their defaults, it spells out
+// FilenameFormat, DateStrategy and ConflictStrategy and retries copies after DefaultCopyRetryDelay.
+// Fields without an Option can be set on the result directly.
+func NewOptions(opts ...Option) Options {
+	o := Options{
+		FilenameFormat:   DefaultFilenameFormat,
+		DateStrategy:     DateStrategyExifFirst,
+		ConflictStrategy: ConflictKeepTarget,
+		CopyRetryDelay:   DefaultCopyRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithVerbose sets Options.Verbose.
+func WithVerbose(verbose bool) Option { return func(o *Options) { o.Verbose = verbose } }
+
+// WithLogger sets Options.Logger.
+func WithLogger(logger Logger) Option { return func(o *Options) { o.Logger = logger } }
+
+// WithMove sets Options.Move.
+func WithMove(move bool) Option { return func(o *Options) { o.Move = move } }
+
+// WithFlatten sets Options.Flatten.
+func WithFlatten(flatten bool) Option { return func(o *Options) { o.Flatten = flatten } }
+
+// WithStructure sets Options.Structure.
+func WithStructure(structure string) Option { return func(o *Options) { o.Structure = structure } }
+
+// WithLayout sets Options.Layout.
+func WithLayout(layout string) Option { return func(o *Options) { o.Layout = layout } }
+
+// WithFilenameFormat sets Options.FilenameFormat.
+func WithFilenameFormat(format string) Option { return func(o *Options) { o.FilenameFormat = format } }
+
+// WithFileAffixes sets Options.FilePrefix and Options.FileSuffix.
+func WithFileAffixes(prefix, suffix string) Option {
+	return func(o *Options) { o.FilePrefix, o.FileSuffix = prefix, suffix }
+}
+
+// WithHashWorkers sets Options.HashWorkers.
+func WithHashWorkers(workers int) Option { return func(o *Options) { o.HashWorkers = workers } }
+
+// WithMaxOpenFiles sets Options.MaxOpenFiles.
+func WithMaxOpenFiles(n int) Option { return func(o *Options) { o.MaxOpenFiles = n } }
+
+// WithDirMode sets Options.DirMode.
+func WithDirMode(mode fs.FileMode) Option { return func(o *Options) { o.DirMode = mode } }
+
+// WithFileMode sets Options.FileMode.
+func WithFileMode(mode fs.FileMode) Option { return func(o *Options) { o.FileMode = mode } }
+
+// WithMaxDepth sets Options.MaxDepth.
+func WithMaxDepth(maxDepth int) Option { return func(o *Options) { o.MaxDepth = maxDepth } }
+
+// WithQuarantineDir sets Options.QuarantineDir.
+func WithQuarantineDir(dir string) Option { return func(o *Options) { o.QuarantineDir = dir } }
+
+// WithUnknownDateDir sets Options.UnknownDateDir.
+func WithUnknownDateDir(dir string) Option { return func(o *Options) { o.UnknownDateDir = dir } }
+
+// WithDateStrategy sets Options.DateStrategy.
+func WithDateStrategy(strategy DateStrategy) Option {
+	return func(o *Options) { o.DateStrategy = strategy }
+}
+
+// WithConflictStrategy sets Options.ConflictStrategy.
+func WithConflictStrategy(strategy string) Option {
+	return func(o *Options) { o.ConflictStrategy = strategy }
+}
+
+// WithPreferNewer sets Options.PreferNewer.
+func WithPreferNewer(preferNewer bool) Option {
+	return func(o *Options) { o.PreferNewer = preferNewer }
+}
+
+// WithPathResolver sets Options.PathResolver.
+func WithPathResolver(resolver PathResolver) Option {
+	return func(o *Options) { o.PathResolver = resolver }
+}
+
+// WithPreferLargerFile sets Options.PreferLargerFile.
+func WithPreferLargerFile(preferLargerFile bool) Option {
+	return func(o *Options) { o.PreferLargerFile = preferLargerFile }
+}
+
+// WithSkipExistingTargetFast sets Options.SkipExistingTargetFast.
+func WithSkipExistingTargetFast(skip bool) Option {
+	return func(o *Options) { o.SkipExistingTargetFast = skip }
+}
+
+// WithKnownHashesFile sets Options.KnownHashesFile and Options.UpdateKnownHashes.
+func WithKnownHashesFile(path string, update bool) Option {
+	return func(o *Options) { o.KnownHashesFile, o.UpdateKnownHashes = path, update }
+}
+
+// WithCopyRetries sets Options.CopyRetries and Options.CopyRetryDelay.
+func WithCopyRetries(retries int, delay time.Duration) Option {
+	return func(o *Options) { o.CopyRetries, o.CopyRetryDelay = retries, delay }
+}
+
+// WithPreserveTimes sets Options.PreserveTimes.
+func WithPreserveTimes(preserve bool) Option { return func(o *Options) { o.PreserveTimes = preserve } }
+
+// WithReportPath sets Options.ReportPath.
+func WithReportPath(path string) Option { return func(o *Options) { o.ReportPath = path } }
+
+// WithGroupDuplicates sets Options.GroupDuplicates.
+func WithGroupDuplicates(group bool) Option { return func(o *Options) { o.GroupDuplicates = group } }
+
+// WithExifSignatureFields sets Options.ExifSignatureFields.
+func WithExifSignatureFields(fields ...string) Option {
+	return func(o *Options) { o.ExifSignatureFields = fields }
+}
+
+// WithPreferExtensionOrder sets Options.PreferExtensionOrder.
+func WithPreferExtensionOrder(extensions ...string) Option {
+	return func(o *Options) { o.PreferExtensionOrder = extensions }
+}
+
+// WithReportFormat sets Options.ReportFormat.
+func WithReportFormat(format string) Option { return func(o *Options) { o.ReportFormat = format } }
+
+// WithCopySidecars sets Options.CopySidecars.
+func WithCopySidecars(copySidecars bool) Option {
+	return func(o *Options) { o.CopySidecars = copySidecars }
+}
+
+// WithMachineLog sets Options.MachineLog.
+func WithMachineLog(w io.Writer) Option { return func(o *Options) { o.MachineLog = w } }
+
+// WithCompareAlgorithm sets Options.CompareAlgorithm.
+func WithCompareAlgorithm(algorithm string) Option {
+	return func(o *Options) { o.CompareAlgorithm = algorithm }
+}
+
+// WithAutoRotate sets Options.AutoRotate.
+func WithAutoRotate(autoRotate bool) Option { return func(o *Options) { o.AutoRotate = autoRotate } }
+
+// WithCollapseBursts sets Options.CollapseBursts and Options.BurstWindow.
+func WithCollapseBursts(window time.Duration) Option {
+	return func(o *Options) { o.CollapseBursts, o.BurstWindow = true, window }
+}
+
+// WithJSONLines sets Options.JSONLines.
+func WithJSONLines(w io.Writer) Option { return func(o *Options) { o.JSONLines = w } }
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// DateSourceOverride is the date source reported for files whose date comes from a date overrides file.
+const DateSourceOverride = "Override"
+
+// dateOverrideLayouts are the date formats accepted in a date overrides file, tried in order.
+var dateOverrideLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006:01:02 15:04:05", // EXIF style
+	time.RFC3339,
+	"2006-01-02",
+}
+
+// LoadDateOverrides reads a CSV file of "filename,date" rows into a map from source file
+// base name to date. Dates without a zone are taken as UTC. An optional header row whose
+// first field is "filename" is skipped. Rows whose date cannot be parsed are reported to
+// logger and skipped; rows without exactly two fields or with an empty file name are errors.
+func LoadDateOverrides(path string, logger Logger) (map[string]time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open date overrides file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // Field counts are checked below to report the offending line
+	reader.TrimLeadingSpace = true
+	overrides := make(map[string]time.Time)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read date overrides file %s: %w", path, err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != 2 {
+			return nil, fmt.Errorf("date overrides file %s, line %d: expected 2 fields (filename,date), got %d", path, line, len(record))
+		}
+		name, dateStr := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if line == 1 && strings.EqualFold(name, "filename") {
+			continue
+		}
+		if name == "" {
+			return nil, fmt.Errorf("date overrides file %s, line %d: empty file name", path, line)
+		}
+		date, ok := parseOverrideDate(dateStr)
+		if !ok {
+			logger.Warn("Ignoring date override with unparseable date", "file", path, "line", line, "filename", name, "date", dateStr)
+			continue
+		}
+		overrides[name] = date
+	}
+	return overrides, nil
+}
+
+// parseOverrideDate parses s with the first matching layout in dateOverrideLayouts.
+func parseOverrideDate(s string) (time.Time, bool) {
+	for _, layout := range dateOverrideLayouts {
+		if date, err := time.Parse(layout, s); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
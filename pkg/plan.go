@@ -0,0 +1,420 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PlanAction is what a run would do with a source file, as reported by PlanImport.
+type PlanAction string
+
+// Actions of an ImportPlan entry.
+const (
+	PlanCopy          PlanAction = "copy"           // Copied to a free target path
+	PlanReplace       PlanAction = "replace"        // Copied over an existing target (better or newer duplicate, or keepSource)
+	PlanSkipDuplicate PlanAction = "skip-duplicate" // Discarded as a duplicate of an existing or planned target
+	PlanCollision     PlanAction = "collision"      // Discarded because a different file has its target name
+	PlanVersion       PlanAction = "version"        // Copied to a "-N" version of its taken target name
+	PlanSkip          PlanAction = "skip"           // Left alone, e.g. empty or already in place
+	PlanError         PlanAction = "error"          // Would fail; see PlanEntry.Err
+)
+
+// PlanEntry describes what a run would do with one source file.
+type PlanEntry struct {
+	SourcePath string
+	// TargetPath is where the file would be copied, or the file it duplicates or collides with.
+	TargetPath string
+	Date       time.Time
+	DateSource string
+	Action     PlanAction
+	// Reason and Detail explain skip-duplicate, collision and replace actions, as in DuplicateInfo.
+	// Detail also holds the skip reason of skip actions.
+	Reason Reason
+	Detail string
+	Err    error
+}
+
+// ImportPlan lists, in scan order, what a run would do with each source file.
+type ImportPlan struct {
+	Entries []PlanEntry
+}
+
+// Count returns the number of entries with the given action.
+func (p ImportPlan) Count(action PlanAction) int {
+	count := 0
+	for _, entry := range p.Entries {
+		if entry.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// planTreeNode is a directory of the tree written by ImportPlan.WriteTree.
+type planTreeNode struct {
+	count    int // Files planned into the directory and its subdirectories
+	children map[string]*planTreeNode
+}
+
+// WriteTree writes the directories that the plan copies files into to w as a tree below targetBaseDir,
+// each with the number of files that would land in it or its subdirectories, e.g.
+//
+//	/photos (3)
+//	├── 2023/ (2)
+//	│   └── 10/ (2)
+//	└── 2024/ (1)
+//	    └── 01/ (1)
+//
+// Entries planned as copy, replace or version are counted; the other actions leave the target as it is.
+// Directories are listed in name order.
+func (p ImportPlan) WriteTree(w io.Writer, targetBaseDir string) error {
+	root := &planTreeNode{}
+	for _, entry := range p.Entries {
+		if entry.Action != PlanCopy && entry.Action != PlanReplace && entry.Action != PlanVersion {
+			continue
+		}
+		root.count++
+		dir := filepath.Dir(entry.TargetPath)
+		rel, err := filepath.Rel(targetBaseDir, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			rel = dir // Shown as a single node, e.g. an unknown date directory outside the target
+		}
+		node := root
+		for _, name := range strings.Split(rel, string(filepath.Separator)) {
+			if name == "." || name == "" {
+				continue
+			}
+			if node.children == nil {
+				node.children = make(map[string]*planTreeNode)
+			}
+			child, ok := node.children[name]
+			if !ok {
+				child = &planTreeNode{}
+				node.children[name] = child
+			}
+			child.count++
+			node = child
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "%s (%d)\n", targetBaseDir, root.count); err != nil {
+		return err
+	}
+	return root.writeChildren(w, "")
+}
+
+// writeChildren writes the subdirectories of n, each line starting with indent.
+func (n *planTreeNode) writeChildren(w io.Writer, indent string) error {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		branch, childIndent := "├── ", indent+"│   "
+		if i == len(names)-1 {
+			branch, childIndent = "└── ", indent+"    "
+		}
+		child := n.children[name]
+		if _, err := fmt.Fprintf(w, "%s%s%s/ (%d)\n", indent, branch, name, child.count); err != nil {
+			return err
+		}
+		if err := child.writeChildren(w, childIndent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PlanImport works out what sorting sourceDir into targetBaseDir with opts would do, without writing
+// anything: no directory is created and no file is copied. Files that the plan copies are taken into
+// account for later files, so two sources with the same target are planned as a copy and a duplicate
+// or collision. KnownHashesFile and DateOverridesFile are loaded if the corresponding maps are nil.
+// Like a run, identical source files are planned once (see GroupSourceDuplicatesPreferring), sources
+// that the target index already lists under a preferred extension are planned as duplicates of the
+// indexed file (see ExtensionDuplicates), and with opts.CollapseBursts only the best shot of each
+// burst is planned (see CollapseBursts).
+func PlanImport(sourceDir, targetBaseDir string, opts Options) (ImportPlan, error) {
+	var plan ImportPlan
+	if err := opts.Validate(); err != nil {
+		return plan, err
+	}
+	logger := opts.LoggerOrDefault()
+	if opts.KnownHashes == nil && opts.KnownHashesFile != "" {
+		knownHashes, err := LoadKnownHashes(opts.KnownHashesFile)
+		if err != nil {
+			return plan, err
+		}
+		opts.KnownHashes = knownHashes
+	}
+	if opts.DateOverrides == nil && opts.DateOverridesFile != "" {
+		overrides, err := LoadDateOverrides(opts.DateOverridesFile, logger)
+		if err != nil {
+			return plan, err
+		}
+		opts.DateOverrides = overrides
+	}
+
+	if opts.SourceDirs == nil {
+		opts.SourceDirs = []string{sourceDir}
+	}
+	sourceFiles, err := ScanSourceDirectory(sourceDir, opts.MaxDepth, opts.SniffExtensionless)
+	if err != nil {
+		return plan, err
+	}
+	sourceFiles, _ = ExcludeNestedTarget(sourceFiles, sourceDir, targetBaseDir)
+	representatives, sourceDuplicates := GroupSourceDuplicatesPreferring(context.Background(), sourceFiles, opts.PreferExtensionOrder, logger)
+	var indexedDuplicates []DuplicateInfo
+	if opts.PreferExtensionOrder != nil && !sameFile(sourceDir, targetBaseDir) { // In place, the index lists the sources themselves
+		targetIndex, err := planTargetIndex(targetBaseDir, opts.MaintainIndex)
+		if err != nil {
+			return plan, err
+		}
+		if targetIndex != nil {
+			representatives, indexedDuplicates = targetIndex.ExtensionDuplicates(targetBaseDir, representatives, opts.PreferExtensionOrder, logger)
+		}
+	}
+	if opts.CollapseBursts {
+		var collapsed []DuplicateInfo
+		representatives, collapsed = CollapseBursts(representatives, opts.BurstWindow, opts.OpenFiles, logger)
+		sourceDuplicates = append(sourceDuplicates, collapsed...)
+	}
+	duplicateOf := make(map[string]DuplicateInfo, len(sourceDuplicates))
+	for _, dup := range sourceDuplicates {
+		duplicateOf[dup.DiscardedFile] = dup
+	}
+
+	planner := importPlanner{targetBaseDir: targetBaseDir, opts: opts, logger: logger, planned: make(map[string]string)}
+	entries := make(map[string]PlanEntry, len(sourceFiles))
+	for _, dup := range indexedDuplicates { // Kept files of these are target files, not sources
+		entries[dup.DiscardedFile] = PlanEntry{SourcePath: dup.DiscardedFile, TargetPath: dup.KeptFile,
+			Action: PlanSkipDuplicate, Reason: dup.Reason, Detail: dup.Detail}
+	}
+	for _, sourcePath := range representatives {
+		entries[sourcePath] = planner.planFile(sourcePath)
+	}
+	// entryOf plans a discarded source with the target of the file it was discarded for, which may in
+	// turn be a discarded source, e.g. an identical copy of a collapsed burst shot.
+	var entryOf func(sourcePath string) PlanEntry
+	entryOf = func(sourcePath string) PlanEntry {
+		if entry, ok := entries[sourcePath]; ok {
+			return entry
+		}
+		dup := duplicateOf[sourcePath]
+		kept := entryOf(dup.KeptFile)
+		entry := PlanEntry{SourcePath: sourcePath, TargetPath: kept.TargetPath, Date: kept.Date,
+			DateSource: kept.DateSource, Action: PlanSkipDuplicate, Reason: dup.Reason, Detail: dup.Detail}
+		entries[sourcePath] = entry
+		return entry
+	}
+	for _, sourcePath := range sourceFiles {
+		plan.Entries = append(plan.Entries, entryOf(sourcePath))
+	}
+	return plan, nil
+}
+
+// planTargetIndex returns the target index a run would use, without saving anything: the index file in
+// targetBaseDir refreshed in memory, or with maintainIndex an index built from the target. It returns nil
+// if the run would use no index.
+func planTargetIndex(targetBaseDir string, maintainIndex bool) (*TargetIndex, error) {
+	targetIndex := &TargetIndex{}
+	if err := targetIndex.Load(filepath.Join(targetBaseDir, IndexFileName)); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if _, statErr := os.Stat(targetBaseDir); !maintainIndex || statErr != nil {
+			return nil, nil
+		}
+		return BuildTargetIndex(targetBaseDir)
+	}
+	if err := targetIndex.Refresh(targetBaseDir); err != nil {
+		return nil, err
+	}
+	return targetIndex, nil
+}
+
+// importPlanner plans files one at a time, remembering the target paths that earlier files were planned to take.
+type importPlanner struct {
+	targetBaseDir string
+	opts          Options
+	logger        Logger
+	planned       map[string]string // Planned target path -> source path copied there
+}
+
+// planFile follows the steps of sortFile for sourcePath without writing anything.
+func (p *importPlanner) planFile(sourcePath string) PlanEntry {
+	entry := PlanEntry{SourcePath: sourcePath}
+	if info, err := os.Stat(sourcePath); err == nil && info.Size() == 0 && p.opts.QuarantineDir == "" {
+		entry.Action, entry.Detail = PlanSkip, EmptyFileSkipReason
+		return entry
+	}
+	if p.opts.KnownHashes != nil {
+		if hash, err := CalculateFileHash(sourcePath); err == nil && p.opts.KnownHashes[hash] {
+			entry.Action, entry.Reason, entry.Detail = PlanSkipDuplicate, ReasonKnownHash, "already archived"
+			return entry
+		}
+	}
+
+	if err := checkExtension(sourcePath, p.opts); err != nil {
+		entry.Action, entry.Err = PlanError, err
+		return entry
+	}
+
+	var err error
+	entry.Date, entry.DateSource, err = determinePhotoDateAndDateSource(sourcePath, p.opts.DateOverrides, p.opts.DateStrategy, p.opts.MinPlausibleYear, p.opts.OpenFiles, p.logger)
+	if err != nil {
+		entry.Action, entry.Err = PlanError, err
+		return entry
+	}
+	exactTargetPath, err := resolveTargetPath(p.targetBaseDir, entry.Date, entry.DateSource, sourcePath, p.opts)
+	if err != nil {
+		entry.Action, entry.Err = PlanError, err
+		return entry
+	}
+	entry.TargetPath = exactTargetPath
+	if p.opts.Hardlink && !p.opts.Move {
+		if linkedPath, ok := alreadyLinkedPath(sourcePath, exactTargetPath); ok {
+			entry.TargetPath, entry.Action, entry.Detail = linkedPath, PlanSkip, AlreadyLinkedSkipReason
+			return entry
+		}
+	}
+	if inPlacePath, ok := alreadyAtTargetPath(sourcePath, exactTargetPath); ok {
+		entry.TargetPath, entry.Action, entry.Detail = inPlacePath, PlanSkip, AlreadyInPlaceSkipReason
+		return entry
+	}
+
+	existing, taken := p.occupant(exactTargetPath)
+	if !taken {
+		entry.Action = PlanCopy
+		p.planned[exactTargetPath] = sourcePath
+		return entry
+	}
+	if p.opts.SkipExistingTargetFast && sameSize(sourcePath, existing) {
+		entry.Action, entry.Detail = PlanSkip, ExistingSizeMatchSkipReason
+		return entry
+	}
+	srcHashes := NewFileHashes(sourcePath)
+	srcHashes.ExifPrefilter = p.opts.ExifPrefilter
+	srcHashes.FastPixelHash = p.opts.FastPixelHash
+	srcHashes.ExifSignatureFields = p.opts.ExifSignatureFields
+	srcHashes.CompareAlgorithm = p.opts.CompareAlgorithm
+	srcHashes.OpenFiles = p.opts.OpenFiles
+	compResult, err := AreFilesPotentiallyDuplicateWithHashes(sourcePath, existing, srcHashes)
+	if err != nil {
+		entry.Action, entry.Reason, entry.Detail, entry.Err = PlanError, ReasonError, "comparison error, existing target kept", err
+		return entry
+	}
+	if !compResult.AreDuplicates && p.opts.PreferNewer && compResult.Reason == ReasonExifMismatch && samePixels(srcHashes, existing) {
+		compResult.AreDuplicates, compResult.Reason = true, ReasonPixelHashMatch
+	}
+	if !compResult.AreDuplicates && p.opts.HistogramThreshold > 0 && IsImageExtension(sourcePath) && IsImageExtension(existing) {
+		if _, similar := histogramsSimilar(sourcePath, existing, p.opts.HistogramThreshold); similar {
+			compResult.AreDuplicates, compResult.Reason = true, ReasonHistogramMatch
+		}
+	}
+	if compResult.Reason == ReasonZeroByteSource && !p.opts.NoOverwrite && sourceIsLarger(sourcePath, existing) {
+		entry.Action, entry.Reason, entry.Detail = PlanReplace, compResult.Reason, "existing target was empty"
+		p.planned[exactTargetPath] = sourcePath
+		return entry
+	}
+	if compResult.Reason == ReasonHistogramMatch {
+		// Similar colors do not make the same photo, so a histogram match never replaces the target.
+		entry.Action, entry.Reason, entry.Detail = PlanSkipDuplicate, compResult.Reason, "existing target kept - similar histogram"
+		return entry
+	}
+	if compResult.AreDuplicates {
+		entry.Reason = compResult.Reason
+		detail, better := p.sourceIsBetter(sourcePath, existing, compResult.Reason)
+		switch {
+		case better && p.opts.NoOverwrite && p.opts.ConflictStrategy == ConflictVersion:
+			return p.planVersion(entry, srcHashes)
+		case better && p.opts.NoOverwrite:
+			entry.Action, entry.Detail = PlanSkipDuplicate, "existing target kept - no overwrite"
+		case better:
+			entry.Action, entry.Detail = PlanReplace, detail
+			p.planned[exactTargetPath] = sourcePath
+		default:
+			entry.Action, entry.Detail = PlanSkipDuplicate, "existing target kept"
+		}
+		return entry
+	}
+
+	switch p.opts.ConflictStrategy {
+	case ConflictKeepSource:
+		entry.Action, entry.Reason, entry.Detail = PlanReplace, ReasonNameCollision, "content different, existing target overwritten"
+		p.planned[exactTargetPath] = sourcePath
+	case ConflictVersion:
+		return p.planVersion(entry, srcHashes)
+	case ConflictSkip:
+		entry.Action, entry.Reason, entry.Detail = PlanSkip, ReasonNameCollision, "content different, source skipped"
+	default:
+		entry.Action, entry.Reason, entry.Detail = PlanCollision, ReasonNameCollision, "content different, existing target preserved"
+	}
+	return entry
+}
+
+// occupant returns the file to compare with for targetPath: the source planned to be copied there,
+// or the existing target file. taken is false if the path is free.
+func (p *importPlanner) occupant(targetPath string) (path string, taken bool) {
+	if sourcePath, ok := p.planned[targetPath]; ok {
+		return sourcePath, true
+	}
+	if _, err := os.Stat(targetPath); err == nil {
+		return targetPath, true
+	}
+	return "", false
+}
+
+// sourceIsBetter reports whether the source would replace its duplicate existing, as handleTargetConflict decides.
+func (p *importPlanner) sourceIsBetter(sourcePath, existing string, reason Reason) (detail string, better bool) {
+	targetHigherResolution := false
+	if reason == ReasonPixelHashMatch {
+		sourceWidth, sourceHeight, _ := getDisplayResolution(sourcePath, p.opts.OpenFiles)
+		targetWidth, targetHeight, err := getDisplayResolution(existing, p.opts.OpenFiles)
+		if err != nil {
+			if sourceWidth*sourceHeight > 0 {
+				return "source is better resolution", true
+			}
+			return "", false
+		}
+		if IsHigherResolution(sourceWidth, sourceHeight, targetWidth, targetHeight) {
+			return "source is better resolution", true
+		}
+		targetHigherResolution = IsHigherResolution(targetWidth, targetHeight, sourceWidth, sourceHeight)
+		if p.opts.PreferLargerFile && !targetHigherResolution && sourceIsLarger(sourcePath, existing) {
+			return "source is larger file at same resolution", true
+		}
+	}
+	if p.opts.PreferNewer && !targetHigherResolution {
+		return sourceIsNewer(sourcePath, existing, p.opts.OpenFiles)
+	}
+	return "", false
+}
+
+// planVersion plans a source whose target name is taken by a different file under ConflictVersion,
+// as copyToNextVersion does.
+func (p *importPlanner) planVersion(entry PlanEntry, srcHashes *FileHashes) PlanEntry {
+	dir := filepath.Dir(entry.TargetPath)
+	extension := filepath.Ext(entry.TargetPath)
+	baseName := strings.TrimSuffix(filepath.Base(entry.TargetPath), extension)
+	for n := 1; ; n++ {
+		versionPath := filepath.Join(dir, fmt.Sprintf("%s-%d%s", baseName, n, extension))
+		existing, taken := p.occupant(versionPath)
+		if !taken {
+			entry.TargetPath, entry.Action = versionPath, PlanVersion
+			p.planned[versionPath] = entry.SourcePath
+			return entry
+		}
+		if compResult, err := AreFilesPotentiallyDuplicateWithHashes(entry.SourcePath, existing, srcHashes); err == nil && compResult.AreDuplicates {
+			entry.TargetPath, entry.Action, entry.Reason, entry.Detail = versionPath, PlanSkipDuplicate, compResult.Reason, "existing version kept"
+			return entry
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// rawExtensions are the image extensions of camera RAW formats, whose EXIF data is read by
+// GetRawCreationDate when goexif cannot parse the file.
+var rawExtensions = map[string]bool{
+	".raw": true,
+	".cr2": true,
+	".nef": true,
+	".arw": true,
+	".orf": true,
+	".rw2": true,
+	".pef": true,
+	".dng": true,
+}
+
+// rawHeaderScanSize is how much of a RAW file is searched for an embedded EXIF block. RAW formats
+// store their metadata ahead of the image data.
+const rawHeaderScanSize = 1 << 20
+
+// rawTIFFMagics are the headers of TIFF-structured RAW formats: plain TIFF (CR2, NEF, ARW, PEF, DNG)
+// and the variants with their own magic number (ORF, RW2), which goexif does not recognize.
+var rawTIFFMagics = []string{"II*\x00", "MM\x00*", "IIRO", "IIRS", "MMOR", "IIU\x00"}
+
+// rawDateFields maps the IDs of the date tags in IFD0 and the EXIF sub-IFD to their field names.
+var rawDateFields = map[uint16]exif.FieldName{
+	0x0132: exif.DateTime,
+	0x9003: exif.DateTimeOriginal,
+	0x9004: exif.DateTimeDigitized,
+	0x9290: exif.SubSecTime,
+	0x9291: exif.SubSecTimeOriginal,
+	0x9292: exif.SubSecTimeDigitized,
+	0x9010: exifOffsetTime,
+	0x9011: exifOffsetTimeOriginal,
+	0x9012: exifOffsetTimeDigitized,
+}
+
+// IsRawExtension checks if the given filePath has the extension of a camera RAW format, such as .cr2 or .nef.
+func IsRawExtension(filePath string) bool {
+	return rawExtensions[strings.ToLower(filepath.Ext(filePath))]
+}
+
+// GetRawCreationDate extracts the creation date from the EXIF data of a camera RAW file. Unlike
+// GetPhotoCreationDate, it only decodes IFD0 and the EXIF sub-IFD, so it copes with RAW formats whose
+// other directories goexif cannot parse, with the ORF and RW2 variants of the TIFF header, and with
+// an EXIF block embedded further into the file (e.g. in the JPEG preview of a RAF file).
+// The date tags are tried as by GetPhotoCreationDateWithTag, except for the GPS date; ErrNoExifDate
+// is returned if none is present.
+func GetRawCreationDate(path string) (time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	date, _, err := rawCreationDate(file)
+	if err != nil && !errors.Is(err, ErrNoExifDate) {
+		return time.Time{}, fmt.Errorf("failed to read RAW EXIF data from %s: %w", path, err)
+	}
+	return date, err
+}
+
+// rawCreationDate implements GetRawCreationDate for an open file and also returns the name of the date tag.
+func rawCreationDate(file *os.File) (time.Time, string, error) {
+	header := make([]byte, rawHeaderScanSize)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return time.Time{}, "", err
+	}
+	start, ok := findRawTIFFBlock(header[:n])
+	if !ok {
+		return time.Time{}, "", fmt.Errorf("no TIFF or EXIF block found")
+	}
+	block := io.NewSectionReader(file, start, 1<<62) // Tag offsets are relative to the TIFF header
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[start] == 'M' {
+		order = binary.BigEndian
+	}
+	ifd0, err := decodeRawDir(block, int64(order.Uint32(header[start+4:start+8])), order)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("failed to decode IFD0: %w", err)
+	}
+	tags := make(map[exif.FieldName]*tiff.Tag)
+	loadRawDateTags := func(dir *tiff.Dir) {
+		for _, tag := range dir.Tags {
+			if name, ok := rawDateFields[tag.Id]; ok {
+				tags[name] = tag
+			}
+		}
+	}
+	loadRawDateTags(ifd0)
+	for _, tag := range ifd0.Tags {
+		if tag.Id != 0x8769 { // ExifIFDPointer
+			continue
+		}
+		if offset, err := tag.Int64(0); err == nil {
+			if subDir, err := decodeRawDir(block, offset, order); err == nil {
+				loadRawDateTags(subDir)
+			}
+		}
+	}
+
+	return exifCaptureDate(func(name exif.FieldName) (*tiff.Tag, error) {
+		if tag, ok := tags[name]; ok {
+			return tag, nil
+		}
+		return nil, exif.TagNotPresentError(name)
+	})
+}
+
+// findRawTIFFBlock returns the offset of the TIFF header holding the EXIF data in the start of a RAW file:
+// the file's own header if it is TIFF-structured, otherwise that of the first "Exif" block or, failing
+// that, of the first TIFF header found (e.g. in the metadata box of a CR3 file).
+func findRawTIFFBlock(header []byte) (int64, bool) {
+	if len(header) < 8 {
+		return 0, false
+	}
+	for _, magic := range rawTIFFMagics {
+		if string(header[:4]) == magic {
+			return 0, true
+		}
+	}
+	for _, marker := range []string{"Exif\x00\x00II*\x00", "Exif\x00\x00MM\x00*"} {
+		if i := bytes.Index(header, []byte(marker)); i >= 0 && i+6+8 <= len(header) {
+			return int64(i + 6), true
+		}
+	}
+	for _, magic := range rawTIFFMagics[:2] {
+		if i := bytes.Index(header, []byte(magic)); i >= 0 && i+8 <= len(header) {
+			return int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// decodeRawDir decodes the IFD at offset in the TIFF block r.
+func decodeRawDir(r *io.SectionReader, offset int64, order binary.ByteOrder) (*tiff.Dir, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	dir, _, err := tiff.DecodeDir(r, order)
+	return dir, err
+}
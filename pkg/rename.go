@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// renameTemplateToken matches a token of a rename template, e.g. {make} or {date:2006-01-02}.
+var renameTemplateToken = regexp.MustCompile(`\{([a-z]+)(?::([^{}]*))?\}`)
+
+// UnknownCameraName replaces {make} and {model} in file names of files without that EXIF tag.
+const UnknownCameraName = "unknown"
+
+// ValidateRenameTemplate checks that template is a usable rename template (see Options.RenameTemplate).
+// Unknown tokens, invalid date layouts, path separators and a {seq} anywhere but at the end are
+// rejected, as are templates without a {date} or {orig} token, which would give every file the same name.
+// An empty template is accepted and means Options.FilenameFormat is used.
+func ValidateRenameTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+	literal := renameTemplateToken.ReplaceAllString(template, "")
+	if strings.ContainsAny(literal, "{}") {
+		return fmt.Errorf("rename template '%s' has an unmatched brace", template)
+	}
+	if strings.ContainsAny(literal, `/\`) {
+		return fmt.Errorf("rename template '%s' must not contain path separators", template)
+	}
+	distinct := false
+	for _, m := range renameTemplateToken.FindAllStringSubmatchIndex(template, -1) {
+		name := template[m[2]:m[3]]
+		hasArg := m[4] >= 0
+		switch name {
+		case "date":
+			distinct = true
+			if hasArg {
+				if err := ValidateFilenameFormat(template[m[4]:m[5]]); err != nil {
+					return fmt.Errorf("rename template '%s': %w", template, err)
+				}
+			}
+			continue
+		case "orig":
+			distinct = true
+		case "make", "model":
+		case "seq":
+			if m[1] != len(template) {
+				return fmt.Errorf("rename template '%s': {seq} must be at the end of the template", template)
+			}
+		default:
+			return fmt.Errorf("rename template '%s' has an unknown token {%s} (expected {date}, {date:layout}, {make}, {model}, {orig} or {seq})", template, name)
+		}
+		if hasArg {
+			return fmt.Errorf("rename template '%s': {%s} takes no argument", template, name)
+		}
+	}
+	if !distinct {
+		return fmt.Errorf("rename template '%s' must contain {date} or {orig}", template)
+	}
+	return nil
+}
+
+// RenderRenameTemplate returns the target file name, without extension, that template gives the source
+// at sourcePath dated photoDate:
+//
+//	{date}         photoDate in DefaultFilenameFormat
+//	{date:layout}  photoDate in the Go time layout, e.g. {date:2006-01-02}
+//	{make}         the camera make from EXIF (see GetCameraInfo), or UnknownCameraName
+//	{model}        the camera model from EXIF, or UnknownCameraName
+//	{orig}         the source's base name without extension
+//	{seq}          nothing; "-N" collision versions of the name are numbered here
+//
+// Characters that are not allowed in file names are replaced by underscores. The template must be valid
+// (see ValidateRenameTemplate).
+func RenderRenameTemplate(template string, photoDate time.Time, sourcePath string) (string, error) {
+	if err := ValidateRenameTemplate(template); err != nil {
+		return "", err
+	}
+	var cameraMake, cameraModel string
+	if strings.Contains(template, "{make}") || strings.Contains(template, "{model}") {
+		cameraMake, cameraModel, _ = GetCameraInfo(sourcePath) // Files without EXIF get UnknownCameraName
+	}
+	camera := func(value string) string {
+		if value == "" {
+			return UnknownCameraName
+		}
+		return value
+	}
+
+	name := renameTemplateToken.ReplaceAllStringFunc(template, func(token string) string {
+		m := renameTemplateToken.FindStringSubmatch(token)
+		switch m[1] {
+		case "date":
+			layout := m[2]
+			if layout == "" {
+				layout = DefaultFilenameFormat
+			}
+			return targetNameTime(photoDate).Format(layout)
+		case "make":
+			return sanitizeFileName(camera(cameraMake))
+		case "model":
+			return sanitizeFileName(camera(cameraModel))
+		case "orig":
+			base := filepath.Base(sourcePath)
+			return sanitizeFileName(strings.TrimSuffix(base, filepath.Ext(base)))
+		}
+		return "" // {seq}
+	})
+	// Windows drops trailing dots and spaces from file names.
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "", fmt.Errorf("rename template '%s' gives %s an empty file name", template, sourcePath)
+	}
+	return name, nil
+}
+
+// sanitizeFileName replaces path separators, control characters and the characters that Windows
+// does not allow in file names with underscores.
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
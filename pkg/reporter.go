@@ -2,22 +2,243 @@ package pkg
 
 import (
 	"fmt"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"time"
 )
 
+// ReportFileName is the name of the report written to the root of the target directory.
+const ReportFileName = "report.txt"
+
+// TimestampedReportName returns the name of the report of a run started at t,
+// e.g. "report-20231027-153000.txt", for runs that should not overwrite earlier reports.
+func TimestampedReportName(t time.Time) string {
+	return "report-" + t.Format("20060102-150405") + ".txt"
+}
+
 // DuplicateInfo holds information about a pair of duplicate files.
 type DuplicateInfo struct {
 	KeptFile      string
 	DiscardedFile string
-	Reason        string // e.g., "Lower resolution", "Identical to already copied file"
+	Reason        Reason // Why the files were paired, e.g. ReasonPixelHashMatch or ReasonNameCollision
+	Detail        string // Which file was kept and why, e.g. "source is better resolution"
+	// HashType, Hash1 and Hash2 record the comparison behind the decision, as in ComparisonResult:
+	// Hash1 is the source's hash or signature and Hash2 that of the file it was compared with.
+	// They are empty for duplicates that were not compared with a target, e.g. known hashes.
+	HashType string
+	Hash1    string
+	Hash2    string
+	// ComparisonReason is the outcome of that comparison, e.g. ReasonPixelHashMatch, or ReasonSizeMismatch
+	// for a name collision between files told apart by size (see DetectionMethod).
+	ComparisonReason Reason
+	// Replaced is true when the source (KeptFile) was copied over the existing target (DiscardedFile)
+	// because it has a higher resolution or, with PreferNewer, is newer.
+	Replaced bool
+}
+
+// withComparison returns d with the hash type, hashes and outcome of r.
+func (d DuplicateInfo) withComparison(r ComparisonResult) DuplicateInfo {
+	d.HashType, d.Hash1, d.Hash2, d.ComparisonReason = r.HashType, r.Hash1, r.Hash2, r.Reason
+	return d
+}
+
+// DetectionMethod returns how d was detected, as tallied in ReportSummary.DuplicatesByMethod: the
+// ComparisonReason if the files were compared, e.g. ReasonPixelHashMatch or ReasonFileHashMatch, and
+// otherwise the Reason, e.g. ReasonKnownHash or ReasonSourceDuplicate.
+func (d DuplicateInfo) DetectionMethod() Reason {
+	if d.ComparisonReason != "" {
+		return d.ComparisonReason
+	}
+	return d.Reason
+}
+
+// reportHashLength is the number of characters of a hash shown in verbose reports.
+const reportHashLength = 12
+
+// ComparisonText returns the comparison as shown in verbose reports: the hash type, followed by the
+// source and target hashes truncated to reportHashLength characters. It is empty if nothing was compared.
+func (d DuplicateInfo) ComparisonText() string {
+	if d.HashType == "" && d.Hash1 == "" && d.Hash2 == "" {
+		return ""
+	}
+	truncate := func(hash string) string {
+		if hash == "" {
+			return "-"
+		}
+		if len(hash) > reportHashLength {
+			return hash[:reportHashLength] + "..."
+		}
+		return hash
+	}
+	hashType := d.HashType
+	if hashType == "" {
+		hashType = "none"
+	}
+	return fmt.Sprintf("%s, source %s, target %s", hashType, truncate(d.Hash1), truncate(d.Hash2))
+}
+
+// ReasonText returns the reason as shown in reports: the Reason, followed by the Detail in parentheses.
+func (d DuplicateInfo) ReasonText() string {
+	if d.Detail == "" {
+		return d.Reason.String()
+	}
+	return d.Reason.String() + " (" + d.Detail + ")"
+}
+
+// QuarantineInfo holds information about a source file that failed processing
+// and was copied into the quarantine directory.
+type QuarantineInfo struct {
+	SourceFile     string
+	QuarantinePath string
+	Reason         string // The processing error that caused the file to be quarantined
+}
+
+// SkippedInfo holds information about a source file that was neither copied nor compared.
+type SkippedInfo struct {
+	SourceFile string
+	Reason     string
+}
+
+// CorruptInfo holds information about a source image that is damaged, e.g. truncated.
+type CorruptInfo struct {
+	SourceFile string
+	Reason     string // The decoding error (see ValidateImageIntegrity)
+}
+
+// ExtensionCorrection records a source file whose target got the extension of its content instead of
+// its own (see Options.ValidateMime).
+type ExtensionCorrection struct {
+	SourceFile string
+	Extension  string // The extension of the content, e.g. ".png"
+}
+
+// OrphanedSidecar is a sidecar file left in the source because its image was not copied
+// (see Options.CopySidecars).
+type OrphanedSidecar struct {
+	SidecarFile string
+	SourceFile  string // The image the sidecar belongs to
+	Reason      string // Why the image was not copied
+}
+
+// ReportSummary collects the results of a sorting run that are written to the report.
+type ReportSummary struct {
+	ProcessedFilesCount       int
+	CopiedFilesCount          int
+	FilesToCopyCount          int
+	PixelHashUnsupportedCount int
+	Duplicates                []DuplicateInfo
+	Quarantined               []QuarantineInfo
+	Skipped                   []SkippedInfo
+	// Corrupt lists damaged source images. They are also in Quarantined when they were quarantined,
+	// and copied as they are otherwise.
+	Corrupt []CorruptInfo
+	// ExtensionCorrections lists the sources whose extension did not match their content.
+	ExtensionCorrections []ExtensionCorrection
+	// CopiedSidecarsCount is the number of sidecar files copied along with their images, and
+	// OrphanedSidecars lists the sidecars of images that were not copied (see Options.CopySidecars).
+	CopiedSidecarsCount int
+	OrphanedSidecars    []OrphanedSidecar
+	// CopiedByExtension and DuplicatesByExtension count copied and duplicate source files
+	// keyed by lowercased extension (e.g. ".jpg").
+	CopiedByExtension     map[string]int
+	DuplicatesByExtension map[string]int
+	// DateSourceCounts counts the processed source files by the category of their date source
+	// (see DateSourceCategory), e.g. how many were dated by EXIF and how many by modification time.
+	DateSourceCounts map[string]int
+	// DuplicatesByMethod counts the Duplicates by their DetectionMethod, e.g. how many were found by
+	// pixel hash and how many by file hash, and how many name collisions were told apart by size.
+	DuplicatesByMethod map[Reason]int
+	// Interrupted is true when the run was cancelled before all files were processed.
+	Interrupted bool
+	// ProcessingErrorCount is the number of non-fatal errors met while processing individual files,
+	// e.g. unreadable sources or failed copies. Such files are skipped and the run continues.
+	ProcessingErrorCount int
+	// ElapsedSeconds is the wall-clock time spent processing files, and FilesPerSecond
+	// the resulting throughput.
+	ElapsedSeconds float64
+	FilesPerSecond float64
+	// Verbose adds the comparison behind each duplicate (see DuplicateInfo.ComparisonText) to the report.
+	Verbose bool
+	// DirMode and FileMode are the permissions of the directories created for the report and of the
+	// report file. Zero means DefaultDirMode and DefaultFileMode.
+	DirMode, FileMode fs.FileMode
+}
+
+// ReplacedCount returns the number of Duplicates in which the source replaced an existing target.
+// The remaining duplicates are sources that were discarded or skipped.
+func (s ReportSummary) ReplacedCount() int {
+	replaced := 0
+	for _, d := range s.Duplicates {
+		if d.Replaced {
+			replaced++
+		}
+	}
+	return replaced
 }
 
 // GenerateReport creates a text report summarizing the sorting process.
 func GenerateReport(reportPath string, duplicates []DuplicateInfo, copiedFilesCount int, processedFilesCount int, filesToCopyCount int, pixelHashUnsupportedCount int) error {
+	return GenerateSummaryReport(reportPath, ReportSummary{
+		ProcessedFilesCount:       processedFilesCount,
+		CopiedFilesCount:          copiedFilesCount,
+		FilesToCopyCount:          filesToCopyCount,
+		PixelHashUnsupportedCount: pixelHashUnsupportedCount,
+		Duplicates:                duplicates,
+	})
+}
+
+// DuplicateGroup collects the duplicates that were discarded in favor of the same kept file.
+type DuplicateGroup struct {
+	KeptFile   string
+	Duplicates []DuplicateInfo
+}
+
+// GroupDuplicates clusters duplicates by KeptFile. Groups are ordered by the first
+// appearance of their kept file, and each group keeps the order of its entries.
+func GroupDuplicates(duplicates []DuplicateInfo) []DuplicateGroup {
+	var groups []DuplicateGroup
+	groupIndex := make(map[string]int)
+	for _, d := range duplicates {
+		i, ok := groupIndex[d.KeptFile]
+		if !ok {
+			i = len(groups)
+			groupIndex[d.KeptFile] = i
+			groups = append(groups, DuplicateGroup{KeptFile: d.KeptFile})
+		}
+		groups[i].Duplicates = append(groups[i].Duplicates, d)
+	}
+	return groups
+}
+
+// GenerateSummaryReport creates a text report from a ReportSummary,
+// listing each duplicate as a kept/discarded pair.
+func GenerateSummaryReport(reportPath string, summary ReportSummary) error {
+	return writeReport(reportPath, summary, false)
+}
+
+// GenerateGroupedReport behaves like GenerateSummaryReport but lists duplicates grouped
+// by the file that was kept, with all discarded files and reasons under each keeper.
+func GenerateGroupedReport(reportPath string, summary ReportSummary) error {
+	return writeReport(reportPath, summary, true)
+}
+
+// writeReport writes the report for summary to reportPath, grouping duplicates by kept file if grouped is true.
+func writeReport(reportPath string, summary ReportSummary, grouped bool) error {
+	duplicates := summary.Duplicates
 	// Ensure the directory for the report exists
+	dirMode, fileMode := DefaultDirMode, DefaultFileMode
+	if summary.DirMode != 0 {
+		dirMode = summary.DirMode
+	}
+	if summary.FileMode != 0 {
+		fileMode = summary.FileMode
+	}
 	reportDir := filepath.Dir(reportPath)
-	if err := os.MkdirAll(reportDir, 0755); err != nil {
+	if err := MkdirAll(reportDir, dirMode); err != nil {
 		return fmt.Errorf("failed to create directory for report '%s': %w", reportDir, err)
 	}
 
@@ -26,6 +247,9 @@ func GenerateReport(reportPath string, duplicates []DuplicateInfo, copiedFilesCo
 		return fmt.Errorf("failed to create report file '%s': %w", reportPath, err)
 	}
 	defer file.Close()
+	if err := file.Chmod(fileMode); err != nil {
+		return fmt.Errorf("failed to set permissions on report file '%s': %w", reportPath, err)
+	}
 
 	_, err = fmt.Fprintf(file, "Photo Sorting Report\n")
 	if err != nil {
@@ -35,29 +259,126 @@ func GenerateReport(reportPath string, duplicates []DuplicateInfo, copiedFilesCo
 	if err != nil {
 		return err
 	}
+	if summary.Interrupted {
+		_, err = fmt.Fprintf(file, "NOTE: The run was interrupted before all files were processed.\n\n")
+		if err != nil {
+			return err
+		}
+	}
 	_, err = fmt.Fprintf(file, "Summary:\n")
 	if err != nil {
 		return err
 	}
-	_, err = fmt.Fprintf(file, "  - Total files scanned: %d\n", processedFilesCount)
+	_, err = fmt.Fprintf(file, "  - Total files scanned: %d\n", summary.ProcessedFilesCount)
 	if err != nil {
 		return err
 	}
 	// Files identified for copying is removed as it's redundant with Files successfully copied.
-	_, err = fmt.Fprintf(file, "  - Files successfully copied: %d\n", copiedFilesCount)
+	_, err = fmt.Fprintf(file, "  - Files successfully copied: %d\n", summary.CopiedFilesCount)
+	if err != nil {
+		return err
+	}
+	replaced := summary.ReplacedCount()
+	_, err = fmt.Fprintf(file, "  - Duplicate files found and discarded/skipped: %d\n", len(duplicates)-replaced)
 	if err != nil {
 		return err
 	}
-	_, err = fmt.Fprintf(file, "  - Duplicate files found and discarded/skipped: %d\n", len(duplicates))
+	_, err = fmt.Fprintf(file, "  - Targets replaced by higher-resolution source: %d\n", replaced)
 	if err != nil {
 		return err
 	}
-	_, err = fmt.Fprintf(file, "  - Image files where pixel hashing was not supported (fallback to file hash): %d\n", pixelHashUnsupportedCount)
+	_, err = fmt.Fprintf(file, "  - Image files where pixel hashing was not supported (fallback to file hash): %d\n", summary.PixelHashUnsupportedCount)
+	if err != nil {
+		return err
+	}
+	if len(summary.Corrupt) > 0 {
+		_, err = fmt.Fprintf(file, "  - Corrupt images: %d\n", len(summary.Corrupt))
+		if err != nil {
+			return err
+		}
+	}
+	if summary.CopiedSidecarsCount > 0 || len(summary.OrphanedSidecars) > 0 {
+		_, err = fmt.Fprintf(file, "  - Sidecar files copied: %d, orphaned: %d\n", summary.CopiedSidecarsCount, len(summary.OrphanedSidecars))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(file, "  - Processing time: %.2fs (%.1f files/sec)\n", summary.ElapsedSeconds, summary.FilesPerSecond)
 	if err != nil {
 		return err
 	}
 
-	if len(duplicates) > 0 {
+	if len(summary.CopiedByExtension) > 0 || len(summary.DuplicatesByExtension) > 0 {
+		_, err = fmt.Fprintf(file, "\nBy type:\n")
+		if err != nil {
+			return err
+		}
+		for _, ext := range sortedExtensionKeys(summary.CopiedByExtension, summary.DuplicatesByExtension) {
+			_, err = fmt.Fprintf(file, "  - %s: %d copied, %d duplicates\n", ext, summary.CopiedByExtension[ext], summary.DuplicatesByExtension[ext])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(summary.DateSourceCounts) > 0 {
+		_, err = fmt.Fprintf(file, "\nDate source breakdown:\n")
+		if err != nil {
+			return err
+		}
+		for _, category := range DateSourceCategories {
+			_, err = fmt.Fprintf(file, "  - %s: %d\n", category, summary.DateSourceCounts[category])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(summary.DuplicatesByMethod) > 0 {
+		_, err = fmt.Fprintf(file, "\nDuplicate detection method breakdown:\n")
+		if err != nil {
+			return err
+		}
+		for _, method := range slices.Sorted(maps.Keys(summary.DuplicatesByMethod)) {
+			_, err = fmt.Fprintf(file, "  - %s: %d\n", method, summary.DuplicatesByMethod[method])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(duplicates) > 0 && grouped {
+		_, err = fmt.Fprintf(file, "\nDuplicate Groups:\n")
+		if err != nil {
+			return err
+		}
+		for _, g := range GroupDuplicates(duplicates) {
+			_, err = fmt.Fprintf(file, "  - Kept: %s (%d discarded)\n", g.KeptFile, len(g.Duplicates))
+			if err != nil {
+				return err
+			}
+			for _, d := range g.Duplicates {
+				_, err = fmt.Fprintf(file, "    - Discarded: %s\n", d.DiscardedFile)
+				if err != nil {
+					return err
+				}
+				_, err = fmt.Fprintf(file, "      Reason: %s\n", d.ReasonText())
+				if err != nil {
+					return err
+				}
+				if comparison := d.ComparisonText(); summary.Verbose && comparison != "" {
+					_, err = fmt.Fprintf(file, "      Comparison: %s\n", comparison)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			_, err = fmt.Fprintf(file, "\n")
+			if err != nil {
+				return err
+			}
+		}
+	} else if len(duplicates) > 0 {
 		_, err = fmt.Fprintf(file, "\nDuplicate Details:\n")
 		if err != nil {
 			return err
@@ -71,7 +392,110 @@ func GenerateReport(reportPath string, duplicates []DuplicateInfo, copiedFilesCo
 			if err != nil {
 				return err
 			}
-			_, err = fmt.Fprintf(file, "    Reason: %s\n\n", d.Reason)
+			_, err = fmt.Fprintf(file, "    Reason: %s\n", d.ReasonText())
+			if err != nil {
+				return err
+			}
+			if comparison := d.ComparisonText(); summary.Verbose && comparison != "" {
+				_, err = fmt.Fprintf(file, "    Comparison: %s\n", comparison)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = fmt.Fprintf(file, "\n")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(summary.Quarantined) > 0 {
+		_, err = fmt.Fprintf(file, "\nQuarantined files:\n")
+		if err != nil {
+			return err
+		}
+		for _, q := range summary.Quarantined {
+			_, err = fmt.Fprintf(file, "  - Source: %s\n", q.SourceFile)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(file, "    Quarantined to: %s\n", q.QuarantinePath)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(file, "    Reason: %s\n\n", q.Reason)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(summary.Corrupt) > 0 {
+		_, err = fmt.Fprintf(file, "\nCorrupt images:\n")
+		if err != nil {
+			return err
+		}
+		for _, c := range summary.Corrupt {
+			_, err = fmt.Fprintf(file, "  - Source: %s\n", c.SourceFile)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(file, "    Reason: Corrupt image - %s\n\n", c.Reason)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(summary.ExtensionCorrections) > 0 {
+		_, err = fmt.Fprintf(file, "\nCorrected extensions:\n")
+		if err != nil {
+			return err
+		}
+		for _, c := range summary.ExtensionCorrections {
+			_, err = fmt.Fprintf(file, "  - Source: %s\n", c.SourceFile)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(file, "    Content: %s, target extension corrected\n\n", c.Extension)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(summary.OrphanedSidecars) > 0 {
+		_, err = fmt.Fprintf(file, "\nOrphaned sidecars:\n")
+		if err != nil {
+			return err
+		}
+		for _, o := range summary.OrphanedSidecars {
+			_, err = fmt.Fprintf(file, "  - Sidecar: %s\n", o.SidecarFile)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(file, "    Image: %s\n", o.SourceFile)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(file, "    Reason: %s\n\n", o.Reason)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(summary.Skipped) > 0 {
+		_, err = fmt.Fprintf(file, "\nSkipped files:\n")
+		if err != nil {
+			return err
+		}
+		for _, s := range summary.Skipped {
+			_, err = fmt.Fprintf(file, "  - Source: %s\n", s.SourceFile)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(file, "    Reason: %s\n\n", s.Reason)
 			if err != nil {
 				return err
 			}
@@ -81,3 +505,19 @@ func GenerateReport(reportPath string, duplicates []DuplicateInfo, copiedFilesCo
 	fmt.Printf("Report generated at %s\n", reportPath)
 	return nil
 }
+
+// sortedExtensionKeys returns the union of the keys of the given maps in sorted order.
+func sortedExtensionKeys(counts ...map[string]int) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range counts {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
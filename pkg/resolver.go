@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileMeta describes a source file to a PathResolver.
+type FileMeta struct {
+	Path       string    // The source file
+	Date       time.Time // The photo date the file is sorted by
+	DateSource string    // Where Date comes from, e.g. "EXIF DateTimeOriginal" or DateSourceFileModTime
+	// Width and Height are the display resolution of the image (see GetDisplayResolution),
+	// or 0 if it cannot be decoded.
+	Width, Height int
+	// CameraMake and CameraModel are the camera recorded in EXIF (see GetCameraInfo), or empty.
+	CameraMake, CameraModel string
+}
+
+// PathResolver decides the directory a source file is sorted into, in place of the date layout
+// (see Options.PathResolver).
+type PathResolver interface {
+	// Resolve returns the directory for file relative to the target base directory, using '/' or the
+	// OS separator between levels. "" or "." is the target base directory itself. The path must not
+	// be absolute or lead out of the target base directory.
+	Resolve(file FileMeta) (relPath string, err error)
+}
+
+// PathResolverFunc adapts a function to a PathResolver.
+type PathResolverFunc func(file FileMeta) (string, error)
+
+// Resolve calls f(file).
+func (f PathResolverFunc) Resolve(file FileMeta) (string, error) {
+	return f(file)
+}
+
+// builtinPathResolvers are the resolvers that BuiltinPathResolver returns by name.
+var builtinPathResolvers = map[string]PathResolver{
+	// camera sorts by EXIF make and model, e.g. "Canon/EOS R5", using UnknownCameraName for missing tags.
+	"camera": PathResolverFunc(func(file FileMeta) (string, error) {
+		cameraMake, cameraModel := file.CameraMake, file.CameraModel
+		if cameraMake == "" {
+			cameraMake = UnknownCameraName
+		}
+		if cameraModel == "" {
+			cameraModel = UnknownCameraName
+		}
+		return sanitizeFileName(cameraMake) + "/" + sanitizeFileName(cameraModel), nil
+	}),
+	// orientation sorts into "landscape", "portrait" or "square" by display resolution, or "unknown".
+	"orientation": PathResolverFunc(func(file FileMeta) (string, error) {
+		switch {
+		case file.Width == 0 || file.Height == 0:
+			return "unknown", nil
+		case file.Width > file.Height:
+			return "landscape", nil
+		case file.Width < file.Height:
+			return "portrait", nil
+		}
+		return "square", nil
+	}),
+}
+
+// BuiltinPathResolverNames returns the names accepted by BuiltinPathResolver, in sorted order.
+func BuiltinPathResolverNames() []string {
+	names := make([]string, 0, len(builtinPathResolvers))
+	for name := range builtinPathResolvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuiltinPathResolver returns the built-in PathResolver called name: "camera" sorts into directories
+// by camera make and model (e.g. Canon/EOS R5), and "orientation" into landscape, portrait and square.
+// An empty name returns nil, which keeps the date layout.
+func BuiltinPathResolver(name string) (PathResolver, error) {
+	if name == "" {
+		return nil, nil
+	}
+	resolver, ok := builtinPathResolvers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown path resolver '%s' (expected one of %s)", name, strings.Join(BuiltinPathResolverNames(), ", "))
+	}
+	return resolver, nil
+}
+
+// resolveTargetDir returns the directory below targetBaseDir that resolver picks for the source at
+// sourceFilePath dated photoDate by dateSource.
+func resolveTargetDir(resolver PathResolver, targetBaseDir string, sourceFilePath string, photoDate time.Time, dateSource string) (string, error) {
+	file := FileMeta{Path: sourceFilePath, Date: photoDate, DateSource: dateSource}
+	file.Width, file.Height, _ = GetDisplayResolution(sourceFilePath) // Left at 0 for images that cannot be decoded
+	file.CameraMake, file.CameraModel, _ = GetCameraInfo(sourceFilePath)
+	relPath, err := resolver.Resolve(file)
+	if err != nil {
+		return "", fmt.Errorf("path resolver failed for %s: %w", sourceFilePath, err)
+	}
+	relPath = filepath.Clean(filepath.FromSlash(relPath))
+	if filepath.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path resolver returned '%s' for %s, which is outside the target directory", relPath, sourceFilePath)
+	}
+	return filepath.Join(targetBaseDir, relPath), nil
+}
@@ -0,0 +1,307 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"time"
+)
+
+// pngSignature starts every PNG file; the chunks follow it.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// tiffTagOrientation is the TIFF tag of the EXIF orientation, whose SHORT value 1-8 tells viewers how
+// to turn the stored image upright.
+const tiffTagOrientation = 0x0112
+
+// TIFF tags of the image dimensions, which AutoRotateImage swaps for images turned by 90°.
+const (
+	tiffTagImageWidth      = 0x0100
+	tiffTagImageLength     = 0x0101
+	tiffTagExifIFD         = 0x8769 // Pointer to the EXIF sub-IFD
+	tiffTagPixelXDimension = 0xA002 // In the EXIF sub-IFD
+	tiffTagPixelYDimension = 0xA003
+)
+
+// AutoRotateImage writes the JPEG or PNG image at srcPath to destPath turned upright according to its EXIF
+// orientation, through a temporary file like CopyFile, and reports whether it did. The EXIF data is carried
+// over with the orientation set to 1 (normal), so that viewers do not turn the image again, the dimensions
+// of the upright image and without its thumbnail, which would still show the image as stored. JPEGs are
+// re-encoded at HeicJPEGQuality, keeping their other APPn segments, such as an ICC profile or XMP data. Other files and images that are already upright are left alone and
+// rotated is false, for the caller to copy them. preserveTimes gives destPath the times of srcPath.
+func AutoRotateImage(srcPath, destPath string, preserveTimes bool) (rotated bool, err error) {
+	return autoRotateImage(srcPath, destPath, preserveTimes, defaultFileModes, nil)
+}
+
+// autoRotateImage implements AutoRotateImage, giving destPath and the directories it creates modes.
+// The source is read, and the temporary target written, under files.
+func autoRotateImage(srcPath, destPath string, preserveTimes bool, modes fileModes, files *OpenFileLimiter) (rotated bool, err error) {
+	release := files.acquire(2) // The source and the temporary target
+	defer release()
+	file, err := openWithRetry(func() (*os.File, error) { return os.Open(srcPath) })
+	if err != nil {
+		return false, fmt.Errorf("failed to open source file %s: %w", srcPath, err)
+	}
+	defer file.Close()
+	header := make([]byte, len(pngSignature))
+	n, _ := io.ReadFull(file, header)
+	isJPEG := bytes.HasPrefix(header[:n], []byte("\xff\xd8\xff"))
+	if !isJPEG && !bytes.Equal(header[:n], []byte(pngSignature)) {
+		return false, nil
+	}
+	srcInfo, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat source file %s: %w", srcPath, err)
+	}
+	data, err := io.ReadAll(io.MultiReader(bytes.NewReader(header[:n]), file))
+	if err != nil {
+		return false, fmt.Errorf("failed to read source file %s: %w", srcPath, err)
+	}
+
+	var tiffData []byte
+	var segments [][]byte // The APPn segments of a JPEG
+	exifSegment := -1
+	if isJPEG {
+		segments = jpegAppSegments(data)
+		for i, segment := range segments {
+			if segment[1] == 0xE1 && bytes.HasPrefix(segment[4:], []byte(exifHeader)) {
+				tiffData, exifSegment = bytes.Clone(segment[4+len(exifHeader):]), i
+				break
+			}
+		}
+	} else {
+		tiffData = bytes.Clone(pngExifChunk(data))
+	}
+	offset, order := tiffOrientationOffset(tiffData)
+	if offset < 0 {
+		return false, nil
+	}
+	orientation := order.Uint16(tiffData[offset:])
+	if orientation < 2 || orientation > 8 {
+		return false, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("failed to decode %s for rotation: %w", srcPath, err)
+	}
+	upright := orientImage(img, int(orientation))
+	order.PutUint16(tiffData[offset:], 1)
+	uprightTIFF(tiffData, upright.Bounds().Dx(), upright.Bounds().Dy())
+
+	var encoded, out bytes.Buffer
+	if isJPEG {
+		if err := jpeg.Encode(&encoded, upright, &jpeg.Options{Quality: HeicJPEGQuality}); err != nil {
+			return false, fmt.Errorf("failed to encode rotated %s: %w", srcPath, err)
+		}
+		jpegBytes := encoded.Bytes()
+		out.Write(jpegBytes[:2]) // SOI
+		_, cmyk := img.(*image.CMYK)
+		for i, segment := range segments {
+			switch {
+			case i == exifSegment:
+				out.Write([]byte{0xFF, 0xE1})
+				binary.Write(&out, binary.BigEndian, uint16(len(exifHeader)+len(tiffData)+2))
+				out.WriteString(exifHeader)
+				out.Write(tiffData)
+			case segment[1] == 0xEE: // Adobe APP14 describes the color transform of the source's encoding
+			case cmyk && segment[1] == 0xE2 && bytes.HasPrefix(segment[4:], []byte("ICC_PROFILE\x00")):
+				// A CMYK profile does not fit the RGB image that is encoded
+			default:
+				out.Write(segment)
+			}
+		}
+		out.Write(jpegBytes[2:])
+	} else {
+		if err := png.Encode(&encoded, upright); err != nil {
+			return false, fmt.Errorf("failed to encode rotated %s: %w", srcPath, err)
+		}
+		// The eXIf chunk must precede the image data, so it follows the IHDR chunk, which comes first.
+		pngBytes := encoded.Bytes()
+		ihdrEnd := len(pngSignature) + 8 + int(binary.BigEndian.Uint32(pngBytes[len(pngSignature):])) + 4
+		out.Write(pngBytes[:ihdrEnd])
+		writePNGChunk(&out, "eXIf", tiffData)
+		out.Write(pngBytes[ihdrEnd:])
+	}
+
+	var accessTime, modTime time.Time
+	if preserveTimes {
+		accessTime, modTime = fileAccessTime(srcInfo), srcInfo.ModTime()
+	}
+	if err := copyReader(&out, srcPath, destPath, nil, accessTime, modTime, modes); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// orientImage returns img turned upright according to the EXIF orientation: mirrored (2), rotated by
+// 180° (3), flipped (4), transposed (5), rotated clockwise (6), transversed (7) or rotated counterclockwise (8).
+func orientImage(img image.Image, orientation int) *image.NRGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// jpegAppSegments returns the APPn segments of the JPEG file data that precede its image data, each
+// with its marker and length, in order.
+func jpegAppSegments(data []byte) [][]byte {
+	var segments [][]byte
+	for offset := 2; offset+4 <= len(data) && data[offset] == 0xFF; {
+		marker := data[offset+1]
+		end := offset + 2 + int(binary.BigEndian.Uint16(data[offset+2:]))
+		if marker == 0xDA || end < offset+4 || end > len(data) { // Start of scan, or a broken segment
+			break
+		}
+		if marker >= 0xE0 && marker <= 0xEF {
+			segments = append(segments, data[offset:end])
+		}
+		offset = end
+	}
+	return segments
+}
+
+// tiffFirstIFD returns the byte order of tiffData and the offset of its first image directory, or a nil
+// order if tiffData is not TIFF data.
+func tiffFirstIFD(tiffData []byte) (binary.ByteOrder, int) {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiffData, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiffData, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return nil, 0
+	}
+	if len(tiffData) < 8 {
+		return nil, 0
+	}
+	ifd := int(order.Uint32(tiffData[4:]))
+	if ifd < 8 || ifd+2 > len(tiffData) {
+		return nil, 0
+	}
+	return order, ifd
+}
+
+// tiffEntry returns the offset in tiffData of the entry for tag in the image directory at ifd, or -1
+// if it has none.
+func tiffEntry(tiffData []byte, order binary.ByteOrder, ifd int, tag uint16) int {
+	if ifd < 8 || ifd+2 > len(tiffData) {
+		return -1
+	}
+	for i := range int(order.Uint16(tiffData[ifd:])) {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiffData) {
+			break
+		}
+		if order.Uint16(tiffData[entry:]) == tag {
+			return entry
+		}
+	}
+	return -1
+}
+
+// tiffOrientationOffset returns the offset in tiffData of the value of its first image's orientation
+// tag and the data's byte order, or -1 if the tag cannot be found.
+func tiffOrientationOffset(tiffData []byte) (int, binary.ByteOrder) {
+	order, ifd := tiffFirstIFD(tiffData)
+	if order == nil {
+		return -1, nil
+	}
+	if entry := tiffEntry(tiffData, order, ifd, tiffTagOrientation); entry >= 0 && order.Uint16(tiffData[entry+2:]) == 3 { // SHORT
+		return entry + 8, order
+	}
+	return -1, nil
+}
+
+// uprightTIFF updates tiffData, the EXIF data of an image that was turned upright to width x height
+// pixels: its dimension tags are set to these, and its second image directory, the thumbnail, is
+// unlinked. The orientation is left to the caller.
+func uprightTIFF(tiffData []byte, width, height int) {
+	order, ifd := tiffFirstIFD(tiffData)
+	if order == nil {
+		return
+	}
+	setTIFFNumber(tiffData, order, tiffEntry(tiffData, order, ifd, tiffTagImageWidth), width)
+	setTIFFNumber(tiffData, order, tiffEntry(tiffData, order, ifd, tiffTagImageLength), height)
+	if entry := tiffEntry(tiffData, order, ifd, tiffTagExifIFD); entry >= 0 {
+		exifIFD := int(order.Uint32(tiffData[entry+8:]))
+		setTIFFNumber(tiffData, order, tiffEntry(tiffData, order, exifIFD, tiffTagPixelXDimension), width)
+		setTIFFNumber(tiffData, order, tiffEntry(tiffData, order, exifIFD, tiffTagPixelYDimension), height)
+	}
+	if next := ifd + 2 + 12*int(order.Uint16(tiffData[ifd:])); next+4 <= len(tiffData) {
+		order.PutUint32(tiffData[next:], 0)
+	}
+}
+
+// setTIFFNumber sets the value of the TIFF entry at offset entry of tiffData to value if the entry
+// holds a single SHORT or LONG. Other entries, and an entry offset of -1, are left alone.
+func setTIFFNumber(tiffData []byte, order binary.ByteOrder, entry int, value int) {
+	if entry < 0 || order.Uint32(tiffData[entry+4:]) != 1 {
+		return
+	}
+	switch order.Uint16(tiffData[entry+2:]) {
+	case 3: // SHORT
+		order.PutUint16(tiffData[entry+8:], uint16(value))
+	case 4: // LONG
+		order.PutUint32(tiffData[entry+8:], uint32(value))
+	}
+}
+
+// pngExifChunk returns the TIFF data of the eXIf chunk of the PNG file data, or nil if it has none.
+func pngExifChunk(data []byte) []byte {
+	for offset := len(pngSignature); offset+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		start := offset + 8
+		if length > len(data)-start {
+			return nil
+		}
+		if string(data[offset+4:start]) == "eXIf" {
+			return data[start : start+length]
+		}
+		offset = start + length + 4 // CRC
+	}
+	return nil
+}
+
+// writePNGChunk writes a PNG chunk of chunkType with chunkData and its CRC to w.
+func writePNGChunk(w *bytes.Buffer, chunkType string, chunkData []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(chunkData)))
+	w.WriteString(chunkType)
+	w.Write(chunkData)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(chunkData)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
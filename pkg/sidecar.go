@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SidecarExtensions are the extensions of the sidecar files that Options.CopySidecars copies along with
+// their image: XMP metadata, Apple photo edits (.aae) and Google Takeout metadata (.json).
+var SidecarExtensions = []string{".xmp", ".aae", ".json"}
+
+// SidecarFiles returns the sidecars of imagePath: the files next to it named like the image with its
+// extension replaced by (IMG_0001.xmp) or followed by (IMG_0001.JPG.json) one of SidecarExtensions,
+// in lower or upper case.
+func SidecarFiles(imagePath string) []string {
+	names := []string{strings.TrimSuffix(imagePath, filepath.Ext(imagePath))}
+	if names[0] != imagePath {
+		names = append(names, imagePath)
+	}
+	var sidecars []string
+	for _, ext := range SidecarExtensions {
+		for _, name := range names {
+			// Only one case is taken, as both name the same file on case-insensitive filesystems.
+			for _, candidate := range []string{name + ext, name + strings.ToUpper(ext)} {
+				if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+					sidecars = append(sidecars, candidate)
+					break
+				}
+			}
+		}
+	}
+	return sidecars
+}
+
+// sidecarTargetPath returns the path that the sidecar at sidecarPath of sourcePath gets when the source
+// is copied to targetPath: the target's name with its extension replaced or followed by the sidecar's
+// extension, as the sidecar's name is formed from the source's.
+func sidecarTargetPath(sourcePath, sidecarPath, targetPath string) string {
+	sidecarExt := filepath.Ext(sidecarPath)
+	if strings.TrimSuffix(sidecarPath, sidecarExt) == sourcePath {
+		return targetPath + sidecarExt
+	}
+	return strings.TrimSuffix(targetPath, filepath.Ext(targetPath)) + sidecarExt
+}
+
+// copySidecars copies the sidecars of sourcePath next to targetPath with copyFile, named after the
+// target (see sidecarTargetPath), and returns the paths they were copied to. Existing files at those
+// paths are replaced. A sidecar that fails to copy does not keep the others from being copied.
+func copySidecars(sourcePath, targetPath string, copyFile copyFunc) (copiedTo []string, err error) {
+	var errs []error
+	for _, sidecar := range SidecarFiles(sourcePath) {
+		sidecarTarget := sidecarTargetPath(sourcePath, sidecar, targetPath)
+		if copyErr := copyFile(sidecar, sidecarTarget); copyErr != nil {
+			errs = append(errs, fmt.Errorf("error copying sidecar %s to %s: %w", sidecar, sidecarTarget, copyErr))
+			continue
+		}
+		copiedTo = append(copiedTo, sidecarTarget)
+	}
+	return copiedTo, errors.Join(errs...)
+}
@@ -0,0 +1,1268 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Options holds the settings that control a photo sorting run.
+type Options struct {
+	// Verbose enables Debug-level messages on the default logger. It has no effect when Logger is set.
+	Verbose bool
+	// Logger receives the run's messages. When nil, a text logger writing to stdout is used,
+	// at Debug level if Verbose is set and Info level otherwise.
+	Logger Logger
+	// QuarantineDir, when non-empty, receives a copy of every source file that fails
+	// processing, preserving its path relative to the source directory.
+	// Undecodable or empty images are treated as failures when it is set.
+	QuarantineDir string
+	// Flatten writes all files directly into the target base directory instead of YYYY/MM subfolders.
+	// It is equivalent to Structure "flat".
+	Flatten bool
+	// Structure names a directory layout preset (see StructureLayout). Defaults to "year-month".
+	Structure string
+	// Layout is a custom directory layout: a Go time layout using "/" between directory levels,
+	// e.g. "2006/01-Jan", which may contain the ISO week tokens LayoutISOYear and LayoutISOWeek.
+	// It cannot be combined with Structure, Flatten or MonthNameFormat.
+	Layout string
+	// MonthNameFormat renders the month directory of the Structure preset as a number (the default)
+	// or an English month name joined with the year, e.g. 2023-October (see MonthNameLayout).
+	MonthNameFormat string
+	// FilenameFormat is the Go time layout used for target file names.
+	// Defaults to DefaultFilenameFormat when empty.
+	FilenameFormat string
+	// RenameTemplate, when set, builds target file names from the tokens {date}, {date:layout}, {make},
+	// {model}, {orig} and {seq} instead of FilenameFormat (see RenderRenameTemplate).
+	RenameTemplate string
+	// FilePrefix and FileSuffix are added before and after the name built from FilenameFormat or
+	// RenameTemplate, before the extension, e.g. to tag the files of an import. "-N" versions are numbered
+	// after the suffix. Files in UnknownDateDir keep their source names.
+	FilePrefix string
+	FileSuffix string
+	// SkipExistingTargetFast skips a source, as a presumed duplicate, when its target path already holds a
+	// file of the same size, without reading or comparing the contents (ExistingSizeMatchSkipReason).
+	// It makes re-imports fast, but a different photo of the same size and name is skipped too.
+	SkipExistingTargetFast bool
+	// PathResolver, when set, picks the directory of each dated file below the target in place of the
+	// directory layout (Layout, Structure and Flatten); see BuiltinPathResolver for ready-made ones.
+	// File names are still built from FilenameFormat or RenameTemplate.
+	PathResolver PathResolver
+	// NormalizeUnicode converts target file names to Unicode NFC, so that a name stored decomposed
+	// (NFD, as macOS does) and its composed form map to the same target path.
+	NormalizeUnicode bool
+	// PreserveSubdir sorts each source file into a subdirectory of its date directory named after the
+	// file's directory relative to its source directory in SourceDirs, e.g. 2023/10/Birthday for
+	// Birthday/a.jpg. Files directly in a source directory, or in none of them, get no subdirectory.
+	PreserveSubdir bool
+	// SourceDirs are the source directories that PreserveSubdir takes paths relative to. Runs and
+	// PlanImport set them to their sources if they are empty.
+	SourceDirs []string
+	// FromList, when non-empty, is a file listing the source files to sort, one path per line, which are
+	// sorted instead of scanning the source directories (see ReadSourceList). Listed paths that are missing
+	// or not images are reported as skipped.
+	FromList string
+	// MaxDepth limits how deep the source directory is scanned (1 = files directly in it). 0 means unlimited.
+	MaxDepth int
+	// StreamScan sorts source files while the source directories are still being scanned, so the first
+	// files are sorted at once even in huge trees. Files are then sorted in walk order, and the free
+	// space check and the grouping of identical source files are skipped. ZIP archives and a source that
+	// is the target directory are always scanned first.
+	StreamScan bool
+	// HashWorkers, when greater than 1, is the number of goroutines that compute the hashes of upcoming
+	// sources (see FileHashes.Precompute) while the sources are sorted and copied one at a time, in order.
+	// Hashing then uses several CPU cores while the disk copies; what is copied is the same as without.
+	// It has no effect for ZIP archive sources, whose entries are only extracted when they are sorted.
+	HashWorkers int
+	// MaxOpenFiles, when positive, limits the files that hashing and copying hold open at once during
+	// a run (see OpenFiles), so that many hash workers do not exhaust the file descriptors.
+	MaxOpenFiles int
+	// OpenFiles enforces MaxOpenFiles across the goroutines of a run; RunApplicationLogic creates it
+	// for each run. Calls given the same OpenFiles share its limit; nil means no limit.
+	OpenFiles *OpenFileLimiter
+	// MaxFilesPerDir, when positive, limits the number of entries in a date directory; further files
+	// go into "-2", "-3", ... sibling directories (see BucketDirectory). It requires a non-flat layout.
+	MaxFilesPerDir int
+	// KnownHashesFile, when non-empty, is a newline-delimited list of SHA-256 file hashes of
+	// already archived files. Sources whose hash is listed are skipped.
+	KnownHashesFile string
+	// UpdateKnownHashes appends the hashes of newly copied files to KnownHashesFile (see AppendKnownHashes).
+	UpdateKnownHashes bool
+	// KnownHashes, when non-nil, is the set of already archived file hashes used by SortFile:
+	// sources whose hash it contains are skipped, and the hashes of copied files are added to it.
+	// A full run fills it from KnownHashesFile.
+	KnownHashes map[string]bool
+	// DateOverridesFile, when non-empty, is a CSV file of "filename,date" rows (see LoadDateOverrides).
+	DateOverridesFile string
+	// DateOverrides maps source file base names to dates that take precedence over EXIF and
+	// the modification time. A full run fills it from DateOverridesFile.
+	DateOverrides map[string]time.Time
+	// DateStrategy picks the photo date of files without a date override from their EXIF date,
+	// file name date and modification time (see ResolvePhotoDate). Defaults to DateStrategyExifFirst.
+	DateStrategy DateStrategy
+	// MinPlausibleYear is the earliest year of a plausible EXIF, XMP or file name date; earlier dates,
+	// e.g. the 1970 of a camera whose clock was reset, are ignored like missing ones (see
+	// ResolvePhotoDateWithMinYear). 0 means DefaultMinPlausibleYear.
+	MinPlausibleYear int
+	// CopyBufferSize, when positive, is the size in bytes of the buffer used to copy files.
+	// Buffers are pooled and reused across copies. 0 uses the io.Copy default.
+	CopyBufferSize int
+	// CopyRetries is how many times a copy that fails with a transient error is retried (see RetryCopy).
+	CopyRetries int
+	// CopyRetryDelay is the wait before the first copy retry; it doubles for each further retry.
+	CopyRetryDelay time.Duration
+	// SniffExtensionless includes source files without an extension whose content is a recognized
+	// image type; they are given the detected extension in their target file name.
+	SniffExtensionless bool
+	// ValidateMime sniffs the content of JPEG, PNG, GIF, WebP, HEIC/HEIF and MP4/QuickTime sources and
+	// handles those whose extension names another type according to MimeMismatchAction (see ExtensionMismatch).
+	ValidateMime bool
+	// MimeMismatchAction is what ValidateMime does with a mismatching source: MimeMismatchFix (the default)
+	// gives its target the extension of its content, and MimeMismatchQuarantine fails it with
+	// ErrExtensionMismatch, so it is quarantined with QuarantineDir and left alone otherwise.
+	MimeMismatchAction string
+	// ConvertHeicToJpeg converts HEIC/HEIF sources to JPEG files with a ".jpg" extension instead of
+	// copying them, carrying over their EXIF data where it can be found (see ConvertHeicToJPEG).
+	// It needs a registered HEIF decoder; other files are copied as usual. It cannot be combined with Move,
+	// as the lossy JPEG would replace the original.
+	ConvertHeicToJpeg bool
+	// PreserveTimes gives copied files the modification and access times of their source.
+	PreserveTimes bool
+	// ReportPath, when non-empty, is where the report is written instead of ReportFileName in the
+	// target directory. Its parent directory is created if needed.
+	ReportPath string
+	// TimestampReport names the report in the target directory after the start of the run
+	// (see TimestampedReportName), so that runs do not overwrite each other's reports.
+	// It cannot be combined with ReportPath.
+	TimestampReport bool
+	// StrictReport makes a failure to write the report fail the run. By default, the failure is logged
+	// and the report is written to a temporary file instead, as the files have been sorted already.
+	StrictReport bool
+	// GroupDuplicates lists duplicates in the report grouped by the file that was kept
+	// instead of as individual kept/discarded pairs.
+	GroupDuplicates bool
+	// ReportFormat is ReportFormatText (the default) or ReportFormatHTML, which also writes the duplicates
+	// with thumbnails to an HTML page next to the text report (see GenerateHTMLReport and HTMLReportPath).
+	ReportFormat string
+	// ReportIncludeSkipped also lists the files that the scan of the source passes over, such as files
+	// of unsupported formats and videos, under the skipped files of the report (see ScanSkippedFiles).
+	// It has no effect for ZIP archive sources.
+	ReportIncludeSkipped bool
+	// ExifPrefilter compares a source image with its target by EXIF signature and file size first,
+	// so that images that differ in either are never decoded (see FileHashes.ExifPrefilter).
+	// Pixel-identical duplicates whose files differ in size, e.g. after a metadata edit, are then missed.
+	ExifPrefilter bool
+	// CompareAlgorithm selects which stages decide whether a source image duplicates its target:
+	// CompareFull (the default when empty), ComparePixelOnly, CompareFileOnly or CompareExifOnly.
+	CompareAlgorithm string
+	// ExifSignatureFields are the EXIF tags, named as in goexif, whose values make up the EXIF signature
+	// that images are first compared by (see ExifSignatureWithFields). nil means DefaultExifSignatureFields;
+	// fewer fields make more unrelated images, e.g. the frames of a burst, share a signature.
+	ExifSignatureFields []string
+	// FastPixelHash compares images by the pixel hash of a copy scaled down to FastPixelHashMaxDim
+	// (see CalculateDownscaledPixelHash) before their full pixel hash, so that images whose downscaled
+	// copies differ are told apart without hashing every pixel. Images whose downscaled copies match are
+	// still only duplicates if their full pixel hashes match as well.
+	FastPixelHash bool
+	// HistogramThreshold, when greater than 0, treats a source image as a duplicate of the different image
+	// at its target path if their color histograms have a HistogramSimilarity of at least this value,
+	// e.g. 0.9, so that slightly cropped or re-encoded copies are not imported twice. Such a source is
+	// always discarded and the existing target kept, as similar colors may still be a different photo.
+	// Exact comparisons always come first.
+	HistogramThreshold float64
+	// MaintainIndex keeps a content index of the target (IndexFileName in its root) up to date.
+	// An existing index is always maintained, even when this is false.
+	MaintainIndex bool
+	// PreferExtensionOrder lists extensions in order of preference, lowercased and without dots (see
+	// ParseExtensionOrder). When set, the source kept of identical source files is the one whose extension
+	// comes first (see GroupSourceDuplicatesPreferring), and sources whose content is in the target index
+	// under another extension that does not come after theirs are discarded as duplicates of the indexed file
+	// (see TargetIndex.ExtensionDuplicateFunc). Sources with a preferred extension are sorted as usual.
+	PreferExtensionOrder []string
+	// ConflictStrategy decides what happens when a source's target name is taken by a file with
+	// different content (see ConflictKeepTarget and friends). Defaults to ConflictKeepTarget.
+	// Duplicates of the target are unaffected: they are still replaced only by higher resolution sources
+	// (or newer ones, see PreferNewer).
+	ConflictStrategy string
+	// PreferNewer replaces an existing target with a duplicate source that is newer: one with a later
+	// EXIF date or, if the dates are equal or missing, a larger file. Images with identical pixels count
+	// as duplicates even when their EXIF data differs. A higher resolution target is still kept.
+	PreferNewer bool
+	// PreferLargerFile replaces an existing target with a pixel-identical source of the same resolution
+	// if the source file is larger, as a larger file of the same image is usually less compressed.
+	PreferLargerFile bool
+	// NoOverwrite never replaces a file in the target: a duplicate source of higher resolution is copied
+	// to a "-N" version with ConflictVersion and discarded otherwise. It cannot be combined with
+	// ConflictKeepSource or PreferNewer.
+	NoOverwrite bool
+	// UnknownDateDir, when non-empty, is a directory relative to the target base directory that receives
+	// files without a reliable capture date (date source DateSourceFileModTime) under their original name,
+	// instead of a date folder.
+	UnknownDateDir string
+	// Move moves files into the target instead of copying them. Sources that are discarded as
+	// duplicates or skipped stay where they are.
+	Move bool
+	// KeepEmptySourceDirs leaves source directories in place that a Move emptied. By default, they
+	// are removed after the run (see RemoveEmptyDirs); the source directories themselves never are.
+	KeepEmptySourceDirs bool
+	// Hardlink hard links files into the target instead of copying them, so that an import on the same
+	// filesystem takes no extra space. Files on another filesystem are copied, and other link failures, e.g. on
+	// a filesystem without hard links, are errors. Move takes precedence.
+	// Sources already linked at their target, e.g. by an earlier run, are skipped (AlreadyLinkedSkipReason).
+	Hardlink bool
+	// IgnoreSpaceCheck skips the check that the source files fit into the free space of the target
+	// filesystem, which a full run makes before processing any file (see CheckFreeSpace).
+	IgnoreSpaceCheck bool
+	// AvailableSpace, when non-nil, replaces AvailableSpace for the free space check.
+	AvailableSpace func(dir string) (uint64, error)
+	// OnCopy, when non-empty, is a command run after each file is copied into the target (see RunOnCopy).
+	// Duplicates and skipped files do not run it. Failures are logged and do not stop the run.
+	OnCopy string
+	// CopySidecars copies the sidecar files of each copied source (see SidecarFiles) next to its target,
+	// named after the target. Failures are logged and do not stop the run.
+	CopySidecars bool
+	// MachineLog, when non-nil, receives a single line for every duplicate and skipped source of a run,
+	// whatever the log level (see DuplicateLogLine and SkipLogLine), so the decisions can be grepped.
+	MachineLog io.Writer
+	// AutoRotate turns JPEG and PNG sources upright according to their EXIF orientation when copying
+	// them (see AutoRotateImage). Other files are copied unchanged. It cannot be combined with Move or Hardlink.
+	AutoRotate bool
+	// JSONLines, when non-nil, receives a FileEvent for every source of a run as a line of JSON as soon
+	// as the source is sorted (see WriteFileEvent), for tools that follow a run while it progresses.
+	JSONLines io.Writer
+	// CollapseBursts keeps only the best shot of each burst among the sources: of images taken at most
+	// BurstWindow apart with near-identical content, the one with the most pixels, and the sharpest of
+	// those, is sorted and the others are reported as ReasonBurstCollapsed duplicates (see CollapseBursts).
+	// Like the grouping of identical sources, it needs the whole source list, so it disables StreamScan.
+	CollapseBursts bool
+	// BurstWindow is the longest time between consecutive shots of a burst with CollapseBursts.
+	// 0 means DefaultBurstWindow.
+	BurstWindow time.Duration
+	// DirMode is the permission mode of the directories created in the target directory, and FileMode
+	// that of the files copied there, regardless of the umask. 0 means DefaultDirMode and DefaultFileMode.
+	// Moved and hard-linked files keep their own permissions.
+	DirMode  fs.FileMode
+	FileMode fs.FileMode
+}
+
+// DateSourceFileModTime is the date source of files dated by their modification time, usually because
+// they have neither a date override nor an EXIF date.
+const DateSourceFileModTime = "FileModTime"
+
+// EmptyFileSkipReason is the reason recorded for zero-byte sources, which are never copied.
+const EmptyFileSkipReason = "Skipped (empty file)"
+
+// AlreadyInPlaceSkipReason is the reason recorded for sources that already are at their target path,
+// e.g. when the source and target directories are the same.
+const AlreadyInPlaceSkipReason = "Already in correct location"
+
+// errAlreadyInPlace is returned by sortFile for sources that already are at their target path.
+var errAlreadyInPlace = errors.New("source is already at its target path")
+
+// AlreadyLinkedSkipReason is the reason recorded, with Hardlink, for sources whose target path already
+// is a hard link to them, e.g. when the same sources are sorted again.
+const AlreadyLinkedSkipReason = "Already linked"
+
+// errAlreadyLinked is returned by sortFile for sources that already are hard linked at their target path.
+var errAlreadyLinked = errors.New("source is already linked at its target path")
+
+// ExistingSizeMatchSkipReason is the reason recorded, with SkipExistingTargetFast, for sources whose
+// target path already holds a file of the same size.
+const ExistingSizeMatchSkipReason = "Skipped (existing, size match)"
+
+// errExistingSizeMatch is returned by sortFile for sources skipped by SkipExistingTargetFast.
+var errExistingSizeMatch = errors.New("target path holds a file of the same size")
+
+// LoggerOrDefault returns opts.Logger, or a text logger on stdout at the level implied by opts.Verbose.
+func (opts Options) LoggerOrDefault() Logger {
+	if opts.Logger != nil {
+		return opts.Logger
+	}
+	level := slog.LevelInfo
+	if opts.Verbose {
+		level = slog.LevelDebug
+	}
+	return NewLogger(os.Stdout, level, false)
+}
+
+// DirModeOrDefault returns opts.DirMode, or DefaultDirMode if it is not set.
+func (opts Options) DirModeOrDefault() fs.FileMode {
+	if opts.DirMode == 0 {
+		return DefaultDirMode
+	}
+	return opts.DirMode
+}
+
+// FileModeOrDefault returns opts.FileMode, or DefaultFileMode if it is not set.
+func (opts Options) FileModeOrDefault() fs.FileMode {
+	if opts.FileMode == 0 {
+		return DefaultFileMode
+	}
+	return opts.FileMode
+}
+
+// Validate checks the filename format, directory layout, max files per directory, conflict strategy,
+// date strategy, unknown date directory, copy retry settings, report naming, on-copy command,
+// permission modes, file name prefix and suffix and the options that cannot be combined with Move in opts.
+func (opts Options) Validate() error {
+	if opts.CopyRetries < 0 || opts.CopyRetryDelay < 0 {
+		return fmt.Errorf("copy retries and retry delay must not be negative")
+	}
+	if opts.FilenameFormat != "" {
+		if err := ValidateFilenameFormat(opts.FilenameFormat); err != nil {
+			return err
+		}
+	}
+	if affixes := opts.FilePrefix + opts.FileSuffix; sanitizeFileName(affixes) != affixes {
+		return fmt.Errorf("file prefix and suffix must not contain path separators or characters not allowed in file names")
+	}
+	if err := ValidateRenameTemplate(opts.RenameTemplate); err != nil {
+		return err
+	}
+	layout, err := directoryLayout(opts)
+	if err != nil {
+		return err
+	}
+	if opts.HashWorkers < 0 {
+		return fmt.Errorf("hash workers must not be negative")
+	}
+	if opts.MaxOpenFiles < 0 {
+		return fmt.Errorf("max open files must not be negative")
+	}
+	if opts.MaxFilesPerDir < 0 {
+		return fmt.Errorf("max files per directory must not be negative")
+	}
+	if opts.MaxFilesPerDir > 0 && layout == "" {
+		return fmt.Errorf("max files per directory cannot be used with a flat layout")
+	}
+	if err := ValidateConflictStrategy(opts.ConflictStrategy); err != nil {
+		return err
+	}
+	if opts.NoOverwrite && opts.ConflictStrategy == ConflictKeepSource {
+		return fmt.Errorf("no-overwrite mode cannot be combined with the %s conflict strategy", ConflictKeepSource)
+	}
+	if opts.NoOverwrite && opts.PreferNewer {
+		return fmt.Errorf("no-overwrite mode cannot be combined with preferring newer duplicates")
+	}
+	if err := ValidateDateStrategy(opts.DateStrategy); err != nil {
+		return err
+	}
+	if err := ValidateMimeMismatchAction(opts.MimeMismatchAction); err != nil {
+		return err
+	}
+	if opts.MinPlausibleYear < 0 {
+		return fmt.Errorf("minimum plausible year must not be negative, got %d", opts.MinPlausibleYear)
+	}
+	for _, ext := range opts.PreferExtensionOrder {
+		if ext == "" || strings.HasPrefix(ext, ".") || ext != strings.ToLower(ext) {
+			return fmt.Errorf("preferred extension '%s' must be lowercase and without a leading dot", ext)
+		}
+	}
+	for _, field := range opts.ExifSignatureFields {
+		if strings.TrimSpace(field) == "" {
+			return fmt.Errorf("EXIF signature fields must not be blank")
+		}
+	}
+	if err := ValidateReportFormat(opts.ReportFormat); err != nil {
+		return err
+	}
+	if err := ValidateCompareAlgorithm(opts.CompareAlgorithm); err != nil {
+		return err
+	}
+	if opts.TimestampReport && opts.ReportPath != "" {
+		return fmt.Errorf("a timestamped report name cannot be combined with a report path")
+	}
+	if opts.HistogramThreshold < 0 || opts.HistogramThreshold > 1 {
+		return fmt.Errorf("histogram threshold must be between 0 and 1")
+	}
+	if opts.OnCopy != "" && len(strings.Fields(opts.OnCopy)) == 0 {
+		return fmt.Errorf("on-copy command must not be blank")
+	}
+	if opts.BurstWindow < 0 {
+		return fmt.Errorf("burst window must not be negative")
+	}
+	if opts.DirMode&^fs.ModePerm != 0 || opts.FileMode&^fs.ModePerm != 0 {
+		return fmt.Errorf("directory and file modes must only have permission bits")
+	}
+	if opts.DirMode != 0 && opts.DirMode&0300 != 0300 {
+		return fmt.Errorf("directory mode %04o must let the owner write and search directories", opts.DirMode)
+	}
+	if opts.FileMode != 0 && opts.FileMode&0400 == 0 {
+		return fmt.Errorf("file mode %04o must let the owner read files", opts.FileMode)
+	}
+	if opts.ConvertHeicToJpeg && opts.Move {
+		return fmt.Errorf("HEIC conversion re-encodes images and cannot be combined with moving, which would delete the originals")
+	}
+	if opts.AutoRotate && (opts.Move || opts.Hardlink) {
+		return fmt.Errorf("auto-rotation writes new files and cannot be combined with moving or hard linking")
+	}
+	return ValidateUnknownDateDir(opts.UnknownDateDir)
+}
+
+// ValidateUnknownDateDir returns an error if dir is not a relative path inside the target directory.
+// An empty dir is accepted and means undated files are sorted by modification time.
+func ValidateUnknownDateDir(dir string) error {
+	if dir != "" && !filepath.IsLocal(dir) {
+		return fmt.Errorf("unknown date directory '%s' must be a relative path within the target directory", dir)
+	}
+	return nil
+}
+
+// FileOutcome describes what SortFile did with a source file.
+type FileOutcome struct {
+	// Copied is true if the source was copied into the target, including replacing an existing target.
+	Copied bool
+	// TargetPath is where the source was copied, or the existing file it was found to duplicate.
+	TargetPath string
+	// Duplicate is set when either the source or an existing target was discarded.
+	Duplicate *DuplicateInfo
+	// UsedFileHash is true if the source could not be pixel hashed and was compared by file hash.
+	UsedFileHash bool
+	// PixelHashUnsupported is true if the source is an image that could not be pixel hashed,
+	// whether or not it had to be compared with a target. With ExifPrefilter, CompareFileOnly or
+	// CompareExifOnly, only images that were pixel hashed in a comparison are known to be unsupported.
+	PixelHashUnsupported bool
+	// DateSource is how the photo date was determined: DateSourceOverride, "EXIF " and the tag name,
+	// DateSourceXMP, DateSourceFilename or DateSourceFileModTime.
+	DateSource string
+	// SkipReason is set when the source was left alone without being copied or compared,
+	// e.g. EmptyFileSkipReason or AlreadyInPlaceSkipReason.
+	SkipReason string
+	// CorruptReason is set to the decoding error when the source is a damaged image, e.g. a truncated
+	// JPEG (see ValidateImageIntegrity). Such images are not counted as PixelHashUnsupported.
+	CorruptReason string
+	// CorrectedExtension is set, with Options.ValidateMime, to the extension of the source's content
+	// that its target got instead of the source's own, e.g. ".png" for a PNG named IMG_0001.jpg.
+	CorrectedExtension string
+	// Sidecars are the paths that the source's sidecar files were copied to, with Options.CopySidecars.
+	Sidecars []string
+}
+
+// determinePhotoDateAndDateSource uses the date in overrides for the file's base name if there is one,
+// and otherwise picks a date from EXIF, an XMP sidecar, the file name and the file modification time
+// according to strategy, ignoring dates before minYear (see ResolvePhotoDateWithMinYear). The EXIF date
+// is read under files.
+func determinePhotoDateAndDateSource(currentSourceFilepath string, overrides map[string]time.Time, strategy DateStrategy, minYear int, files *OpenFileLimiter, logger Logger) (photoDate time.Time, dateSource string, err error) {
+	if overrideDate, ok := overrides[filepath.Base(currentSourceFilepath)]; ok {
+		photoDate = overrideDate
+		dateSource = DateSourceOverride
+	} else {
+		photoDate, dateSource, err = resolvePhotoDate(currentSourceFilepath, strategy, minYear, files)
+		if err != nil {
+			logger.Debug("Error determining date, skipping", "source", currentSourceFilepath, "error", err)
+			return time.Time{}, "", err
+		}
+	}
+	logger.Debug("Determined date", "source", currentSourceFilepath, "dateSource", dateSource, "date", photoDate.Format("2006-01-02 15:04:05"))
+	return photoDate, dateSource, nil
+}
+
+// SourceExtension returns the extension sourceFilePath gets in the target. Extensionless files get the
+// extension of their sniffed image type when opts.SniffExtensionless is set, files whose content does
+// not match their extension get that of their content when opts.ValidateMime fixes extensions, and
+// HEIC/HEIF files get ".jpg" when opts.ConvertHeicToJpeg is set.
+func SourceExtension(sourceFilePath string, opts Options) string {
+	if opts.ConvertHeicToJpeg && IsHeicPath(sourceFilePath) {
+		return ".jpg"
+	}
+	if corrected, ok := correctedExtension(sourceFilePath, opts); ok {
+		return corrected
+	}
+	extension := filepath.Ext(sourceFilePath)
+	if extension == "" && opts.SniffExtensionless {
+		if sniffed, ok := SniffImageType(sourceFilePath); ok {
+			return sniffed
+		}
+	}
+	return extension
+}
+
+// directoryLayout returns the directory layout selected by opts.Layout, opts.Structure or opts.Flatten,
+// defaulting to DefaultDirectoryLayout. Conflicting or invalid settings are reported as errors.
+func directoryLayout(opts Options) (string, error) {
+	if opts.Layout != "" {
+		if opts.Structure != "" || opts.Flatten {
+			return "", fmt.Errorf("a custom layout cannot be combined with a structure preset or flatten")
+		}
+		if opts.MonthNameFormat != "" {
+			return "", fmt.Errorf("a custom layout cannot be combined with a month name format; use Jan or January in the layout instead")
+		}
+		if err := ValidateDirectoryLayout(opts.Layout); err != nil {
+			return "", err
+		}
+		return opts.Layout, nil
+	}
+	if opts.Flatten {
+		if opts.Structure != "" && opts.Structure != "flat" {
+			return "", fmt.Errorf("flatten cannot be combined with structure '%s'", opts.Structure)
+		}
+		return MonthNameLayout("", opts.MonthNameFormat)
+	}
+	layout := DefaultDirectoryLayout
+	if opts.Structure != "" {
+		var err error
+		if layout, err = StructureLayout(opts.Structure); err != nil {
+			return "", err
+		}
+	}
+	return MonthNameLayout(layout, opts.MonthNameFormat)
+}
+
+// ComputeTargetPath returns the path a run with opts would copy the source at sourcePath to if it is
+// dated date and nothing occupies that path yet: the date directory of the layout selected in opts (or
+// of opts.PathResolver, which gets no date source) and the name from opts.FilenameFormat or
+// opts.RenameTemplate. Nothing is created or written, so it suits previews; a run only creates the
+// directory when it copies a file there. date is taken as a reliable date, so opts.UnknownDateDir does not apply.
+func ComputeTargetPath(targetBaseDir string, date time.Time, sourcePath string, opts Options) (string, error) {
+	targetPath, _, err := determineTargetPath(targetBaseDir, date, "", sourcePath, opts)
+	return targetPath, err
+}
+
+// resolveTargetPath returns the target path of a source dated photoDate by dateSource: a path in
+// opts.UnknownDateDir for files dated by modification time when it is set, and a date directory otherwise.
+// No directory is created.
+func resolveTargetPath(targetBaseDir string, photoDate time.Time, dateSource string, sourceFilePath string, opts Options) (string, error) {
+	if opts.UnknownDateDir != "" && dateSource == DateSourceFileModTime {
+		return undatedTargetPath(targetBaseDir, sourceFilePath, opts)
+	}
+	exactTargetPath, _, err := determineTargetPath(targetBaseDir, photoDate, dateSource, sourceFilePath, opts)
+	return exactTargetPath, err
+}
+
+// targetNameTime returns the time whose wall clock names the target file of a photo dated photoDate.
+// File times, which are in the local time zone, are named in UTC; dates that carry the camera's own
+// time zone (EXIF dates with an offset tag) keep the wall clock of the camera.
+func targetNameTime(photoDate time.Time) time.Time {
+	if photoDate.Location() == time.Local {
+		return photoDate.In(time.UTC)
+	}
+	return photoDate
+}
+
+// determineTargetPath returns the target directory path and filename, without creating the directory.
+// The directory below targetBaseDir follows the layout selected in opts (YYYY/MM by default), or is
+// picked by opts.PathResolver; a flat layout places the file directly in targetBaseDir. With
+// opts.PreserveSubdir, the source's subdirectory is added below it, and with opts.MaxFilesPerDir,
+// the file may go into an overflow sibling of the resulting directory instead. The file name is
+// decorated with opts.FilePrefix and opts.FileSuffix.
+func determineTargetPath(targetBaseDir string, photoDate time.Time, dateSource string, sourceFilePath string, opts Options) (exactTargetPath string, targetMonthDir string, err error) {
+	logger := opts.LoggerOrDefault()
+	layout, err := directoryLayout(opts)
+	if err != nil {
+		return "", "", err
+	}
+	targetMonthDir = targetBaseDir
+	if opts.PathResolver != nil {
+		if targetMonthDir, err = resolveTargetDir(opts.PathResolver, targetBaseDir, sourceFilePath, photoDate, dateSource); err != nil {
+			return "", "", err
+		}
+	} else if layout != "" {
+		targetMonthDir = filepath.Join(targetBaseDir, filepath.FromSlash(FormatDirectoryLayout(photoDate, layout)))
+	}
+	if opts.PreserveSubdir {
+		targetMonthDir = filepath.Join(targetMonthDir, sourceSubdir(sourceFilePath, opts.SourceDirs))
+	}
+
+	originalExtension := SourceExtension(sourceFilePath, opts)
+	filenameFormat := opts.FilenameFormat
+	if filenameFormat == "" {
+		filenameFormat = DefaultFilenameFormat
+	}
+	baseNameWithoutExt := targetNameTime(photoDate).Format(filenameFormat)
+	if opts.RenameTemplate != "" {
+		if baseNameWithoutExt, err = RenderRenameTemplate(opts.RenameTemplate, photoDate, sourceFilePath); err != nil {
+			return "", "", err
+		}
+	}
+	targetFileName := opts.FilePrefix + baseNameWithoutExt + opts.FileSuffix + originalExtension
+	if opts.NormalizeUnicode {
+		targetFileName = norm.NFC.String(targetFileName)
+	}
+	if opts.MaxFilesPerDir > 0 {
+		if targetMonthDir, err = BucketDirectory(targetMonthDir, targetFileName, opts.MaxFilesPerDir); err != nil {
+			return "", "", err
+		}
+	}
+	exactTargetPath = filepath.Join(targetMonthDir, targetFileName)
+
+	// Only image extensions are scanned, so a generated name can never be the report file,
+	// but guard against it explicitly since a flat layout shares the report's directory.
+	if filepath.Clean(exactTargetPath) == filepath.Join(targetBaseDir, ReportFileName) {
+		return "", "", fmt.Errorf("target path %s collides with the report file", exactTargetPath)
+	}
+
+	logger.Debug("Proposed target path", "source", sourceFilePath, "target", exactTargetPath)
+	return exactTargetPath, targetMonthDir, nil
+}
+
+// sourceSubdir returns the directory of sourceFilePath relative to the innermost of sourceDirs that
+// contains it, or "" if it is directly in that directory or in none of them.
+func sourceSubdir(sourceFilePath string, sourceDirs []string) string {
+	subdir := ""
+	found := false
+	for _, dir := range sourceDirs {
+		rel, err := filepath.Rel(dir, filepath.Dir(sourceFilePath))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if rel == "." {
+			rel = ""
+		}
+		if !found || len(rel) < len(subdir) {
+			subdir, found = rel, true
+		}
+	}
+	return subdir
+}
+
+// undatedTargetPath returns the path in opts.UnknownDateDir below targetBaseDir for a source without
+// a reliable date, keeping the source's file name (in NFC with opts.NormalizeUnicode). The directory is not created.
+func undatedTargetPath(targetBaseDir string, sourceFilePath string, opts Options) (string, error) {
+	logger := opts.LoggerOrDefault()
+	undatedDir := filepath.Join(targetBaseDir, opts.UnknownDateDir)
+	if opts.PreserveSubdir {
+		undatedDir = filepath.Join(undatedDir, sourceSubdir(sourceFilePath, opts.SourceDirs))
+	}
+
+	baseName := filepath.Base(sourceFilePath)
+	targetFileName := strings.TrimSuffix(baseName, filepath.Ext(baseName)) + SourceExtension(sourceFilePath, opts)
+	if opts.NormalizeUnicode {
+		targetFileName = norm.NFC.String(targetFileName)
+	}
+	exactTargetPath := filepath.Join(undatedDir, targetFileName)
+	if filepath.Clean(exactTargetPath) == filepath.Join(targetBaseDir, ReportFileName) {
+		return "", fmt.Errorf("target path %s collides with the report file", exactTargetPath)
+	}
+
+	logger.Debug("Proposed target path for undated file", "source", sourceFilePath, "target", exactTargetPath)
+	return exactTargetPath, nil
+}
+
+// alreadyAtTargetPath reports whether the source is exactTargetPath itself or one of its "-N" versions,
+// as happens when a directory that was sorted before is sorted in place again. It returns the matching path.
+func alreadyAtTargetPath(sourceFilePath string, exactTargetPath string) (string, bool) {
+	if sameFile(sourceFilePath, exactTargetPath) {
+		return exactTargetPath, true
+	}
+	if !sameFile(filepath.Dir(sourceFilePath), filepath.Dir(exactTargetPath)) {
+		return "", false // Versions live next to exactTargetPath; only list them for sources in that directory
+	}
+	return sameFileVersion(sourceFilePath, exactTargetPath)
+}
+
+// alreadyLinkedPath reports whether exactTargetPath or one of its "-N" versions is a hard link to the
+// source, as happens when sources that were sorted with Hardlink before are sorted again. It returns the
+// linked path.
+func alreadyLinkedPath(sourceFilePath string, exactTargetPath string) (string, bool) {
+	if sameFile(sourceFilePath, exactTargetPath) {
+		return exactTargetPath, true
+	}
+	return sameFileVersion(sourceFilePath, exactTargetPath)
+}
+
+// sameFileVersion returns the "-N" version of exactTargetPath that is the same file as the source, if any.
+func sameFileVersion(sourceFilePath string, exactTargetPath string) (string, bool) {
+	extension := filepath.Ext(exactTargetPath)
+	baseName := strings.TrimSuffix(filepath.Base(exactTargetPath), extension)
+	versions, err := FindPotentialTargetConflicts(filepath.Dir(exactTargetPath), baseName, extension)
+	if err != nil {
+		return "", false
+	}
+	for _, versionPath := range versions {
+		if sameFile(sourceFilePath, versionPath) {
+			return versionPath, true
+		}
+	}
+	return "", false
+}
+
+// sameSize reports whether path1 and path2 both exist and are files of the same size.
+func sameSize(path1, path2 string) bool {
+	info1, err1 := os.Stat(path1)
+	info2, err2 := os.Stat(path2)
+	return err1 == nil && err2 == nil && info1.Mode().IsRegular() && info2.Mode().IsRegular() && info1.Size() == info2.Size()
+}
+
+// sameFile reports whether path1 and path2 both exist and are the same file, e.g. through a symlink.
+func sameFile(path1, path2 string) bool {
+	info1, err1 := os.Stat(path1)
+	info2, err2 := os.Stat(path2)
+	return err1 == nil && err2 == nil && os.SameFile(info1, info2)
+}
+
+// copyFunc copies a source file to its target path.
+type copyFunc func(srcPath, destPath string) error
+
+// copyBuffers pools the copy buffers of opts.CopyBufferSize bytes, so that files sorted one
+// at a time reuse buffers instead of allocating one per file.
+var copyBuffers sync.Pool
+
+// newCopyFunc returns the copyFunc for opts. Copies use a pooled buffer of opts.CopyBufferSize
+// bytes; opts.PreserveTimes keeps the source's times. Transient failures are retried opts.CopyRetries times,
+// unless ctx is cancelled.
+// With opts.Move, files are moved instead, falling back to such a copy, which always keeps the times,
+// across filesystems.
+// With opts.Hardlink, files are hard linked instead, falling back to such a copy across filesystems and
+// when replacing a target.
+// With opts.ConvertHeicToJpeg, HEIC/HEIF sources are converted (see ConvertHeicToJPEG) instead of copied.
+// With opts.AutoRotate, JPEG and PNG sources are written upright (see AutoRotateImage) instead of copied.
+// Created directories and written files get opts.DirMode and opts.FileMode, and files are held open
+// under opts.OpenFiles.
+func newCopyFunc(ctx context.Context, opts Options) copyFunc {
+	modes := fileModes{dir: opts.DirModeOrDefault(), file: opts.FileModeOrDefault()}
+	bufferedCopy := func(preserveTimes bool) copyFunc {
+		return func(srcPath, destPath string) error {
+			var copyBuf []byte
+			if opts.CopyBufferSize > 0 {
+				if pooled, ok := copyBuffers.Get().(*[]byte); ok && len(*pooled) == opts.CopyBufferSize {
+					copyBuf = *pooled
+				} else {
+					copyBuf = make([]byte, opts.CopyBufferSize)
+				}
+				defer copyBuffers.Put(&copyBuf)
+			}
+			return copyFile(srcPath, destPath, copyBuf, preserveTimes, modes, opts.OpenFiles)
+		}
+	}
+	copyOnce := bufferedCopy(opts.PreserveTimes)
+	if opts.Move {
+		copyFile := bufferedCopy(true) // A moved file keeps its times, even when it has to be copied
+		copyOnce = func(srcPath, destPath string) error { return moveFile(srcPath, destPath, modes.dir, copyFile) }
+	} else if opts.Hardlink {
+		copyFile := copyOnce
+		copyOnce = func(srcPath, destPath string) error { return linkFile(srcPath, destPath, modes.dir, copyFile) }
+	}
+	if opts.ConvertHeicToJpeg {
+		transfer := copyOnce
+		copyOnce = func(srcPath, destPath string) error {
+			if !IsHeicPath(srcPath) {
+				return transfer(srcPath, destPath)
+			}
+			return convertHeicToJPEG(srcPath, destPath, opts.PreserveTimes, modes, opts.OpenFiles)
+		}
+	}
+	if opts.AutoRotate {
+		transfer := copyOnce
+		copyOnce = func(srcPath, destPath string) error {
+			rotated, err := autoRotateImage(srcPath, destPath, opts.PreserveTimes, modes, opts.OpenFiles)
+			if err != nil || rotated {
+				return err
+			}
+			return transfer(srcPath, destPath)
+		}
+	}
+	if opts.CopyRetries == 0 {
+		return copyOnce
+	}
+	logger := opts.LoggerOrDefault()
+	return func(srcPath, destPath string) error {
+		return retryCopy(ctx, destPath, opts.CopyRetries, opts.CopyRetryDelay, func() error { return copyOnce(srcPath, destPath) },
+			func(attempt int, wait time.Duration, err error) {
+				logger.Warn("Copy failed, retrying", "source", srcPath, "target", destPath, "attempt", attempt, "wait", wait, "error", err)
+			})
+	}
+}
+
+// targetLockStripes is the number of mutexes that target paths are striped over.
+const targetLockStripes = 256
+
+// targetLocks are the striped target path locks, picked by path hash. Paths that share a stripe
+// merely take turns, and their number stays fixed however many targets a run considers.
+var targetLocks [targetLockStripes]sync.Mutex
+
+// lockTargetPath locks path against concurrent sortFile calls and returns the unlock func.
+// The lock covers the stat-then-copy of the path and the resolution of a conflict with it,
+// including the "-N" versions created next to it. A sortFile call holds at most one lock at a time.
+func lockTargetPath(path string) (unlock func()) {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	mu := &targetLocks[h.Sum32()%targetLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// checkAndCopyIfTargetEmpty checks if the target path is empty and copies the file if it is.
+// Returns true if copied, false if target existed or copy error. Error is returned for system/copy errors.
+// copyFile creates the target directory, with the configured permissions.
+func checkAndCopyIfTargetEmpty(sourceFilePath string, exactTargetPath string, copyFile copyFunc, logger Logger) (copied bool, err error) {
+	_, statErr := os.Stat(exactTargetPath)
+	if statErr == nil { // File exists
+		logger.Debug("File already exists at target path", "target", exactTargetPath)
+		return false, nil // Not copied by this function, target exists
+	} else if !os.IsNotExist(statErr) { // Other stat error
+		logger.Debug("Error checking target path, skipping", "source", sourceFilePath, "target", exactTargetPath, "error", statErr)
+		return false, fmt.Errorf("error checking target path %s: %w", exactTargetPath, statErr)
+	}
+
+	// Target does not exist (os.IsNotExist(statErr) is true)
+	logger.Debug("Target path is free, copying", "source", sourceFilePath, "target", exactTargetPath)
+	if copyErr := copyFile(sourceFilePath, exactTargetPath); copyErr != nil {
+		logger.Debug("Error copying file", "source", sourceFilePath, "target", exactTargetPath, "error", copyErr)
+		return false, fmt.Errorf("error copying file %s to %s: %w", sourceFilePath, exactTargetPath, copyErr)
+	}
+	logger.Debug("Copied file", "source", sourceFilePath, "target", exactTargetPath)
+	return true, nil // Copied successfully
+}
+
+// handleTargetConflict deals with situations where a file already exists at the target path.
+// A target with different content is resolved according to conflictStrategy, unless histogramThreshold
+// is greater than 0 and the two images have similar color histograms, in which case the source is
+// discarded and the target never replaced. With preferNewer, a duplicate
+// target is also replaced by a newer source (see sourceIsNewer). With noOverwrite, the target is never
+// replaced: a better source is copied to a new version with ConflictVersion and discarded otherwise.
+func handleTargetConflict(currentSourceFilepath string, srcHashes *FileHashes, exactTargetPath string, currentWidth int, currentHeight int, conflictStrategy string, preferNewer bool, preferLargerFile bool, noOverwrite bool, histogramThreshold float64, copyFile copyFunc, logger Logger) (copied bool, finalTargetPath string, duplicateInfo *DuplicateInfo, usedFileHash bool, err error) {
+	logger.Debug("Comparing source with existing target", "source", currentSourceFilepath, "target", exactTargetPath)
+	compResult, errComp := AreFilesPotentiallyDuplicateWithHashes(currentSourceFilepath, exactTargetPath, srcHashes)
+	currentUsedFileHash := compResult.HashType == HashTypeFile && IsImageExtension(currentSourceFilepath)
+
+	if errComp != nil {
+		logger.Debug("Error comparing source with target, keeping target", "source", currentSourceFilepath, "target", exactTargetPath, "error", errComp)
+		dupInfo := DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: ReasonError, Detail: "comparison error, existing target kept"}.withComparison(compResult)
+		// Report the duplicate and surface the error so the source can be quarantined; it does not stop processing other files.
+		return false, exactTargetPath, &dupInfo, currentUsedFileHash, fmt.Errorf("error comparing %s with %s: %w", currentSourceFilepath, exactTargetPath, errComp)
+	}
+
+	if !compResult.AreDuplicates && preferNewer && compResult.Reason == ReasonExifMismatch && samePixels(srcHashes, exactTargetPath) {
+		// The same picture with edited metadata: let the newer one win instead of treating it as a name collision.
+		compResult.AreDuplicates, compResult.Reason, compResult.HashType = true, ReasonPixelHashMatch, HashTypePixel
+		compResult.Hash1, _ = srcHashes.PixelHash() // samePixels found the target's pixel hash equal
+		compResult.Hash2 = compResult.Hash1
+	}
+	if !compResult.AreDuplicates && histogramThreshold > 0 && IsImageExtension(currentSourceFilepath) && IsImageExtension(exactTargetPath) {
+		if similarity, similar := histogramsSimilar(currentSourceFilepath, exactTargetPath, histogramThreshold); similar {
+			logger.Debug("Color histograms are similar, treating as duplicates", "source", currentSourceFilepath, "target", exactTargetPath, "similarity", similarity)
+			compResult.AreDuplicates, compResult.Reason = true, ReasonHistogramMatch
+			compResult.HashType, compResult.Hash1, compResult.Hash2 = HashTypeHistogram, "", ""
+		}
+	}
+	if compResult.Reason == ReasonZeroByteSource && !noOverwrite && sourceIsLarger(currentSourceFilepath, exactTargetPath) {
+		// An empty target, e.g. left behind by an interrupted copy, holds nothing worth keeping over the source.
+		dupInfo := DuplicateInfo{KeptFile: currentSourceFilepath, DiscardedFile: exactTargetPath, Reason: compResult.Reason, Detail: "existing target was empty", Replaced: true}.withComparison(compResult)
+		if copyErr := copyFile(currentSourceFilepath, exactTargetPath); copyErr != nil {
+			return false, "", nil, currentUsedFileHash, fmt.Errorf("failed to replace empty target %s: %w", exactTargetPath, copyErr)
+		}
+		logger.Debug("Replaced empty target", "source", currentSourceFilepath, "target", exactTargetPath)
+		return true, exactTargetPath, &dupInfo, currentUsedFileHash, nil
+	}
+	if !compResult.AreDuplicates {
+		return resolveNameCollision(currentSourceFilepath, srcHashes, exactTargetPath, compResult, conflictStrategy, currentUsedFileHash, copyFile, logger)
+	}
+	if compResult.Reason == ReasonHistogramMatch {
+		// Similar colors do not make the same photo, so a histogram match never replaces the target.
+		dupInfo := DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: compResult.Reason, Detail: "existing target kept - similar histogram"}.withComparison(compResult)
+		logger.Debug("Target kept, source discarded", "source", currentSourceFilepath, "target", exactTargetPath, "reason", dupInfo.ReasonText())
+		return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil
+	}
+
+	// Files are duplicates
+	logger.Debug("Duplicate found", "source", currentSourceFilepath, "target", exactTargetPath, "reason", compResult.Reason)
+	targetResolutionBetterOrEqual := true
+	targetHigherResolution := false
+	replaceDetail := "source is better resolution"
+
+	if compResult.Reason == ReasonPixelHashMatch {
+		targetWidth, targetHeight, errResTarget := getDisplayResolution(exactTargetPath, srcHashes.OpenFiles)
+		if errResTarget != nil {
+			logger.Debug("Could not get target resolution, source may replace it", "target", exactTargetPath, "error", errResTarget)
+			if currentWidth*currentHeight > 0 { // Source has valid resolution
+				targetResolutionBetterOrEqual = false
+			} else { // Source also has resolution error or 0x0
+				dupInfo := DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: compResult.Reason, Detail: "existing target kept - resolution error for target, source has no resolution or also error"}.withComparison(compResult)
+				logger.Debug("Target kept (pixel hash match, no usable resolution for target or source)", "source", currentSourceFilepath, "target", exactTargetPath)
+				return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil
+			}
+		} else { // Target resolution is available
+			logger.Debug("Target resolution", "target", exactTargetPath, "width", targetWidth, "height", targetHeight)
+			if IsHigherResolution(currentWidth, currentHeight, targetWidth, targetHeight) {
+				targetResolutionBetterOrEqual = false
+			}
+			targetHigherResolution = IsHigherResolution(targetWidth, targetHeight, currentWidth, currentHeight)
+			if preferLargerFile && compResult.Reason == ReasonPixelHashMatch && targetResolutionBetterOrEqual && !targetHigherResolution && sourceIsLarger(currentSourceFilepath, exactTargetPath) {
+				targetResolutionBetterOrEqual = false
+				replaceDetail = "source is larger file at same resolution"
+			}
+		}
+	}
+	if targetResolutionBetterOrEqual && !targetHigherResolution && preferNewer {
+		if detail, newer := sourceIsNewer(currentSourceFilepath, exactTargetPath, srcHashes.OpenFiles); newer {
+			targetResolutionBetterOrEqual = false
+			replaceDetail = detail
+		}
+	}
+
+	if !targetResolutionBetterOrEqual && noOverwrite {
+		if conflictStrategy == ConflictVersion {
+			logger.Debug("Source is better but targets are never overwritten, copying it as a new version", "source", currentSourceFilepath, "target", exactTargetPath)
+			return copyToNextVersion(currentSourceFilepath, srcHashes, exactTargetPath, currentUsedFileHash, copyFile, logger)
+		}
+		dupInfo := DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: compResult.Reason, Detail: "existing target kept - no overwrite"}.withComparison(compResult)
+		logger.Debug("Source is better but targets are never overwritten, discarding source", "source", currentSourceFilepath, "target", exactTargetPath)
+		return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil
+	}
+	if !targetResolutionBetterOrEqual { // Source is better resolution, or newer
+		logger.Debug("Source is better, replacing target", "source", currentSourceFilepath, "width", currentWidth, "height", currentHeight, "target", exactTargetPath, "detail", replaceDetail)
+		dupInfo := DuplicateInfo{
+			KeptFile:      currentSourceFilepath, // Source is kept, will be copied to exactTargetPath
+			DiscardedFile: exactTargetPath,
+			Reason:        compResult.Reason,
+			Detail:        replaceDetail,
+			Replaced:      true,
+		}.withComparison(compResult)
+		if copyErr := copyFile(currentSourceFilepath, exactTargetPath); copyErr != nil {
+			logger.Debug("Error overwriting target, original target remains", "source", currentSourceFilepath, "target", exactTargetPath, "error", copyErr)
+			// If overwrite fails, the original target was kept. Adjust DuplicateInfo.
+			dupInfo.KeptFile = exactTargetPath
+			dupInfo.DiscardedFile = currentSourceFilepath
+			dupInfo.Detail = "attempted replacement failed, original target kept"
+			dupInfo.Replaced = false
+			return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil // Not an error for runApplicationLogic, but a handled duplicate.
+		}
+		logger.Debug("Replaced target", "source", currentSourceFilepath, "target", exactTargetPath)
+		// Successfully replaced, so copied is true, finalTargetPath is exactTargetPath
+		return true, exactTargetPath, &dupInfo, currentUsedFileHash, nil
+	}
+
+	// Target is better or same resolution, or not a pixel hash match (e.g. file hash match, where resolution is not the primary factor for replacement)
+	detail := "existing target kept"
+	if compResult.Reason == ReasonPixelHashMatch { // Only mention resolution if it was an image match and target was kept due to resolution
+		detail = "existing target kept - resolution"
+	}
+	dupInfo := DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: compResult.Reason, Detail: detail}.withComparison(compResult)
+	logger.Debug("Target kept, source discarded", "source", currentSourceFilepath, "target", exactTargetPath, "reason", dupInfo.ReasonText())
+	return false, exactTargetPath, &dupInfo, currentUsedFileHash, nil
+}
+
+// samePixels reports whether the source and the image at targetPath have the same pixel hash.
+func samePixels(srcHashes *FileHashes, targetPath string) bool {
+	srcHash, srcErr := srcHashes.PixelHash()
+	targetHashes := NewFileHashes(targetPath)
+	targetHashes.OpenFiles = srcHashes.OpenFiles
+	targetHash, targetErr := targetHashes.PixelHash()
+	return srcErr == nil && targetErr == nil && srcHash == targetHash
+}
+
+// sourceIsNewer reports whether the source should replace its duplicate at targetPath under PreferNewer:
+// the later EXIF date wins; if either has no EXIF date or the dates are equal, the larger file wins.
+// detail describes which attribute decided. The EXIF dates are read under files.
+func sourceIsNewer(sourcePath, targetPath string, files *OpenFileLimiter) (detail string, newer bool) {
+	sourceDate, sourceErr := getPhotoCreationDate(sourcePath, files)
+	targetDate, targetErr := getPhotoCreationDate(targetPath, files)
+	if sourceErr == nil && targetErr == nil && !sourceDate.Equal(targetDate) {
+		return "source is newer - later EXIF date", sourceDate.After(targetDate)
+	}
+	if sourceIsLarger(sourcePath, targetPath) {
+		return "source is newer - larger file", true
+	}
+	return "", false
+}
+
+// sourceIsLarger reports whether the file at sourcePath is larger than the one at targetPath.
+func sourceIsLarger(sourcePath, targetPath string) bool {
+	sourceSize, sourceErr := getFileSize(sourcePath)
+	targetSize, targetErr := getFileSize(targetPath)
+	return sourceErr == nil && targetErr == nil && sourceSize > targetSize
+}
+
+// resolveNameCollision applies conflictStrategy to a source whose target path is taken by a file with different content.
+func resolveNameCollision(currentSourceFilepath string, srcHashes *FileHashes, exactTargetPath string, compResult ComparisonResult, conflictStrategy string, usedFileHash bool, copyFile copyFunc, logger Logger) (copied bool, finalTargetPath string, duplicateInfo *DuplicateInfo, _ bool, err error) {
+	switch conflictStrategy {
+	case ConflictKeepSource:
+		logger.Debug("Source and target differ but share the target path, overwriting target", "source", currentSourceFilepath, "target", exactTargetPath)
+		if copyErr := copyFile(currentSourceFilepath, exactTargetPath); copyErr != nil {
+			return false, "", nil, usedFileHash, fmt.Errorf("error overwriting %s with %s: %w", exactTargetPath, currentSourceFilepath, copyErr)
+		}
+		dupInfo := DuplicateInfo{KeptFile: currentSourceFilepath, DiscardedFile: exactTargetPath, Reason: ReasonNameCollision, Detail: "content different, existing target overwritten"}.withComparison(compResult)
+		return true, exactTargetPath, &dupInfo, usedFileHash, nil
+
+	case ConflictVersion:
+		return copyToNextVersion(currentSourceFilepath, srcHashes, exactTargetPath, usedFileHash, copyFile, logger)
+
+	case ConflictSkip:
+		logger.Debug("Source and target differ but share the target path, skipping source", "source", currentSourceFilepath, "target", exactTargetPath)
+		return false, "", nil, usedFileHash, nil
+	}
+
+	logger.Debug("Source and target differ but share the target path, discarding source to protect existing target", "source", currentSourceFilepath, "target", exactTargetPath)
+	dupInfo := DuplicateInfo{KeptFile: exactTargetPath, DiscardedFile: currentSourceFilepath, Reason: ReasonNameCollision, Detail: "content different, existing target preserved"}.withComparison(compResult)
+	return false, exactTargetPath, &dupInfo, usedFileHash, nil
+}
+
+// copyToNextVersion copies the source next to exactTargetPath under the first free "-N" name.
+// If an existing version is a duplicate of the source, that version is kept and the source discarded,
+// so repeated runs do not create further versions of the same file.
+func copyToNextVersion(currentSourceFilepath string, srcHashes *FileHashes, exactTargetPath string, usedFileHash bool, copyFile copyFunc, logger Logger) (copied bool, finalTargetPath string, duplicateInfo *DuplicateInfo, _ bool, err error) {
+	dir := filepath.Dir(exactTargetPath)
+	extension := filepath.Ext(exactTargetPath)
+	baseName := strings.TrimSuffix(filepath.Base(exactTargetPath), extension)
+
+	versions, err := FindPotentialTargetConflicts(dir, baseName, extension)
+	if err != nil {
+		return false, "", nil, usedFileHash, err
+	}
+	for _, versionPath := range versions {
+		if versionPath == exactTargetPath {
+			continue
+		}
+		compResult, errComp := AreFilesPotentiallyDuplicateWithHashes(currentSourceFilepath, versionPath, srcHashes)
+		if errComp != nil {
+			logger.Debug("Error comparing source with existing version, ignoring it", "source", currentSourceFilepath, "version", versionPath, "error", errComp)
+			continue
+		}
+		if compResult.AreDuplicates {
+			logger.Debug("Source duplicates an existing version, discarding source", "source", currentSourceFilepath, "version", versionPath, "reason", compResult.Reason)
+			dupInfo := DuplicateInfo{KeptFile: versionPath, DiscardedFile: currentSourceFilepath, Reason: compResult.Reason, Detail: "existing version kept"}.withComparison(compResult)
+			return false, versionPath, &dupInfo, usedFileHash, nil
+		}
+	}
+
+	for n := 1; ; n++ {
+		versionPath := filepath.Join(dir, fmt.Sprintf("%s-%d%s", baseName, n, extension))
+		copied, err := checkAndCopyIfTargetEmpty(currentSourceFilepath, versionPath, copyFile, logger)
+		if err != nil {
+			return false, "", nil, usedFileHash, err
+		}
+		if copied {
+			logger.Debug("Source and target differ but share the target path, copied source as new version", "source", currentSourceFilepath, "target", exactTargetPath, "version", versionPath)
+			return true, versionPath, nil, usedFileHash, nil
+		}
+	}
+}
+
+// isCorruptImage reports whether a failure to read an image's resolution means the file
+// is damaged, as opposed to being in a format with no registered decoder (e.g. RAW).
+// Empty files are always considered corrupt.
+func isCorruptImage(filePath string, resolutionErr error) bool {
+	if info, err := os.Stat(filePath); err == nil && info.Size() == 0 {
+		return true
+	}
+	return !errors.Is(resolutionErr, image.ErrFormat)
+}
+
+// SortFile sorts a single source file into targetBaseDir: it determines the photo date, computes
+// the target path, resolves any conflict with an existing target and copies the file.
+// The returned outcome describes what happened; it may be partially filled when err is non-nil,
+// e.g. when a comparison error left the existing target in place.
+// Concurrent calls with the same target path are serialized, so only one of several identical
+// sources is copied. SortFile is not safe for concurrent use with the same opts.KnownHashes. HEIF images are only
+// decoded if a HEIF decoder is registered, e.g. by importing github.com/vegidio/heif-go.
+func SortFile(sourceFilePath string, targetBaseDir string, opts Options) (outcome FileOutcome, err error) {
+	return SortFileContext(context.Background(), sourceFilePath, targetBaseDir, opts)
+}
+
+// SortFileContext behaves like SortFile, but stops retrying a failed copy (see Options.CopyRetries) once
+// ctx is cancelled.
+func SortFileContext(ctx context.Context, sourceFilePath string, targetBaseDir string, opts Options) (outcome FileOutcome, err error) {
+	return SortFileWithHashesContext(ctx, NewFileHashes(sourceFilePath), targetBaseDir, opts)
+}
+
+// SortFileWithHashes behaves like SortFile for the source at srcHashes.Path, but takes its hashes from
+// srcHashes, e.g. after they were computed ahead of time by FileHashes.Precompute.
+func SortFileWithHashes(srcHashes *FileHashes, targetBaseDir string, opts Options) (outcome FileOutcome, err error) {
+	return SortFileWithHashesContext(context.Background(), srcHashes, targetBaseDir, opts)
+}
+
+// SortFileWithHashesContext behaves like SortFileWithHashes, but stops retrying a failed copy once ctx
+// is cancelled, as SortFileContext does.
+func SortFileWithHashesContext(ctx context.Context, srcHashes *FileHashes, targetBaseDir string, opts Options) (outcome FileOutcome, err error) {
+	sourceFilePath := srcHashes.Path
+	if err := opts.Validate(); err != nil {
+		return outcome, err
+	}
+	// Empty sources are skipped, unless they are to be quarantined as undecodable images.
+	if info, statErr := os.Stat(sourceFilePath); statErr == nil && info.Size() == 0 && opts.QuarantineDir == "" {
+		opts.LoggerOrDefault().Debug("Source is empty, skipping", "source", sourceFilePath)
+		return FileOutcome{SkipReason: EmptyFileSkipReason}, nil
+	}
+	copied, finalTargetPath, dupInfo, usedFileHash, dateSource, err := sortFile(sourceFilePath, srcHashes, targetBaseDir, opts, newCopyFunc(ctx, opts))
+	if errors.Is(err, errAlreadyInPlace) {
+		opts.LoggerOrDefault().Debug("Source is already in its target location, skipping", "source", sourceFilePath)
+		return FileOutcome{TargetPath: finalTargetPath, DateSource: dateSource, SkipReason: AlreadyInPlaceSkipReason}, nil
+	}
+	if errors.Is(err, errExistingSizeMatch) {
+		opts.LoggerOrDefault().Debug("Target of the same size exists, skipping", "source", sourceFilePath, "target", finalTargetPath)
+		return FileOutcome{TargetPath: finalTargetPath, DateSource: dateSource, SkipReason: ExistingSizeMatchSkipReason}, nil
+	}
+	if errors.Is(err, errAlreadyLinked) {
+		opts.LoggerOrDefault().Debug("Source is already linked at its target location, skipping", "source", sourceFilePath, "target", finalTargetPath)
+		return FileOutcome{TargetPath: finalTargetPath, DateSource: dateSource, SkipReason: AlreadyLinkedSkipReason}, nil
+	}
+	if copied && opts.OnCopy != "" {
+		logger := opts.LoggerOrDefault()
+		if output, hookErr := RunOnCopy(opts.OnCopy, sourceFilePath, finalTargetPath); hookErr != nil {
+			logger.Warn("On-copy command failed", "source", sourceFilePath, "target", finalTargetPath, "error", hookErr, "output", string(output))
+		} else {
+			logger.Debug("Ran on-copy command", "source", sourceFilePath, "target", finalTargetPath)
+		}
+	}
+	var sidecars []string
+	if copied && opts.CopySidecars {
+		var sidecarErr error
+		if sidecars, sidecarErr = copySidecars(sourceFilePath, finalTargetPath, newCopyFunc(ctx, opts)); sidecarErr != nil {
+			opts.LoggerOrDefault().Warn("Could not copy sidecar files", "source", sourceFilePath, "target", finalTargetPath, "error", sidecarErr)
+		}
+	}
+	corruptErr := srcHashes.corruptErr
+	if errors.Is(err, ErrCorruptImage) {
+		corruptErr = err
+	}
+	outcome = FileOutcome{Copied: copied, TargetPath: finalTargetPath, Duplicate: dupInfo, UsedFileHash: usedFileHash,
+		PixelHashUnsupported: srcHashes.pixelHashUnsupported() && corruptErr == nil, DateSource: dateSource, Sidecars: sidecars}
+	if corruptErr != nil {
+		outcome.CorruptReason = corruptErr.Error()
+	}
+	if corrected, ok := correctedExtension(sourceFilePath, opts); ok && err == nil {
+		outcome.CorrectedExtension = corrected
+	}
+	return outcome, err
+}
+
+// sortFile handles the logic for processing one image file.
+// It returns whether the file was copied, the path it was copied to (if applicable),
+// any duplicate information, if file hash was used, how the date was determined, and any error.
+// When opts.KnownHashes is non-nil, sources whose file hash is in it are skipped as already archived,
+// and the hashes of copied files are added to it. Files are copied with copyFile.
+// The source's hashes are computed through srcHashes, at most once however many targets it is compared
+// against; its pixel hash is always computed for files that reach the target, so callers can tell
+// from srcHashes whether it is supported.
+func sortFile(currentSourceFilepath string, srcHashes *FileHashes, targetBaseDir string, opts Options, copyFile copyFunc) (copied bool, finalTargetPath string, duplicateInfo *DuplicateInfo, usedFileHash bool, dateSource string, err error) {
+	logger := opts.LoggerOrDefault()
+	logger.Debug("Processing file", "source", currentSourceFilepath)
+	srcHashes.ExifPrefilter = opts.ExifPrefilter
+	srcHashes.FastPixelHash = opts.FastPixelHash
+	srcHashes.ExifSignatureFields = opts.ExifSignatureFields
+	srcHashes.CompareAlgorithm = opts.CompareAlgorithm
+	srcHashes.OpenFiles = opts.OpenFiles
+
+	var sourceHash string
+	if opts.KnownHashes != nil {
+		sourceHash, err = srcHashes.FileHash()
+		if err != nil {
+			return false, "", nil, false, "", err
+		}
+		if opts.KnownHashes[sourceHash] {
+			logger.Debug("Hash is listed in known hashes file, skipping", "source", currentSourceFilepath, "hash", sourceHash, "knownHashes", opts.KnownHashesFile)
+			return false, "", &DuplicateInfo{KeptFile: opts.KnownHashesFile, DiscardedFile: currentSourceFilepath, Reason: ReasonKnownHash, Detail: "already archived"}, false, "", nil
+		}
+		defer func() {
+			if copied {
+				opts.KnownHashes[sourceHash] = true
+			}
+		}()
+	}
+
+	if err := checkExtension(currentSourceFilepath, opts); err != nil {
+		logger.Debug("Content does not match extension, skipping", "source", currentSourceFilepath, "error", err)
+		return false, "", nil, false, "", err
+	}
+
+	// 1.a Determine photoDate and dateSource
+	photoDate, dateSource, err := determinePhotoDateAndDateSource(currentSourceFilepath, opts.DateOverrides, opts.DateStrategy, opts.MinPlausibleYear, opts.OpenFiles, logger)
+	if err != nil {
+		// The error is already logged by determinePhotoDateAndDateSource.
+		// Return the error to be handled by the caller.
+		return false, "", nil, false, "", err
+	}
+
+	// 1.b Determine target path
+	exactTargetPath, err := resolveTargetPath(targetBaseDir, photoDate, dateSource, currentSourceFilepath, opts)
+	if err != nil {
+		return false, "", nil, false, dateSource, err
+	}
+
+	// With SkipExistingTargetFast, an existing target of the same size is trusted before anything is decoded.
+	if opts.SkipExistingTargetFast && !sameFile(currentSourceFilepath, exactTargetPath) && sameSize(currentSourceFilepath, exactTargetPath) {
+		return false, exactTargetPath, nil, false, dateSource, errExistingSizeMatch
+	}
+
+	// Resolutions are compared as displayed, so a rotated original and an already-rotated copy compare equal.
+	currentWidth, currentHeight, errRes := getDisplayResolution(currentSourceFilepath, opts.OpenFiles)
+	if errRes != nil {
+		if opts.QuarantineDir != "" && isCorruptImage(currentSourceFilepath, errRes) {
+			logger.Debug("Image could not be decoded, skipping", "source", currentSourceFilepath, "error", errRes)
+			return false, "", nil, false, dateSource, fmt.Errorf("error decoding image %s: %w: %w", currentSourceFilepath, ErrCorruptImage, errRes)
+		}
+		logger.Debug("Could not get source resolution, proceeding with 0x0", "source", currentSourceFilepath, "error", errRes)
+		currentWidth = 0
+		currentHeight = 0
+		// Not returning an error here as we proceed with 0x0 resolution
+	} else {
+		logger.Debug("Source resolution", "source", currentSourceFilepath, "width", currentWidth, "height", currentHeight)
+	}
+
+	if opts.Hardlink && !opts.Move {
+		if linkedPath, ok := alreadyLinkedPath(currentSourceFilepath, exactTargetPath); ok {
+			return false, linkedPath, nil, false, dateSource, errAlreadyLinked
+		}
+	}
+	if inPlacePath, ok := alreadyAtTargetPath(currentSourceFilepath, exactTargetPath); ok {
+		return false, inPlacePath, nil, false, dateSource, errAlreadyInPlace
+	}
+
+	// Pixel hash support is recorded for every image, not only for those compared with a target, unless
+	// the comparison avoids pixel hashes (see FileHashes.PixelHashUpFront).
+	// Images that cannot be hashed because they are damaged are quarantined, or copied as they are.
+	// With FastPixelHash, the downscaled hash stands in, so the full hash is only computed when needed.
+	if IsImageExtension(currentSourceFilepath) && srcHashes.PixelHashUpFront() {
+		pixelHash := srcHashes.PixelHash
+		if opts.FastPixelHash {
+			pixelHash = srcHashes.DownscaledPixelHash
+		}
+		if _, pixelErr := pixelHash(); pixelErr != nil {
+			logger.Debug("Source cannot be pixel hashed", "source", currentSourceFilepath, "error", pixelErr)
+			if integrityErr := ValidateImageIntegrity(currentSourceFilepath); errors.Is(integrityErr, ErrCorruptImage) {
+				if opts.QuarantineDir != "" {
+					logger.Debug("Image is corrupt, skipping", "source", currentSourceFilepath, "error", integrityErr)
+					return false, "", nil, false, dateSource, integrityErr
+				}
+				logger.Warn("Image is corrupt, copying it as it is", "source", currentSourceFilepath, "error", integrityErr)
+				srcHashes.corruptErr = integrityErr
+			}
+		}
+	}
+
+	// 2. Check if target is empty and copy if so. Concurrent sources with the same target take turns,
+	// so exactly one copies and the others are resolved against its copy. With MaxFilesPerDir, sources
+	// of the same date directory take turns and choose their bucket again under the lock, so that
+	// concurrent sources cannot overfill a bucket that each saw with room.
+	lockPath := exactTargetPath
+	if opts.MaxFilesPerDir > 0 {
+		unbucketed := opts
+		unbucketed.MaxFilesPerDir = 0
+		if lockPath, err = resolveTargetPath(targetBaseDir, photoDate, dateSource, currentSourceFilepath, unbucketed); err != nil {
+			return false, "", nil, false, dateSource, err
+		}
+		lockPath = filepath.Dir(lockPath)
+	}
+	unlock := lockTargetPath(lockPath)
+	defer unlock()
+	if opts.MaxFilesPerDir > 0 {
+		if exactTargetPath, err = resolveTargetPath(targetBaseDir, photoDate, dateSource, currentSourceFilepath, opts); err != nil {
+			return false, "", nil, false, dateSource, err
+		}
+	}
+	wasCopied, copyErr := checkAndCopyIfTargetEmpty(currentSourceFilepath, exactTargetPath, copyFile, logger)
+	if copyErr != nil {
+		// Propagate error from checkAndCopyIfTargetEmpty
+		return false, "", nil, false, dateSource, copyErr
+	}
+	if wasCopied {
+		// File was successfully copied to an empty target path
+		return true, exactTargetPath, nil, false, dateSource, nil
+	}
+
+	// Conflict: File exists at exactTargetPath. Call conflict resolution.
+	copied, finalTargetPath, duplicateInfo, usedFileHash, err = handleTargetConflict(currentSourceFilepath, srcHashes, exactTargetPath, currentWidth, currentHeight, opts.ConflictStrategy, opts.PreferNewer, opts.PreferLargerFile, opts.NoOverwrite, opts.HistogramThreshold, copyFile, logger)
+	return copied, finalTargetPath, duplicateInfo, usedFileHash, dateSource, err
+}
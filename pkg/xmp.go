@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DateSourceXMP is the date source of files dated by their XMP sidecar (see GetDateFromXMP).
+const DateSourceXMP = "XMP"
+
+// ErrNoXMPDate is returned by GetDateFromXMP when a file has no sidecar or its sidecar has no date.
+var ErrNoXMPDate = errors.New("no XMP sidecar date")
+
+// xmpDatePattern matches the xmp:CreateDate and photoshop:DateCreated properties of an XMP packet,
+// written either as attributes (xmp:CreateDate="...") or as elements (<xmp:CreateDate>...</xmp:CreateDate>).
+var xmpDatePattern = regexp.MustCompile(`(xmp:CreateDate|photoshop:DateCreated)\s*(?:=\s*["']([^"']*)["']|>\s*([^<]*?)\s*<)`)
+
+// xmpDateLayouts are the forms of XMP dates, which are ISO 8601 dates with optional time, fractional
+// seconds and time zone.
+var xmpDateLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// xmpSidecarPath returns the path of the sidecar of imagePath: a file with the same base name and an
+// .xmp extension (IMG_0001.xmp), or with .xmp appended (IMG_0001.CR2.xmp, as written by e.g. darktable).
+// The extension may be upper case. ok is false if there is no sidecar.
+func xmpSidecarPath(imagePath string) (sidecarPath string, ok bool) {
+	base := strings.TrimSuffix(imagePath, filepath.Ext(imagePath))
+	for _, candidate := range []string{base + ".xmp", base + ".XMP", imagePath + ".xmp", imagePath + ".XMP"} {
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// GetDateFromXMP returns the date recorded in the XMP sidecar of imagePath, as a UTC wall-clock time
+// like EXIF dates: its xmp:CreateDate or, if that is missing, its photoshop:DateCreated. Time zones in
+// the sidecar are ignored, and a date that cannot be parsed is skipped. ErrNoXMPDate is returned if there
+// is no sidecar or it contains neither date.
+func GetDateFromXMP(imagePath string) (time.Time, error) {
+	sidecarPath, ok := xmpSidecarPath(imagePath)
+	if !ok {
+		return time.Time{}, ErrNoXMPDate
+	}
+	content, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read XMP sidecar %s: %w", sidecarPath, err)
+	}
+
+	values := make(map[string]string)
+	for _, m := range xmpDatePattern.FindAllStringSubmatch(string(content), -1) {
+		if _, seen := values[m[1]]; !seen {
+			values[m[1]] = m[2] + m[3]
+		}
+	}
+	parseErr := ErrNoXMPDate
+	for _, property := range []string{"xmp:CreateDate", "photoshop:DateCreated"} {
+		value, found := values[property]
+		if !found {
+			continue
+		}
+		for _, layout := range xmpDateLayouts {
+			if date, err := time.Parse(layout, value); err == nil {
+				return time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), 0, time.UTC), nil
+			}
+		}
+		parseErr = fmt.Errorf("invalid %s '%s' in XMP sidecar %s", property, value, sidecarPath)
+	}
+	return time.Time{}, parseErr
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IsZipSource reports whether path is a regular file with a .zip extension, to be imported as an archive.
+func IsZipSource(path string) bool {
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// ScanZipSource returns the sorted names of the image entries in the ZIP archive at zipPath.
+// Names use "/" separators, as in the archive. Entries whose names would escape the archive root
+// and macOS resource fork entries (below "__MACOSX/") are ignored.
+func ScanZipSource(zipPath string) ([]string, error) {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ZIP archive '%s': %w", zipPath, err)
+	}
+	defer archive.Close()
+
+	imageEntries := []string{}
+	for _, entry := range archive.File {
+		if isZipImageEntry(entry) {
+			imageEntries = append(imageEntries, entry.Name)
+		}
+	}
+	sort.Strings(imageEntries)
+	return imageEntries, nil
+}
+
+// isZipImageEntry reports whether entry is an image file that can safely be extracted.
+func isZipImageEntry(entry *zip.File) bool {
+	if entry.FileInfo().IsDir() || !filepath.IsLocal(filepath.FromSlash(entry.Name)) {
+		return false
+	}
+	if strings.HasPrefix(entry.Name, "__MACOSX/") {
+		return false
+	}
+	return IsImageExtension(entry.Name)
+}
+
+// ExtractZipEntry streams entry to destPath and gives the file the entry's modification time,
+// so it can be sorted like any other source file. A corrupt entry fails its checksum and is not written.
+func ExtractZipEntry(entry *zip.File, destPath string) error {
+	reader, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open ZIP entry %s: %w", entry.Name, err)
+	}
+	defer reader.Close()
+	modTime := entry.Modified
+	return copyReader(reader, entry.Name, destPath, nil, modTime, modTime, defaultFileModes)
+}
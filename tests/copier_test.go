@@ -1,11 +1,19 @@
 package tests
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/user/photo-sorter/pkg"
 )
@@ -98,3 +106,381 @@ func TestCopyFile(t *testing.T) {
 		})
 	}
 }
+
+func TestQuarantineFile(t *testing.T) {
+	sourceDir := t.TempDir()
+	quarantineDir := t.TempDir()
+
+	srcFilePath := filepath.Join(sourceDir, "event", "broken.jpg")
+	if err := os.MkdirAll(filepath.Dir(srcFilePath), 0755); err != nil {
+		t.Fatalf("Failed to create source subdirectory: %v", err)
+	}
+	if err := os.WriteFile(srcFilePath, []byte("broken"), 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	quarantinePath, err := pkg.QuarantineFile(srcFilePath, sourceDir, quarantineDir)
+	if err != nil {
+		t.Fatalf("QuarantineFile() unexpected error: %v", err)
+	}
+
+	expectedPath := filepath.Join(quarantineDir, "event", "broken.jpg")
+	if quarantinePath != expectedPath {
+		t.Errorf("QuarantineFile() path = %s, want %s", quarantinePath, expectedPath)
+	}
+	if _, statErr := os.Stat(expectedPath); statErr != nil {
+		t.Errorf("QuarantineFile() did not create %s: %v", expectedPath, statErr)
+	}
+	if _, statErr := os.Stat(srcFilePath); statErr != nil {
+		t.Errorf("QuarantineFile() should leave the source file in place: %v", statErr)
+	}
+}
+
+// TestCopyFileBuffer tests that copying a multi-megabyte file through small and large
+// reusable buffers produces identical content.
+func TestCopyFileBuffer(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := make([]byte, 3<<20+123)
+	for i := range content {
+		content[i] = byte(i * 31)
+	}
+	srcPath := filepath.Join(tmpDir, "large.bin")
+	if err := os.WriteFile(srcPath, content, 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	for _, size := range []int{512, 4 << 20} {
+		buf := make([]byte, size)
+		destPath := filepath.Join(tmpDir, "out", "copy.bin")
+		// Copy twice with the same buffer to make sure it can be reused.
+		for i := 0; i < 2; i++ {
+			if err := pkg.CopyFileBuffer(srcPath, destPath, buf); err != nil {
+				t.Fatalf("CopyFileBuffer() with %d byte buffer error = %v", size, err)
+			}
+			copied, err := os.ReadFile(destPath)
+			if err != nil {
+				t.Fatalf("Failed to read copied file: %v", err)
+			}
+			if !bytes.Equal(copied, content) {
+				t.Errorf("CopyFileBuffer() with %d byte buffer produced different content", size)
+			}
+		}
+	}
+}
+
+// TestParseByteSize tests parsing of human-readable buffer sizes.
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"65536", 65536, false},
+		{"512k", 512 << 10, false},
+		{"4m", 4 << 20, false},
+		{"4M", 4 << 20, false},
+		{"4MB", 4 << 20, false},
+		{"1MiB", 1 << 20, false},
+		{"1g", 1 << 30, false},
+		{"", 0, true},
+		{"m", 0, true},
+		{"-4m", 0, true},
+		{"four", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := pkg.ParseByteSize(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseByteSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestCopyFilePreservingTimes tests that the copy gets the source's modification time,
+// while a plain copy gets the current time.
+func TestCopyFilePreservingTimes(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcPath := filepath.Join(tmpDir, "old.jpg")
+	if err := os.WriteFile(srcPath, []byte("old photo"), 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+	past := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(srcPath, past, past); err != nil {
+		t.Fatalf("Failed to set source times: %v", err)
+	}
+
+	preservedPath := filepath.Join(tmpDir, "out", "preserved.jpg")
+	if err := pkg.CopyFilePreservingTimes(srcPath, preservedPath, nil); err != nil {
+		t.Fatalf("CopyFilePreservingTimes() error = %v", err)
+	}
+	info, err := os.Stat(preservedPath)
+	if err != nil {
+		t.Fatalf("Failed to stat copy: %v", err)
+	}
+	if diff := info.ModTime().Sub(past); diff < -time.Second || diff > time.Second {
+		t.Errorf("CopyFilePreservingTimes() mtime = %v, want %v", info.ModTime(), past)
+	}
+
+	plainPath := filepath.Join(tmpDir, "out", "plain.jpg")
+	if err := pkg.CopyFile(srcPath, plainPath); err != nil {
+		t.Fatalf("CopyFile() error = %v", err)
+	}
+	info, err = os.Stat(plainPath)
+	if err != nil {
+		t.Fatalf("Failed to stat copy: %v", err)
+	}
+	if time.Since(info.ModTime()) > time.Hour {
+		t.Errorf("CopyFile() mtime = %v, expected the time of the copy", info.ModTime())
+	}
+}
+
+// flakyWriter writes part of its input and then fails with an I/O error for its first failures writes.
+type flakyWriter struct {
+	w        io.Writer
+	failures int
+	writes   int
+}
+
+func (fw *flakyWriter) Write(p []byte) (int, error) {
+	fw.writes++
+	if fw.writes <= fw.failures {
+		n, _ := fw.w.Write(p[:len(p)/2])
+		return n, syscall.EIO
+	}
+	return fw.w.Write(p)
+}
+
+func TestRetryCopy(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := []byte("content copied to a flaky network share")
+	destPath := filepath.Join(tmpDir, "dest.txt")
+	writer := &flakyWriter{failures: 2}
+	attempts := 0
+	copyThroughWriter := func() error {
+		attempts++
+		dest, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		defer dest.Close()
+		writer.w = dest
+		_, err = writer.Write(content)
+		return err
+	}
+
+	if err := pkg.RetryCopy(destPath, 3, time.Millisecond, copyThroughWriter); err != nil {
+		t.Fatalf("RetryCopy() error = %v, want success after retries", err)
+	}
+	if attempts != 3 {
+		t.Errorf("RetryCopy() made %d attempts, want 3", attempts)
+	}
+	if got, _ := os.ReadFile(destPath); !bytes.Equal(got, content) {
+		t.Errorf("destination content = %q, want %q", got, content)
+	}
+
+	// Running out of retries returns the last error and leaves no partial destination.
+	os.Remove(destPath)
+	writer.writes, attempts = 0, 0
+	if err := pkg.RetryCopy(destPath, 1, time.Millisecond, copyThroughWriter); !errors.Is(err, syscall.EIO) {
+		t.Errorf("RetryCopy() error = %v, want EIO", err)
+	}
+	if attempts != 2 {
+		t.Errorf("RetryCopy() made %d attempts, want 2", attempts)
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("partial destination left behind: %v", err)
+	}
+}
+
+func TestMoveFile_RenameFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	srcPath := filepath.Join(sourceDir, "photo.jpg")
+	if err := os.MkdirAll(sourceDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(srcPath, []byte("photo content"), 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+	if err := os.Chmod(sourceDir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(sourceDir, 0755) })
+	// Permissions are not enforced for root or on some platforms, so check that they apply here.
+	if f, err := os.CreateTemp(sourceDir, "probe"); err == nil {
+		f.Close()
+		os.Remove(f.Name())
+		t.Skip("read-only directories cannot be simulated with chmod on this system")
+	}
+
+	destPath := filepath.Join(tmpDir, "target", "photo.jpg")
+	if err := pkg.MoveFile(srcPath, destPath); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("MoveFile() error = %v, want a permission error", err)
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("MoveFile() left a copy at the destination: %v", err)
+	}
+	if _, err := os.Stat(srcPath); err != nil {
+		t.Errorf("MoveFile() lost the source: %v", err)
+	}
+}
+
+func TestLinkFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcPath := filepath.Join(tmpDir, "source.jpg")
+	content := []byte("photo content")
+	if err := os.WriteFile(srcPath, content, 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		t.Fatalf("Failed to stat source file: %v", err)
+	}
+
+	t.Run("same filesystem", func(t *testing.T) {
+		destPath := filepath.Join(tmpDir, "target", "2023", "linked.jpg")
+		if err := pkg.LinkFile(srcPath, destPath); err != nil {
+			t.Fatalf("LinkFile() error = %v", err)
+		}
+		destInfo, err := os.Stat(destPath)
+		if err != nil {
+			t.Fatalf("Failed to stat destination file: %v", err)
+		}
+		if !os.SameFile(srcInfo, destInfo) {
+			t.Errorf("LinkFile() destination is not a hard link to the source")
+		}
+	})
+
+	t.Run("existing destination falls back to a copy", func(t *testing.T) {
+		destPath := filepath.Join(tmpDir, "existing.jpg")
+		if err := os.WriteFile(destPath, []byte("old content"), 0644); err != nil {
+			t.Fatalf("Failed to create destination file: %v", err)
+		}
+		if err := pkg.LinkFile(srcPath, destPath); err != nil {
+			t.Fatalf("LinkFile() error = %v", err)
+		}
+		destInfo, err := os.Stat(destPath)
+		if err != nil {
+			t.Fatalf("Failed to stat destination file: %v", err)
+		}
+		if os.SameFile(srcInfo, destInfo) {
+			t.Errorf("LinkFile() linked over an existing destination, want a copy")
+		}
+		if got, _ := os.ReadFile(destPath); !bytes.Equal(got, content) {
+			t.Errorf("LinkFile() destination content = %q, want %q", got, content)
+		}
+	})
+
+	t.Run("other filesystem falls back to a copy", func(t *testing.T) {
+		otherDir, err := os.MkdirTemp("/dev/shm", "linkfile-test-")
+		if err != nil {
+			t.Skipf("No second filesystem available: %v", err)
+		}
+		defer os.RemoveAll(otherDir)
+		probe := filepath.Join(otherDir, "probe.jpg")
+		if os.Link(srcPath, probe) == nil {
+			t.Skip("/dev/shm is on the same filesystem as the temporary directory")
+		}
+		destPath := filepath.Join(otherDir, "2023", "copied.jpg")
+		if err := pkg.LinkFile(srcPath, destPath); err != nil {
+			t.Fatalf("LinkFile() error = %v", err)
+		}
+		if got, _ := os.ReadFile(destPath); !bytes.Equal(got, content) {
+			t.Errorf("LinkFile() destination content = %q, want %q", got, content)
+		}
+	})
+
+	t.Run("other link failures are returned", func(t *testing.T) {
+		// Directories cannot be hard linked, which stands in for a filesystem without hard links.
+		destPath := filepath.Join(tmpDir, "linked-dir")
+		if err := pkg.LinkFile(t.TempDir(), destPath); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("LinkFile() of a directory error = %v, want the permission error of the link", err)
+		}
+	})
+}
+
+func TestCopyFileWithRetry_NotTransient(t *testing.T) {
+	tmpDir := t.TempDir()
+	destPath := filepath.Join(tmpDir, "dest.txt")
+	start := time.Now()
+	err := pkg.CopyFileWithRetry(filepath.Join(tmpDir, "missing.txt"), destPath, nil, 3, time.Second)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("CopyFileWithRetry() error = %v, want a not-exist error", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("CopyFileWithRetry() retried a missing source (took %v)", elapsed)
+	}
+
+	srcPath := filepath.Join(tmpDir, "src.txt")
+	if err := os.WriteFile(srcPath, []byte("photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.CopyFileWithRetry(srcPath, destPath, nil, 3, time.Millisecond); err != nil {
+		t.Fatalf("CopyFileWithRetry() error = %v", err)
+	}
+	if got, _ := os.ReadFile(destPath); string(got) != "photo" {
+		t.Errorf("destination content = %q, want %q", got, "photo")
+	}
+}
+
+func TestOpenFileLimiter_ConcurrentCalls(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 10; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%02d.png", i))
+		if err := os.WriteFile(path, pngMinimal_2x2_A, 0644); err != nil {
+			t.Fatalf("writing %s: %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+	files := pkg.NewOpenFileLimiter(1)
+
+	// Every call waits for the single open file, including copies and rotations, which need two at once.
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*len(paths))
+	for _, path := range paths {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			hashes := &pkg.FileHashes{Path: path, OpenFiles: files}
+			hashes.Precompute()
+			_, err := hashes.PixelHash()
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := pkg.SortFile(path, t.TempDir(), pkg.Options{OpenFiles: files})
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := pkg.SortFile(path, t.TempDir(), pkg.Options{OpenFiles: files, AutoRotate: true})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("call with a limit of one open file failed: %v", err)
+		}
+	}
+}
+
+func TestIsTooManyOpenFiles(t *testing.T) {
+	for _, err := range []error{
+		&os.PathError{Op: "open", Path: "a.png", Err: syscall.EMFILE},
+		fmt.Errorf("failed to open a.png: %w", &os.PathError{Op: "open", Path: "a.png", Err: syscall.ENFILE}),
+		fmt.Errorf("hashing: %w", pkg.ErrTooManyOpenFiles),
+	} {
+		if !pkg.IsTooManyOpenFiles(err) {
+			t.Errorf("IsTooManyOpenFiles(%v) = false, want true", err)
+		}
+	}
+	if pkg.IsTooManyOpenFiles(&os.PathError{Op: "open", Path: "a.png", Err: syscall.ENOENT}) {
+		t.Errorf("IsTooManyOpenFiles() = true for a missing file")
+	}
+}
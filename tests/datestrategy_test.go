@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+func TestDateFromFilename(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   time.Time
+		wantOK bool
+	}{
+		{"IMG_20200505_050505.jpg", time.Date(2020, 5, 5, 5, 5, 5, 0, time.UTC), true},
+		{"PXL_20200505.jpg", time.Date(2020, 5, 5, 0, 0, 0, 0, time.UTC), true},
+		{"2020-05-05 05.05.05.jpg", time.Date(2020, 5, 5, 5, 5, 5, 0, time.UTC), true},
+		{"2023-10-27-153000-1.jpg", time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC), true},
+		{"IMG_20201350.jpg", time.Time{}, false}, // Month 13
+		{"DSC01234.jpg", time.Time{}, false},
+		{"120200505.jpg", time.Time{}, false}, // Part of a longer number
+	}
+	for _, tt := range tests {
+		got, ok := pkg.DateFromFilename(filepath.Join("dir", tt.name))
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("DateFromFilename(%q) = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestGetDateFromXMP(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		sidecar string // Name of the sidecar of "photo.CR2"; empty for none
+		xmp     string
+		want    time.Time
+		wantErr bool
+	}{
+		{"attribute", "photo.xmp", `<rdf:Description xmp:CreateDate="2019-07-14T18:30:05.25+02:00" photoshop:DateCreated="2001-01-01"/>`, time.Date(2019, 7, 14, 18, 30, 5, 0, time.UTC), false},
+		{"element", "photo.CR2.xmp", `<rdf:Description><photoshop:DateCreated>2018-03-04T05:06:07</photoshop:DateCreated></rdf:Description>`, time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC), false},
+		{"date only", "photo.XMP", `<rdf:Description xmp:CreateDate='2017-12-31'/>`, time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC), false},
+		{"invalid create date", "photo.xmp", `<rdf:Description xmp:CreateDate="yesterday" photoshop:DateCreated="2016-02-29T10:00Z"/>`, time.Date(2016, 2, 29, 10, 0, 0, 0, time.UTC), false},
+		{"no date", "photo.xmp", `<rdf:Description xmp:Rating="5"/>`, time.Time{}, true},
+		{"no sidecar", "", "", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caseDir := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_"))
+			files := map[string][]byte{"photo.CR2": []byte("raw data")}
+			if tt.sidecar != "" {
+				files[tt.sidecar] = []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + tt.xmp + `</x:xmpmeta>`)
+			}
+			createScanTestDir(t, caseDir, files)
+
+			got, err := pkg.GetDateFromXMP(filepath.Join(caseDir, "photo.CR2"))
+			if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+				t.Errorf("GetDateFromXMP() = %v, %v, want %v (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolvePhotoDate(t *testing.T) {
+	sourceDir, _ := setupTestDirs(t)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	fillImage(img, color.RGBA{G: 255, A: 255})
+	earlyExif := jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2000:01:01 00:00:00"}}})
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "IMG_20200505_050505.jpg", Content: earlyExif, ModTime: sortFileTime},
+		{Path: "IMG_20200505_050505.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+	})
+	withExif := filepath.Join(sourceDir, "IMG_20200505_050505.jpg")
+	withoutExif := filepath.Join(sourceDir, "IMG_20200505_050505.png")
+	exifDate := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	filenameDate := time.Date(2020, 5, 5, 5, 5, 5, 0, time.UTC)
+
+	tests := []struct {
+		path       string
+		strategy   pkg.DateStrategy
+		want       time.Time
+		wantSource string
+	}{
+		{withExif, "", exifDate, "EXIF " + pkg.DateTagDateTimeOriginal},
+		{withExif, pkg.DateStrategyExifFirst, exifDate, "EXIF " + pkg.DateTagDateTimeOriginal},
+		{withExif, pkg.DateStrategyFilenameFirst, filenameDate, pkg.DateSourceFilename},
+		{withExif, pkg.DateStrategyEarliest, exifDate, "EXIF " + pkg.DateTagDateTimeOriginal},
+		{withExif, pkg.DateStrategyLatest, sortFileTime, pkg.DateSourceFileModTime},
+		{withoutExif, pkg.DateStrategyExifFirst, sortFileTime, pkg.DateSourceFileModTime},
+		{withoutExif, pkg.DateStrategyFilenameFirst, filenameDate, pkg.DateSourceFilename},
+		{withoutExif, pkg.DateStrategyEarliest, filenameDate, pkg.DateSourceFilename},
+	}
+	for _, tt := range tests {
+		got, source, err := pkg.ResolvePhotoDate(tt.path, tt.strategy)
+		if err != nil {
+			t.Errorf("ResolvePhotoDate(%s, %q) error = %v", filepath.Base(tt.path), tt.strategy, err)
+			continue
+		}
+		if !got.Equal(tt.want) || source != tt.wantSource {
+			t.Errorf("ResolvePhotoDate(%s, %q) = %v from %q, want %v from %q", filepath.Base(tt.path), tt.strategy, got, source, tt.want, tt.wantSource)
+		}
+	}
+
+	if _, _, err := pkg.ResolvePhotoDate(withExif, "newest"); err == nil {
+		t.Error("ResolvePhotoDate() with an unknown strategy succeeded, want an error")
+	}
+}
+
+// TestResolvePhotoDate_EarliestAcrossTimeZones tests that an EXIF date without offset is compared with
+// the modification time as a local time, in a local time zone east of UTC.
+func TestResolvePhotoDate_EarliestAcrossTimeZones(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	defer func() { time.Local = local }()
+
+	sourceDir, _ := setupTestDirs(t)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	fillImage(img, color.RGBA{G: 255, A: 255})
+	content := jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2023:10:27 12:00:00"}}})
+	// 12:30 on the local clock, but 10:30 UTC, before the EXIF date's instant.
+	modTime := time.Date(2023, 10, 27, 12, 30, 0, 0, time.Local)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "photo.jpg", Content: content, ModTime: modTime}})
+	path := filepath.Join(sourceDir, "photo.jpg")
+
+	got, source, err := pkg.ResolvePhotoDate(path, pkg.DateStrategyEarliest)
+	if err != nil || source != "EXIF "+pkg.DateTagDateTimeOriginal || got.Hour() != 12 || got.Minute() != 0 {
+		t.Errorf("ResolvePhotoDate(earliest) = %v from %q (error %v), want 12:00 from the EXIF date", got, source, err)
+	}
+	got, source, err = pkg.ResolvePhotoDate(path, pkg.DateStrategyLatest)
+	if err != nil || source != pkg.DateSourceFileModTime || got.Hour() != 12 || got.Minute() != 30 {
+		t.Errorf("ResolvePhotoDate(latest) = %v from %q (error %v), want 12:30 from the modification time", got, source, err)
+	}
+}
+
+func TestResolvePhotoDate_ImplausibleExifDate(t *testing.T) {
+	sourceDir, _ := setupTestDirs(t)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	future := time.Now().AddDate(2, 0, 0).Format("2006:01:02 15:04:05")
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "reset.jpg", Content: jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "1970:01:01 00:00:00"}}}), ModTime: sortFileTime},
+		{Path: "future.jpg", Content: jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: future}}}), ModTime: sortFileTime},
+		{Path: "IMG_20200505_050505.jpg", Content: jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "1970:01:01 00:00:00"}}}), ModTime: sortFileTime},
+	})
+	filenameDate := time.Date(2020, 5, 5, 5, 5, 5, 0, time.UTC)
+
+	tests := []struct {
+		file       string
+		strategy   pkg.DateStrategy
+		minYear    int
+		want       time.Time
+		wantSource string
+	}{
+		{"reset.jpg", pkg.DateStrategyExifFirst, 0, sortFileTime, pkg.DateSourceFileModTime},
+		{"future.jpg", pkg.DateStrategyExifFirst, 0, sortFileTime, pkg.DateSourceFileModTime},
+		{"IMG_20200505_050505.jpg", pkg.DateStrategyEarliest, 0, filenameDate, pkg.DateSourceFilename},
+		{"reset.jpg", pkg.DateStrategyExifFirst, 1960, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), "EXIF " + pkg.DateTagDateTimeOriginal},
+	}
+	for _, tt := range tests {
+		got, source, err := pkg.ResolvePhotoDateWithMinYear(filepath.Join(sourceDir, tt.file), tt.strategy, tt.minYear)
+		if err != nil || !got.Equal(tt.want) || source != tt.wantSource {
+			t.Errorf("ResolvePhotoDateWithMinYear(%s, %q, %d) = %v from %q, %v, want %v from %q", tt.file, tt.strategy, tt.minYear, got, source, err, tt.want, tt.wantSource)
+		}
+	}
+
+	if pkg.IsPlausibleDate(time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC), 0) || !pkg.IsPlausibleDate(time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC), 0) {
+		t.Error("IsPlausibleDate() does not start at DefaultMinPlausibleYear")
+	}
+	if err := (pkg.Options{MinPlausibleYear: -1}).Validate(); err == nil {
+		t.Error("Validate() accepted a negative MinPlausibleYear")
+	}
+}
@@ -2,13 +2,20 @@ package tests
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"image"
 	"image/color"
+	"image/gif"
 	"image/png"
+	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
+	"os"
 	"path/filepath"
 	// "strings" // No longer directly used in this file after test adjustments
+	"sync/atomic"
 	"testing"
 	// "time"    // No longer directly used in this file after test adjustments
 
@@ -167,7 +174,7 @@ func TestAreFilesPotentiallyDuplicate_HEIF_Identical(t *testing.T) {
 
 	// Check reason: Could be PixelHashMatch (if heif-go decodes them identically)
 	// or FileHashMatch (if pixel hash fails and it falls back to file hash).
-	expectedReasons := []string{pkg.ReasonPixelHashMatch, pkg.ReasonFileHashMatch}
+	expectedReasons := []pkg.Reason{pkg.ReasonPixelHashMatch, pkg.ReasonFileHashMatch}
 	assert.Contains(t, expectedReasons, res.Reason, "Duplicate reason should be PixelHashMatch or FileHashMatch")
 
 	if res.Reason == pkg.ReasonPixelHashMatch {
@@ -344,11 +351,34 @@ func TestAreFilesPotentiallyDuplicate_ZeroByteFiles(t *testing.T) {
 	assert.True(t, res.AreDuplicates, "Two zero-byte files should be duplicates")
 	assert.Equal(t, pkg.ReasonFileHashMatch, res.Reason) // Current logic uses FileHashMatch
 
-	// One zero, one not (non-image path, should be size mismatch)
+	// One zero, one not, in either order
 	res, err = pkg.AreFilesPotentiallyDuplicate(f1Path, f3Path)
 	require.NoError(t, err)
-	assert.False(t, res.AreDuplicates, "Zero-byte and non-zero-byte should be size mismatch")
-	assert.Equal(t, pkg.ReasonSizeMismatch, res.Reason)
+	assert.False(t, res.AreDuplicates, "Zero-byte and non-zero-byte files should not be duplicates")
+	assert.Equal(t, pkg.ReasonZeroByteSource, res.Reason)
+	res, err = pkg.AreFilesPotentiallyDuplicate(f3Path, f1Path)
+	require.NoError(t, err)
+	assert.False(t, res.AreDuplicates)
+	assert.Equal(t, pkg.ReasonZeroByteSource, res.Reason)
+}
+
+// TestAreFilesPotentiallyDuplicate_ZeroByteImage tests that an empty image is not compared
+// against a real image, while two empty images are still duplicates.
+func TestAreFilesPotentiallyDuplicate_ZeroByteImage(t *testing.T) {
+	dir := t.TempDir()
+	emptyPath := createTempFile(t, dir, "empty.png", []byte{})
+	otherEmptyPath := createTempFile(t, dir, "empty2.png", []byte{})
+	imagePath := createTempFile(t, dir, "red.png", duplicates_pngMinimal_2x2_Red)
+
+	res, err := pkg.AreFilesPotentiallyDuplicate(emptyPath, imagePath)
+	require.NoError(t, err)
+	assert.False(t, res.AreDuplicates)
+	assert.Equal(t, pkg.ReasonZeroByteSource, res.Reason)
+
+	res, err = pkg.AreFilesPotentiallyDuplicate(emptyPath, otherEmptyPath)
+	require.NoError(t, err)
+	assert.True(t, res.AreDuplicates)
+	assert.Equal(t, pkg.ReasonFileHashMatch, res.Reason)
 }
 
 // TestAreFilesPotentiallyDuplicate_ImageAndNonImage_SameSize_DifferentContent
@@ -371,3 +401,634 @@ func TestAreFilesPotentiallyDuplicate_ImageAndNonImage_SameSize_DifferentContent
 	assert.Equal(t, pkg.ReasonFileHashMismatch, res.Reason)
 	assert.Equal(t, pkg.HashTypeFile, res.HashType)
 }
+
+func TestGetDisplayResolution_Orientation(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4)) // Stored as landscape 8x4
+	duplicates_fillImageForTest(img, color.RGBA{G: 255, A: 255})
+
+	tests := []struct {
+		name           string
+		orientation    uint16
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"orientation 1 (normal)", 1, 8, 4},
+		{"orientation 3 (180 degrees)", 3, 8, 4},
+		{"orientation 6 (90 degrees CW)", 6, 4, 8},
+		{"orientation 8 (90 degrees CCW)", 8, 4, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := jpegWithExif(t, img, exifSpec{IFD0: []exifTag{{ID: exifTagOrientation, Value: tt.orientation}}})
+			path := createTempFile(t, dir, "oriented.jpg", content)
+
+			rawWidth, rawHeight, err := pkg.GetImageResolution(path)
+			require.NoError(t, err)
+			assert.Equal(t, 8, rawWidth, "GetImageResolution should return raw stored width")
+			assert.Equal(t, 4, rawHeight, "GetImageResolution should return raw stored height")
+
+			width, height, err := pkg.GetDisplayResolution(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedWidth, width)
+			assert.Equal(t, tt.expectedHeight, height)
+		})
+	}
+
+	t.Run("no EXIF", func(t *testing.T) {
+		pngBytes, err := duplicates_encodePNGForTest(img)
+		require.NoError(t, err)
+		path := createTempFile(t, dir, "plain.png", pngBytes)
+
+		width, height, err := pkg.GetDisplayResolution(path)
+		require.NoError(t, err)
+		assert.Equal(t, 8, width)
+		assert.Equal(t, 4, height)
+	})
+}
+
+// TestLoadAndAppendKnownHashes tests reading a hash list (including sha256sum-style lines and
+// comments) and appending to it without touching the existing lines.
+func TestLoadAndAppendKnownHashes(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := "# archive hashes\nBBBB\n\naaaa  ./2020/01/photo.jpg\n"
+	path := createTempFile(t, tmpDir, "hashes.txt", []byte(content))
+
+	hashes, err := pkg.LoadKnownHashes(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"aaaa": true, "bbbb": true}, hashes)
+
+	require.NoError(t, pkg.AppendKnownHashes(path, []string{"cccc", "dddd"}))
+	written, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, content+"cccc\ndddd\n", string(written), "existing lines must be kept byte for byte")
+
+	unterminated := createTempFile(t, tmpDir, "unterminated.txt", []byte("aaaa  ./photo.jpg"))
+	require.NoError(t, pkg.AppendKnownHashes(unterminated, []string{"cccc"}))
+	written, err = ioutil.ReadFile(unterminated)
+	require.NoError(t, err)
+	assert.Equal(t, "aaaa  ./photo.jpg\ncccc\n", string(written))
+
+	newPath := filepath.Join(tmpDir, "new.txt")
+	require.NoError(t, pkg.AppendKnownHashes(newPath, []string{"cccc"}))
+	written, err = ioutil.ReadFile(newPath)
+	require.NoError(t, err)
+	assert.Equal(t, "cccc\n", string(written))
+
+	_, err = pkg.LoadKnownHashes(filepath.Join(tmpDir, "missing.txt"))
+	assert.Error(t, err)
+}
+
+// TestDeduplicateDirectory tests that identical files and pixel-identical re-encodings are grouped,
+// that the preferred copy is kept, and that duplicates are only deleted when requested.
+func TestDeduplicateDirectory(t *testing.T) {
+	for _, remove := range []bool{false, true} {
+		tmpDir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "sub"), 0755))
+		identicalA := createTempFile(t, tmpDir, "a.png", duplicates_pngMinimal_2x2_Red)
+		identicalB := createTempFile(t, filepath.Join(tmpDir, "sub"), "b.png", duplicates_pngMinimal_2x2_Red)
+
+		// A higher-resolution copy of the same content is a distinct image and must be left alone.
+		higherRes := createTempFile(t, tmpDir, "c_large.png", duplicates_pngMinimal_4x4_Red(t))
+
+		// The same pixels stored uncompressed: a larger file with identical pixel data wins the tie.
+		img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+		duplicates_fillImageForTest(img, color.RGBA{G: 255, A: 255})
+		var compressed, uncompressed bytes.Buffer
+		require.NoError(t, png.Encode(&compressed, img))
+		require.NoError(t, (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&uncompressed, img))
+		require.Greater(t, uncompressed.Len(), compressed.Len())
+		small := createTempFile(t, tmpDir, "d_small.png", compressed.Bytes())
+		large := createTempFile(t, tmpDir, "e_uncompressed.png", uncompressed.Bytes())
+		createTempFile(t, tmpDir, "notes.txt", duplicates_pngMinimal_2x2_Red) // Not an image extension, ignored
+
+		duplicates, err := pkg.DeduplicateDirectory(tmpDir, remove)
+		require.NoError(t, err)
+
+		assert.ElementsMatch(t, []pkg.DuplicateInfo{
+			{KeptFile: identicalA, DiscardedFile: identicalB, Reason: pkg.ReasonFileHashMatch, Detail: "identical file kept"},
+			{KeptFile: large, DiscardedFile: small, Reason: pkg.ReasonPixelHashMatch, Detail: "highest resolution kept"},
+		}, duplicates)
+
+		assert.FileExists(t, identicalA)
+		assert.FileExists(t, higherRes)
+		assert.FileExists(t, large)
+		for _, discarded := range []string{identicalB, small} {
+			_, statErr := os.Stat(discarded)
+			assert.Equal(t, remove, os.IsNotExist(statErr), "remove=%v: unexpected existence of %s", remove, discarded)
+		}
+	}
+}
+
+// duplicates_pngMinimal_4x4_Red returns a 4x4 red PNG, the higher-resolution version of duplicates_pngMinimal_2x2_Red.
+func duplicates_pngMinimal_4x4_Red(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	duplicates_fillImageForTest(img, color.RGBA{R: 255, A: 255})
+	data, err := duplicates_encodePNGForTest(img)
+	require.NoError(t, err)
+	return data
+}
+
+func TestImagePixelHash_CMYK(t *testing.T) {
+	cmyk := image.NewCMYK(image.Rect(0, 0, 3, 2))
+	for i := range cmyk.Pix {
+		cmyk.Pix[i] = uint8(i * 37)
+	}
+
+	hash := pkg.ImagePixelHash(cmyk)
+	assert.Equal(t, hash, pkg.ImagePixelHash(cmyk), "Hash should be repeatable")
+
+	// The same colors as an RGBA image must hash identically.
+	rgba := image.NewRGBA(cmyk.Bounds())
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			c := cmyk.CMYKAt(x, y)
+			r, g, b := color.CMYKToRGB(c.C, c.M, c.Y, c.K)
+			rgba.SetRGBA(x, y, color.RGBA{R: r, G: g, B: b, A: 255})
+		}
+	}
+	assert.Equal(t, hash, pkg.ImagePixelHash(rgba))
+}
+
+func TestImagePixelHash_OffsetBoundsAndPaletted(t *testing.T) {
+	palette := color.Palette{color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	paletted := image.NewPaletted(image.Rect(5, 5, 7, 6), palette)
+	paletted.SetColorIndex(6, 5, 1)
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	nrgba.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	nrgba.SetNRGBA(1, 0, color.NRGBA{B: 255, A: 255})
+
+	assert.Equal(t, pkg.ImagePixelHash(nrgba), pkg.ImagePixelHash(paletted))
+
+	paletted.Pix[0] = 7 // Out of range index must not panic
+	assert.NotPanics(t, func() { pkg.ImagePixelHash(paletted) })
+}
+
+// TestCalculatePixelDataHash_SameContentDifferentEncodings tests that 16-bit and grayscale
+// PNGs hash the same as 8-bit RGBA PNGs with the same pixels.
+func TestCalculatePixelDataHash_SameContentDifferentEncodings(t *testing.T) {
+	dir := t.TempDir()
+	bounds := image.Rect(0, 0, 4, 3)
+	rgba := image.NewRGBA(bounds)
+	rgba64 := image.NewRGBA64(bounds)
+	gray := image.NewGray(bounds)
+	grayAsRGBA := image.NewRGBA(bounds)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			r, g, b := uint8(x*60), uint8(y*100), uint8(x*y*20)
+			rgba.SetRGBA(x, y, color.RGBA{R: r, G: g, B: b, A: 255})
+			rgba64.SetRGBA64(x, y, color.RGBA64{R: uint16(r) * 0x101, G: uint16(g) * 0x101, B: uint16(b) * 0x101, A: 0xffff})
+			gray.SetGray(x, y, color.Gray{Y: r})
+			grayAsRGBA.SetRGBA(x, y, color.RGBA{R: r, G: r, B: r, A: 255})
+		}
+	}
+
+	hashOf := func(name string, img image.Image) string {
+		content, err := duplicates_encodePNGForTest(img)
+		require.NoError(t, err)
+		hash, err := pkg.CalculatePixelDataHash(createTempFile(t, dir, name, content))
+		require.NoError(t, err)
+		return hash
+	}
+
+	assert.Equal(t, hashOf("rgba.png", rgba), hashOf("rgba64.png", rgba64), "8-bit and 16-bit encodings should hash equally")
+	assert.Equal(t, hashOf("gray.png", gray), hashOf("gray_rgba.png", grayAsRGBA), "Grayscale and RGBA encodings should hash equally")
+}
+
+// countingImageMagic prefixes the test-only "counting" image format: the magic followed by one gray byte
+// decodes to a 1x1 gray image, and every decode is counted in countingImageDecodes. Anything after
+// the gray byte, such as an EXIF segment, is ignored by the decoder.
+const countingImageMagic = "PHSCOUNT"
+
+var countingImageDecodes atomic.Int64
+
+func init() {
+	decodeConfig := func(r io.Reader) (image.Config, error) {
+		return image.Config{ColorModel: color.GrayModel, Width: 1, Height: 1}, nil
+	}
+	decode := func(r io.Reader) (image.Image, error) {
+		countingImageDecodes.Add(1)
+		data, err := io.ReadAll(r)
+		if err != nil || len(data) <= len(countingImageMagic) {
+			return nil, image.ErrFormat
+		}
+		img := image.NewGray(image.Rect(0, 0, 1, 1))
+		img.Pix[0] = data[len(countingImageMagic)]
+		return img, nil
+	}
+	image.RegisterFormat("phscount", countingImageMagic, decode, decodeConfig)
+}
+
+// scaledImageMagic prefixes the test-only "scaled" image format: the magic followed by a gray byte and
+// a width byte decodes to a 1x1 gray image, but its config reports the given width. Scaled images with
+// the same gray byte are therefore pixel hash duplicates with different resolutions.
+const scaledImageMagic = "PHSSCALE"
+
+func init() {
+	decodeConfig := func(r io.Reader) (image.Config, error) {
+		header := make([]byte, len(scaledImageMagic)+2)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return image.Config{}, image.ErrFormat
+		}
+		return image.Config{ColorModel: color.GrayModel, Width: int(header[len(header)-1]), Height: 1}, nil
+	}
+	decode := func(r io.Reader) (image.Image, error) {
+		header := make([]byte, len(scaledImageMagic)+2)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, image.ErrFormat
+		}
+		img := image.NewGray(image.Rect(0, 0, 1, 1))
+		img.Pix[0] = header[len(scaledImageMagic)]
+		return img, nil
+	}
+	image.RegisterFormat("phsscale", scaledImageMagic, decode, decodeConfig)
+}
+
+// scaledImage returns a scaled format image of the given gray value and reported width.
+func scaledImage(gray, width byte) []byte {
+	return append([]byte(scaledImageMagic), gray, width)
+}
+
+// setupCountingImages writes a source and several targets in the counting format, all with different pixels.
+// The files use an image extension so they take the pixel hash path.
+func setupCountingImages(tb testing.TB, targets int) (string, []string) {
+	dir := tb.TempDir()
+	write := func(name string, gray byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(tb, os.WriteFile(path, append([]byte(countingImageMagic), gray), 0644))
+		return path
+	}
+	source := write("source.gif", 0)
+	var targetPaths []string
+	for i := 1; i <= targets; i++ {
+		targetPaths = append(targetPaths, write(fmt.Sprintf("target%d.gif", i), byte(i)))
+	}
+	return source, targetPaths
+}
+
+func TestAreFilesPotentiallyDuplicateWithHashes(t *testing.T) {
+	source, targets := setupCountingImages(t, 3)
+
+	countingImageDecodes.Store(0)
+	var want []pkg.ComparisonResult
+	for _, target := range targets {
+		result, err := pkg.AreFilesPotentiallyDuplicate(source, target)
+		require.NoError(t, err)
+		want = append(want, result)
+	}
+	assert.EqualValues(t, 6, countingImageDecodes.Load(), "uncached comparisons should decode source and target each time")
+
+	countingImageDecodes.Store(0)
+	srcHashes := pkg.NewFileHashes(source)
+	for i, target := range targets {
+		result, err := pkg.AreFilesPotentiallyDuplicateWithHashes(source, target, srcHashes)
+		require.NoError(t, err)
+		assert.Equal(t, want[i], result)
+		assert.Equal(t, pkg.ReasonPixelHashMismatch, result.Reason)
+	}
+	assert.EqualValues(t, 4, countingImageDecodes.Load(), "the source should be decoded once")
+
+	result, err := pkg.AreFilesPotentiallyDuplicateWithHashes(source, targets[0], nil)
+	require.NoError(t, err)
+	assert.Equal(t, want[0], result, "nil hashes should behave like AreFilesPotentiallyDuplicate")
+}
+
+// countingImageWithExif returns a counting image of the given gray level followed by an APP1 EXIF
+// segment with dateTaken as DateTimeOriginal and padding extra bytes.
+func countingImageWithExif(t *testing.T, gray byte, dateTaken string, padding int) []byte {
+	t.Helper()
+	payload := append([]byte("Exif\x00\x00"), buildTIFFExif(t, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: dateTaken}}})...)
+	var out bytes.Buffer
+	out.WriteString(countingImageMagic)
+	out.WriteByte(gray)
+	out.Write([]byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)})
+	out.Write(payload)
+	out.Write(make([]byte, padding))
+	return out.Bytes()
+}
+
+func TestAreFilesPotentiallyDuplicate_ExifPrefilter(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, content, 0644))
+		return path
+	}
+	source := write("source.gif", countingImageWithExif(t, 0, "2020:05:05 05:05:05", 0))
+
+	sig, err := pkg.ExifSignature(source)
+	require.NoError(t, err)
+	assert.Contains(t, sig, "2020:05:05 05:05:05")
+	_, err = pkg.ExifSignature(write("noexif.gif", append([]byte(countingImageMagic), 0)))
+	assert.ErrorIs(t, err, pkg.ErrNoExif)
+
+	tests := []struct {
+		name          string
+		target        []byte
+		wantDuplicate bool
+		wantReason    pkg.Reason
+		wantDecodes   int64
+	}{
+		{"different EXIF signature", countingImageWithExif(t, 0, "2021:06:06 06:06:06", 0), false, pkg.ReasonExifMismatch, 0},
+		{"same signature, different size", countingImageWithExif(t, 0, "2020:05:05 05:05:05", 16), false, pkg.ReasonSizeMismatch, 0},
+		{"identical file", countingImageWithExif(t, 0, "2020:05:05 05:05:05", 0), true, pkg.ReasonFileHashMatch, 0},
+		{"same fingerprint, different pixels", countingImageWithExif(t, 1, "2020:05:05 05:05:05", 0), false, pkg.ReasonPixelHashMismatch, 2},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := write(fmt.Sprintf("target%d.gif", i), tt.target)
+			countingImageDecodes.Store(0)
+			srcHashes := pkg.NewFileHashes(source)
+			srcHashes.ExifPrefilter = true
+			result, err := pkg.AreFilesPotentiallyDuplicateWithHashes(source, target, srcHashes)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDuplicate, result.AreDuplicates)
+			assert.Equal(t, tt.wantReason, result.Reason)
+			assert.Equal(t, tt.wantDecodes, countingImageDecodes.Load(), "decodes")
+		})
+	}
+
+	// Without the prefilter, the same pixels in a file of a different size are still a duplicate.
+	countingImageDecodes.Store(0)
+	result, err := pkg.AreFilesPotentiallyDuplicate(source, filepath.Join(dir, "target1.gif"))
+	require.NoError(t, err)
+	assert.True(t, result.AreDuplicates)
+	assert.EqualValues(t, 2, countingImageDecodes.Load())
+}
+
+// patternPNG encodes a width x height image whose pixel at (x, y) is that of (x/scale, y/scale) in
+// a fixed pattern shifted by seed, so scale 2 gives a nearest-neighbor upscaled copy of scale 1.
+func patternPNG(t *testing.T, width, height, scale int, seed uint8) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px, py := x/scale, y/scale
+			img.Set(x, y, color.RGBA{R: uint8(px*7+py*13) + seed, G: uint8(px ^ py), B: uint8(px * py), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestCalculateDownscaledPixelHash(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, content, 0644))
+		return path
+	}
+	original := write("original.png", patternPNG(t, 300, 200, 1, 0))
+	identical := write("identical.png", patternPNG(t, 300, 200, 1, 0))
+	resized := write("resized.png", patternPNG(t, 600, 400, 2, 0))
+	different := write("different.png", patternPNG(t, 300, 200, 1, 1))
+	small := write("small.png", pngMinimal_2x2_A)
+
+	hash := func(path string) string {
+		h, err := pkg.CalculateDownscaledPixelHash(path, 256)
+		require.NoError(t, err)
+		return h
+	}
+	assert.Equal(t, hash(original), hash(identical))
+	assert.Equal(t, hash(original), hash(resized), "a resized copy has the same downscaled pixels")
+	assert.NotEqual(t, hash(original), hash(different))
+	exact, err := pkg.CalculatePixelDataHash(small)
+	require.NoError(t, err)
+	assert.Equal(t, exact, hash(small), "images that fit are hashed as they are")
+
+	_, err = pkg.CalculateDownscaledPixelHash(original, 0)
+	assert.Error(t, err)
+	_, err = pkg.CalculateDownscaledPixelHash(write("text.png", []byte("not an image")), 256)
+	assert.ErrorIs(t, err, pkg.ErrUnsupportedForPixelHashing)
+
+	// A downscaled match is confirmed by the full pixel hash; only mismatches are decided early.
+	tests := []struct {
+		name          string
+		target        string
+		wantDuplicate bool
+		wantReason    pkg.Reason
+		wantHashType  string
+	}{
+		{"identical", identical, true, pkg.ReasonPixelHashMatch, pkg.HashTypePixel},
+		{"resized copy", resized, false, pkg.ReasonPixelHashMismatch, pkg.HashTypePixel},
+		{"different", different, false, pkg.ReasonPixelHashMismatch, pkg.HashTypeDownscaledPixel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcHashes := pkg.NewFileHashes(original)
+			srcHashes.FastPixelHash = true
+			result, err := pkg.AreFilesPotentiallyDuplicateWithHashes(original, tt.target, srcHashes)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDuplicate, result.AreDuplicates)
+			assert.Equal(t, tt.wantReason, result.Reason)
+			assert.Equal(t, tt.wantHashType, result.HashType)
+		})
+	}
+}
+
+func BenchmarkAreFilesPotentiallyDuplicate(b *testing.B) {
+	source, targets := setupCountingImages(b, 8)
+	b.Run("uncached", func(b *testing.B) {
+		countingImageDecodes.Store(0)
+		for i := 0; i < b.N; i++ {
+			for _, target := range targets {
+				if _, err := pkg.AreFilesPotentiallyDuplicate(source, target); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(countingImageDecodes.Load())/float64(b.N), "decodes/op")
+	})
+	b.Run("withHashes", func(b *testing.B) {
+		countingImageDecodes.Store(0)
+		for i := 0; i < b.N; i++ {
+			srcHashes := pkg.NewFileHashes(source)
+			for _, target := range targets {
+				if _, err := pkg.AreFilesPotentiallyDuplicateWithHashes(source, target, srcHashes); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(countingImageDecodes.Load())/float64(b.N), "decodes/op")
+	})
+}
+
+func writeTestGIF(t *testing.T, path string, frameColors []color.Color, delays []int) {
+	t.Helper()
+	animation := &gif.GIF{Delay: delays}
+	for _, c := range frameColors {
+		frame := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White, c})
+		for i := range frame.Pix {
+			frame.Pix[i] = 2
+		}
+		animation.Image = append(animation.Image, frame)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, animation))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+}
+
+func TestCalculatePixelDataHash_AnimatedGIF(t *testing.T) {
+	dir := t.TempDir()
+	red, green, blue := color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	pathA := filepath.Join(dir, "a.gif")
+	pathB := filepath.Join(dir, "b.gif")
+	pathSlow := filepath.Join(dir, "slow.gif")
+	pathSingle := filepath.Join(dir, "single.gif")
+	writeTestGIF(t, pathA, []color.Color{red, green}, []int{10, 10})
+	writeTestGIF(t, pathB, []color.Color{red, blue}, []int{10, 10})
+	writeTestGIF(t, pathSlow, []color.Color{red, green}, []int{10, 50})
+	writeTestGIF(t, pathSingle, []color.Color{red}, []int{0})
+
+	hashA, err := pkg.CalculatePixelDataHash(pathA)
+	require.NoError(t, err)
+	hashB, err := pkg.CalculatePixelDataHash(pathB)
+	require.NoError(t, err)
+	hashSlow, err := pkg.CalculatePixelDataHash(pathSlow)
+	require.NoError(t, err)
+	assert.NotEqual(t, hashA, hashB, "Animations differing in the second frame should hash differently")
+	assert.NotEqual(t, hashA, hashSlow, "Animations differing in frame delays should hash differently")
+
+	// A single-frame GIF hashes like any image with the same pixels.
+	hashSingle, err := pkg.CalculatePixelDataHash(pathSingle)
+	require.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	duplicates_fillImageForTest(img, red)
+	assert.Equal(t, pkg.ImagePixelHash(img), hashSingle)
+
+	res, err := pkg.AreFilesPotentiallyDuplicate(pathA, pathB)
+	require.NoError(t, err)
+	assert.False(t, res.AreDuplicates)
+}
+
+func TestExifSignature_BurstFrames(t *testing.T) {
+	dir := t.TempDir()
+	frame := func(name, subSec string, exposure uint32) string {
+		spec := exifSpec{
+			IFD0: []exifTag{{ID: exifTagMake, Value: "Canon"}, {ID: exifTagModel, Value: "EOS R5"}},
+			Exif: []exifTag{
+				{ID: exifTagDateTimeOriginal, Value: "2023:10:27 15:30:00"},
+				{ID: exifTagSubSecTimeOriginal, Value: subSec},
+				{ID: exifTagFNumber, Value: []exifRational{{28, 10}}},
+				{ID: exifTagExposureTime, Value: []exifRational{{1, exposure}}},
+				{ID: exifTagISOSpeedRatings, Value: uint16(400)},
+			},
+		}
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, jpegWithExif(t, image.NewGray(image.Rect(0, 0, 4, 4)), spec), 0644))
+		return path
+	}
+	first, second := frame("burst1.jpg", "10", 500), frame("burst2.jpg", "45", 500)
+	bracketed := frame("burst3.jpg", "10", 1000)
+
+	sig1, err := pkg.ExifSignatureWithFields(first, pkg.BurstExifSignatureFields)
+	require.NoError(t, err)
+	sig2, err := pkg.ExifSignatureWithFields(second, pkg.BurstExifSignatureFields)
+	require.NoError(t, err)
+	assert.NotEqual(t, sig1, sig2, "burst frames of the same second differ by SubSecTimeOriginal")
+	assert.Contains(t, sig1, "28/10", "rational tags are part of the signature")
+	assert.Contains(t, sig1, "400")
+	sig3, err := pkg.ExifSignatureWithFields(bracketed, pkg.BurstExifSignatureFields)
+	require.NoError(t, err)
+	assert.NotEqual(t, sig1, sig3, "bracketed frames differ by ExposureTime")
+
+	default1, err := pkg.ExifSignature(first)
+	require.NoError(t, err)
+	default2, err := pkg.ExifSignature(second)
+	require.NoError(t, err)
+	assert.Equal(t, default1, default2, "by default the burst frames share a signature and are compared by their pixels")
+
+	hashes := pkg.NewFileHashes(first)
+	hashes.ExifSignatureFields = pkg.BurstExifSignatureFields
+	cached, err := hashes.ExifSignature()
+	require.NoError(t, err)
+	assert.Equal(t, sig1, cached)
+
+	assert.Equal(t, []string{"Model", "FNumber"}, pkg.ParseExifSignatureFields(" Model, ,FNumber"))
+	assert.Nil(t, pkg.ParseExifSignatureFields(""))
+	assert.Error(t, pkg.Options{ExifSignatureFields: []string{"Model", " "}}.Validate())
+}
+
+func TestGroupSourceDuplicatesPreferring(t *testing.T) {
+	dir := t.TempDir()
+	createTestFiles(t, dir, []fileSpec{
+		{Path: "a.jpeg", Content: pngMinimal_2x2_A},
+		{Path: "b.png", Content: pngMinimal_2x2_B},
+		{Path: "c.JPG", Content: pngMinimal_2x2_A},
+		{Path: "d.tif", Content: pngMinimal_2x2_A},
+	})
+	files := []string{filepath.Join(dir, "a.jpeg"), filepath.Join(dir, "b.png"), filepath.Join(dir, "c.JPG"), filepath.Join(dir, "d.tif")}
+	logger := pkg.NewLogger(io.Discard, slog.LevelInfo, false)
+
+	representatives, duplicates := pkg.GroupSourceDuplicates(context.Background(), files, logger)
+	assert.Equal(t, []string{files[0], files[1]}, representatives, "the first identical file is kept by default")
+	assert.Len(t, duplicates, 2)
+
+	representatives, duplicates = pkg.GroupSourceDuplicatesPreferring(context.Background(), files, pkg.ParseExtensionOrder("jpg, .JPEG,png"), logger)
+	assert.Equal(t, []string{files[2], files[1]}, representatives, "the preferred extension is kept in place of the group")
+	require.Len(t, duplicates, 2)
+	for _, d := range duplicates {
+		assert.Equal(t, files[2], d.KeptFile)
+		assert.Equal(t, pkg.ReasonSourceDuplicate, d.Reason)
+	}
+	assert.Error(t, pkg.Options{PreferExtensionOrder: []string{".jpg"}}.Validate())
+}
+
+func TestAreFilesPotentiallyDuplicate_CompareAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(3, 3, color.RGBA{R: 200, A: 255})
+	exifFor := func(cameraMake, dateTime string) exifSpec {
+		return exifSpec{
+			IFD0: []exifTag{{exifTagMake, cameraMake}, {exifTagDateTime, dateTime}},
+			Exif: []exifTag{{exifTagDateTimeOriginal, "2020:05:05 10:00:00"}},
+		}
+	}
+	original := createTempFile(t, dir, "original.jpg", jpegWithExif(t, img, exifFor("Canon", "2020:05:05 10:00:00")))
+	// Same pixels and EXIF signature, but DateTime (not part of the signature) was edited.
+	edited := createTempFile(t, dir, "edited.jpg", jpegWithExif(t, img, exifFor("Canon", "2021:01:01 12:00:00")))
+	// Same pixels, different camera in EXIF.
+	otherCamera := createTempFile(t, dir, "other.jpg", jpegWithExif(t, img, exifFor("Nikon", "2020:05:05 10:00:00")))
+
+	tests := []struct {
+		algorithm string
+		target    string
+		wantDup   bool
+		reason    pkg.Reason
+	}{
+		{pkg.CompareFull, edited, true, pkg.ReasonPixelHashMatch},
+		{"", edited, true, pkg.ReasonPixelHashMatch},
+		{pkg.ComparePixelOnly, edited, true, pkg.ReasonPixelHashMatch},
+		{pkg.CompareFileOnly, edited, false, pkg.ReasonFileHashMismatch},
+		{pkg.CompareExifOnly, edited, true, pkg.ReasonExifMatch},
+		{pkg.CompareFull, otherCamera, false, pkg.ReasonExifMismatch},
+		{pkg.ComparePixelOnly, otherCamera, true, pkg.ReasonPixelHashMatch},
+		{pkg.CompareExifOnly, otherCamera, false, pkg.ReasonExifMismatch},
+	}
+	for _, tt := range tests {
+		srcHashes := pkg.NewFileHashes(original)
+		srcHashes.CompareAlgorithm = tt.algorithm
+		res, err := pkg.AreFilesPotentiallyDuplicateWithHashes(original, tt.target, srcHashes)
+		require.NoError(t, err)
+		assert.Equal(t, tt.wantDup, res.AreDuplicates, "%q with %s", tt.algorithm, filepath.Base(tt.target))
+		assert.Equal(t, tt.reason, res.Reason, "%q with %s", tt.algorithm, filepath.Base(tt.target))
+	}
+
+	// Byte-identical files are duplicates whatever the algorithm.
+	content, err := os.ReadFile(original)
+	require.NoError(t, err)
+	copyPath := createTempFile(t, dir, "copy.jpg", content)
+	srcHashes := pkg.NewFileHashes(original)
+	srcHashes.CompareAlgorithm = pkg.CompareFileOnly
+	res, err := pkg.AreFilesPotentiallyDuplicateWithHashes(original, copyPath, srcHashes)
+	require.NoError(t, err)
+	assert.True(t, res.AreDuplicates)
+	assert.Equal(t, pkg.ReasonFileHashMatch, res.Reason)
+
+	assert.Error(t, pkg.ValidateCompareAlgorithm("pixel"))
+	assert.Error(t, pkg.Options{CompareAlgorithm: "pixel"}.Validate())
+}
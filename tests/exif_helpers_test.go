@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// --- Synthetic EXIF helpers ---
+// These build minimal little-endian TIFF/EXIF blocks so tests can control EXIF tags
+// without shipping binary fixtures.
+
+// EXIF tag IDs used by the tests.
+const (
+	exifTagMake               uint16 = 0x010F
+	exifTagModel              uint16 = 0x0110
+	exifTagOrientation        uint16 = 0x0112
+	exifTagDateTime           uint16 = 0x0132
+	exifTagExposureTime       uint16 = 0x829A
+	exifTagFNumber            uint16 = 0x829D
+	exifTagISOSpeedRatings    uint16 = 0x8827
+	exifTagDateTimeOriginal   uint16 = 0x9003
+	exifTagDateTimeDigitized  uint16 = 0x9004
+	exifTagOffsetTimeOriginal uint16 = 0x9011
+	exifTagSubSecTimeOriginal uint16 = 0x9291
+	exifTagPixelXDimension    uint16 = 0xA002
+	exifTagPixelYDimension    uint16 = 0xA003
+	exifTagGPSTimeStamp       uint16 = 0x0007
+	exifTagGPSDateStamp       uint16 = 0x001D
+
+	exifTagExifIFDPointer uint16 = 0x8769
+	exifTagGPSIFDPointer  uint16 = 0x8825
+)
+
+// exifRational is an unsigned TIFF RATIONAL value.
+type exifRational struct {
+	Num, Den uint32
+}
+
+// exifTag is a single TIFF entry. Value must be a string (ASCII), uint16 (SHORT),
+// uint32 (LONG) or []exifRational (RATIONAL).
+type exifTag struct {
+	ID    uint16
+	Value interface{}
+}
+
+// exifSpec lists the tags to place in IFD0, the EXIF sub-IFD and the GPS sub-IFD.
+type exifSpec struct {
+	IFD0 []exifTag
+	Exif []exifTag
+	GPS  []exifTag
+}
+
+// exifValueBytes returns the TIFF type, count and encoded bytes of a tag value.
+func exifValueBytes(t *testing.T, value interface{}) (uint16, uint32, []byte) {
+	t.Helper()
+	switch v := value.(type) {
+	case string:
+		data := append([]byte(v), 0)
+		return 2, uint32(len(data)), data
+	case uint16:
+		data := make([]byte, 2)
+		binary.LittleEndian.PutUint16(data, v)
+		return 3, 1, data
+	case uint32:
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, v)
+		return 4, 1, data
+	case []exifRational:
+		data := make([]byte, 8*len(v))
+		for i, r := range v {
+			binary.LittleEndian.PutUint32(data[8*i:], r.Num)
+			binary.LittleEndian.PutUint32(data[8*i+4:], r.Den)
+		}
+		return 5, uint32(len(v)), data
+	}
+	t.Fatalf("unsupported EXIF tag value type %T", value)
+	return 0, 0, nil
+}
+
+// exifIFDSize returns the number of bytes an IFD and its out-of-line values occupy.
+func exifIFDSize(t *testing.T, tags []exifTag) uint32 {
+	t.Helper()
+	size := uint32(2 + 12*len(tags) + 4)
+	for _, tag := range tags {
+		_, _, data := exifValueBytes(t, tag.Value)
+		if len(data) > 4 {
+			size += uint32(len(data)+1) &^ 1 // Keep values word aligned
+		}
+	}
+	return size
+}
+
+// encodeExifIFD serializes an IFD that starts at offset within the TIFF block.
+func encodeExifIFD(t *testing.T, tags []exifTag, offset uint32) []byte {
+	t.Helper()
+	sorted := append([]exifTag(nil), tags...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var entries, extra bytes.Buffer
+	_ = binary.Write(&entries, binary.LittleEndian, uint16(len(sorted)))
+	dataOffset := offset + uint32(2+12*len(sorted)+4)
+	for _, tag := range sorted {
+		typ, count, data := exifValueBytes(t, tag.Value)
+		_ = binary.Write(&entries, binary.LittleEndian, tag.ID)
+		_ = binary.Write(&entries, binary.LittleEndian, typ)
+		_ = binary.Write(&entries, binary.LittleEndian, count)
+		if len(data) <= 4 {
+			inline := make([]byte, 4)
+			copy(inline, data)
+			entries.Write(inline)
+			continue
+		}
+		_ = binary.Write(&entries, binary.LittleEndian, dataOffset+uint32(extra.Len()))
+		extra.Write(data)
+		if extra.Len()%2 == 1 {
+			extra.WriteByte(0)
+		}
+	}
+	_ = binary.Write(&entries, binary.LittleEndian, uint32(0)) // No next IFD
+	return append(entries.Bytes(), extra.Bytes()...)
+}
+
+// buildTIFFExif builds a little-endian TIFF block containing the given tags.
+func buildTIFFExif(t *testing.T, spec exifSpec) []byte {
+	t.Helper()
+	ifd0 := append([]exifTag(nil), spec.IFD0...)
+	if len(spec.Exif) > 0 {
+		ifd0 = append(ifd0, exifTag{ID: exifTagExifIFDPointer, Value: uint32(0)})
+	}
+	if len(spec.GPS) > 0 {
+		ifd0 = append(ifd0, exifTag{ID: exifTagGPSIFDPointer, Value: uint32(0)})
+	}
+
+	ifd0Offset := uint32(8)
+	exifOffset := ifd0Offset + exifIFDSize(t, ifd0)
+	gpsOffset := exifOffset
+	if len(spec.Exif) > 0 {
+		gpsOffset += exifIFDSize(t, spec.Exif)
+	}
+	for i := range ifd0 {
+		switch ifd0[i].ID {
+		case exifTagExifIFDPointer:
+			ifd0[i].Value = exifOffset
+		case exifTagGPSIFDPointer:
+			ifd0[i].Value = gpsOffset
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	_ = binary.Write(&buf, binary.LittleEndian, ifd0Offset)
+	buf.Write(encodeExifIFD(t, ifd0, ifd0Offset))
+	if len(spec.Exif) > 0 {
+		buf.Write(encodeExifIFD(t, spec.Exif, exifOffset))
+	}
+	if len(spec.GPS) > 0 {
+		buf.Write(encodeExifIFD(t, spec.GPS, gpsOffset))
+	}
+	return buf.Bytes()
+}
+
+// jpegWithExif encodes img as a JPEG and inserts an APP1 EXIF segment built from spec.
+func jpegWithExif(t *testing.T, img image.Image, spec exifSpec) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	require.NoError(t, jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 90}))
+	jpegBytes := encoded.Bytes()
+
+	payload := append([]byte("Exif\x00\x00"), buildTIFFExif(t, spec)...)
+	var out bytes.Buffer
+	out.Write(jpegBytes[:2]) // SOI
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	out.Write(jpegBytes[2:])
+	return out.Bytes()
+}
+
+// pngWithExif encodes img as a PNG and inserts an eXIf chunk built from spec after the IHDR chunk.
+func pngWithExif(t *testing.T, img image.Image, spec exifSpec) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, img))
+	pngBytes := encoded.Bytes()
+
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4 // signature, length, type, data, CRC
+	chunk := append([]byte("eXIf"), buildTIFFExif(t, spec)...)
+	var out bytes.Buffer
+	out.Write(pngBytes[:ihdrEnd])
+	_ = binary.Write(&out, binary.BigEndian, uint32(len(chunk)-4))
+	out.Write(chunk)
+	_ = binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	out.Write(pngBytes[ihdrEnd:])
+	return out.Bytes()
+}
@@ -1,12 +1,16 @@
 package tests
 
 import (
+	"context"
 	"errors" // Added for errors.Is
+	"fmt"
 	"github.com/user/photo-sorter/pkg"
+	"image"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime" // Added for runtime.GOOS
+	"slices"
 	"sort"
 	"strings" // Added for strings.Contains
 	"testing"
@@ -333,7 +337,7 @@ func TestScanSourceDirectory(t *testing.T) {
 				}
 			}
 
-			files, err := pkg.ScanSourceDirectory(scanDir)
+			files, err := pkg.ScanSourceDirectory(scanDir, 0, false)
 
 			if (err != nil) != tt.expectedErr {
 				t.Errorf("pkg.ScanSourceDirectory() error = %v, expectedErr %v", err, tt.expectedErr)
@@ -559,3 +563,781 @@ func TestGetPhotoCreationDate(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateFilenameFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		layout    string
+		expectErr bool
+	}{
+		{"default format", pkg.DefaultFilenameFormat, false},
+		{"compact format", "20060102_150405", false},
+		{"dotted format with literal text", "2006.01.02 15h04m05", false},
+		{"empty format", "", true},
+		{"format with forward slash", "2006/01/02-150405", true},
+		{"format with backslash", `2006\01\02`, true},
+		{"format without date elements", "photo", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := pkg.ValidateFilenameFormat(tt.layout)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("pkg.ValidateFilenameFormat(%q) error = %v, expectErr %v", tt.layout, err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestFindPotentialTargetConflicts_UnicodeForms(t *testing.T) {
+	tmpDir := t.TempDir()
+	names := []string{"cafe\u0301.jpg", "caf\u00e9-1.jpg", "cafe.jpg"} // NFD, NFC version, unaccented
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+	}
+	expected := []string{filepath.Join(tmpDir, names[0]), filepath.Join(tmpDir, names[1])}
+	sort.Strings(expected)
+
+	for _, baseName := range []string{"caf\u00e9", "cafe\u0301"} {
+		conflicts, err := pkg.FindPotentialTargetConflicts(tmpDir, baseName, ".jpg")
+		if err != nil {
+			t.Fatalf("pkg.FindPotentialTargetConflicts(%q) unexpected error: %v", baseName, err)
+		}
+		sort.Strings(conflicts)
+		if !reflect.DeepEqual(conflicts, expected) {
+			t.Errorf("pkg.FindPotentialTargetConflicts(%q) = %v, expected %v", baseName, conflicts, expected)
+		}
+	}
+}
+
+// TestFindPotentialTargetConflicts_SuffixIsExtension tests a base name ending in the extension, as with a
+// file suffix of ".jpg", next to a file named like the base name alone.
+func TestFindPotentialTargetConflicts_SuffixIsExtension(t *testing.T) {
+	tmpDir := t.TempDir()
+	names := []string{"2023-10-27-153000.jpg", "2023-10-27-153000.jpg.jpg", "2023-10-27-153000.jpg-1.jpg"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+	}
+	expected := []string{filepath.Join(tmpDir, names[1]), filepath.Join(tmpDir, names[2])}
+	sort.Strings(expected)
+
+	conflicts, err := pkg.FindPotentialTargetConflicts(tmpDir, "2023-10-27-153000.jpg", ".jpg")
+	if err != nil {
+		t.Fatalf("pkg.FindPotentialTargetConflicts() unexpected error: %v", err)
+	}
+	sort.Strings(conflicts)
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("pkg.FindPotentialTargetConflicts() = %v, expected %v", conflicts, expected)
+	}
+}
+
+func TestFindPotentialTargetConflicts_ArbitraryBaseNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{
+		"20231027_153000.jpg",
+		"20231027_153000-1.jpg",
+		"20231027_153000-2.jpg",
+		"20231027_153001.jpg", // Different timestamp
+		"2023.10.27 15h30 (x).jpg",
+		"2023.10.27 15h30 (x)-1.jpg",
+	} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		baseName string
+		expected []string
+	}{
+		{"20231027_153000", []string{"20231027_153000.jpg", "20231027_153000-1.jpg", "20231027_153000-2.jpg"}},
+		{"2023.10.27 15h30 (x)", []string{"2023.10.27 15h30 (x).jpg", "2023.10.27 15h30 (x)-1.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.baseName, func(t *testing.T) {
+			conflicts, err := pkg.FindPotentialTargetConflicts(tmpDir, tt.baseName, ".jpg")
+			if err != nil {
+				t.Fatalf("pkg.FindPotentialTargetConflicts() unexpected error: %v", err)
+			}
+			expected := make([]string, len(tt.expected))
+			for i, name := range tt.expected {
+				expected[i] = filepath.Join(tmpDir, name)
+			}
+			sort.Strings(expected)
+			sort.Strings(conflicts)
+			if !reflect.DeepEqual(conflicts, expected) {
+				t.Errorf("pkg.FindPotentialTargetConflicts() = %v, expected %v", conflicts, expected)
+			}
+		})
+	}
+}
+
+func TestScanSourceDirectory_MaxDepth(t *testing.T) {
+	tmpDir := t.TempDir()
+	createScanTestDir(t, tmpDir, map[string][]byte{
+		"level1.jpg":                  []byte("fake jpg"),
+		"a/level2.jpg":                []byte("fake jpg"),
+		"a/b/level3.jpg":              []byte("fake jpg"),
+		"a/b/not_an_image_level3.txt": []byte("text"),
+	})
+
+	tests := []struct {
+		name          string
+		maxDepth      int
+		expectedFiles []string
+	}{
+		{"depth 1", 1, []string{"level1.jpg"}},
+		{"depth 2", 2, []string{"level1.jpg", "a/level2.jpg"}},
+		{"unlimited", 0, []string{"level1.jpg", "a/level2.jpg", "a/b/level3.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := pkg.ScanSourceDirectory(tmpDir, tt.maxDepth, false)
+			if err != nil {
+				t.Fatalf("pkg.ScanSourceDirectory() unexpected error: %v", err)
+			}
+
+			expected := make([]string, len(tt.expectedFiles))
+			for i, f := range tt.expectedFiles {
+				expected[i] = filepath.Join(tmpDir, f)
+			}
+			sort.Strings(expected)
+			sort.Strings(files)
+
+			if !reflect.DeepEqual(files, expected) {
+				t.Errorf("pkg.ScanSourceDirectory(maxDepth=%d) files = %v, expected %v", tt.maxDepth, files, expected)
+			}
+		})
+	}
+}
+
+func TestIsVideoExtension(t *testing.T) {
+	tests := []struct {
+		filePath  string
+		wantVideo bool
+		wantImage bool
+	}{
+		{"clip.mp4", true, false},
+		{"clip.MOV", true, false},
+		{"clip.m2ts", true, false},
+		{"photo.jpg", false, true},
+		{"notes.txt", false, false},
+		{"noextension", false, false},
+	}
+	for _, tt := range tests {
+		if got := pkg.IsVideoExtension(tt.filePath); got != tt.wantVideo {
+			t.Errorf("IsVideoExtension(%q) = %v, want %v", tt.filePath, got, tt.wantVideo)
+		}
+		if got := pkg.IsImageExtension(tt.filePath); got != tt.wantImage {
+			t.Errorf("IsImageExtension(%q) = %v, want %v", tt.filePath, got, tt.wantImage)
+		}
+	}
+}
+
+func TestScanMediaDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	createScanTestDir(t, tmpDir, map[string][]byte{
+		"photo.jpg":          []byte("fake jpg"),
+		"clip.mp4":           []byte("fake mp4"),
+		"sub/IMG_0001.HEIC":  []byte("fake heic"),
+		"sub/IMG_0001.MOV":   []byte("fake mov"),
+		"sub/deep/notes.txt": []byte("text"),
+		"report.txt":         []byte("Photo Sorting Report"),
+	})
+
+	images, videos, err := pkg.ScanMediaDirectory(tmpDir)
+	if err != nil {
+		t.Fatalf("pkg.ScanMediaDirectory() unexpected error: %v", err)
+	}
+	wantImages := []string{filepath.Join(tmpDir, "photo.jpg"), filepath.Join(tmpDir, "sub", "IMG_0001.HEIC")}
+	wantVideos := []string{filepath.Join(tmpDir, "clip.mp4"), filepath.Join(tmpDir, "sub", "IMG_0001.MOV")}
+	sort.Strings(images)
+	sort.Strings(videos)
+	sort.Strings(wantImages)
+	sort.Strings(wantVideos)
+	if !reflect.DeepEqual(images, wantImages) {
+		t.Errorf("pkg.ScanMediaDirectory() images = %v, want %v", images, wantImages)
+	}
+	if !reflect.DeepEqual(videos, wantVideos) {
+		t.Errorf("pkg.ScanMediaDirectory() videos = %v, want %v", videos, wantVideos)
+	}
+
+	// ScanSourceDirectory still returns images only.
+	files, err := pkg.ScanSourceDirectory(tmpDir, 0, false)
+	if err != nil {
+		t.Fatalf("pkg.ScanSourceDirectory() unexpected error: %v", err)
+	}
+	sort.Strings(files)
+	if !reflect.DeepEqual(files, wantImages) {
+		t.Errorf("pkg.ScanSourceDirectory() = %v, want %v", files, wantImages)
+	}
+
+	if _, _, err := pkg.ScanMediaDirectory(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Errorf("pkg.ScanMediaDirectory() of a missing directory: expected an error")
+	}
+}
+
+func TestWalkSourceImages(t *testing.T) {
+	tmpDir := t.TempDir()
+	createScanTestDir(t, tmpDir, map[string][]byte{
+		"a.jpg":        []byte("fake jpg"),
+		"b.png":        []byte("fake png"),
+		"clip.mp4":     []byte("fake mp4"),
+		"sub/c.heic":   []byte("fake heic"),
+		"sub/notes.md": []byte("text"),
+		"report.txt":   []byte("Photo Sorting Report"),
+	})
+
+	var walked []string
+	err := pkg.WalkSourceImages(tmpDir, func(path string) error {
+		walked = append(walked, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("pkg.WalkSourceImages() unexpected error: %v", err)
+	}
+	scanned, err := pkg.ScanSourceDirectory(tmpDir, 0, false)
+	if err != nil {
+		t.Fatalf("pkg.ScanSourceDirectory() unexpected error: %v", err)
+	}
+	if len(walked) != 3 || !reflect.DeepEqual(walked, scanned) {
+		t.Errorf("pkg.WalkSourceImages() called back with %v, want the 3 images %v", walked, scanned)
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = pkg.WalkSourceImages(tmpDir, func(path string) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("pkg.WalkSourceImages() error = %v, want the callback's error", err)
+	}
+	if calls != 1 {
+		t.Errorf("pkg.WalkSourceImages() called back %d times after an error, want 1", calls)
+	}
+
+	if err := pkg.WalkSourceImages(filepath.Join(tmpDir, "missing"), func(string) error { return nil }); err == nil {
+		t.Errorf("pkg.WalkSourceImages() of a missing directory: expected an error")
+	}
+}
+
+func TestScanSkippedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	createScanTestDir(t, tmpDir, map[string][]byte{
+		"a.jpg":             []byte("fake jpg"),
+		"clip.mp4":          []byte("fake mp4"),
+		"sub/notes.md":      []byte("text"),
+		"report.txt":        []byte("Photo Sorting Report"),
+		"sub/deep/skip.txt": []byte("beyond max depth"),
+	})
+
+	skipped, err := pkg.ScanSkippedFiles(context.Background(), tmpDir, 2, false)
+	if err != nil {
+		t.Fatalf("pkg.ScanSkippedFiles() unexpected error: %v", err)
+	}
+	want := []pkg.SkippedInfo{
+		{SourceFile: filepath.Join(tmpDir, "clip.mp4"), Reason: pkg.VideoSkipReason},
+		{SourceFile: filepath.Join(tmpDir, "report.txt"), Reason: pkg.GeneratedFileSkipReason},
+		{SourceFile: filepath.Join(tmpDir, "sub", "notes.md"), Reason: pkg.UnsupportedFormatSkipReason},
+	}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("pkg.ScanSkippedFiles() = %v, want %v", skipped, want)
+	}
+}
+
+func TestScanSourceDirectory_SkipsGeneratedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	createScanTestDir(t, tmpDir, map[string][]byte{
+		"photo.jpg":                  []byte("fake jpg"),
+		"report.txt":                 []byte("Photo Sorting Report"),
+		"report-20231027-153000.txt": []byte("Photo Sorting Report"),
+		"manifest.csv":               []byte("source,target"),
+		"index.json":                 []byte("{}"),
+		"2023/10/report.txt":         []byte("Photo Sorting Report"),
+	})
+
+	files, err := pkg.ScanSourceDirectory(tmpDir, 0, true)
+	if err != nil {
+		t.Fatalf("pkg.ScanSourceDirectory() unexpected error: %v", err)
+	}
+	expected := []string{filepath.Join(tmpDir, "photo.jpg")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("pkg.ScanSourceDirectory() = %v, want %v", files, expected)
+	}
+}
+
+func TestRemoveEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+	createScanTestDir(t, root, map[string][]byte{
+		"a/b/c/dir":    nil, // Creates a/b/c
+		"a/d/file.jpg": []byte("fake jpg"),
+		"e/dir":        nil,
+	})
+	outside := filepath.Join(t.TempDir(), "empty")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := pkg.RemoveEmptyDirs(root, []string{filepath.Join(root, "a", "b", "c"), filepath.Join(root, "a", "d"), root, outside})
+	if err != nil {
+		t.Fatalf("pkg.RemoveEmptyDirs() unexpected error: %v", err)
+	}
+	want := []string{filepath.Join(root, "a", "b", "c"), filepath.Join(root, "a", "b")}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("pkg.RemoveEmptyDirs() removed %v, want %v", removed, want)
+	}
+	for _, dir := range []string{root, filepath.Join(root, "a", "d"), filepath.Join(root, "e"), outside} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("directory %s should have been kept: %v", dir, err)
+		}
+	}
+}
+
+func TestHasImageDecoder(t *testing.T) {
+	for ext, want := range map[string]bool{".jpg": true, ".JPEG": true, ".png": true, ".gif": true, ".webp": true, ".cr2": false, ".raw": false, ".txt": false} {
+		if got := pkg.HasImageDecoder(ext); got != want {
+			t.Errorf("pkg.HasImageDecoder(%q) = %v, want %v", ext, got, want)
+		}
+	}
+	extensions := pkg.ImageExtensions()
+	if !sort.StringsAreSorted(extensions) || !slices.Contains(extensions, ".heic") || !slices.Contains(extensions, ".jpg") {
+		t.Errorf("pkg.ImageExtensions() = %v, want a sorted list including .heic and .jpg", extensions)
+	}
+	if videos := pkg.VideoExtensions(); !slices.Contains(videos, ".mov") {
+		t.Errorf("pkg.VideoExtensions() = %v, want it to include .mov", videos)
+	}
+}
+
+func TestIsGeneratedFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"report.txt", true},
+		{"report-20231027-153000.txt", true},
+		{"report-20231027-153000-2.txt", true},
+		{"report.json", true},
+		{"manifest.csv", true},
+		{pkg.IndexFileName, true},
+		{".photocp-write-test-12345", true},
+		{"report-draft.txt", false},
+		{"my-report.txt", false},
+		{"photo.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := pkg.IsGeneratedFileName(tt.name); got != tt.want {
+			t.Errorf("pkg.IsGeneratedFileName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanSourceDirectoryContext_Cancel(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := make(map[string][]byte)
+	for d := 0; d < 20; d++ {
+		for f := 0; f < 50; f++ {
+			files[fmt.Sprintf("dir%02d/img%02d.jpg", d, f)] = []byte("fake jpg")
+		}
+	}
+	createScanTestDir(t, tmpDir, files)
+
+	ctx := &cancelAfterContext{Context: context.Background(), allowed: 100}
+	scanned, err := pkg.ScanSourceDirectoryContext(ctx, tmpDir, 0, false)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("pkg.ScanSourceDirectoryContext() error = %v, expected %v", err, context.Canceled)
+	}
+	if scanned != nil {
+		t.Errorf("pkg.ScanSourceDirectoryContext() returned %d files after cancellation, expected none", len(scanned))
+	}
+	// The tree has over 1000 entries; the walk should stop right after the cancellation.
+	if ctx.calls > ctx.allowed+2 {
+		t.Errorf("ctx.Err() was called %d times, expected the walk to stop after %d", ctx.calls, ctx.allowed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pkg.ScanSourceDirectoryContext(cancelled, tmpDir, 0, false); !errors.Is(err, context.Canceled) {
+		t.Errorf("pkg.ScanSourceDirectoryContext() with a cancelled context error = %v, expected %v", err, context.Canceled)
+	}
+	if scanned, err := pkg.ScanSourceDirectoryContext(context.Background(), tmpDir, 0, false); err != nil || len(scanned) != len(files) {
+		t.Errorf("pkg.ScanSourceDirectoryContext() = %d files, %v, expected %d files", len(scanned), err, len(files))
+	}
+}
+
+// TestSniffImageType tests magic-number detection of extensionless files and that
+// ScanSourceDirectory only includes them when sniffing is enabled.
+func TestSniffImageType(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string][]byte{
+		"photo":   {0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00},
+		"picture": {0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n', 0x00},
+		"anim":    []byte("GIF89a\x01\x00"),
+		"iphone":  {0x00, 0x00, 0x00, 0x18, 'f', 't', 'y', 'p', 'h', 'e', 'i', 'c'},
+		"browser": []byte("RIFF\x1a\x00\x00\x00WEBPVP8L"),
+		"random":  {0x13, 0x37, 0xC0, 0xFF, 0xEE, 0x42, 0x00, 0x01},
+		"tiny":    {0xFF},
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		wantExt string
+		wantOK  bool
+	}{
+		{"photo", ".jpg", true},
+		{"picture", ".png", true},
+		{"anim", ".gif", true},
+		{"iphone", ".heic", true},
+		{"browser", ".webp", true},
+		{"random", "", false},
+		{"tiny", "", false},
+		{"missing", "", false},
+	}
+	for _, tt := range tests {
+		ext, ok := pkg.SniffImageType(filepath.Join(tmpDir, tt.name))
+		if ext != tt.wantExt || ok != tt.wantOK {
+			t.Errorf("SniffImageType(%s) = (%q, %v), want (%q, %v)", tt.name, ext, ok, tt.wantExt, tt.wantOK)
+		}
+	}
+
+	withoutSniffing, err := pkg.ScanSourceDirectory(tmpDir, 0, false)
+	if err != nil {
+		t.Fatalf("pkg.ScanSourceDirectory() unexpected error: %v", err)
+	}
+	if len(withoutSniffing) != 0 {
+		t.Errorf("pkg.ScanSourceDirectory(sniff=false) files = %v, expected none", withoutSniffing)
+	}
+
+	withSniffing, err := pkg.ScanSourceDirectory(tmpDir, 0, true)
+	if err != nil {
+		t.Fatalf("pkg.ScanSourceDirectory() unexpected error: %v", err)
+	}
+	sort.Strings(withSniffing)
+	expected := []string{filepath.Join(tmpDir, "anim"), filepath.Join(tmpDir, "browser"), filepath.Join(tmpDir, "iphone"), filepath.Join(tmpDir, "photo"), filepath.Join(tmpDir, "picture")}
+	if !reflect.DeepEqual(withSniffing, expected) {
+		t.Errorf("pkg.ScanSourceDirectory(sniff=true) files = %v, expected %v", withSniffing, expected)
+	}
+}
+
+func TestGetPhotoCreationDate_OffsetAndSubSec(t *testing.T) {
+	tmpDir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+
+	tests := []struct {
+		name     string
+		exif     []exifTag
+		wantUTC  time.Time
+		wantDay  int // Day of the camera's wall clock
+		wantZone int // Offset from UTC in seconds
+	}{
+		{
+			name: "offset ahead of UTC with sub-seconds",
+			exif: []exifTag{
+				{ID: exifTagDateTimeOriginal, Value: "2023:10:27 23:30:00"},
+				{ID: exifTagOffsetTimeOriginal, Value: "+09:00"},
+				{ID: exifTagSubSecTimeOriginal, Value: "25"},
+			},
+			wantUTC:  time.Date(2023, 10, 27, 14, 30, 0, 250000000, time.UTC),
+			wantDay:  27,
+			wantZone: 9 * 3600,
+		},
+		{
+			name: "offset behind UTC crossing midnight",
+			exif: []exifTag{
+				{ID: exifTagDateTimeOriginal, Value: "2023:10:27 20:00:00"},
+				{ID: exifTagOffsetTimeOriginal, Value: "-05:00"},
+			},
+			wantUTC:  time.Date(2023, 10, 28, 1, 0, 0, 0, time.UTC),
+			wantDay:  27,
+			wantZone: -5 * 3600,
+		},
+		{
+			name: "malformed offset ignored",
+			exif: []exifTag{
+				{ID: exifTagDateTimeOriginal, Value: "2023:10:27 20:00:00"},
+				{ID: exifTagOffsetTimeOriginal, Value: "   :  "},
+			},
+			wantUTC: time.Date(2023, 10, 27, 20, 0, 0, 0, time.UTC),
+			wantDay: 27,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, fmt.Sprintf("photo%d.jpg", i))
+			if err := os.WriteFile(path, jpegWithExif(t, img, exifSpec{Exif: tt.exif}), 0644); err != nil {
+				t.Fatalf("Failed to write test image: %v", err)
+			}
+			date, err := pkg.GetPhotoCreationDate(path)
+			if err != nil {
+				t.Fatalf("pkg.GetPhotoCreationDate() unexpected error: %v", err)
+			}
+			if !date.Equal(tt.wantUTC) {
+				t.Errorf("pkg.GetPhotoCreationDate() = %v, want the instant %v", date, tt.wantUTC)
+			}
+			if _, offset := date.Zone(); date.Day() != tt.wantDay || offset != tt.wantZone {
+				t.Errorf("pkg.GetPhotoCreationDate() = %v, want day %d at offset %ds", date, tt.wantDay, tt.wantZone)
+			}
+		})
+	}
+}
+
+func TestSortFile_ExifOffsetKeepsCameraDay(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	content := jpegWithExif(t, img, exifSpec{Exif: []exifTag{
+		{ID: exifTagDateTimeOriginal, Value: "2023:10:31 20:00:00"},
+		{ID: exifTagOffsetTimeOriginal, Value: "-05:00"},
+	}})
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "photo.jpg", Content: content, ModTime: time.Now()}})
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "photo.jpg"), targetDir, pkg.Options{Structure: "year-month-day"})
+	if err != nil {
+		t.Fatalf("pkg.SortFile() unexpected error: %v", err)
+	}
+	// 01:00 UTC on November 1st, but the photo was taken on the evening of October 31st.
+	want := filepath.Join(targetDir, "2023", "10", "31", "2023-10-31-200000.jpg")
+	if outcome.TargetPath != want {
+		t.Errorf("pkg.SortFile() target = %s, want %s", outcome.TargetPath, want)
+	}
+}
+
+// TestGetPhotoCreationDateWithTag_Fallbacks tests that the DateTime tag and the GPS date/time
+// stamps are used, in that order, when DateTimeOriginal and DateTimeDigitized are missing.
+func TestGetPhotoCreationDateWithTag_Fallbacks(t *testing.T) {
+	tmpDir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	gpsTime := []exifRational{{Num: 14, Den: 1}, {Num: 5, Den: 1}, {Num: 3050, Den: 100}}
+
+	tests := []struct {
+		name         string
+		spec         exifSpec
+		expectedTime time.Time
+		expectedTag  string
+	}{
+		{
+			name:         "only DateTime",
+			spec:         exifSpec{IFD0: []exifTag{{ID: exifTagDateTime, Value: "2019:07:04 18:30:00"}}},
+			expectedTime: time.Date(2019, 7, 4, 18, 30, 0, 0, time.UTC),
+			expectedTag:  pkg.DateTagDateTime,
+		},
+		{
+			name: "only GPS date and time",
+			spec: exifSpec{GPS: []exifTag{
+				{ID: exifTagGPSDateStamp, Value: "2020:02:29"},
+				{ID: exifTagGPSTimeStamp, Value: gpsTime},
+			}},
+			expectedTime: time.Date(2020, 2, 29, 14, 5, 30, 500000000, time.UTC),
+			expectedTag:  pkg.DateTagGPS,
+		},
+		{
+			name:         "only GPS date",
+			spec:         exifSpec{GPS: []exifTag{{ID: exifTagGPSDateStamp, Value: "2020:02:29"}}},
+			expectedTime: time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC),
+			expectedTag:  pkg.DateTagGPS,
+		},
+		{
+			name: "DateTime preferred over GPS",
+			spec: exifSpec{
+				IFD0: []exifTag{{ID: exifTagDateTime, Value: "2019:07:04 18:30:00"}},
+				GPS:  []exifTag{{ID: exifTagGPSDateStamp, Value: "2020:02:29"}, {ID: exifTagGPSTimeStamp, Value: gpsTime}},
+			},
+			expectedTime: time.Date(2019, 7, 4, 18, 30, 0, 0, time.UTC),
+			expectedTag:  pkg.DateTagDateTime,
+		},
+		{
+			name: "DateTimeDigitized preferred over DateTime",
+			spec: exifSpec{
+				IFD0: []exifTag{{ID: exifTagDateTime, Value: "2019:07:04 18:30:00"}},
+				Exif: []exifTag{{ID: exifTagDateTimeDigitized, Value: "2018:01:02 03:04:05"}},
+			},
+			expectedTime: time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+			expectedTag:  pkg.DateTagDateTimeDigitized,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, fmt.Sprintf("photo%d.jpg", i))
+			if err := os.WriteFile(path, jpegWithExif(t, img, tt.spec), 0644); err != nil {
+				t.Fatalf("Failed to write test image: %v", err)
+			}
+			date, tag, err := pkg.GetPhotoCreationDateWithTag(path)
+			if err != nil {
+				t.Fatalf("pkg.GetPhotoCreationDateWithTag() unexpected error: %v", err)
+			}
+			if !date.Equal(tt.expectedTime) || tag != tt.expectedTag {
+				t.Errorf("pkg.GetPhotoCreationDateWithTag() = (%v, %q), expected (%v, %q)", date, tag, tt.expectedTime, tt.expectedTag)
+			}
+		})
+	}
+
+	// Without any date tag the error is still ErrNoExifDate.
+	noDate := filepath.Join(tmpDir, "nodate.jpg")
+	if err := os.WriteFile(noDate, jpegWithExif(t, img, exifSpec{IFD0: []exifTag{{ID: exifTagMake, Value: "Camera"}}}), 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+	if _, _, err := pkg.GetPhotoCreationDateWithTag(noDate); !errors.Is(err, pkg.ErrNoExifDate) {
+		t.Errorf("pkg.GetPhotoCreationDateWithTag() error = %v, expected ErrNoExifDate", err)
+	}
+}
+
+// TestCreateTargetDirectoryWithLayout_Presets tests that every structure preset produces the
+// expected directory for a fixed date, and that unknown presets are rejected.
+func TestCreateTargetDirectoryWithLayout_Presets(t *testing.T) {
+	baseDir := t.TempDir()
+	date := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		preset      string
+		expectedRel string
+	}{
+		{"year", "2023"},
+		{"year-month", filepath.Join("2023", "10")},
+		{"year-month-day", filepath.Join("2023", "10", "27")},
+		{"week", filepath.Join("2023", "W43")},
+		{"flat", ""},
+	}
+	for _, tt := range tests {
+		layout, err := pkg.StructureLayout(tt.preset)
+		if err != nil {
+			t.Fatalf("pkg.StructureLayout(%q) unexpected error: %v", tt.preset, err)
+		}
+		dir, err := pkg.CreateTargetDirectoryWithLayout(baseDir, date, layout)
+		if err != nil {
+			t.Fatalf("pkg.CreateTargetDirectoryWithLayout(%q) unexpected error: %v", layout, err)
+		}
+		expected := filepath.Join(baseDir, tt.expectedRel)
+		if dir != expected {
+			t.Errorf("preset %q: directory = %s, expected %s", tt.preset, dir, expected)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("preset %q: directory %s was not created", tt.preset, dir)
+		}
+	}
+
+	if _, err := pkg.StructureLayout("byYearOnly"); err == nil {
+		t.Errorf("pkg.StructureLayout(\"byYearOnly\") expected an error, got nil")
+	}
+}
+
+// TestCreateTargetDirectoryWithMode tests that the date directories get exactly the requested mode.
+func TestCreateTargetDirectoryWithMode(t *testing.T) {
+	baseDir := t.TempDir()
+	date := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+
+	dir, err := pkg.CreateTargetDirectoryWithMode(baseDir, date, pkg.DefaultDirectoryLayout, 0750)
+	if err != nil {
+		t.Fatalf("pkg.CreateTargetDirectoryWithMode() unexpected error: %v", err)
+	}
+	for _, d := range []string{filepath.Join(baseDir, "2023"), dir} {
+		info, err := os.Stat(d)
+		if err != nil {
+			t.Fatalf("directory %s was not created: %v", d, err)
+		}
+		if info.Mode().Perm() != 0750 {
+			t.Errorf("directory %s has mode %v, expected 0750", d, info.Mode().Perm())
+		}
+	}
+}
+
+// TestFormatDirectoryLayout_ISOWeek tests the week preset around New Year, where the ISO week-numbering
+// year differs from the calendar year.
+func TestFormatDirectoryLayout_ISOWeek(t *testing.T) {
+	layout, err := pkg.StructureLayout("week")
+	if err != nil {
+		t.Fatalf("pkg.StructureLayout(\"week\") unexpected error: %v", err)
+	}
+	tests := []struct {
+		date     time.Time
+		expected string
+	}{
+		{time.Date(2023, 7, 12, 10, 0, 0, 0, time.UTC), "2023/W28"},
+		{time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC), "2022/W52"},   // A Sunday, in the last week of 2022
+		{time.Date(2021, 1, 3, 10, 0, 0, 0, time.UTC), "2020/W53"},   // 2020 has 53 ISO weeks
+		{time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC), "2023/W01"},   // The Monday after
+		{time.Date(2024, 12, 31, 10, 0, 0, 0, time.UTC), "2025/W01"}, // In the first week of 2025
+	}
+	for _, tt := range tests {
+		if got := pkg.FormatDirectoryLayout(tt.date, layout); got != tt.expected {
+			t.Errorf("pkg.FormatDirectoryLayout(%s, %q) = %s, expected %s", tt.date.Format(time.DateOnly), layout, got, tt.expected)
+		}
+	}
+	if got := pkg.FormatDirectoryLayout(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC), "2006/{isoweek}-Jan"); got != "2023/52-Jan" {
+		t.Errorf("pkg.FormatDirectoryLayout() mixing tokens and layout elements = %s, expected 2023/52-Jan", got)
+	}
+}
+
+func TestValidateDirectoryLayout(t *testing.T) {
+	tests := []struct {
+		layout  string
+		wantErr bool
+	}{
+		{"", false},
+		{"2006", false},
+		{"2006/01-Jan", false},
+		{"Photos/2006/01/02", false},
+		{"{isoyear}/W{isoweek}", false},
+		{"/2006/01", true},
+		{`2006\01`, true},
+		{"2006//01", true},
+		{"2006/../01", true},
+		{"2006/01/", true},
+	}
+	for _, tt := range tests {
+		err := pkg.ValidateDirectoryLayout(tt.layout)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pkg.ValidateDirectoryLayout(%q) error = %v, wantErr %v", tt.layout, err, tt.wantErr)
+		}
+	}
+}
+
+// TestMonthNameLayout tests that each month name format produces the expected month directory for a fixed date.
+func TestMonthNameLayout(t *testing.T) {
+	baseDir := t.TempDir()
+	date := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		preset      string
+		format      string
+		expectedRel string
+	}{
+		{"year-month", pkg.MonthNameNumber, filepath.Join("2023", "10")},
+		{"year-month", pkg.MonthNameShort, "2023-Oct"},
+		{"year-month", pkg.MonthNameLong, "2023-October"},
+		{"year-month", "", filepath.Join("2023", "10")},
+		{"year-month-day", pkg.MonthNameLong, filepath.Join("2023-October", "27")},
+		{"year", pkg.MonthNameShort, "2023"},
+	}
+	for _, tt := range tests {
+		preset, err := pkg.StructureLayout(tt.preset)
+		if err != nil {
+			t.Fatalf("pkg.StructureLayout(%q) unexpected error: %v", tt.preset, err)
+		}
+		layout, err := pkg.MonthNameLayout(preset, tt.format)
+		if err != nil {
+			t.Fatalf("pkg.MonthNameLayout(%q, %q) unexpected error: %v", preset, tt.format, err)
+		}
+		dir, err := pkg.CreateTargetDirectoryWithLayout(baseDir, date, layout)
+		if err != nil {
+			t.Fatalf("pkg.CreateTargetDirectoryWithLayout(%q) unexpected error: %v", layout, err)
+		}
+		if expected := filepath.Join(baseDir, tt.expectedRel); dir != expected {
+			t.Errorf("preset %q with month format %q: directory = %s, expected %s", tt.preset, tt.format, dir, expected)
+		}
+	}
+
+	if _, err := pkg.MonthNameLayout(pkg.DefaultDirectoryLayout, "roman"); err == nil {
+		t.Errorf("pkg.MonthNameLayout() with an unknown format expected an error, got nil")
+	}
+}
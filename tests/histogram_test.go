@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// histogramTestImage returns a 64x64 PNG of four colored quadrants with a horizontal gradient,
+// cropped by crop pixels on every side.
+func histogramTestImage(t *testing.T, colors [4]color.RGBA, crop int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := colors[(y/32)*2+x/32]
+			c.R = uint8(min(255, int(c.R)+x/2))
+			img.Set(x, y, c)
+		}
+	}
+	content, err := encodePNG(img.SubImage(image.Rect(crop, crop, 64-crop, 64-crop)))
+	if err != nil {
+		t.Fatalf("encodePNG() error = %v", err)
+	}
+	return content
+}
+
+// upscaledHistogramTestImage returns the uncropped histogramTestImage scaled up to 128x128, which has
+// the same color histogram but different pixels and a higher resolution.
+func upscaledHistogramTestImage(t *testing.T, colors [4]color.RGBA) []byte {
+	t.Helper()
+	small, err := png.Decode(bytes.NewReader(histogramTestImage(t, colors, 0)))
+	if err != nil {
+		t.Fatalf("png.Decode() error = %v", err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			img.Set(x, y, small.At(x/2, y/2))
+		}
+	}
+	content, err := encodePNG(img)
+	if err != nil {
+		t.Fatalf("encodePNG() error = %v", err)
+	}
+	return content
+}
+
+var (
+	histogramColors = [4]color.RGBA{{R: 200, G: 30, B: 30, A: 255}, {R: 20, G: 160, B: 40, A: 255}, {R: 30, G: 40, B: 220, A: 255}, {R: 220, G: 220, B: 40, A: 255}}
+	unrelatedColors = [4]color.RGBA{{R: 90, G: 90, B: 90, A: 255}, {R: 10, G: 10, B: 10, A: 255}, {R: 140, G: 100, B: 160, A: 255}, {R: 250, G: 250, B: 250, A: 255}}
+)
+
+func TestColorHistogram(t *testing.T) {
+	dir := t.TempDir()
+	createTestFiles(t, dir, []fileSpec{
+		{Path: "original.png", Content: histogramTestImage(t, histogramColors, 0), ModTime: sortFileTime},
+		{Path: "cropped.png", Content: histogramTestImage(t, histogramColors, 3), ModTime: sortFileTime},
+		{Path: "unrelated.png", Content: histogramTestImage(t, unrelatedColors, 0), ModTime: sortFileTime},
+	})
+	histogram := func(name string) []float64 {
+		h, err := pkg.ColorHistogram(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("ColorHistogram(%s) error = %v", name, err)
+		}
+		return h
+	}
+	original, cropped, unrelated := histogram("original.png"), histogram("cropped.png"), histogram("unrelated.png")
+
+	sum := 0.0
+	for _, share := range original {
+		sum += share
+	}
+	if sum < 0.999 || sum > 1.001 {
+		t.Errorf("ColorHistogram() shares sum to %v, want 1", sum)
+	}
+	if got := pkg.HistogramSimilarity(original, original); got < 0.999 {
+		t.Errorf("HistogramSimilarity() of an image with itself = %v, want 1", got)
+	}
+	if got := pkg.HistogramSimilarity(original, cropped); got < 0.9 {
+		t.Errorf("HistogramSimilarity() of a slightly cropped copy = %v, want at least 0.9", got)
+	}
+	if got := pkg.HistogramSimilarity(original, unrelated); got > 0.2 {
+		t.Errorf("HistogramSimilarity() of an unrelated image = %v, want at most 0.2", got)
+	}
+	if got := pkg.HistogramSimilarity(original, original[:10]); got != 0 {
+		t.Errorf("HistogramSimilarity() of histograms of different lengths = %v, want 0", got)
+	}
+	if _, err := pkg.ColorHistogram(filepath.Join(dir, "missing.png")); err == nil {
+		t.Errorf("ColorHistogram() of a missing file: expected an error")
+	}
+}
+
+func TestSortFile_HistogramThreshold(t *testing.T) {
+	targetFile := filepath.Join("2023", "10", "2023-10-27-153000.png")
+	tests := []struct {
+		name       string
+		source     []byte
+		threshold  float64
+		wantReason pkg.Reason
+	}{
+		{"cropped copy", histogramTestImage(t, histogramColors, 3), 0.9, pkg.ReasonHistogramMatch},
+		{"cropped copy without threshold", histogramTestImage(t, histogramColors, 3), 0, pkg.ReasonNameCollision},
+		{"unrelated image", histogramTestImage(t, unrelatedColors, 3), 0.9, pkg.ReasonNameCollision},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir, targetDir := setupTestDirs(t)
+			createTestFiles(t, targetDir, []fileSpec{{Path: targetFile, Content: histogramTestImage(t, histogramColors, 0), ModTime: sortFileTime}})
+			createTestFiles(t, sourceDir, []fileSpec{{Path: "edit.png", Content: tt.source, ModTime: sortFileTime}})
+
+			outcome, err := pkg.SortFile(filepath.Join(sourceDir, "edit.png"), targetDir, pkg.Options{HistogramThreshold: tt.threshold})
+			if err != nil {
+				t.Fatalf("SortFile() error = %v", err)
+			}
+			if outcome.Copied || outcome.Duplicate == nil {
+				t.Fatalf("SortFile() = copied %v, duplicate %v, want the source discarded", outcome.Copied, outcome.Duplicate)
+			}
+			if outcome.Duplicate.Reason != tt.wantReason || outcome.Duplicate.KeptFile != filepath.Join(targetDir, targetFile) {
+				t.Errorf("SortFile() duplicate = %+v, want reason %s with the larger target kept", *outcome.Duplicate, tt.wantReason)
+			}
+		})
+	}
+
+	t.Run("higher resolution source", func(t *testing.T) {
+		sourceDir, targetDir := setupTestDirs(t)
+		targetContent := histogramTestImage(t, histogramColors, 0)
+		createTestFiles(t, targetDir, []fileSpec{{Path: targetFile, Content: targetContent, ModTime: sortFileTime}})
+		createTestFiles(t, sourceDir, []fileSpec{{Path: "other.png", Content: upscaledHistogramTestImage(t, histogramColors), ModTime: sortFileTime}})
+
+		opts := pkg.Options{HistogramThreshold: 0.9, PreferNewer: true, PreferLargerFile: true}
+		outcome, err := pkg.SortFile(filepath.Join(sourceDir, "other.png"), targetDir, opts)
+		if err != nil {
+			t.Fatalf("SortFile() error = %v", err)
+		}
+		if outcome.Copied || outcome.Duplicate == nil || outcome.Duplicate.Replaced || outcome.Duplicate.Reason != pkg.ReasonHistogramMatch {
+			t.Fatalf("SortFile() = copied %v, duplicate %+v, want the source discarded as a histogram match", outcome.Copied, outcome.Duplicate)
+		}
+		if got, err := os.ReadFile(filepath.Join(targetDir, targetFile)); err != nil || !bytes.Equal(got, targetContent) {
+			t.Errorf("target was changed by a histogram match (read error %v)", err)
+		}
+	})
+
+	if err := (pkg.Options{HistogramThreshold: 1.5}).Validate(); err == nil {
+		t.Errorf("Validate() with a histogram threshold above 1: expected an error")
+	}
+}
@@ -0,0 +1,25 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+func TestOnCopyArgs(t *testing.T) {
+	got := pkg.OnCopyArgs("  exiftool -Artist=Me  {dst} --from={src} ", "/in/my photo.jpg", "/out/2023/10/a.jpg")
+	want := []string{"exiftool", "-Artist=Me", "/out/2023/10/a.jpg", "--from=/in/my photo.jpg"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OnCopyArgs() = %q, want %q", got, want)
+	}
+	if args := pkg.OnCopyArgs("   ", "src", "dst"); len(args) != 0 {
+		t.Errorf("OnCopyArgs() of a blank template = %q, want no arguments", args)
+	}
+}
+
+func TestRunOnCopy_Failure(t *testing.T) {
+	if _, err := pkg.RunOnCopy("photo-sorter-no-such-command {dst}", "src", "dst"); err == nil {
+		t.Error("RunOnCopy() with a missing command succeeded, want error")
+	}
+}
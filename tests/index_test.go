@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// TestTargetIndex_RoundTripAndStale tests that an index survives a JSON round trip and that
+// changed, removed and added files are detected and refreshed.
+func TestTargetIndex_RoundTripAndStale(t *testing.T) {
+	targetDir := t.TempDir()
+	createScanTestDir(t, targetDir, map[string][]byte{
+		"2024/01/a.png": duplicates_pngMinimal_2x2_Red,
+		"2024/02/b.png": duplicates_pngMinimal_1x1_Blue,
+		"2024/02/c.png": duplicates_pngMinimal_2x2_Red, // Same content as a.png
+		"notes.txt":     []byte("not indexed"),
+	})
+
+	idx, err := pkg.BuildTargetIndex(targetDir)
+	if err != nil {
+		t.Fatalf("pkg.BuildTargetIndex() unexpected error: %v", err)
+	}
+	if len(idx.Entries) != 3 {
+		t.Fatalf("pkg.BuildTargetIndex() indexed %d files, expected 3: %v", len(idx.Entries), idx.Entries)
+	}
+	redHash := idx.Entries["2024/01/a.png"].FileHash
+	if got := idx.PathsForFileHash(redHash); !reflect.DeepEqual(got, []string{"2024/01/a.png", "2024/02/c.png"}) {
+		t.Errorf("PathsForFileHash() = %v, expected a.png and c.png", got)
+	}
+	redPixels := idx.Entries["2024/01/a.png"].PixelHash
+	if redPixels == "" || !reflect.DeepEqual(idx.PathsForPixelHash(redPixels), []string{"2024/01/a.png", "2024/02/c.png"}) {
+		t.Errorf("PathsForPixelHash(%q) = %v, expected a.png and c.png", redPixels, idx.PathsForPixelHash(redPixels))
+	}
+
+	indexPath := filepath.Join(targetDir, pkg.IndexFileName)
+	if err := idx.Save(indexPath); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+	loaded := &pkg.TargetIndex{}
+	if err := loaded.Load(indexPath); err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if len(loaded.Entries) != len(idx.Entries) {
+		t.Fatalf("Load() returned %d entries, expected %d", len(loaded.Entries), len(idx.Entries))
+	}
+	for relPath, entry := range idx.Entries {
+		got := loaded.Entries[relPath]
+		if got.FileHash != entry.FileHash || got.PixelHash != entry.PixelHash || got.Size != entry.Size || !got.ModTime.Equal(entry.ModTime) {
+			t.Errorf("Load() entry %s = %+v, expected %+v", relPath, got, entry)
+		}
+	}
+	if stale := loaded.Stale(targetDir); len(stale) != 0 {
+		t.Errorf("Stale() = %v right after building, expected none", stale)
+	}
+
+	// Change b.png, remove c.png and add d.png.
+	bPath := filepath.Join(targetDir, "2024", "02", "b.png")
+	if err := os.WriteFile(bPath, duplicates_pngMinimal_1x1_Red, 0644); err != nil {
+		t.Fatalf("Failed to modify b.png: %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(bPath, future, future); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+	if err := os.Remove(filepath.Join(targetDir, "2024", "02", "c.png")); err != nil {
+		t.Fatalf("Failed to remove c.png: %v", err)
+	}
+	createScanTestDir(t, targetDir, map[string][]byte{"2024/03/d.png": duplicates_pngMinimal_1x1_Blue})
+
+	if stale := loaded.Stale(targetDir); !reflect.DeepEqual(stale, []string{"2024/02/b.png", "2024/02/c.png"}) {
+		t.Errorf("Stale() = %v, expected b.png and c.png", stale)
+	}
+	if err := loaded.Refresh(targetDir); err != nil {
+		t.Fatalf("Refresh() unexpected error: %v", err)
+	}
+	if stale := loaded.Stale(targetDir); len(stale) != 0 {
+		t.Errorf("Stale() = %v after Refresh, expected none", stale)
+	}
+	expectedPaths := []string{"2024/01/a.png", "2024/02/b.png", "2024/03/d.png"}
+	var paths []string
+	for relPath := range loaded.Entries {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("Refresh() entries = %v, expected %v", paths, expectedPaths)
+	}
+	if loaded.Entries["2024/02/b.png"].FileHash == idx.Entries["2024/02/b.png"].FileHash {
+		t.Errorf("Refresh() did not re-hash the modified b.png")
+	}
+}
+
+// TestTargetIndex_ExtensionDuplicates tests that only sources whose extension is not preferred over that of
+// an identical indexed file are discarded, and that indexed files that are gone are ignored.
+func TestTargetIndex_ExtensionDuplicates(t *testing.T) {
+	targetDir := t.TempDir()
+	createScanTestDir(t, targetDir, map[string][]byte{
+		"2024/01/a.jpg":  duplicates_pngMinimal_2x2_Red,
+		"2024/01/b.jpeg": duplicates_pngMinimal_1x1_Blue,
+	})
+	idx, err := pkg.BuildTargetIndex(targetDir)
+	if err != nil {
+		t.Fatalf("pkg.BuildTargetIndex() unexpected error: %v", err)
+	}
+	sourceDir := t.TempDir()
+	createScanTestDir(t, sourceDir, map[string][]byte{
+		"red.jpeg": duplicates_pngMinimal_2x2_Red,  // Indexed as the preferred .jpg
+		"blue.jpg": duplicates_pngMinimal_1x1_Blue, // Preferred over the indexed .jpeg
+	})
+	sources := []string{filepath.Join(sourceDir, "blue.jpg"), filepath.Join(sourceDir, "red.jpeg")}
+	logger := pkg.NewLogger(io.Discard, slog.LevelInfo, false)
+
+	remaining, duplicates := idx.ExtensionDuplicates(targetDir, sources, pkg.ParseExtensionOrder("jpg,jpeg"), logger)
+	if !reflect.DeepEqual(remaining, sources[:1]) {
+		t.Errorf("ExtensionDuplicates() remaining = %v, expected only the preferred blue.jpg", remaining)
+	}
+	if len(duplicates) != 1 || duplicates[0].DiscardedFile != sources[1] || duplicates[0].KeptFile != filepath.Join(targetDir, "2024", "01", "a.jpg") {
+		t.Errorf("ExtensionDuplicates() duplicates = %+v, expected red.jpeg discarded for a.jpg", duplicates)
+	}
+
+	if err := os.Remove(filepath.Join(targetDir, "2024", "01", "a.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, duplicates = idx.ExtensionDuplicates(targetDir, sources, pkg.ParseExtensionOrder("jpg,jpeg"), logger); len(duplicates) != 0 || len(remaining) != 2 {
+		t.Errorf("ExtensionDuplicates() with a removed indexed file = %v remaining, %+v duplicates, expected both sources remaining", remaining, duplicates)
+	}
+}
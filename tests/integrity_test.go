@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// truncatedJPEG returns a 64x64 JPEG cut off halfway through its image data, as left behind by an
+// interrupted download: its header is intact, so its resolution can still be read.
+func truncatedJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8(x * y), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+	return buf.Bytes()[:buf.Len()/2]
+}
+
+func TestValidateImageIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	createTestFiles(t, dir, []fileSpec{
+		{Path: "good.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "truncated.jpg", Content: truncatedJPEG(t), ModTime: sortFileTime},
+		{Path: "damaged.png", Content: append(append([]byte{}, pngMinimal_2x2_A[:40]...), bytes.Repeat([]byte{0xAB}, 40)...), ModTime: sortFileTime},
+		{Path: "raw.cr2", Content: []byte("II*\x00 not decodable"), ModTime: sortFileTime},
+		{Path: "empty.jpg", Content: []byte{}, ModTime: sortFileTime},
+	})
+
+	if err := pkg.ValidateImageIntegrity(filepath.Join(dir, "good.png")); err != nil {
+		t.Errorf("ValidateImageIntegrity(good.png) error = %v, want nil", err)
+	}
+	if _, _, err := pkg.GetImageResolution(filepath.Join(dir, "truncated.jpg")); err != nil {
+		t.Fatalf("GetImageResolution(truncated.jpg) error = %v, want the header to be readable", err)
+	}
+	for _, name := range []string{"truncated.jpg", "damaged.png", "empty.jpg"} {
+		if err := pkg.ValidateImageIntegrity(filepath.Join(dir, name)); !errors.Is(err, pkg.ErrCorruptImage) || errors.Is(err, image.ErrFormat) {
+			t.Errorf("ValidateImageIntegrity(%s) error = %v, want ErrCorruptImage", name, err)
+		}
+	}
+	if err := pkg.ValidateImageIntegrity(filepath.Join(dir, "raw.cr2")); !errors.Is(err, image.ErrFormat) || errors.Is(err, pkg.ErrCorruptImage) {
+		t.Errorf("ValidateImageIntegrity(raw.cr2) error = %v, want an unsupported format", err)
+	}
+}
+
+func TestSortFile_CorruptImage(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "partial.jpg", Content: truncatedJPEG(t), ModTime: sortFileTime}})
+	sourcePath := filepath.Join(sourceDir, "partial.jpg")
+
+	outcome, err := pkg.SortFile(sourcePath, targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	if !outcome.Copied || outcome.CorruptReason == "" || outcome.PixelHashUnsupported {
+		t.Errorf("SortFile() = %+v, want the corrupt image copied and flagged corrupt rather than unsupported", outcome)
+	}
+
+	quarantineDir := t.TempDir()
+	_, err = pkg.SortFile(sourcePath, t.TempDir(), pkg.Options{QuarantineDir: quarantineDir})
+	if !errors.Is(err, pkg.ErrCorruptImage) {
+		t.Errorf("SortFile() with a quarantine directory error = %v, want ErrCorruptImage", err)
+	}
+	if _, statErr := os.Stat(sourcePath); statErr != nil {
+		t.Errorf("source was removed: %v", statErr)
+	}
+}
+
+// TestSortFile_CorruptImage_NoPixelComparison tests that images are not decoded, and so not found
+// corrupt, when the comparison does not need their pixel hash.
+func TestSortFile_CorruptImage_NoPixelComparison(t *testing.T) {
+	sourceDir, _ := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "partial.jpg", Content: truncatedJPEG(t), ModTime: sortFileTime}})
+	sourcePath := filepath.Join(sourceDir, "partial.jpg")
+
+	for name, opts := range map[string]pkg.Options{
+		"fileOnly":      {CompareAlgorithm: pkg.CompareFileOnly},
+		"exifOnly":      {CompareAlgorithm: pkg.CompareExifOnly},
+		"exifPrefilter": {ExifPrefilter: true},
+	} {
+		opts.QuarantineDir = t.TempDir()
+		outcome, err := pkg.SortFile(sourcePath, t.TempDir(), opts)
+		if err != nil {
+			t.Fatalf("%s: SortFile() error = %v", name, err)
+		}
+		if !outcome.Copied || outcome.CorruptReason != "" {
+			t.Errorf("%s: SortFile() = %+v, want the image copied without being decoded", name, outcome)
+		}
+	}
+}
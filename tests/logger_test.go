@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"Warning", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := pkg.ParseLogLevel(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLogLevel(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLogLevel(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestNewLogger tests that records below the level are dropped and that JSON output is one object per line.
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := pkg.NewLogger(&buf, slog.LevelInfo, true)
+	logger.Debug("hidden")
+	logger.Info("Copied file", "source", "a.jpg")
+	logger.Warn("Careful")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("NewLogger() wrote %d lines, want 2: %q", len(lines), buf.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("NewLogger() output is not JSON: %v", err)
+	}
+	if record["level"] != "INFO" || record["msg"] != "Copied file" || record["source"] != "a.jpg" {
+		t.Errorf("NewLogger() record = %v, want level INFO, msg \"Copied file\", source a.jpg", record)
+	}
+
+	buf.Reset()
+	pkg.NewLogger(&buf, slog.LevelDebug, false).Debug("Shown", "count", 2)
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "count=2") {
+		t.Errorf("NewLogger() text output = %q, want a DEBUG record with count=2", buf.String())
+	}
+}
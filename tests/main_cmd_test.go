@@ -1,12 +1,25 @@
 package tests
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"image"
 	"image/color"
+	"image/jpeg"
 	"image/png"
+	"io"
+	"io/fs"
 	"log"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -218,7 +231,7 @@ func TestRunApplicationLogic_SourceHasTwoIdenticalFiles_TargetEmpty(t *testing.T
 	// And that the KeptFile is the single copied file.
 	assert.Contains(t, []string{fullSourceFile1Path, fullSourceFile2Path}, duplicates[0].DiscardedFile, "Discarded file should be one of the original source paths")
 
-	assert.Contains(t, duplicates[0].Reason, pkg.ReasonPixelHashMatch, "Reason should indicate a pixel hash match")
+	assert.Equal(t, pkg.ReasonSourceDuplicate, duplicates[0].Reason, "Identical source files should be grouped before reaching the target")
 
 	_, statErr := os.Stat(expectedTargetFilePath)
 	assert.NoError(t, statErr, "Expected target file %s (copy of the first source file) to exist", expectedTargetFilePath)
@@ -262,7 +275,8 @@ func TestRunApplicationLogic_TargetExists_SourceDifferentContent_TargetPreserved
 	assert.Equal(t, expectedTargetFilePath, duplicates[0].KeptFile)
 	assert.Equal(t, fullSourceFilePath, duplicates[0].DiscardedFile)
 	// In main.go, if AreFilesPotentiallyDuplicate is false for a name collision, this reason is used.
-	assert.Equal(t, "Content different, but name collision; existing target preserved", duplicates[0].Reason)
+	assert.Equal(t, pkg.ReasonNameCollision, duplicates[0].Reason)
+	assert.Equal(t, "content different, existing target preserved", duplicates[0].Detail)
 
 	_, statErr := os.Stat(expectedTargetFilePath) // Original target
 	assert.NoError(t, statErr, "Target file should still exist and be unchanged")
@@ -310,7 +324,8 @@ func TestRunApplicationLogic_TargetExists_SourceDifferentContent_TargetPreserved
 
 	assert.Equal(t, expectedTargetFilePath, duplicates[0].KeptFile)
 	assert.Equal(t, fullSourceFilePath, duplicates[0].DiscardedFile)
-	assert.Equal(t, "Content different, but name collision; existing target preserved", duplicates[0].Reason)
+	assert.Equal(t, pkg.ReasonNameCollision, duplicates[0].Reason)
+	assert.Equal(t, "content different, existing target preserved", duplicates[0].Detail)
 
 	_, statErr := os.Stat(expectedTargetFilePath) // Original target
 	assert.NoError(t, statErr, "Original target file should still exist and be unchanged")
@@ -356,7 +371,8 @@ func TestRunApplicationLogic_TargetExists_SourceDifferentFileSameName(t *testing
 
 	assert.Equal(t, expectedTargetFilePath, duplicates[0].KeptFile)
 	assert.Equal(t, fullSourceFilePath, duplicates[0].DiscardedFile)
-	assert.Equal(t, "Content different, but name collision; existing target preserved", duplicates[0].Reason)
+	assert.Equal(t, pkg.ReasonNameCollision, duplicates[0].Reason)
+	assert.Equal(t, "content different, existing target preserved", duplicates[0].Detail)
 
 	_, statErr := os.Stat(expectedTargetFilePath)
 	assert.NoError(t, statErr, "Original target file should still exist and be unchanged")
@@ -423,11 +439,13 @@ func TestRunApplicationLogic_PixelHashUnsupported_FallbackToFileHash(t *testing.
 	for _, dup := range duplicates {
 		if dup.DiscardedFile == sourceFilePathS1 {
 			assert.Equal(t, expectedTargetFilePath, dup.KeptFile)
-			assert.Equal(t, pkg.ReasonFileHashMatch+" (existing target kept)", dup.Reason, "S1 should be a file hash match with T1")
+			assert.Equal(t, pkg.ReasonFileHashMatch, dup.Reason, "S1 should be a file hash match with T1")
+			assert.Equal(t, "existing target kept", dup.Detail)
 			dupS1Found = true
 		} else if dup.DiscardedFile == sourceFilePathS2 {
 			assert.Equal(t, expectedTargetFilePath, dup.KeptFile)
-			assert.Equal(t, "Content different, but name collision; existing target preserved", dup.Reason, "S2 should be discarded as different content with name collision")
+			assert.Equal(t, pkg.ReasonNameCollision, dup.Reason, "S2 should be discarded as different content with name collision")
+			assert.Equal(t, "content different, existing target preserved", dup.Detail)
 			dupS2Found = true
 		}
 	}
@@ -477,7 +495,7 @@ func TestRunApplicationLogic_SourceConflictsWithIdenticalTarget_MarkedDuplicate(
 	assert.Equal(t, expectedTargetFilePath, dup.KeptFile)
 	assert.Equal(t, fullSourceFilePath, dup.DiscardedFile)
 	// Since they are identical PNGs, it should be a pixel hash match.
-	assert.Contains(t, dup.Reason, pkg.ReasonPixelHashMatch)
+	assert.Equal(t, pkg.ReasonPixelHashMatch, dup.Reason)
 
 	_, statErr := os.Stat(expectedTargetFilePath)
 	assert.NoError(t, statErr, "Target file should still exist")
@@ -503,9 +521,7 @@ func TestRunApplicationLogic_SequentialSourceToSameTarget(t *testing.T) {
 	s2Path := "s2_different.png"
 	s3Path := "s3_same_as_s1.png"
 
-	// Ensure a specific processing order for deterministic testing if possible,
-	// by naming files alphabetically or relying on Go's file walk order (usually alphabetical).
-	// For this test, file names s1, s2, s3 should lead to that processing order.
+	// Source files are processed in lexicographic order, so s1, s2, s3 are processed in that order.
 	sourceFiles := []fileSpec{
 		{Path: s1Path, Content: pngMinimal_2x2_A, ModTime: photoTime}, // S1 - Content A
 		{Path: s2Path, Content: pngMinimal_2x2_B, ModTime: photoTime}, // S2 - Content B (different from A)
@@ -542,10 +558,11 @@ func TestRunApplicationLogic_SequentialSourceToSameTarget(t *testing.T) {
 		assert.Equal(t, expectedTargetForS1, dup.KeptFile, "KeptFile for all duplicates should be the path of S1's copy")
 		if dup.DiscardedFile == fullS2Path {
 			s2Discarded = true
-			assert.Equal(t, "Content different, but name collision; existing target preserved", dup.Reason, "Reason for S2 discard")
+			assert.Equal(t, pkg.ReasonNameCollision, dup.Reason, "Reason for S2 discard")
+			assert.Equal(t, "content different, existing target preserved", dup.Detail)
 		} else if dup.DiscardedFile == fullS3Path {
 			s3Discarded = true
-			assert.Contains(t, dup.Reason, pkg.ReasonPixelHashMatch, "Reason for S3 discard should be pixel hash match")
+			assert.Equal(t, pkg.ReasonSourceDuplicate, dup.Reason, "S3 is identical to S1 and should be grouped with it")
 		}
 	}
 	assert.True(t, s2Discarded, "S2 should be in discarded list")
@@ -556,6 +573,31 @@ func TestRunApplicationLogic_SequentialSourceToSameTarget(t *testing.T) {
 	assert.Len(t, dirEntries, 1, "Only S1's copy should be in the target directory")
 }
 
+func TestRunApplicationLogic_LexicographicOrderDecidesKeeper(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+
+	// filepath.Walk visits "DCIM/b.png" before "DCIM-backup.png", but '-' sorts before '/', and
+	// uppercase letters sort before lowercase ones, so "DCIM-backup.png" comes first.
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: filepath.Join("DCIM", "b.png"), Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: "DCIM-backup.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+	})
+
+	_, copied, _, duplicates, _, err := photocp.RunApplicationLogic(sourceDir, targetDir, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, copied)
+	keptTarget := filepath.Join(targetDir, "2024", "03", "2024-03-01-100000.png")
+	assert.FileExists(t, keptTarget, "DCIM-backup.png should be the one copied")
+
+	require.Len(t, duplicates, 2)
+	discarded := []string{duplicates[0].DiscardedFile, duplicates[1].DiscardedFile}
+	assert.ElementsMatch(t, []string{filepath.Join(sourceDir, "a.png"), filepath.Join(sourceDir, "DCIM", "b.png")}, discarded)
+	for _, dup := range duplicates {
+		assert.Equal(t, keptTarget, dup.KeptFile)
+	}
+}
+
 // TODO: Add more tests:
 // - Pixel hash unsupported for an actual image type (e.g. if pkg.IsImageExtension says true, but image.Decode fails or it's a format not in stdlib image decoders)
 //   This is partly covered by TestRunApplicationLogic_PixelHashUnsupported_FallbackToFileHash, but could be more specific with an image that's not text.
@@ -591,17 +633,9 @@ func TestRunApplicationLogic_HEICSupport(t *testing.T) {
 	assert.Equal(t, 1, filesToCopy, "Files to copy should be 1") // filesToCopy == copied
 	assert.Len(t, duplicates, 0, "Should be no duplicates for a single HEIC file to empty target")
 
-	// Behavior of pixelHashUnsupported depends on heif-go's ability to decode the placeholder.
-	// If heif-go decodes it (even if to a default image), unsupported might be 0.
-	// If heif-go fails and it falls back to file hash, unsupported might be 1.
-	// We'll be flexible here or assert based on expected heif-go behavior with minimal files.
-	// Given it's "simulated heic content", heif-go will likely fail to decode it.
-	// And pkg.IsImageExtension("sampleA.heic") is true.
-	// So, pixel hash will be attempted, fail, and it will be marked as unsupported
-	// IF AND ONLY IF it goes through the duplicate check path.
-	// Since it's a direct copy to an empty target, it will NOT go through duplicate check.
-	// Thus, pixelHashUnsupported will be 0 based on current main.go logic.
-	assert.Equal(t, 0, pixelHashUnsupported, "Pixel hash should be 0 for a directly copied file, as it doesn't go through duplicate check logic where this is counted.")
+	// "simulated heic content" cannot be decoded, and pkg.IsImageExtension("sampleA.heic") is true,
+	// so the file is counted as not pixel hashable even though it was copied to an empty target.
+	assert.Equal(t, 1, pixelHashUnsupported, "A directly copied file that cannot be pixel hashed should be counted")
 
 	// Verify the file was copied to the correct location based on ModTime
 	expectedTargetFilename := heicModTime.Format(testDateFormat) + ".heic"
@@ -628,7 +662,1977 @@ func TestRunApplicationLogic_HEICSupport(t *testing.T) {
 	assert.Contains(t, reportStr, "Total files scanned: 1", "Report: Files Processed count incorrect")
 	assert.Contains(t, reportStr, "Files successfully copied: 1", "Report: Files Copied count incorrect")
 	assert.Contains(t, reportStr, "Duplicate files found and discarded/skipped: 0", "Report: Duplicates Found count incorrect")
-	// Based on the corrected understanding, pixelHashUnsupported should be 0 for this test case.
-	// Also ensure the report text matches the change from "Files where..." to "Image files where..."
-	assert.Contains(t, reportStr, "Image files where pixel hashing was not supported (fallback to file hash): 0", "Report: Pixel Hash Unsupported count incorrect")
+	assert.Contains(t, reportStr, "Image files where pixel hashing was not supported (fallback to file hash): 1", "Report: Pixel Hash Unsupported count incorrect")
+}
+
+// TestRunApplicationLogic_QuarantineFailedFiles tests that corrupt and empty images are copied into
+// the quarantine directory (preserving their relative source path) instead of the target, and are listed in the report.
+func TestRunApplicationLogic_QuarantineFailedFiles(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	quarantineDir := t.TempDir()
+	photoTime := time.Date(2024, 5, 5, 8, 0, 0, 0, time.UTC)
+
+	// A PNG signature followed by garbage: recognised as PNG, but fails to decode.
+	corruptPNG := append([]byte("\x89PNG\r\n\x1a\n"), []byte("this is not a valid png chunk stream")...)
+	sourceFiles := []fileSpec{
+		{Path: "good.png", Content: pngMinimal_2x2_A, ModTime: photoTime},
+		{Path: "corrupt.png", Content: corruptPNG, ModTime: photoTime},
+		{Path: filepath.Join("sub", "empty.jpg"), Content: []byte{}, ModTime: photoTime},
+	}
+	createTestFiles(t, sourceDir, sourceFiles)
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{QuarantineDir: quarantineDir})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, summary.ProcessedFilesCount)
+	assert.Equal(t, 1, summary.CopiedFilesCount, "Only the valid image should be copied")
+	require.Len(t, summary.Quarantined, 2, "Corrupt and empty images should be quarantined")
+
+	expectedCorrupt := filepath.Join(quarantineDir, "corrupt.png")
+	expectedEmpty := filepath.Join(quarantineDir, "sub", "empty.jpg")
+	corruptContent, readErr := os.ReadFile(expectedCorrupt)
+	require.NoError(t, readErr, "Corrupt image should be in quarantine")
+	assert.Equal(t, corruptPNG, corruptContent)
+	_, statErr := os.Stat(expectedEmpty)
+	assert.NoError(t, statErr, "Empty image should be in quarantine under its relative source path")
+
+	// Only the valid image should have reached the target month directory.
+	dirEntries, _ := os.ReadDir(filepath.Join(targetDir, "2024", "05"))
+	assert.Len(t, dirEntries, 1, "Quarantined files must not be copied to the target")
+
+	reportContent, readReportErr := os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, readReportErr)
+	reportStr := string(reportContent)
+	assert.Contains(t, reportStr, "Quarantined files:")
+	assert.Contains(t, reportStr, "Quarantined to: "+expectedCorrupt)
+	assert.Contains(t, reportStr, "Quarantined to: "+expectedEmpty)
+}
+
+// TestRunApplicationLogic_NoQuarantine_CorruptImageCopied tests that without a quarantine directory
+// an undecodable image keeps the previous behaviour and is copied with an unknown resolution.
+func TestRunApplicationLogic_NoQuarantine_CorruptImageCopied(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	photoTime := time.Date(2024, 5, 6, 8, 0, 0, 0, time.UTC)
+
+	corruptPNG := append([]byte("\x89PNG\r\n\x1a\n"), []byte("truncated")...)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "corrupt.png", Content: corruptPNG, ModTime: photoTime}})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+	assert.Empty(t, summary.Quarantined)
+}
+
+// TestRunApplicationLogic_Flatten tests that in flatten mode files from different months are all
+// written directly into the target root with their full timestamp names.
+func TestRunApplicationLogic_Flatten(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	octTime := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+	decTime := time.Date(2023, 12, 1, 12, 0, 0, 0, time.UTC)
+
+	sourceFiles := []fileSpec{
+		{Path: "october.png", Content: pngMinimal_2x2_A, ModTime: octTime},
+		{Path: "december.png", Content: pngMinimal_2x2_B, ModTime: decTime},
+	}
+	createTestFiles(t, sourceDir, sourceFiles)
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Flatten: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.CopiedFilesCount)
+
+	for _, expectedName := range []string{"2023-10-27-153000.png", "2023-12-01-120000.png"} {
+		_, statErr := os.Stat(filepath.Join(targetDir, expectedName))
+		assert.NoError(t, statErr, "Expected %s directly under the target root", expectedName)
+	}
+
+	_, statErr := os.Stat(filepath.Join(targetDir, "2023"))
+	assert.True(t, os.IsNotExist(statErr), "No year directory should be created in flatten mode")
+
+	dirEntries, _ := os.ReadDir(targetDir)
+	assert.Len(t, dirEntries, 3, "Target root should contain the two photos and the report")
+	_, statErr = os.Stat(filepath.Join(targetDir, "report.txt"))
+	assert.NoError(t, statErr, "Report should be written alongside the flattened photos")
+}
+
+// TestRunApplicationLogic_Flatten_Collision tests that same-timestamp files still go through the
+// existing conflict handling when flattened.
+func TestRunApplicationLogic_Flatten_Collision(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	photoTime := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	sourceFiles := []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: photoTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: photoTime},
+	}
+	createTestFiles(t, sourceDir, sourceFiles)
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Flatten: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+	require.Len(t, summary.Duplicates, 1)
+	assert.Equal(t, filepath.Join(targetDir, "2024-03-10-090000.png"), summary.Duplicates[0].KeptFile)
+	assert.Equal(t, pkg.ReasonNameCollision, summary.Duplicates[0].Reason)
+}
+
+// TestRunApplicationLogic_FilenameFormat tests that a custom filename format replaces the default base name layout.
+func TestRunApplicationLogic_FilenameFormat(t *testing.T) {
+	photoTime := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		format       string
+		expectedName string
+	}{
+		{"compact", "20060102_150405", "20231027_153000.png"},
+		{"dotted", "2006.01.02 15h04", "2023.10.27 15h30.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir, targetDir := setupTestDirs(t)
+			createTestFiles(t, sourceDir, []fileSpec{{Path: "photo.png", Content: pngMinimal_2x2_A, ModTime: photoTime}})
+
+			summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{FilenameFormat: tt.format})
+			require.NoError(t, err)
+			assert.Equal(t, 1, summary.CopiedFilesCount)
+
+			_, statErr := os.Stat(filepath.Join(targetDir, "2023", "10", tt.expectedName))
+			assert.NoError(t, statErr, "Expected target file named %s", tt.expectedName)
+		})
+	}
+
+	t.Run("rejected format with slash", func(t *testing.T) {
+		sourceDir, targetDir := setupTestDirs(t)
+		createTestFiles(t, sourceDir, []fileSpec{{Path: "photo.png", Content: pngMinimal_2x2_A, ModTime: photoTime}})
+
+		_, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{FilenameFormat: "2006/01/02"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "path separators")
+
+		_, statErr := os.Stat(filepath.Join(targetDir, "2023"))
+		assert.True(t, os.IsNotExist(statErr), "Nothing should be written for a rejected format")
+	})
+}
+
+// TestRunApplicationLogic_PerExtensionStatistics tests that copied and duplicate files are tallied by
+// lowercased extension and rendered in the report's "By type" section.
+func TestRunApplicationLogic_PerExtensionStatistics(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+
+	jpegImg := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	fillImage(jpegImg, color.RGBA{G: 255, A: 255})
+	var jpegBuf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpegBuf, jpegImg, nil))
+
+	sourceFiles := []fileSpec{
+		{Path: "a.jpg", Content: jpegBuf.Bytes(), ModTime: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: "b.JPG", Content: pngMinimal_2x2_B, ModTime: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{Path: "c.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)},
+		{Path: "d.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)},             // Duplicate of c.png
+		{Path: "e.png", Content: []byte("text posing as png"), ModTime: time.Date(2024, 1, 4, 10, 0, 0, 0, time.UTC)}, // Copied, not decodable
+	}
+	createTestFiles(t, sourceDir, sourceFiles)
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, summary.CopiedFilesCount)
+	assert.Equal(t, map[string]int{".jpg": 2, ".png": 2}, summary.CopiedByExtension)
+	assert.Equal(t, map[string]int{".png": 1}, summary.DuplicatesByExtension)
+
+	reportContent, readErr := os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, readErr)
+	reportStr := string(reportContent)
+	assert.Contains(t, reportStr, "By type:")
+	assert.Contains(t, reportStr, "  - .jpg: 2 copied, 0 duplicates")
+	assert.Contains(t, reportStr, "  - .png: 2 copied, 1 duplicates")
+}
+
+// TestRunApplicationLogic_DateSourceBreakdown tests that processed files are counted by the category
+// of their date source and rendered in the report's "Date source breakdown" section.
+func TestRunApplicationLogic_DateSourceBreakdown(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	exifJPEG := jpegWithExif(t, image.NewRGBA(image.Rect(0, 0, 2, 2)), exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2022:05:01 12:00:00"}}})
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "exif.jpg", Content: exifJPEG, ModTime: sortFileTime},
+		{Path: "plain.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "other.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},
+		{Path: "IMG_20210304_101112.png", Content: pngMinimal_4x4_A, ModTime: sortFileTime},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{pkg.DateSourceEXIF: 1, pkg.DateSourceFileModTime: 3}, summary.DateSourceCounts)
+
+	summary, err = photocp.RunApplicationLogicWithOptions(sourceDir, t.TempDir(), photocp.Options{DateStrategy: pkg.DateStrategyFilenameFirst})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{pkg.DateSourceEXIF: 1, pkg.DateSourceFilename: 1, pkg.DateSourceFileModTime: 2}, summary.DateSourceCounts)
+
+	reportContent, readErr := os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, readErr)
+	reportStr := string(reportContent)
+	assert.Contains(t, reportStr, "Date source breakdown:\n  - EXIF: 1\n  - Filename: 0\n  - XMP: 0\n  - FileModTime: 3\n  - Override: 0\n")
+}
+
+// TestRunApplicationLogic_DuplicateMethodBreakdown tests that duplicates are counted by how they were
+// detected and rendered in the report's "Duplicate detection method breakdown" section.
+func TestRunApplicationLogic_DuplicateMethodBreakdown(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	pixelTime := time.Date(2024, 2, 20, 11, 0, 0, 0, time.UTC)
+	fileTime := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	undecodable := []byte("Not really a PNG, so only its file hash can match.")
+	createTestFiles(t, targetDir, []fileSpec{
+		{Path: filepath.Join("2024", "02", "2024-02-20-110000.png"), Content: pngMinimal_2x2_A, ModTime: pixelTime},
+		{Path: filepath.Join("2024", "01", "2024-01-15-100000.png"), Content: undecodable, ModTime: fileTime},
+	})
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "pixel.png", Content: pngMinimal_2x2_A, ModTime: pixelTime},
+		{Path: "file.png", Content: undecodable, ModTime: fileTime},
+		{Path: "other.png", Content: pngMinimal_2x2_B, ModTime: pixelTime}, // Name collision, told apart by pixels
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	require.Len(t, summary.Duplicates, 3)
+	assert.Equal(t, map[pkg.Reason]int{pkg.ReasonPixelHashMatch: 1, pkg.ReasonFileHashMatch: 1, pkg.ReasonPixelHashMismatch: 1}, summary.DuplicatesByMethod)
+
+	reportContent, readErr := os.ReadFile(filepath.Join(targetDir, pkg.ReportFileName))
+	require.NoError(t, readErr)
+	assert.Contains(t, string(reportContent), "Duplicate detection method breakdown:\n  - file_hash_match: 1\n  - pixel_hash_match: 1\n  - pixel_hash_mismatch: 1\n")
+}
+
+// cancelAfterContext reports itself as cancelled once Err has been called more than allowed times,
+// letting a test interrupt the run at a deterministic file boundary.
+type cancelAfterContext struct {
+	context.Context
+	allowed int
+	calls   int
+}
+
+func (c *cancelAfterContext) Err() error {
+	c.calls++
+	if c.calls > c.allowed {
+		return context.Canceled
+	}
+	return nil
+}
+
+// TestRunApplicationLogic_Interrupted tests that a cancelled run stops between files, leaves no
+// temporary files behind and still writes a report flagged as interrupted.
+func TestRunApplicationLogic_Interrupted(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+
+	sourceFiles := []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{Path: "c.png", Content: pngMinimal_4x4_C, ModTime: time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)},
+	}
+	createTestFiles(t, sourceDir, sourceFiles)
+
+	// The scan of the source directory and the grouping of identical files check ctx too; cancel
+	// after them and two files.
+	scanCtx := &cancelAfterContext{Context: context.Background(), allowed: math.MaxInt}
+	_, err := pkg.ScanSourceDirectoryContext(scanCtx, sourceDir, 0, false)
+	require.NoError(t, err)
+	ctx := &cancelAfterContext{Context: context.Background(), allowed: scanCtx.calls + len(sourceFiles) + 2}
+	summary, err := photocp.RunApplicationLogicContext(ctx, sourceDir, targetDir, photocp.Options{})
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.True(t, summary.Interrupted)
+	assert.Equal(t, 2, summary.CopiedFilesCount)
+
+	var leftovers []string
+	walkErr := filepath.Walk(targetDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".tmp") {
+			leftovers = append(leftovers, path)
+		}
+		return err
+	})
+	require.NoError(t, walkErr)
+	assert.Empty(t, leftovers, "No temporary files should remain in the target")
+
+	reportContent, readErr := os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, readErr)
+	assert.Contains(t, string(reportContent), "interrupted")
+
+	// Re-running resumes: the two imported files are recognised as duplicates.
+	summary, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	assert.False(t, summary.Interrupted)
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+}
+
+// TestRunApplicationLogic_KnownHashes tests that sources whose file hash is listed in the known
+// hashes file are skipped, and that -updateKnownHashes records the hashes of copied files.
+func TestRunApplicationLogic_KnownHashes(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+
+	sourceFiles := []fileSpec{
+		{Path: "archived.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: "new.png", Content: pngMinimal_2x2_B, ModTime: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+	}
+	createTestFiles(t, sourceDir, sourceFiles)
+
+	archivedHash, err := pkg.CalculateFileHash(filepath.Join(sourceDir, "archived.png"))
+	require.NoError(t, err)
+	newHash, err := pkg.CalculateFileHash(filepath.Join(sourceDir, "new.png"))
+	require.NoError(t, err)
+
+	hashesFile := filepath.Join(t.TempDir(), "hashes.txt")
+	require.NoError(t, os.WriteFile(hashesFile, []byte(strings.ToUpper(archivedHash)+"\n"), 0644))
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{KnownHashesFile: hashesFile, UpdateKnownHashes: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+	require.Len(t, summary.Duplicates, 1)
+	assert.Equal(t, filepath.Join(sourceDir, "archived.png"), summary.Duplicates[0].DiscardedFile)
+	assert.Equal(t, pkg.ReasonKnownHash, summary.Duplicates[0].Reason)
+	assert.Equal(t, "already archived", summary.Duplicates[0].Detail)
+	assert.NoFileExists(t, filepath.Join(targetDir, "2024", "01", "2024-01-01-100000.png"))
+	assert.FileExists(t, filepath.Join(targetDir, "2024", "01", "2024-01-02-100000.png"))
+
+	updated, err := pkg.LoadKnownHashes(hashesFile)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{archivedHash: true, newHash: true}, updated)
+	written, err := os.ReadFile(hashesFile)
+	require.NoError(t, err)
+	assert.Equal(t, strings.ToUpper(archivedHash)+"\n"+newHash+"\n", string(written), "only the new hash is appended")
+}
+
+// TestRunApplicationLogic_SniffExtensionless tests that an extensionless JPEG is imported with a
+// ".jpg" target name only when sniffing is enabled, and that unrecognized files are ignored.
+func TestRunApplicationLogic_SniffExtensionless(t *testing.T) {
+	jpegImg := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	fillImage(jpegImg, color.RGBA{B: 255, A: 255})
+	var jpegBuf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpegBuf, jpegImg, nil))
+
+	for _, sniff := range []bool{false, true} {
+		sourceDir, targetDir := setupTestDirs(t)
+		createTestFiles(t, sourceDir, []fileSpec{
+			{Path: "photo", Content: jpegBuf.Bytes(), ModTime: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+			{Path: "random", Content: []byte("not an image"), ModTime: time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)},
+		})
+
+		summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{SniffExtensionless: sniff})
+		require.NoError(t, err)
+
+		expectedTarget := filepath.Join(targetDir, "2024", "03", "2024-03-01-090000.jpg")
+		if sniff {
+			assert.Equal(t, 1, summary.ProcessedFilesCount)
+			assert.Equal(t, 1, summary.CopiedFilesCount)
+			assert.Equal(t, map[string]int{".jpg": 1}, summary.CopiedByExtension)
+			assert.FileExists(t, expectedTarget)
+		} else {
+			assert.Equal(t, 0, summary.ProcessedFilesCount)
+			assert.NoFileExists(t, expectedTarget)
+		}
+	}
+}
+
+// capturedLogEntry is a single message recorded by capturingLogger.
+type capturedLogEntry struct {
+	Level string
+	Msg   string
+	Args  []any
+}
+
+// capturingLogger is a pkg.Logger that records every message for later assertions.
+type capturingLogger struct {
+	entries []capturedLogEntry
+}
+
+func (l *capturingLogger) record(level, msg string, args []any) {
+	l.entries = append(l.entries, capturedLogEntry{Level: level, Msg: msg, Args: args})
+}
+func (l *capturingLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg, args) }
+func (l *capturingLogger) Info(msg string, args ...any)  { l.record("INFO", msg, args) }
+func (l *capturingLogger) Warn(msg string, args ...any)  { l.record("WARN", msg, args) }
+func (l *capturingLogger) Error(msg string, args ...any) { l.record("ERROR", msg, args) }
+
+// find returns the first entry with the given level and message.
+func (l *capturingLogger) find(level, msg string) (capturedLogEntry, bool) {
+	for _, entry := range l.entries {
+		if entry.Level == level && entry.Msg == msg {
+			return entry, true
+		}
+	}
+	return capturedLogEntry{}, false
+}
+
+// TestRunApplicationLogic_Logger tests that an injected logger receives the run's messages at the
+// expected levels for a simple copy, instead of them being printed.
+func TestRunApplicationLogic_Logger(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "image.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)},
+	})
+
+	logger := &capturingLogger{}
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Logger: logger})
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.CopiedFilesCount)
+
+	found, ok := logger.find("INFO", "Found image files to process")
+	require.True(t, ok, "expected an INFO message announcing the files found")
+	assert.Equal(t, []any{"count", 1}, found.Args)
+
+	copied, ok := logger.find("DEBUG", "Copied file")
+	require.True(t, ok, "expected a DEBUG message for the copied file")
+	assert.Equal(t, []any{"source", filepath.Join(sourceDir, "image.png"), "target", filepath.Join(targetDir, "2024", "05", "2024-05-06-070809.png")}, copied.Args)
+
+	_, ok = logger.find("INFO", "Photo sorting process completed")
+	assert.True(t, ok, "expected an INFO message when the run completes")
+	for _, entry := range logger.entries {
+		assert.NotEqual(t, "ERROR", entry.Level, "unexpected error message: %s", entry.Msg)
+	}
+}
+
+// TestRunApplicationLogic_StructureAndLayout tests that a structure preset and a custom layout
+// place files accordingly, and that combining them is rejected.
+func TestRunApplicationLogic_StructureAndLayout(t *testing.T) {
+	modTime := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: modTime}})
+	_, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Structure: "year-month-day"})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "27", "2023-10-27-153000.png"))
+
+	sourceDir, targetDir = setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: modTime}})
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Layout: "2006/01-Jan"})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "10-Oct", "2023-10-27-153000.png"))
+
+	sourceDir, targetDir = setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: modTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)}, // ISO week 52 of 2022
+	})
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Structure: "week"})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "W43", "2023-10-27-153000.png"))
+	assert.FileExists(t, filepath.Join(targetDir, "2022", "W52", "2023-01-01-090000.png"))
+
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Structure: "year", Layout: "2006"})
+	assert.Error(t, err)
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Structure: "decade"})
+	assert.Error(t, err)
+}
+
+// TestRunApplicationLogic_MaintainIndex tests that the target index is created on request and
+// kept up to date by later runs once it exists.
+func TestRunApplicationLogic_MaintainIndex(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)}})
+
+	_, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{MaintainIndex: true})
+	require.NoError(t, err)
+
+	indexPath := filepath.Join(targetDir, pkg.IndexFileName)
+	idx := &pkg.TargetIndex{}
+	require.NoError(t, idx.Load(indexPath))
+	assert.Contains(t, idx.Entries, "2024/01/2024-01-01-100000.png")
+
+	secondSource := t.TempDir()
+	createTestFiles(t, secondSource, []fileSpec{{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)}})
+	_, err = photocp.RunApplicationLogicWithOptions(secondSource, targetDir, photocp.Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, idx.Load(indexPath))
+	assert.Len(t, idx.Entries, 2)
+	assert.Contains(t, idx.Entries, "2024/02/2024-02-01-100000.png")
+	assert.Empty(t, idx.Stale(targetDir))
+}
+
+// TestRunApplicationLogic_ConflictStrategy tests each conflict strategy with a source whose
+// target name is taken by a file with different content.
+func TestRunApplicationLogic_ConflictStrategy(t *testing.T) {
+	photoTime := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+	targetRelPath := filepath.Join("2023", "10", "2023-10-27-153000.png")
+	versionRelPath := filepath.Join("2023", "10", "2023-10-27-153000-1.png")
+
+	setup := func(t *testing.T) (sourceDir, targetDir string) {
+		sourceDir, targetDir = setupTestDirs(t)
+		createTestFiles(t, targetDir, []fileSpec{{Path: targetRelPath, Content: pngMinimal_2x2_A, ModTime: photoTime}})
+		createTestFiles(t, sourceDir, []fileSpec{{Path: "photo.png", Content: pngMinimal_2x2_B, ModTime: photoTime}})
+		return sourceDir, targetDir
+	}
+
+	t.Run(pkg.ConflictKeepTarget, func(t *testing.T) {
+		sourceDir, targetDir := setup(t)
+		summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{ConflictStrategy: pkg.ConflictKeepTarget})
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, summary.CopiedFilesCount)
+		require.Len(t, summary.Duplicates, 1)
+		assert.Equal(t, filepath.Join(targetDir, targetRelPath), summary.Duplicates[0].KeptFile)
+		assert.Equal(t, pkg.ReasonNameCollision, summary.Duplicates[0].Reason)
+		assert.Equal(t, "content different, existing target preserved", summary.Duplicates[0].Detail)
+		content, readErr := os.ReadFile(filepath.Join(targetDir, targetRelPath))
+		require.NoError(t, readErr)
+		assert.Equal(t, pngMinimal_2x2_A, content)
+	})
+
+	t.Run(pkg.ConflictKeepSource, func(t *testing.T) {
+		sourceDir, targetDir := setup(t)
+		summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{ConflictStrategy: pkg.ConflictKeepSource})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, summary.CopiedFilesCount)
+		require.Len(t, summary.Duplicates, 1)
+		assert.Equal(t, filepath.Join(targetDir, targetRelPath), summary.Duplicates[0].DiscardedFile)
+		assert.Equal(t, pkg.ReasonNameCollision, summary.Duplicates[0].Reason)
+		assert.Equal(t, "content different, existing target overwritten", summary.Duplicates[0].Detail)
+		content, readErr := os.ReadFile(filepath.Join(targetDir, targetRelPath))
+		require.NoError(t, readErr)
+		assert.Equal(t, pngMinimal_2x2_B, content, "Target should have been overwritten by the source")
+	})
+
+	t.Run(pkg.ConflictVersion, func(t *testing.T) {
+		sourceDir, targetDir := setup(t)
+		opts := photocp.Options{ConflictStrategy: pkg.ConflictVersion}
+		summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, opts)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, summary.CopiedFilesCount)
+		assert.Empty(t, summary.Duplicates)
+		content, readErr := os.ReadFile(filepath.Join(targetDir, targetRelPath))
+		require.NoError(t, readErr)
+		assert.Equal(t, pngMinimal_2x2_A, content, "Original target should be untouched")
+		content, readErr = os.ReadFile(filepath.Join(targetDir, versionRelPath))
+		require.NoError(t, readErr)
+		assert.Equal(t, pngMinimal_2x2_B, content, "Source should have been copied to a -1 version")
+
+		// Re-running must recognize the existing version instead of adding another one.
+		summary, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, opts)
+		require.NoError(t, err)
+		assert.Equal(t, 0, summary.CopiedFilesCount)
+		require.Len(t, summary.Duplicates, 1)
+		assert.Equal(t, filepath.Join(targetDir, versionRelPath), summary.Duplicates[0].KeptFile)
+		entries, readDirErr := os.ReadDir(filepath.Join(targetDir, "2023", "10"))
+		require.NoError(t, readDirErr)
+		assert.Len(t, entries, 2)
+	})
+
+	t.Run(pkg.ConflictSkip, func(t *testing.T) {
+		sourceDir, targetDir := setup(t)
+		summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{ConflictStrategy: pkg.ConflictSkip})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, summary.ProcessedFilesCount)
+		assert.Equal(t, 0, summary.CopiedFilesCount)
+		assert.Empty(t, summary.Duplicates, "Skipped sources should not be reported as duplicates")
+		content, readErr := os.ReadFile(filepath.Join(targetDir, targetRelPath))
+		require.NoError(t, readErr)
+		assert.Equal(t, pngMinimal_2x2_A, content)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		sourceDir, targetDir := setup(t)
+		_, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{ConflictStrategy: "rename"})
+		assert.Error(t, err)
+	})
+}
+
+// TestRunApplicationLogic_ProcessingTime tests that the processing duration and throughput
+// are measured and written to the report.
+func TestRunApplicationLogic_ProcessingTime(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, summary.ElapsedSeconds, 0.0)
+	assert.GreaterOrEqual(t, summary.FilesPerSecond, 0.0)
+
+	report, err := os.ReadFile(filepath.Join(targetDir, pkg.ReportFileName))
+	require.NoError(t, err)
+	assert.Regexp(t, `Processing time: \d+\.\d{2}s \(\d+\.\d files/sec\)`, string(report))
+}
+
+// TestRunApplicationLogic_EmptySourceSkipped tests that zero-byte sources are reported as skipped
+// and never copied, whether their target path is free, holds a real image or holds another empty file.
+func TestRunApplicationLogic_EmptySourceSkipped(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	realTime := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	emptyTime := time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)
+	freeTime := time.Date(2024, 3, 3, 9, 0, 0, 0, time.UTC)
+	realTarget := filepath.Join("2024", "03", realTime.Format(testDateFormat)+".jpg")
+	emptyTarget := filepath.Join("2024", "03", emptyTime.Format(testDateFormat)+".jpg")
+	createTestFiles(t, targetDir, []fileSpec{
+		{Path: realTarget, Content: pngMinimal_2x2_A, ModTime: realTime},
+		{Path: emptyTarget, Content: []byte{}, ModTime: emptyTime},
+	})
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "vs_real.jpg", Content: []byte{}, ModTime: realTime},
+		{Path: "vs_empty.jpg", Content: []byte{}, ModTime: emptyTime},
+		{Path: "free.jpg", Content: []byte{}, ModTime: freeTime},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, summary.ProcessedFilesCount)
+	assert.Equal(t, 0, summary.CopiedFilesCount)
+	assert.Empty(t, summary.Duplicates, "Empty sources should not be reported as duplicates")
+	require.Len(t, summary.Skipped, 3)
+	for _, skipped := range summary.Skipped {
+		assert.Equal(t, pkg.EmptyFileSkipReason, skipped.Reason)
+	}
+
+	content, readErr := os.ReadFile(filepath.Join(targetDir, realTarget))
+	require.NoError(t, readErr)
+	assert.Equal(t, pngMinimal_2x2_A, content, "The real target must not be touched")
+	assert.NoFileExists(t, filepath.Join(targetDir, "2024", "03", freeTime.Format(testDateFormat)+".jpg"))
+
+	report, readErr := os.ReadFile(filepath.Join(targetDir, pkg.ReportFileName))
+	require.NoError(t, readErr)
+	assert.Contains(t, string(report), "Skipped files:")
+	assert.Contains(t, string(report), "  - Source: "+filepath.Join(sourceDir, "vs_real.jpg")+"\n    Reason: Skipped (empty file)")
+}
+
+// TestRunApplicationLogic_DateOverrides tests that a file listed in the date overrides CSV is sorted
+// by the overriding date, ignoring both its EXIF date and its modification time.
+func TestRunApplicationLogic_DateOverrides(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	modTime := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	fillImage(img, color.RGBA{G: 255, A: 255})
+	withExif := jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2020:05:05 05:05:05"}}})
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "scan.jpg", Content: withExif, ModTime: modTime},
+		{Path: "other.png", Content: pngMinimal_2x2_A, ModTime: modTime},
+	})
+	overridesPath := filepath.Join(t.TempDir(), "overrides.csv")
+	require.NoError(t, os.WriteFile(overridesPath, []byte("scan.jpg,1998-07-14 12:30:00\n"), 0644))
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{DateOverridesFile: overridesPath})
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.CopiedFilesCount)
+	assert.FileExists(t, filepath.Join(targetDir, "1998", "07", "1998-07-14-123000.jpg"))
+	assert.FileExists(t, filepath.Join(targetDir, "2024", "06", "2024-06-01-100000.png"), "Files without an override keep their usual date")
+}
+
+func TestRunApplicationLogic_UnknownDateDir(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	modTime := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	fillImage(img, color.RGBA{B: 255, A: 255})
+	withExif := jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2020:05:05 05:05:05"}}})
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "dated.jpg", Content: withExif, ModTime: modTime},
+		{Path: "IMG_0042.png", Content: pngMinimal_2x2_A, ModTime: modTime},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{UnknownDateDir: "undated"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.CopiedFilesCount)
+	assert.FileExists(t, filepath.Join(targetDir, "2020", "05", "2020-05-05-050505.jpg"), "EXIF-dated files go to their date folder")
+	assert.FileExists(t, filepath.Join(targetDir, "undated", "IMG_0042.png"), "Files without EXIF keep their name in the unknown date directory")
+	assert.NoDirExists(t, filepath.Join(targetDir, "2024"), "Modification times must not be used for folders")
+
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{UnknownDateDir: "../undated"})
+	assert.Error(t, err, "An unknown date directory outside the target must be rejected")
+}
+
+func TestRunApplicationLogic_PixelHashUnsupportedDirectCopy(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	photoTime := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "undecodable.png", Content: []byte("not really a PNG"), ModTime: photoTime},
+		{Path: "decodable.png", Content: pngMinimal_2x2_A, ModTime: photoTime.Add(time.Hour)},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.CopiedFilesCount, "Both files go to empty targets")
+	assert.Empty(t, summary.Duplicates)
+	assert.Equal(t, 1, summary.PixelHashUnsupportedCount, "Only the undecodable image should be counted")
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "undecodable.png"), t.TempDir(), pkg.Options{})
+	require.NoError(t, err)
+	assert.True(t, outcome.Copied)
+	assert.True(t, outcome.PixelHashUnsupported)
+	assert.False(t, outcome.UsedFileHash, "No comparison took place")
+}
+
+func TestRunApplicationLogic_OnCopy(t *testing.T) {
+	if _, err := exec.LookPath("touch"); err != nil {
+		t.Skip("touch is not available")
+	}
+	sourceDir, targetDir := setupTestDirs(t)
+	photoTime := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: photoTime},
+		{Path: "a copy.png", Content: pngMinimal_2x2_A, ModTime: photoTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: photoTime.Add(time.Hour)},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{OnCopy: "touch {dst}.done"})
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.CopiedFilesCount)
+	require.Len(t, summary.Duplicates, 1)
+
+	markers, err := filepath.Glob(filepath.Join(targetDir, "*", "*", "*.done"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png.done"),
+		filepath.Join(targetDir, "2023", "10", "2023-10-27-163000.png.done"),
+	}, markers, "The command should run once per copied file and not for the duplicate")
+
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, t.TempDir(), photocp.Options{OnCopy: "photo-sorter-no-such-command {dst}"})
+	assert.NoError(t, err, "A failing on-copy command must not fail the run")
+}
+
+func TestRunApplicationLogic_InPlace(t *testing.T) {
+	dir := t.TempDir()
+	sortedTime := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+	unsortedTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	sortedRelPath := filepath.Join("2023", "10", "2023-10-27-153000.png")
+	createTestFiles(t, dir, []fileSpec{
+		{Path: sortedRelPath, Content: pngMinimal_2x2_A, ModTime: sortedTime},
+		{Path: filepath.Join("Downloads", "IMG_0001.png"), Content: pngMinimal_2x2_B, ModTime: unsortedTime},
+	})
+
+	logger := &capturingLogger{}
+	summary, err := photocp.RunApplicationLogicWithOptions(dir, dir, photocp.Options{Logger: logger})
+	require.NoError(t, err)
+	_, ok := logger.find("INFO", "Source and target are the same directory, moving files into place")
+	assert.True(t, ok, "moving instead of copying must be announced")
+	assert.Equal(t, 2, summary.ProcessedFilesCount)
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+	assert.Empty(t, summary.Duplicates)
+	require.Len(t, summary.Skipped, 1)
+	assert.Equal(t, filepath.Join(dir, sortedRelPath), summary.Skipped[0].SourceFile)
+	assert.Equal(t, pkg.AlreadyInPlaceSkipReason, summary.Skipped[0].Reason)
+
+	sortedContent, err := os.ReadFile(filepath.Join(dir, sortedRelPath))
+	require.NoError(t, err)
+	assert.Equal(t, pngMinimal_2x2_A, sortedContent, "The file in its date folder must be left alone")
+	assert.FileExists(t, filepath.Join(dir, "2022", "01", "2022-01-02-030405.png"))
+	assert.NoFileExists(t, filepath.Join(dir, "Downloads", "IMG_0001.png"), "The unsorted file should have been moved, not copied")
+
+	// A second run finds everything in place.
+	summary, err = photocp.RunApplicationLogicWithOptions(dir, dir, photocp.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, summary.CopiedFilesCount)
+	assert.Len(t, summary.Skipped, 2)
+
+	// Converting HEIC files while moving them into place would delete the originals.
+	_, err = photocp.RunApplicationLogicWithOptions(dir, dir, photocp.Options{ConvertHeicToJpeg: true})
+	assert.Error(t, err)
+}
+
+func TestRunApplicationLogic_TargetNotWritable(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Now()}})
+	require.NoError(t, os.Chmod(targetDir, 0555))
+	t.Cleanup(func() { os.Chmod(targetDir, 0755) })
+	// Permissions are not enforced for root or on some platforms, so check that they apply here.
+	if f, err := os.CreateTemp(targetDir, "probe"); err == nil {
+		f.Close()
+		os.Remove(f.Name())
+		t.Skip("read-only directories cannot be simulated with chmod on this system")
+	}
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	assert.ErrorIs(t, err, pkg.ErrTargetNotWritable)
+	assert.Equal(t, 0, summary.ProcessedFilesCount, "No file should be processed")
+}
+
+func TestRunApplicationLogic_VerboseReportHashes(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Now()}})
+	pixelHash, err := pkg.CalculatePixelDataHash(filepath.Join(sourceDir, "a.png"))
+	require.NoError(t, err)
+
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	// The re-import is a pixel hash match of the first copy.
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Verbose: true})
+	require.NoError(t, err)
+	require.Len(t, summary.Duplicates, 1)
+	dup := summary.Duplicates[0]
+	assert.Equal(t, pkg.ReasonPixelHashMatch, dup.Reason)
+	assert.Equal(t, pkg.HashTypePixel, dup.HashType)
+	assert.Equal(t, pixelHash, dup.Hash1)
+	assert.Equal(t, pixelHash, dup.Hash2)
+
+	reportContent, err := os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, err)
+	short := pixelHash[:12] + "..."
+	assert.Contains(t, string(reportContent), "    Comparison: pixel_sha256, source "+short+", target "+short+"\n")
+	assert.NotContains(t, string(reportContent), pixelHash, "Hashes should be truncated")
+
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	reportContent, err = os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(reportContent), "Comparison:", "Hashes are only reported in verbose runs")
+}
+
+func TestRunApplicationLogic_ReplacementsCountedSeparately(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	targetPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.gif")
+	createTestFiles(t, targetDir, []fileSpec{{Path: filepath.Join("2023", "10", "2023-10-27-153000.gif"), Content: scaledImage(7, 1), ModTime: sortFileTime}})
+	// big.gif replaces the lower resolution target; small.gif, processed after it, is a discarded duplicate.
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "big.gif", Content: scaledImage(7, 4), ModTime: sortFileTime},
+		{Path: "small.gif", Content: scaledImage(7, 2), ModTime: sortFileTime},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	require.Len(t, summary.Duplicates, 2)
+	assert.Equal(t, 1, summary.ReplacedCount())
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+	assert.Equal(t, summary.ProcessedFilesCount, summary.CopiedFilesCount+len(summary.Duplicates)-summary.ReplacedCount(),
+		"Every source is either copied or discarded")
+	assert.Equal(t, 1, summary.CopiedByExtension[".gif"])
+	assert.Equal(t, 1, summary.DuplicatesByExtension[".gif"], "The replacing source is only counted as copied")
+
+	for _, dup := range summary.Duplicates {
+		if dup.Replaced {
+			assert.Equal(t, targetPath, dup.DiscardedFile)
+		} else {
+			assert.Equal(t, filepath.Join(sourceDir, "small.gif"), dup.DiscardedFile)
+		}
+	}
+	content, err := os.ReadFile(targetPath)
+	require.NoError(t, err)
+	assert.Equal(t, scaledImage(7, 4), content, "The target should have been replaced by big.gif")
+
+	reportContent, err := os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(reportContent), "  - Duplicate files found and discarded/skipped: 1\n")
+	assert.Contains(t, string(reportContent), "  - Targets replaced by higher-resolution source: 1\n")
+}
+
+func TestRunApplicationLogicSources_CrossSourceDedup(t *testing.T) {
+	card1, targetDir := setupTestDirs(t)
+	card2 := t.TempDir()
+	createTestFiles(t, card1, []fileSpec{
+		{Path: filepath.Join("DCIM", "IMG_0001.png"), Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: filepath.Join("DCIM", "IMG_0002.png"), Content: pngMinimal_2x2_B, ModTime: time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)},
+	})
+	createTestFiles(t, card2, []fileSpec{
+		// The same photo as on card1, copied with a different name and time.
+		{Path: filepath.Join("DCIM", "IMG_0001.png"), Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: filepath.Join("DCIM", "IMG_0100.png"), Content: pngMinimal_4x4_C, ModTime: time.Date(2024, 5, 3, 10, 0, 0, 0, time.UTC)},
+	})
+
+	// card1 is also listed through a nested directory, whose files must not be sorted twice.
+	summary, err := photocp.RunApplicationLogicSources(context.Background(), []string{card1, card2, filepath.Join(card1, "DCIM")}, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, summary.ProcessedFilesCount)
+	assert.Equal(t, 3, summary.CopiedFilesCount)
+	require.Len(t, summary.Duplicates, 1)
+	assert.Contains(t, []string{filepath.Join(card1, "DCIM", "IMG_0001.png"), filepath.Join(card2, "DCIM", "IMG_0001.png")}, summary.Duplicates[0].DiscardedFile)
+
+	for _, name := range []string{"2024-05-01-100000.png", "2024-05-02-100000.png", "2024-05-03-100000.png"} {
+		assert.FileExists(t, filepath.Join(targetDir, "2024", "05", name))
+	}
+	reportContent, err := os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(reportContent), "  - Total files scanned: 4\n")
+	assert.Contains(t, string(reportContent), "  - Files successfully copied: 3\n")
+	assert.Contains(t, string(reportContent), "  - Duplicate files found and discarded/skipped: 1\n")
+}
+
+func TestRunApplicationLogicSources_StreamScan(t *testing.T) {
+	card1, targetDir := setupTestDirs(t)
+	card2 := t.TempDir()
+	createTestFiles(t, card1, []fileSpec{
+		{Path: filepath.Join("DCIM", "IMG_0001.png"), Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: filepath.Join("DCIM", "IMG_0002.png"), Content: pngMinimal_2x2_B, ModTime: time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)},
+	})
+	createTestFiles(t, card2, []fileSpec{
+		{Path: filepath.Join("DCIM", "IMG_0001.png"), Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{Path: filepath.Join("DCIM", "IMG_0100.png"), Content: pngMinimal_4x4_C, ModTime: time.Date(2024, 5, 3, 10, 0, 0, 0, time.UTC)},
+	})
+
+	opts := photocp.Options{StreamScan: true}
+	summary, err := photocp.RunApplicationLogicSources(context.Background(), []string{card1, card2, filepath.Join(card1, "DCIM")}, targetDir, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 4, summary.ProcessedFilesCount)
+	assert.Equal(t, 3, summary.CopiedFilesCount)
+	require.Len(t, summary.Duplicates, 1)
+	assert.Equal(t, filepath.Join(card2, "DCIM", "IMG_0001.png"), summary.Duplicates[0].DiscardedFile, "Sources are walked in the order given")
+	for _, name := range []string{"2024-05-01-100000.png", "2024-05-02-100000.png", "2024-05-03-100000.png"} {
+		assert.FileExists(t, filepath.Join(targetDir, "2024", "05", name))
+	}
+	reportContent, err := os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(reportContent), "  - Total files scanned: 4\n")
+
+	_, err = photocp.RunApplicationLogicSources(context.Background(), []string{filepath.Join(card1, "missing")}, targetDir, opts)
+	assert.ErrorContains(t, err, "does not exist")
+}
+
+func TestRunApplicationLogic_CorruptImageQuarantined(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	quarantineDir := t.TempDir()
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: filepath.Join("DCIM", "partial.jpg"), Content: truncatedJPEG(t), ModTime: sortFileTime},
+		{Path: filepath.Join("DCIM", "good.png"), Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{QuarantineDir: quarantineDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+	assert.Equal(t, 0, summary.PixelHashUnsupportedCount, "A corrupt image is not an unsupported one")
+	require.Len(t, summary.Corrupt, 1)
+	assert.Equal(t, filepath.Join(sourceDir, "DCIM", "partial.jpg"), summary.Corrupt[0].SourceFile)
+	assert.Contains(t, summary.Corrupt[0].Reason, "truncated")
+	require.Len(t, summary.Quarantined, 1)
+	assert.FileExists(t, filepath.Join(quarantineDir, "DCIM", "partial.jpg"))
+
+	reportContent, err := os.ReadFile(filepath.Join(targetDir, "report.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(reportContent), "  - Corrupt images: 1\n")
+	assert.Contains(t, string(reportContent), "Reason: Corrupt image - ")
+	assert.Contains(t, string(reportContent), "  - Image files where pixel hashing was not supported (fallback to file hash): 0\n")
+}
+
+func TestRunApplicationLogic_MoveRemovesEmptiedSourceDirs(t *testing.T) {
+	setup := func(t *testing.T) (sourceDir, targetDir string) {
+		sourceDir, targetDir = setupTestDirs(t)
+		createTestFiles(t, sourceDir, []fileSpec{
+			{Path: filepath.Join("all", "x", "y", "d.png"), Content: pngMinimal_4x4_A, ModTime: time.Date(2024, 1, 4, 10, 0, 0, 0, time.UTC)},
+			{Path: filepath.Join("trip", "day1", "a.png"), Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+			{Path: filepath.Join("trip", "day2", "b.png"), Content: pngMinimal_2x2_B, ModTime: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+			{Path: filepath.Join("trip", "day2", "notes.txt"), Content: []byte("not an image"), ModTime: sortFileTime},
+			// A duplicate of a.png, which is discarded and so stays in the source.
+			{Path: filepath.Join("zkeep", "c.png"), Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		})
+		require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "emptyBefore"), 0755))
+		return sourceDir, targetDir
+	}
+
+	t.Run("cleanup", func(t *testing.T) {
+		sourceDir, targetDir := setup(t)
+		summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Move: true})
+		require.NoError(t, err)
+		require.Equal(t, 3, summary.CopiedFilesCount)
+
+		for _, removed := range []string{"all", filepath.Join("trip", "day1")} {
+			assert.NoDirExists(t, filepath.Join(sourceDir, removed))
+		}
+		for _, kept := range []string{"", "trip", filepath.Join("trip", "day2"), "zkeep", "emptyBefore"} {
+			assert.DirExists(t, filepath.Join(sourceDir, kept))
+		}
+		assert.FileExists(t, filepath.Join(sourceDir, "trip", "day2", "notes.txt"))
+		assert.FileExists(t, filepath.Join(sourceDir, "zkeep", "c.png"))
+	})
+
+	t.Run("keep empty directories", func(t *testing.T) {
+		sourceDir, targetDir := setup(t)
+		_, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{Move: true, KeepEmptySourceDirs: true})
+		require.NoError(t, err)
+		assert.DirExists(t, filepath.Join(sourceDir, "all", "x", "y"))
+		assert.DirExists(t, filepath.Join(sourceDir, "trip", "day1"))
+		assert.NoFileExists(t, filepath.Join(sourceDir, "trip", "day1", "a.png"))
+	})
+
+	t.Run("copy leaves sources alone", func(t *testing.T) {
+		sourceDir, targetDir := setup(t)
+		_, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(sourceDir, "all", "x", "y", "d.png"))
+	})
+}
+
+// TestRunApplicationLogic_ReportIncludeSkipped tests that files of unsupported formats are only listed
+// as skipped with ReportIncludeSkipped, next to the empty files that are always listed.
+func TestRunApplicationLogic_ReportIncludeSkipped(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			sourceDir, targetDir := setupTestDirs(t)
+			createTestFiles(t, sourceDir, []fileSpec{
+				{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+				{Path: "empty.jpg", Content: []byte{}, ModTime: sortFileTime},
+				{Path: filepath.Join("docs", "notes.txt"), Content: []byte("not an image"), ModTime: sortFileTime},
+				{Path: filepath.Join("clips", "clip.mp4"), Content: []byte("not a photo"), ModTime: sortFileTime},
+			})
+			wantSkipped := map[string]string{
+				filepath.Join(sourceDir, "empty.jpg"):         pkg.EmptyFileSkipReason,
+				filepath.Join(sourceDir, "docs", "notes.txt"): pkg.UnsupportedFormatSkipReason,
+				filepath.Join(sourceDir, "clips", "clip.mp4"): pkg.VideoSkipReason,
+			}
+
+			summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{ReportIncludeSkipped: true, StreamScan: stream})
+			require.NoError(t, err)
+			assert.Equal(t, 1, summary.CopiedFilesCount)
+			gotSkipped := make(map[string]string)
+			for _, s := range summary.Skipped {
+				gotSkipped[s.SourceFile] = s.Reason
+			}
+			assert.Equal(t, wantSkipped, gotSkipped)
+
+			report, readErr := os.ReadFile(filepath.Join(targetDir, pkg.ReportFileName))
+			require.NoError(t, readErr)
+			assert.Contains(t, string(report), "  - Source: "+filepath.Join(sourceDir, "docs", "notes.txt")+"\n    Reason: "+pkg.UnsupportedFormatSkipReason)
+			assert.Contains(t, string(report), "  - Source: "+filepath.Join(sourceDir, "clips", "clip.mp4")+"\n    Reason: "+pkg.VideoSkipReason)
+		})
+	}
+
+	t.Run("without option", func(t *testing.T) {
+		sourceDir, targetDir := setupTestDirs(t)
+		createTestFiles(t, sourceDir, []fileSpec{
+			{Path: "empty.jpg", Content: []byte{}, ModTime: sortFileTime},
+			{Path: "notes.txt", Content: []byte("not an image"), ModTime: sortFileTime},
+		})
+		summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+		require.NoError(t, err)
+		require.Len(t, summary.Skipped, 1)
+		assert.Equal(t, pkg.EmptyFileSkipReason, summary.Skipped[0].Reason)
+	})
+}
+
+func TestPrintFormats(t *testing.T) {
+	var out bytes.Buffer
+	photocp.PrintFormats(&out)
+	output := out.String()
+
+	assert.Regexp(t, `(?m)^  \.jpg +decoded: resolution and pixel hash$`, output)
+	assert.Regexp(t, `(?m)^  \.cr2 +no decoder: `, output)
+	heicDecoded := regexp.MustCompile(`(?m)^  \.heic +decoded: `).MatchString(output)
+	assert.Regexp(t, `(?m)^  \.heic +(decoded|no decoder): `, output)
+	assert.Equal(t, pkg.HasImageDecoder(".heic"), heicDecoded, "HEIC is listed as decoded only if a HEIF decoder is compiled in")
+	assert.Contains(t, output, ".mp4")
+}
+
+func TestPrintComparison(t *testing.T) {
+	dir := t.TempDir()
+	createTestFiles(t, dir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "a_copy.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},
+	})
+	a, aCopy, b := filepath.Join(dir, "a.png"), filepath.Join(dir, "a_copy.png"), filepath.Join(dir, "b.png")
+
+	t.Run("IdenticalPair", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, photocp.PrintComparison(&out, a, aCopy, false))
+		assert.Contains(t, out.String(), "Result:    duplicates\n")
+		assert.Contains(t, out.String(), "Reason:    "+string(pkg.ReasonPixelHashMatch)+"\n")
+	})
+
+	t.Run("DifferentPair", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, photocp.PrintComparison(&out, a, b, false))
+		assert.Contains(t, out.String(), "Result:    not duplicates\n")
+		assert.Contains(t, out.String(), "Reason:    "+string(pkg.ReasonPixelHashMismatch)+"\n")
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, photocp.PrintComparison(&out, a, b, true))
+		var got struct {
+			AreDuplicates bool   `json:"areDuplicates"`
+			Reason        string `json:"reason"`
+			HashType      string `json:"hashType"`
+			HashA         string `json:"hashA"`
+			HashB         string `json:"hashB"`
+		}
+		require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+		assert.False(t, got.AreDuplicates)
+		assert.Equal(t, string(pkg.ReasonPixelHashMismatch), got.Reason)
+		assert.Equal(t, pkg.HashTypePixel, got.HashType)
+		assert.NotEqual(t, got.HashA, got.HashB)
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		assert.Error(t, photocp.PrintComparison(io.Discard, a, filepath.Join(dir, "missing.png"), false))
+	})
+}
+
+func TestRunApplicationLogicSources_Invalid(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+
+	_, err := photocp.RunApplicationLogicSources(context.Background(), nil, targetDir, photocp.Options{})
+	assert.Error(t, err, "No sources")
+	zipPath := filepath.Join(t.TempDir(), "card.zip")
+	require.NoError(t, os.WriteFile(zipPath, nil, 0644))
+	_, err = photocp.RunApplicationLogicSources(context.Background(), []string{sourceDir, zipPath}, targetDir, photocp.Options{})
+	assert.ErrorContains(t, err, "cannot be combined")
+	_, err = photocp.RunApplicationLogicSources(context.Background(), []string{sourceDir, targetDir}, targetDir, photocp.Options{})
+	assert.ErrorContains(t, err, "is the target directory")
+}
+
+func TestExpandSourceDirs(t *testing.T) {
+	base := t.TempDir()
+	for _, dir := range []string{"card1/DCIM", "card2/DCIM", "card3/MISC"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(base, dir), 0755))
+	}
+
+	var flagValue photocp.SourceDirsFlag
+	require.NoError(t, flagValue.Set(filepath.Join(base, "*", "DCIM")+", "+filepath.Join(base, "card3")))
+	require.NoError(t, flagValue.Set(filepath.Join(base, "card4")))
+	assert.Len(t, flagValue, 3)
+	withComma := filepath.Join(base, "Holiday, Rome")
+	require.NoError(t, os.Mkdir(withComma, 0755))
+	var commaValue photocp.SourceDirsFlag
+	require.NoError(t, commaValue.Set(withComma))
+	assert.Equal(t, photocp.SourceDirsFlag{withComma}, commaValue, "an existing path is not split at its comma")
+
+	expanded, err := photocp.ExpandSourceDirs(flagValue)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(base, "card1", "DCIM"),
+		filepath.Join(base, "card2", "DCIM"),
+		filepath.Join(base, "card3"),
+		filepath.Join(base, "card4"), // Not a pattern, so returned even though it does not exist
+	}, expanded)
+
+	_, err = photocp.ExpandSourceDirs([]string{filepath.Join(base, "*", "Camera")})
+	assert.ErrorContains(t, err, "matches nothing")
+
+	bracketed := filepath.Join(base, "Photos [2023]")
+	require.NoError(t, os.Mkdir(bracketed, 0755))
+	expanded, err = photocp.ExpandSourceDirs([]string{bracketed})
+	require.NoError(t, err)
+	assert.Equal(t, []string{bracketed}, expanded, "an existing path is not taken as a pattern")
+}
+
+func TestRunApplicationLogic_ReportWriteFailure(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Now()}})
+	// A report below a regular file cannot be written, whoever runs the test.
+	blocker := filepath.Join(t.TempDir(), "not-a-dir")
+	require.NoError(t, os.WriteFile(blocker, nil, 0644))
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{ReportPath: filepath.Join(blocker, "report.txt")})
+	require.NoError(t, err, "A report failure should not fail the run")
+	assert.Equal(t, 1, summary.ProcessedFilesCount)
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+
+	fallbacks, globErr := filepath.Glob(filepath.Join(tempDir, "photo-sorter-report-*.txt"))
+	require.NoError(t, globErr)
+	require.Len(t, fallbacks, 1, "The report should be written to a temporary file")
+	reportContent, readErr := os.ReadFile(fallbacks[0])
+	require.NoError(t, readErr)
+	assert.Contains(t, string(reportContent), "Photo Sorting Report")
+
+	summary, err = photocp.RunApplicationLogicWithOptions(sourceDir, t.TempDir(), photocp.Options{ReportPath: filepath.Join(blocker, "report.txt"), StrictReport: true})
+	assert.Error(t, err)
+	assert.Equal(t, 1, summary.CopiedFilesCount, "Counts should be returned with the error")
+}
+
+func TestRunApplicationLogic_InsufficientSpace(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Now()},
+		{Path: "b.png", Content: pngMinimal_4x4_C, ModTime: time.Now().Add(time.Hour)},
+	})
+	var queried string
+	tooLittle := func(dir string) (uint64, error) {
+		queried = dir
+		return pkg.FreeSpaceMargin + 10, nil
+	}
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{AvailableSpace: tooLittle})
+	assert.ErrorIs(t, err, pkg.ErrInsufficientSpace)
+	assert.Equal(t, targetDir, queried)
+	assert.Equal(t, 0, summary.ProcessedFilesCount, "No file should be processed")
+	assert.Equal(t, photocp.ExitFatal, photocp.ExitCode(summary, err))
+	entries, readErr := os.ReadDir(targetDir)
+	require.NoError(t, readErr)
+	assert.Empty(t, entries, "Nothing should be written to the target")
+
+	summary, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{AvailableSpace: tooLittle, IgnoreSpaceCheck: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.CopiedFilesCount)
+
+	plenty := func(string) (uint64, error) { return 1 << 40, nil }
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, t.TempDir(), photocp.Options{AvailableSpace: plenty})
+	assert.NoError(t, err)
+}
+
+func TestExitCode(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	photoTime := time.Date(2024, 5, 5, 8, 0, 0, 0, time.UTC)
+	corruptPNG := append([]byte("\x89PNG\r\n\x1a\n"), []byte("this is not a valid png chunk stream")...)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "good.png", Content: pngMinimal_2x2_A, ModTime: photoTime},
+		{Path: "corrupt.png", Content: corruptPNG, ModTime: photoTime},
+	})
+
+	// Undecodable images are processing errors when quarantining.
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{QuarantineDir: t.TempDir()})
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.ProcessingErrorCount)
+	assert.Equal(t, photocp.ExitFileErrors, photocp.ExitCode(summary, err))
+
+	require.NoError(t, os.Remove(filepath.Join(sourceDir, "corrupt.png")))
+	summary, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, photocp.ExitOK, photocp.ExitCode(summary, err))
+
+	summary, err = photocp.RunApplicationLogicWithOptions(filepath.Join(sourceDir, "missing"), targetDir, photocp.Options{})
+	require.Error(t, err)
+	assert.Equal(t, photocp.ExitFatal, photocp.ExitCode(summary, err))
+
+	assert.Equal(t, photocp.ExitInterrupted, photocp.ExitCode(pkg.ReportSummary{Interrupted: true}, context.Canceled))
+}
+
+func TestRunApplicationLogic_IdenticalSourceFiles(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	photoTime := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	var sourceFiles []fileSpec
+	for i := 1; i <= 5; i++ {
+		// Different dates would give each copy its own target name; grouping happens before that matters.
+		sourceFiles = append(sourceFiles, fileSpec{Path: filepath.Join("card", "copy"+strconv.Itoa(i)+".png"), Content: pngMinimal_2x2_A, ModTime: photoTime.Add(time.Duration(i) * time.Hour)})
+	}
+	createTestFiles(t, sourceDir, sourceFiles)
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, summary.ProcessedFilesCount)
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+	require.Len(t, summary.Duplicates, 4)
+	keptPath := filepath.Join(targetDir, "2024", "06", "2024-06-01-110000.png")
+	for _, dup := range summary.Duplicates {
+		assert.Equal(t, pkg.ReasonSourceDuplicate, dup.Reason)
+		assert.Equal(t, keptPath, dup.KeptFile)
+	}
+	assert.Equal(t, 4, summary.DuplicatesByExtension[".png"])
+}
+
+func TestRunApplicationLogic_IdenticalSourceFiles_KeptFileNotCopied(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "copy1.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC)},
+		{Path: "copy2.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{Path: "copy3.png", Content: pngMinimal_2x2_A, ModTime: time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC)},
+	})
+	// A directory in place of the first copy's target makes sorting it fail.
+	require.NoError(t, os.MkdirAll(filepath.Join(targetDir, "2024", "06", "2024-06-01-110000.png", "blocked"), 0755))
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, summary.CopiedFilesCount, "The second copy should be sorted in place of the first")
+	keptPath := filepath.Join(targetDir, "2024", "06", "2024-06-01-120000.png")
+	assert.FileExists(t, keptPath)
+	var sourceDuplicates []pkg.DuplicateInfo
+	for _, dup := range summary.Duplicates {
+		if dup.Reason == pkg.ReasonSourceDuplicate {
+			sourceDuplicates = append(sourceDuplicates, dup)
+		}
+	}
+	require.Len(t, sourceDuplicates, 1)
+	assert.Equal(t, filepath.Join(sourceDir, "copy3.png"), sourceDuplicates[0].DiscardedFile)
+	assert.Equal(t, keptPath, sourceDuplicates[0].KeptFile)
+}
+
+func TestRunApplicationLogic_IdenticalSourceFiles_Interrupted(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "copy.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+	})
+
+	// Cancel after the scan and the grouping of both files, before any is sorted.
+	scanCtx := &cancelAfterContext{Context: context.Background(), allowed: math.MaxInt}
+	_, err := pkg.ScanSourceDirectoryContext(scanCtx, sourceDir, 0, false)
+	require.NoError(t, err)
+	ctx := &cancelAfterContext{Context: context.Background(), allowed: scanCtx.calls + 2}
+	summary, err := photocp.RunApplicationLogicContext(ctx, sourceDir, targetDir, photocp.Options{})
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.True(t, summary.Interrupted)
+	assert.Equal(t, 0, summary.CopiedFilesCount)
+	assert.Empty(t, summary.Duplicates, "A copy is not discarded for a file that was never sorted")
+}
+
+func TestRunApplicationLogic_ReportPath(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Now()}})
+	reportPath := filepath.Join(t.TempDir(), "reports", "card-07.txt")
+
+	_, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{ReportPath: reportPath})
+	require.NoError(t, err)
+
+	report, err := os.ReadFile(reportPath)
+	require.NoError(t, err, "Report should be written to the custom path, creating its directory")
+	assert.Contains(t, string(report), "Photo Sorting Report")
+	assert.NoFileExists(t, filepath.Join(targetDir, pkg.ReportFileName))
+}
+
+func TestRunApplicationLogic_TimestampReport(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: time.Now()}})
+
+	for run := 0; run < 2; run++ {
+		_, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{TimestampReport: true})
+		require.NoError(t, err)
+	}
+
+	reports, err := filepath.Glob(filepath.Join(targetDir, "report-*.txt"))
+	require.NoError(t, err)
+	assert.Len(t, reports, 2, "Each run should keep its own report")
+	assert.NoFileExists(t, filepath.Join(targetDir, pkg.ReportFileName))
+
+	_, err = photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{TimestampReport: true, ReportPath: filepath.Join(targetDir, "r.txt")})
+	assert.Error(t, err, "A timestamped report name cannot be combined with a report path")
+}
+
+func TestPrintPlanTree(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+	})
+	treeFile := filepath.Join(t.TempDir(), "tree.txt")
+
+	var out bytes.Buffer
+	require.NoError(t, photocp.PrintPlanTree(&out, []string{sourceDir}, targetDir, photocp.Options{}, treeFile))
+	assert.Contains(t, out.String(), "├── 2023/ (1)\n│   └── 10/ (1)\n└── 2024/ (1)\n    └── 01/ (1)\n")
+	written, err := os.ReadFile(treeFile)
+	require.NoError(t, err)
+	assert.Equal(t, out.String(), string(written))
+	_, err = os.Stat(filepath.Join(targetDir, "2023"))
+	assert.True(t, os.IsNotExist(err), "no target directory is created")
+}
+
+func TestRunApplicationLogic_PreserveSubdir(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: filepath.Join("Birthday", "a.png"), Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "top.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{PreserveSubdir: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.CopiedFilesCount)
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "Birthday", "2023-10-27-153000.png"))
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png"))
+
+	_, err = photocp.RunApplicationLogicWithOptions(targetDir, targetDir, photocp.Options{PreserveSubdir: true})
+	assert.Error(t, err, "preserving subdirectories in place is rejected")
+}
+
+func TestRunApplicationLogic_HTMLReport(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "copy/a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{ReportFormat: pkg.ReportFormatHTML})
+	require.NoError(t, err)
+	require.Len(t, summary.Duplicates, 1)
+	assert.FileExists(t, filepath.Join(targetDir, pkg.ReportFileName))
+	content, err := os.ReadFile(filepath.Join(targetDir, pkg.HTMLReportFileName))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `<img src="data:image/png;base64,`)
+}
+
+func TestRunApplicationLogic_PreferExtensionOrder(t *testing.T) {
+	t.Run("identical sources", func(t *testing.T) {
+		sourceDir, targetDir := setupTestDirs(t)
+		createTestFiles(t, sourceDir, []fileSpec{
+			{Path: "photo.jpeg", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+			{Path: "photo.jpg", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		})
+
+		summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{PreferExtensionOrder: []string{"jpg", "jpeg", "png"}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, summary.CopiedFilesCount)
+		require.Len(t, summary.Duplicates, 1)
+		assert.Equal(t, filepath.Join(sourceDir, "photo.jpeg"), summary.Duplicates[0].DiscardedFile)
+		assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.jpg"))
+		assert.NoFileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.jpeg"))
+	})
+
+	// indexedTarget sorts photo.<ext> into a new target with an index and returns the target and the sorted file.
+	indexedTarget := func(t *testing.T, ext string) (targetDir, existing string) {
+		sourceDir, targetDir := setupTestDirs(t)
+		createTestFiles(t, sourceDir, []fileSpec{{Path: "photo." + ext, Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+		_, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{MaintainIndex: true})
+		require.NoError(t, err)
+		existing = filepath.Join(targetDir, "2023", "10", "2023-10-27-153000."+ext)
+		require.FileExists(t, existing)
+		return targetDir, existing
+	}
+
+	for _, streamScan := range []bool{false, true} {
+		t.Run(fmt.Sprintf("identical file in target index, stream scan %v", streamScan), func(t *testing.T) {
+			targetDir, existing := indexedTarget(t, "jpg")
+			secondSource := t.TempDir()
+			createTestFiles(t, secondSource, []fileSpec{
+				{Path: "photo.jpeg", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+				{Path: "other.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},
+			})
+			summary, err := photocp.RunApplicationLogicWithOptions(secondSource, targetDir, photocp.Options{PreferExtensionOrder: []string{"jpg", "jpeg"}, StreamScan: streamScan})
+			require.NoError(t, err)
+			assert.Equal(t, 1, summary.CopiedFilesCount, "only the different image is copied")
+			require.Len(t, summary.Duplicates, 1)
+			assert.Equal(t, existing, summary.Duplicates[0].KeptFile)
+			assert.Equal(t, filepath.Join(secondSource, "photo.jpeg"), summary.Duplicates[0].DiscardedFile)
+			assert.NoFileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.jpeg"))
+		})
+	}
+
+	t.Run("preferred extension is imported", func(t *testing.T) {
+		targetDir, existing := indexedTarget(t, "jpeg")
+		secondSource := t.TempDir()
+		createTestFiles(t, secondSource, []fileSpec{{Path: "photo.jpg", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+		summary, err := photocp.RunApplicationLogicWithOptions(secondSource, targetDir, photocp.Options{PreferExtensionOrder: []string{"jpg", "jpeg"}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, summary.CopiedFilesCount)
+		assert.Empty(t, summary.Duplicates)
+		assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.jpg"))
+		assert.FileExists(t, existing, "existing target files are never removed")
+	})
+}
+
+func TestRunWatch_SortsNewFiles(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "initial.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type result struct {
+		summary pkg.ReportSummary
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		summary, err := photocp.RunWatch(ctx, []string{sourceDir}, targetDir, photocp.Options{}, 100*time.Millisecond)
+		done <- result{summary, err}
+	}()
+	waitForFile := func(path string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(path); err == nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("%s was not created", path)
+	}
+	waitForFile(filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png"))
+
+	// Dated by EXIF, as the file gets the current modification time.
+	content := jpegWithExif(t, image.NewGray(image.Rect(0, 0, 4, 4)), exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2021:06:15 08:00:00"}}})
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "new.jpg"), content, 0644))
+	waitForFile(filepath.Join(targetDir, "2021", "06", "2021-06-15-080000.jpg"))
+
+	cancel()
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("RunWatch did not return after cancellation")
+	}
+	require.NoError(t, res.err)
+	assert.False(t, res.summary.Interrupted)
+	assert.Equal(t, 2, res.summary.ProcessedFilesCount)
+	assert.Equal(t, 2, res.summary.CopiedFilesCount)
+	report, err := os.ReadFile(filepath.Join(targetDir, pkg.ReportFileName))
+	require.NoError(t, err)
+	assert.Contains(t, string(report), "Files successfully copied: 2")
+}
+
+func TestRunApplicationLogic_HashWorkersMatchSequential(t *testing.T) {
+	sourceDir := t.TempDir()
+	files := []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "copy/a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},               // Identical source
+		{Path: "collision.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},            // Same target name as a.png
+		{Path: "later.png", Content: pngMinimal_4x4_A, ModTime: sortFileTime.Add(time.Hour)}, // Copied
+		{Path: "empty.png", Content: []byte{}, ModTime: sortFileTime},
+	}
+	for i := 0; i < 12; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 3, 3))
+		img.Set(1, 1, color.RGBA{R: uint8(i * 20), A: 255})
+		content, err := encodePNG(img)
+		require.NoError(t, err)
+		files = append(files, fileSpec{Path: fmt.Sprintf("burst/%02d.png", i), Content: content, ModTime: sortFileTime.Add(time.Duration(i) * time.Minute)})
+	}
+	createTestFiles(t, sourceDir, files)
+
+	// run sorts the source with opts and returns its target files and duplicates relative to the directories.
+	run := func(opts photocp.Options) (map[string]string, []string, pkg.ReportSummary) {
+		targetDir := t.TempDir()
+		summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, opts)
+		require.NoError(t, err)
+		targets := make(map[string]string)
+		require.NoError(t, filepath.WalkDir(targetDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || pkg.IsGeneratedFileName(entry.Name()) {
+				return err
+			}
+			hash, hashErr := pkg.CalculateFileHash(path)
+			targets[strings.TrimPrefix(path, targetDir)] = hash
+			return hashErr
+		}))
+		var duplicates []string
+		for _, d := range summary.Duplicates {
+			duplicates = append(duplicates, fmt.Sprintf("%s <- %s: %s", strings.TrimPrefix(d.KeptFile, targetDir), strings.TrimPrefix(d.DiscardedFile, sourceDir), d.ReasonText()))
+		}
+		return targets, duplicates, summary
+	}
+
+	for _, opts := range []photocp.Options{{HashWorkers: 3}, {HashWorkers: 8, StreamScan: true}, {HashWorkers: 2, FastPixelHash: true}} {
+		sequential := opts
+		sequential.HashWorkers = 0
+		wantTargets, wantDuplicates, want := run(sequential)
+		require.Len(t, wantTargets, 13, "a.png, later.png and the burst but its first frame, which collides with a.png, are copied")
+		targets, duplicates, summary := run(opts)
+		assert.Equal(t, wantTargets, targets, "targets with %+v", opts)
+		assert.ElementsMatch(t, wantDuplicates, duplicates, "duplicates with %+v", opts)
+		assert.Equal(t, want.CopiedFilesCount, summary.CopiedFilesCount)
+		assert.Equal(t, want.ProcessedFilesCount, summary.ProcessedFilesCount)
+		assert.Equal(t, len(want.Skipped), len(summary.Skipped))
+		assert.Equal(t, want.ProcessingErrorCount, summary.ProcessingErrorCount)
+	}
+	assert.Error(t, pkg.Options{HashWorkers: -1}.Validate())
+}
+
+func TestRunApplicationLogic_MaxOpenFiles(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	var files []fileSpec
+	for i := 0; i < 20; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 3, 3))
+		img.Set(1, 1, color.RGBA{G: uint8(i * 10), A: 255})
+		content, err := encodePNG(img)
+		require.NoError(t, err)
+		files = append(files, fileSpec{Path: fmt.Sprintf("%02d.png", i), Content: content, ModTime: sortFileTime.Add(time.Duration(i) * time.Minute)})
+	}
+	createTestFiles(t, sourceDir, files)
+
+	// Eight hash workers share a single open file, which every hash and copy has to wait for.
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{HashWorkers: 8, MaxOpenFiles: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 20, summary.CopiedFilesCount)
+	assert.Zero(t, summary.ProcessingErrorCount)
+	assert.Error(t, pkg.Options{MaxOpenFiles: -1}.Validate())
+}
+
+func TestRunApplicationLogic_HardlinkTwice(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime}, // Linked as a "-1" version
+	})
+	opts := photocp.Options{Hardlink: true, ConflictStrategy: pkg.ConflictVersion}
+
+	first, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, first.CopiedFilesCount)
+
+	second, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, opts)
+	require.NoError(t, err)
+	assert.Zero(t, second.CopiedFilesCount)
+	assert.Zero(t, second.ProcessingErrorCount)
+	assert.Empty(t, second.Duplicates)
+	require.Len(t, second.Skipped, 2)
+	for _, skipped := range second.Skipped {
+		assert.Equal(t, pkg.AlreadyLinkedSkipReason, skipped.Reason, skipped.SourceFile)
+	}
+	entries, err := os.ReadDir(filepath.Join(targetDir, "2023", "10"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no further versions are linked")
+}
+
+func TestRunApplicationLogic_DirAndFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows does not honor Unix permission bits")
+	}
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: filepath.Join("sub", "empty.jpg"), Content: []byte{}, ModTime: sortFileTime},
+	})
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+	reportDir := filepath.Join(t.TempDir(), "reports")
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{
+		DirMode: 0700, FileMode: 0600, QuarantineDir: quarantineDir, MaintainIndex: true,
+		ReportPath: filepath.Join(reportDir, "report.txt"), ReportFormat: pkg.ReportFormatHTML,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.CopiedFilesCount)
+	require.Len(t, summary.Quarantined, 1)
+	for _, dir := range []string{filepath.Join(targetDir, "2023"), filepath.Join(targetDir, "2023", "10"), quarantineDir, filepath.Join(quarantineDir, "sub"), reportDir} {
+		info, err := os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, fs.FileMode(0700), info.Mode().Perm(), dir)
+	}
+	for _, file := range []string{
+		filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png"),
+		filepath.Join(quarantineDir, "sub", "empty.jpg"),
+		filepath.Join(targetDir, pkg.IndexFileName),
+		filepath.Join(reportDir, "report.txt"),
+		pkg.HTMLReportPath(filepath.Join(reportDir, "report.txt")),
+	} {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		assert.Equal(t, fs.FileMode(0600), info.Mode().Perm(), file)
+	}
+
+	mode, err := pkg.ParseFileMode("750")
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0750), mode)
+	_, err = pkg.ParseFileMode("0789")
+	assert.Error(t, err)
+	assert.Error(t, pkg.Options{DirMode: 0600}.Validate(), "directories the owner cannot search")
+	assert.Error(t, pkg.Options{FileMode: 0200}.Validate(), "files the owner cannot read")
+}
+
+func TestRunApplicationLogic_CopySidecars(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "IMG_0001.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "IMG_0001.xmp", Content: []byte("<x:xmpmeta/>"), ModTime: sortFileTime},
+		{Path: "copy/IMG_0001.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}, // Identical source
+		{Path: "copy/IMG_0001.AAE", Content: []byte("<plist/>"), ModTime: sortFileTime},
+		{Path: "other.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime}, // Discarded, its target name is taken
+		{Path: "other.xmp", Content: []byte("<x:xmpmeta/>"), ModTime: sortFileTime},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{CopySidecars: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.CopiedFilesCount)
+	assert.Equal(t, 1, summary.CopiedSidecarsCount)
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png"))
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.xmp"))
+
+	require.Len(t, summary.OrphanedSidecars, 2)
+	orphans := map[string]pkg.OrphanedSidecar{}
+	for _, o := range summary.OrphanedSidecars {
+		orphans[o.SidecarFile] = o
+	}
+	assert.Equal(t, filepath.Join(sourceDir, "copy", "IMG_0001.png"), orphans[filepath.Join(sourceDir, "copy", "IMG_0001.AAE")].SourceFile)
+	assert.Equal(t, filepath.Join(sourceDir, "other.png"), orphans[filepath.Join(sourceDir, "other.xmp")].SourceFile)
+
+	report, err := os.ReadFile(filepath.Join(targetDir, pkg.ReportFileName))
+	require.NoError(t, err)
+	assert.Contains(t, string(report), "Sidecar files copied: 1, orphaned: 2")
+	assert.Contains(t, string(report), "Orphaned sidecars:\n")
+	assert.Contains(t, string(report), "  - Sidecar: "+filepath.Join(sourceDir, "copy", "IMG_0001.AAE")+"\n")
+}
+
+// TestRunApplicationLogic_SkipsBeforeSorting tests that files skipped by the scan or a source list,
+// before anything is sorted, reach the machine log and JSON lines like the files skipped while sorting.
+func TestRunApplicationLogic_SkipsBeforeSorting(t *testing.T) {
+	sourceDir, _ := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "notes.txt", Content: []byte("not an image"), ModTime: sortFileTime},
+	})
+	listPath := filepath.Join(t.TempDir(), "list.txt")
+	require.NoError(t, os.WriteFile(listPath, []byte(filepath.Join(sourceDir, "a.png")+"\n"+filepath.Join(sourceDir, "missing.png")+"\n"), 0644))
+
+	for name, opts := range map[string]photocp.Options{
+		"scan": {ReportIncludeSkipped: true},
+		"list": {FromList: listPath},
+	} {
+		var machineLog, jsonLines bytes.Buffer
+		opts.MachineLog, opts.JSONLines = &machineLog, &jsonLines
+		summary, err := photocp.RunApplicationLogicSources(context.Background(), []string{sourceDir}, t.TempDir(), opts)
+		require.NoError(t, err, name)
+		require.Len(t, summary.Skipped, 1, name)
+		skipped := summary.Skipped[0]
+
+		assert.Equal(t, pkg.SkipLogLine(skipped)+"\n", machineLog.String(), name)
+		var events []pkg.FileEvent
+		scanner := bufio.NewScanner(&jsonLines)
+		for scanner.Scan() {
+			var event pkg.FileEvent
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &event), scanner.Text())
+			events = append(events, event)
+		}
+		assert.Contains(t, events, pkg.FileEvent{Path: skipped.SourceFile, Action: pkg.FileActionSkip, Reason: skipped.Reason}, name)
+	}
+}
+
+func TestRunApplicationLogic_MachineLog(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "copy/a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}, // Identical source
+		{Path: "other.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},  // Same target name as a.png
+		{Path: "my empty.png", Content: []byte{}, ModTime: sortFileTime},
+	})
+
+	var machineLog bytes.Buffer
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{MachineLog: &machineLog})
+	require.NoError(t, err)
+	target := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+
+	lines := strings.Split(strings.TrimSpace(machineLog.String()), "\n")
+	require.Len(t, lines, len(summary.Duplicates)+len(summary.Skipped))
+	parsed := make(map[string]map[string]string)
+	for _, line := range lines {
+		kind, fields, err := pkg.ParseMachineLogLine(line)
+		require.NoError(t, err, line)
+		key := kind + " " + fields["discarded"] + fields["file"]
+		parsed[key] = fields
+	}
+	assert.Equal(t, map[string]map[string]string{
+		"DUP " + filepath.Join(sourceDir, "copy", "a.png"): {"action": "discard", "reason": "source_duplicate", "kept": filepath.Join(sourceDir, "a.png"), "discarded": filepath.Join(sourceDir, "copy", "a.png")},
+		"DUP " + filepath.Join(sourceDir, "other.png"):     {"action": "discard", "reason": "name_collision", "kept": target, "discarded": filepath.Join(sourceDir, "other.png")},
+		"SKIP " + filepath.Join(sourceDir, "my empty.png"): {"reason": pkg.EmptyFileSkipReason, "file": filepath.Join(sourceDir, "my empty.png")},
+	}, parsed)
+	assert.Contains(t, machineLog.String(), `SKIP reason="Skipped (empty file)" file=`)
+
+	line := pkg.DuplicateLogLine(pkg.DuplicateInfo{KeptFile: "/new.png", DiscardedFile: `/old "x".png`, Reason: pkg.ReasonPixelHashMatch, Replaced: true})
+	assert.Equal(t, `DUP action=replace reason=pixel_hash_match kept=/new.png discarded="/old \"x\".png"`, line)
+	kind, fields, err := pkg.ParseMachineLogLine(line)
+	require.NoError(t, err)
+	assert.Equal(t, pkg.MachineLogDuplicate, kind)
+	assert.Equal(t, `/old "x".png`, fields["discarded"])
+	_, _, err = pkg.ParseMachineLogLine("time=2023-10-27 level=INFO msg=Copied")
+	assert.Error(t, err)
+}
+
+func TestRunApplicationLogic_JSONLines(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "copy/a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}, // Identical source
+		{Path: "other.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},  // Same target name as a.png
+		{Path: "empty.png", Content: []byte{}, ModTime: sortFileTime},
+	})
+
+	// Nothing reaches output unless every event is flushed from the buffered writer.
+	var output bytes.Buffer
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{JSONLines: bufio.NewWriter(&output)})
+	require.NoError(t, err)
+	target := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+
+	events := make(map[string]pkg.FileEvent)
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		var event pkg.FileEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event), scanner.Text())
+		events[event.Path] = event
+	}
+	require.Len(t, events, summary.ProcessedFilesCount)
+	assert.Equal(t, map[string]pkg.FileEvent{
+		filepath.Join(sourceDir, "a.png"):         {Path: filepath.Join(sourceDir, "a.png"), Target: target, Action: pkg.FileActionCopy, DateSource: pkg.DateSourceFileModTime},
+		filepath.Join(sourceDir, "copy", "a.png"): {Path: filepath.Join(sourceDir, "copy", "a.png"), Target: filepath.Join(sourceDir, "a.png"), Action: pkg.FileActionDuplicate, Reason: "source_duplicate"},
+		filepath.Join(sourceDir, "other.png"):     {Path: filepath.Join(sourceDir, "other.png"), Target: target, Action: pkg.FileActionDuplicate, Reason: "name_collision", DateSource: pkg.DateSourceFileModTime},
+		filepath.Join(sourceDir, "empty.png"):     {Path: filepath.Join(sourceDir, "empty.png"), Action: pkg.FileActionSkip, Reason: pkg.EmptyFileSkipReason},
+	}, events)
+	assert.NotContains(t, output.String(), `"target":""`, "empty fields are left out")
+
+	event := pkg.NewFileEvent("/src/broken.png", pkg.FileOutcome{}, fmt.Errorf("permission denied"))
+	assert.Equal(t, pkg.FileEvent{Path: "/src/broken.png", Action: pkg.FileActionError, Reason: "permission denied"}, event)
+	event = pkg.NewFileEvent("/src/a.png", pkg.FileOutcome{Copied: true, TargetPath: "/t/a.png", Duplicate: &pkg.DuplicateInfo{Reason: pkg.ReasonPixelHashMatch, Replaced: true}}, nil)
+	assert.Equal(t, pkg.FileActionReplace, event.Action)
+	assert.Equal(t, "pixel_hash_match", event.Reason)
+}
+
+// TestPhotocp_JSONLinesToStdout tests that with -jsonl -, the photocp command prints nothing but JSON
+// lines to standard output, even where the run prints warnings and the report paths.
+func TestPhotocp_JSONLinesToStdout(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not available")
+	}
+	binary := filepath.Join(t.TempDir(), "photocp")
+	build := exec.Command("go", "build", "-o", binary, "../cmd/photocp")
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "other.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime}, // Same target name as a.png
+		{Path: "empty.png", Content: []byte{}, ModTime: sortFileTime},
+	})
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, "-sourceDir", sourceDir, "-targetDir", targetDir, "-jsonl", "-", "-reportFormat", "html")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	require.NoError(t, cmd.Run(), stderr.String())
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	require.Len(t, lines, 3, stdout.String())
+	for _, line := range lines {
+		var event pkg.FileEvent
+		assert.NoError(t, json.Unmarshal([]byte(line), &event), "standard output line %q", line)
+	}
+	assert.Contains(t, stderr.String(), "Run summary")
+	assert.Contains(t, stderr.String(), "Report generated at")
+	assert.Contains(t, stderr.String(), "HTML report generated at")
+
+	cmd = exec.Command(binary, "-sourceDir", sourceDir, "-targetDir", t.TempDir(), "-jsonl", "-", "-machineLog")
+	assert.Error(t, cmd.Run(), "-machineLog cannot share standard output with the JSON lines")
+}
+
+func TestRunApplicationLogic_FromList(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "sub/b.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime.Add(time.Hour)},
+		{Path: "sub/copy of a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}, // Identical to a.png
+		{Path: "unlisted.png", Content: pngMinimal_4x4_A, ModTime: sortFileTime.Add(2 * time.Hour)},
+		{Path: "clip.mp4", Content: []byte("not really a video"), ModTime: sortFileTime},
+		{Path: "notes.txt", Content: []byte("notes"), ModTime: sortFileTime},
+	})
+	listPath := filepath.Join(t.TempDir(), "selection.txt")
+	list := strings.Join([]string{
+		"# Selected by another tool",
+		filepath.Join(sourceDir, "a.png"),
+		"sub/b.png", // Relative to the current directory
+		"",
+		"  " + filepath.Join(sourceDir, "sub", "copy of a.png") + "  ",
+		filepath.Join(sourceDir, "missing.png"),
+		filepath.Join(sourceDir, "clip.mp4"),
+		filepath.Join(sourceDir, "notes.txt"),
+		filepath.Join(sourceDir, "sub"),
+	}, "\n")
+	require.NoError(t, os.WriteFile(listPath, []byte(list), 0644))
+	t.Chdir(sourceDir)
+
+	summary, err := photocp.RunApplicationLogicSources(context.Background(), nil, targetDir, photocp.Options{FromList: listPath})
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.ProcessedFilesCount)
+	assert.Equal(t, 2, summary.CopiedFilesCount)
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png"))
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-163000.png"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-173000.png"), "unlisted files are not sorted")
+	require.Len(t, summary.Duplicates, 1)
+	assert.Equal(t, filepath.Join(sourceDir, "sub", "copy of a.png"), summary.Duplicates[0].DiscardedFile)
+
+	skipped := make(map[string]string)
+	for _, s := range summary.Skipped {
+		skipped[s.SourceFile] = s.Reason
+	}
+	assert.Equal(t, map[string]string{
+		filepath.Join(sourceDir, "missing.png"): pkg.MissingFileSkipReason,
+		filepath.Join(sourceDir, "clip.mp4"):    pkg.VideoSkipReason,
+		filepath.Join(sourceDir, "notes.txt"):   pkg.UnsupportedFormatSkipReason,
+		filepath.Join(sourceDir, "sub"):         pkg.NotAFileSkipReason,
+	}, skipped)
+
+	_, err = photocp.RunApplicationLogicSources(context.Background(), nil, targetDir, photocp.Options{FromList: filepath.Join(sourceDir, "no such list.txt")})
+	assert.Error(t, err)
+}
+
+func TestRunApplicationLogic_CollapseBursts(t *testing.T) {
+	// stripes returns 64x48 vertical stripes, softened over blur pixels on either side of each edge,
+	// or horizontal ones for another subject.
+	stripes := func(blur int, horizontal bool) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 64, 48))
+		for y := 0; y < 48; y++ {
+			for x := 0; x < 64; x++ {
+				pos := x
+				if horizontal {
+					pos = y
+				}
+				sum := 0
+				for d := -blur; d <= blur; d++ {
+					if (pos+d+64)/8%2 == 0 {
+						sum += 255
+					}
+				}
+				img.SetGray(x, y, color.Gray{Y: uint8(sum / (2*blur + 1))})
+			}
+		}
+		return img
+	}
+	shot := func(img image.Image, date string) []byte {
+		return jpegWithExif(t, img, exifSpec{Exif: []exifTag{{exifTagDateTimeOriginal, date}}})
+	}
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "IMG_0001.jpg", Content: shot(stripes(3, false), "2023:07:01 10:00:00"), ModTime: sortFileTime},
+		{Path: "IMG_0002.jpg", Content: shot(stripes(0, false), "2023:07:01 10:00:01"), ModTime: sortFileTime}, // The sharpest
+		{Path: "IMG_0003.jpg", Content: shot(stripes(2, false), "2023:07:01 10:00:02"), ModTime: sortFileTime},
+		{Path: "IMG_0004.jpg", Content: shot(stripes(0, true), "2023:07:01 10:00:03"), ModTime: sortFileTime},  // Another subject
+		{Path: "IMG_0005.jpg", Content: shot(stripes(1, false), "2023:07:01 10:05:00"), ModTime: sortFileTime}, // Much later
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{CollapseBursts: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.CopiedFilesCount)
+	collapsed := make(map[string]string)
+	for _, d := range summary.Duplicates {
+		assert.Equal(t, pkg.ReasonBurstCollapsed, d.Reason)
+		collapsed[filepath.Base(d.DiscardedFile)] = filepath.Base(d.KeptFile)
+	}
+	// The kept shot is reported by its target, as for identical sources.
+	assert.Equal(t, map[string]string{"IMG_0001.jpg": "2023-07-01-100001.jpg", "IMG_0003.jpg": "2023-07-01-100001.jpg"}, collapsed)
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "07", "2023-07-01-100001.jpg"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "2023", "07", "2023-07-01-100000.jpg"))
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "07", "2023-07-01-100003.jpg"))
+	assert.FileExists(t, filepath.Join(targetDir, "2023", "07", "2023-07-01-100500.jpg"))
+
+	// If the best shot cannot be copied, the next shot of its burst is sorted instead.
+	otherTarget := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(otherTarget, "2023", "07", "2023-07-01-100001.jpg", "blocked"), 0755))
+	summary, err = photocp.RunApplicationLogicWithOptions(sourceDir, otherTarget, photocp.Options{CollapseBursts: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.CopiedFilesCount)
+	collapsed = make(map[string]string)
+	for _, d := range summary.Duplicates {
+		if d.Reason == pkg.ReasonBurstCollapsed {
+			collapsed[filepath.Base(d.DiscardedFile)] = filepath.Base(d.KeptFile)
+		}
+	}
+	assert.Equal(t, map[string]string{"IMG_0003.jpg": "2023-07-01-100000.jpg"}, collapsed)
+	assert.FileExists(t, filepath.Join(otherTarget, "2023", "07", "2023-07-01-100000.jpg"))
+
+	// Without the option, every shot is sorted.
+	summary, err = photocp.RunApplicationLogicWithOptions(sourceDir, t.TempDir(), photocp.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, summary.CopiedFilesCount)
+	assert.Error(t, pkg.Options{BurstWindow: -time.Second}.Validate())
+}
+
+func TestRunApplicationLogic_TargetInsideSource(t *testing.T) {
+	for _, streamScan := range []bool{false, true} {
+		sourceDir := t.TempDir()
+		targetDir := filepath.Join(sourceDir, "zz-sorted") // Walked after the source files, so a streaming scan would find their copies
+		createTestFiles(t, sourceDir, []fileSpec{
+			{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+			{Path: "b.png", Content: pngMinimal_4x4_A, ModTime: sortFileTime.Add(time.Hour)},
+		})
+		opts := photocp.Options{StreamScan: streamScan, ReportIncludeSkipped: true}
+
+		for run := 1; run <= 2; run++ {
+			summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, opts)
+			require.NoError(t, err)
+			assert.Equal(t, 2, summary.ProcessedFilesCount, "streamScan %v, run %d: the target is not scanned", streamScan, run)
+			if run == 1 {
+				assert.Equal(t, 2, summary.CopiedFilesCount)
+				assert.Empty(t, summary.Duplicates)
+			}
+			for _, d := range summary.Duplicates {
+				assert.False(t, pkg.WithinDirectory(d.DiscardedFile, targetDir), "target file %s was sorted again", d.DiscardedFile)
+			}
+			for _, s := range summary.Skipped {
+				assert.False(t, pkg.WithinDirectory(s.SourceFile, targetDir), "target file %s was listed as skipped", s.SourceFile)
+			}
+		}
+		assert.FileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png"))
+		assert.NoDirExists(t, filepath.Join(targetDir, "2023", "10", "zz-sorted"))
+		assert.NoFileExists(t, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000-1.png"))
+	}
+
+	assert.True(t, pkg.WithinDirectory("/photos/sorted/x.png", "/photos"))
+	assert.False(t, pkg.WithinDirectory("/photos", "/photos"))
+	assert.False(t, pkg.WithinDirectory("/photos-sorted/x.png", "/photos"))
+	files, nested := pkg.ExcludeNestedTarget([]string{"/photos/a.png", "/photos/sorted/a.png"}, "/photos", "/photos/sorted")
+	assert.True(t, nested)
+	assert.Equal(t, []string{"/photos/a.png"}, files)
+	files, nested = pkg.ExcludeNestedTarget([]string{"/photos/a.png"}, "/photos", "/photos")
+	assert.False(t, nested, "sorting in place is not a nested target")
+	assert.Equal(t, []string{"/photos/a.png"}, files)
 }
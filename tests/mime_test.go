@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	photocp "github.com/user/photo-sorter/cmd/photocp/lib"
+	"github.com/user/photo-sorter/pkg"
+)
+
+// mp4Header is the start of an MP4 video file.
+var mp4Header = []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+
+func TestExtensionMismatch(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		content  []byte
+		wantExt  string
+		mismatch bool
+	}{
+		{"png.jpg", pngMinimal_2x2_A, ".png", true},
+		{"png.PNG", pngMinimal_2x2_A, "", false},
+		{"video.jpg", mp4Header, ".mp4", true},
+		{"photo.jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0, 0, 0, 0, 0, 0, 0}, "", false},
+		{"unknown.jpg", []byte("no known signature"), "", false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, tt.content, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if ext, mismatch := pkg.ExtensionMismatch(path); ext != tt.wantExt || mismatch != tt.mismatch {
+			t.Errorf("ExtensionMismatch(%s) = %q, %v, want %q, %v", tt.name, ext, mismatch, tt.wantExt, tt.mismatch)
+		}
+	}
+}
+
+func TestSortFile_ValidateMime(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "IMG_0001.jpg", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	source := filepath.Join(sourceDir, "IMG_0001.jpg")
+
+	outcome, err := pkg.SortFile(source, targetDir, pkg.Options{ValidateMime: true, MimeMismatchAction: pkg.MimeMismatchQuarantine})
+	if !errors.Is(err, pkg.ErrExtensionMismatch) || outcome.Copied {
+		t.Errorf("SortFile() with quarantine = %+v, %v, want ErrExtensionMismatch", outcome, err)
+	}
+
+	outcome, err = pkg.SortFile(source, targetDir, pkg.Options{ValidateMime: true})
+	if err != nil {
+		t.Fatalf("SortFile() with fix error = %v", err)
+	}
+	want := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+	if !outcome.Copied || outcome.TargetPath != want || outcome.CorrectedExtension != ".png" {
+		t.Errorf("SortFile() with fix = %+v, want the file copied to %s with corrected extension .png", outcome, want)
+	}
+
+	if err := (pkg.Options{MimeMismatchAction: "rename"}).Validate(); err == nil {
+		t.Error("Validate() accepted an unknown MimeMismatchAction")
+	}
+}
+
+func TestRunApplicationLogic_ValidateMime(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	quarantineDir := t.TempDir()
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "IMG_0001.jpg", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "IMG_0002.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime.Add(time.Hour)},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, pkg.Options{ValidateMime: true})
+	if err != nil {
+		t.Fatalf("RunApplicationLogicWithOptions() error = %v", err)
+	}
+	if len(summary.ExtensionCorrections) != 1 || summary.ExtensionCorrections[0].Extension != ".png" {
+		t.Errorf("ExtensionCorrections = %+v, want one correction to .png", summary.ExtensionCorrections)
+	}
+	report, err := os.ReadFile(filepath.Join(targetDir, pkg.ReportFileName))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(report), "Corrected extensions:\n  - Source: "+filepath.Join(sourceDir, "IMG_0001.jpg")+"\n") {
+		t.Errorf("report does not list the corrected extension:\n%s", report)
+	}
+
+	summary, err = photocp.RunApplicationLogicWithOptions(sourceDir, t.TempDir(), pkg.Options{ValidateMime: true, MimeMismatchAction: pkg.MimeMismatchQuarantine, QuarantineDir: quarantineDir})
+	if err != nil {
+		t.Fatalf("RunApplicationLogicWithOptions() with quarantine error = %v", err)
+	}
+	if summary.CopiedFilesCount != 1 || len(summary.Quarantined) != 1 {
+		t.Errorf("summary = %d copied, %+v quarantined, want 1 copied and IMG_0001.jpg quarantined", summary.CopiedFilesCount, summary.Quarantined)
+	}
+	if _, err := os.Stat(filepath.Join(quarantineDir, "IMG_0001.jpg")); err != nil {
+		t.Errorf("quarantined file not found: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// animatedWebP builds an animated WebP whose frames are the images of the given still 1x1 WebP files,
+// each shown for durationMs.
+func animatedWebP(durationMs int, stills ...[]byte) []byte {
+	chunk := func(fourCC string, payload []byte) []byte {
+		out := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+		out = append(out, payload...)
+		if len(payload)%2 == 1 {
+			out = append(out, 0)
+		}
+		return out
+	}
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	body.Write(chunk("VP8X", []byte{1 << 1, 0, 0, 0, 0, 0, 0, 0, 0, 0})) // Animation flag, 1x1 canvas
+	body.Write(chunk("ANIM", []byte{0, 0, 0, 0, 0, 0}))
+	for _, still := range stills {
+		frame := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, byte(durationMs), byte(durationMs >> 8), 0, 0}
+		frame = append(frame, still[12:]...) // The still's VP8 or VP8L chunk
+		body.Write(chunk("ANMF", frame))
+	}
+	return chunk("RIFF", body.Bytes())
+}
+
+func TestCalculatePixelDataHash_AnimatedWebP(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"still.webp":     webpLossy1x1,
+		"animated.webp":  animatedWebP(100, webpLossy1x1, webpLossless1x1),
+		"reversed.webp":  animatedWebP(100, webpLossless1x1, webpLossy1x1),
+		"slower.webp":    animatedWebP(200, webpLossy1x1, webpLossless1x1),
+		"oneframe.webp":  animatedWebP(100, webpLossy1x1),
+		"truncated.webp": animatedWebP(100, webpLossy1x1, webpLossless1x1)[:60],
+	}
+	hashes := make(map[string]string)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		hash, err := pkg.CalculatePixelDataHash(path)
+		if name == "truncated.webp" {
+			if err == nil {
+				t.Errorf("CalculatePixelDataHash(%s) succeeded, expected an error", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("CalculatePixelDataHash(%s) error = %v", name, err)
+		}
+		hashes[name] = hash
+	}
+
+	if hashes["animated.webp"] == hashes["still.webp"] {
+		t.Error("an animation hashes like the still of its first frame")
+	}
+	if hashes["animated.webp"] == hashes["reversed.webp"] || hashes["animated.webp"] == hashes["slower.webp"] {
+		t.Error("animations with different frame order or timing hash the same")
+	}
+	if hashes["oneframe.webp"] != hashes["still.webp"] {
+		t.Error("a single-frame animation should hash like the still image")
+	}
+	if count, err := pkg.ImageCount(filepath.Join(dir, "animated.webp")); err != nil || count != 2 {
+		t.Errorf("ImageCount(animated.webp) = %d, %v, want 2", count, err)
+	}
+	if err := pkg.ValidateImageIntegrity(filepath.Join(dir, "animated.webp")); err != nil {
+		t.Errorf("ValidateImageIntegrity(animated.webp) error = %v", err)
+	}
+}
+
+// heifItem is an item of the meta box built by heifFile.
+type heifItem struct {
+	id       uint16
+	itemType string
+}
+
+// heifRef is a reference of the iref box built by heifFile, e.g. from a grid to its tiles ("dimg").
+type heifRef struct {
+	refType string
+	from    uint16
+	to      []uint16
+}
+
+// heifFile builds the box structure of a HEIF file with the given items and references and no image
+// data. A sequence adds an (empty) moov box, as in image sequence files.
+func heifFile(items []heifItem, refs []heifRef, sequence bool) []byte {
+	box := func(boxType string, payload ...[]byte) []byte {
+		body := bytes.Join(payload, nil)
+		return append(append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), boxType...), body...)
+	}
+	fullBox := func(boxType string, version byte, payload ...[]byte) []byte {
+		return box(boxType, append([][]byte{{version, 0, 0, 0}}, payload...)...)
+	}
+	u16 := func(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+
+	entries := [][]byte{u16(uint16(len(items)))}
+	for _, item := range items {
+		entries = append(entries, fullBox("infe", 2, u16(item.id), u16(0), []byte(item.itemType), []byte{0}))
+	}
+	var references [][]byte
+	for _, ref := range refs {
+		fields := [][]byte{u16(ref.from), u16(uint16(len(ref.to)))}
+		for _, to := range ref.to {
+			fields = append(fields, u16(to))
+		}
+		references = append(references, box(ref.refType, fields...))
+	}
+	file := append(box("ftyp", []byte("heic"), []byte{0, 0, 0, 0}, []byte("mif1heic")),
+		fullBox("meta", 0, fullBox("iinf", 0, entries...), fullBox("iref", 0, references...))...)
+	if sequence {
+		file = append(file, box("moov")...)
+	}
+	return append(file, box("mdat")...)
+}
+
+func TestImageCount_HEIF(t *testing.T) {
+	tiles := []heifItem{{2, "hvc1"}, {3, "hvc1"}, {4, "hvc1"}, {5, "hvc1"}}
+	tests := []struct {
+		name     string
+		content  []byte
+		expected int
+	}{
+		{
+			name: "grid still with thumbnail, depth map and EXIF",
+			content: heifFile(append([]heifItem{{1, "grid"}, {6, "hvc1"}, {7, "hvc1"}, {8, "Exif"}}, tiles...),
+				[]heifRef{{"dimg", 1, []uint16{2, 3, 4, 5}}, {"thmb", 6, []uint16{1}}, {"auxl", 7, []uint16{1}}, {"cdsc", 8, []uint16{1}}}, false),
+			expected: 1,
+		},
+		{
+			name:     "burst of three",
+			content:  heifFile([]heifItem{{1, "hvc1"}, {2, "hvc1"}, {3, "hvc1"}, {4, "hvc1"}}, []heifRef{{"thmb", 4, []uint16{1}}}, false),
+			expected: 3,
+		},
+		{
+			name:     "image sequence",
+			content:  heifFile([]heifItem{{1, "hvc1"}}, nil, true),
+			expected: 2,
+		},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "image.heic")
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			if count, err := pkg.ImageCount(path); err != nil || count != tt.expected {
+				t.Errorf("ImageCount() = %d, %v, want %d", count, err, tt.expected)
+			}
+		})
+	}
+
+	path := filepath.Join(dir, "still.png")
+	if err := os.WriteFile(path, pngMinimal_2x2_A, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if count, err := pkg.ImageCount(path); err != nil || count != 1 {
+		t.Errorf("ImageCount(still.png) = %d, %v, want 1", count, err)
+	}
+}
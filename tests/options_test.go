@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	photocp "github.com/user/photo-sorter/cmd/photocp/lib"
+	"github.com/user/photo-sorter/pkg"
+)
+
+func TestNewOptions_Defaults(t *testing.T) {
+	opts := pkg.NewOptions()
+	want := pkg.Options{
+		FilenameFormat:   pkg.DefaultFilenameFormat,
+		DateStrategy:     pkg.DateStrategyExifFirst,
+		ConflictStrategy: pkg.ConflictKeepTarget,
+		CopyRetryDelay:   pkg.DefaultCopyRetryDelay,
+	}
+	if opts.FilenameFormat != want.FilenameFormat || opts.DateStrategy != want.DateStrategy ||
+		opts.ConflictStrategy != want.ConflictStrategy || opts.CopyRetryDelay != want.CopyRetryDelay {
+		t.Errorf("NewOptions() = %+v, want defaults %+v", opts, want)
+	}
+	if opts.Verbose || opts.Move || opts.Flatten || opts.Layout != "" || opts.MaxDepth != 0 || opts.CopyRetries != 0 || opts.Logger != nil {
+		t.Errorf("NewOptions() = %+v, want all other fields unset", opts)
+	}
+	if err := opts.Validate(); err != nil {
+		t.Errorf("NewOptions().Validate() error = %v", err)
+	}
+}
+
+func TestNewOptions_Apply(t *testing.T) {
+	opts := pkg.NewOptions(
+		pkg.WithVerbose(true),
+		pkg.WithMove(true),
+		pkg.WithLayout("2006/01-Jan"),
+		pkg.WithConflictStrategy(pkg.ConflictVersion),
+		pkg.WithCopyRetries(3, time.Second),
+		pkg.WithKnownHashesFile("hashes.txt", true),
+		pkg.WithMaxDepth(1),
+		pkg.WithMaxDepth(2), // Later options win
+	)
+	if !opts.Verbose || !opts.Move || opts.Layout != "2006/01-Jan" || opts.ConflictStrategy != pkg.ConflictVersion {
+		t.Errorf("NewOptions() = %+v, want verbose, move, custom layout and version conflicts", opts)
+	}
+	if opts.CopyRetries != 3 || opts.CopyRetryDelay != time.Second {
+		t.Errorf("NewOptions() copy retries = %d after %v, want 3 after 1s", opts.CopyRetries, opts.CopyRetryDelay)
+	}
+	if opts.KnownHashesFile != "hashes.txt" || !opts.UpdateKnownHashes {
+		t.Errorf("NewOptions() known hashes = %q (update %v), want hashes.txt updated", opts.KnownHashesFile, opts.UpdateKnownHashes)
+	}
+	if opts.MaxDepth != 2 {
+		t.Errorf("NewOptions() MaxDepth = %d, want 2", opts.MaxDepth)
+	}
+	if opts.FilenameFormat != pkg.DefaultFilenameFormat {
+		t.Errorf("NewOptions() FilenameFormat = %q, want the default to be kept", opts.FilenameFormat)
+	}
+}
+
+func TestNewOptions_Run(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, pkg.NewOptions(pkg.WithFlatten(true)))
+	if err != nil {
+		t.Fatalf("RunApplicationLogicWithOptions() error = %v", err)
+	}
+	if summary.CopiedFilesCount != 1 {
+		t.Errorf("CopiedFilesCount = %d, want 1", summary.CopiedFilesCount)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "2023-10-27-153000.png")); err != nil {
+		t.Errorf("flattened target file missing: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+func TestLoadDateOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.csv")
+	content := "filename,correctdate\n" +
+		"scan1.jpg,1998-07-14 12:30:00\n" +
+		"scan2.jpg, 1998:07:15 08:00:00\n" +
+		"\"scan, three.png\",1999-01-02\n" +
+		"scan4.jpg,2001-02-03T04:05:06+02:00\n" +
+		"scan5.jpg,last summer\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write overrides file: %v", err)
+	}
+
+	logger := &capturingLogger{}
+	overrides, err := pkg.LoadDateOverrides(path, logger)
+	if err != nil {
+		t.Fatalf("pkg.LoadDateOverrides() unexpected error: %v", err)
+	}
+	expected := map[string]time.Time{
+		"scan1.jpg":       time.Date(1998, 7, 14, 12, 30, 0, 0, time.UTC),
+		"scan2.jpg":       time.Date(1998, 7, 15, 8, 0, 0, 0, time.UTC),
+		"scan, three.png": time.Date(1999, 1, 2, 0, 0, 0, 0, time.UTC),
+		"scan4.jpg":       time.Date(2001, 2, 3, 2, 5, 6, 0, time.UTC),
+	}
+	if len(overrides) != len(expected) {
+		t.Errorf("pkg.LoadDateOverrides() returned %d overrides, expected %d: %v", len(overrides), len(expected), overrides)
+	}
+	for name, want := range expected {
+		if got, ok := overrides[name]; !ok || !got.Equal(want) {
+			t.Errorf("override for %q = %v (found %v), expected %v", name, got, ok, want)
+		}
+	}
+	if _, ok := logger.find("WARN", "Ignoring date override with unparseable date"); !ok {
+		t.Error("expected a warning for the unparseable date")
+	}
+}
+
+func TestLoadDateOverrides_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"too_many_fields.csv": "a.jpg,2020-01-01,extra\n",
+		"one_field.csv":       "a.jpg\n",
+		"empty_name.csv":      ",2020-01-01\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		if _, err := pkg.LoadDateOverrides(path, &capturingLogger{}); err == nil {
+			t.Errorf("pkg.LoadDateOverrides(%s) expected an error", name)
+		}
+	}
+	if _, err := pkg.LoadDateOverrides(filepath.Join(dir, "missing.csv"), &capturingLogger{}); err == nil {
+		t.Error("pkg.LoadDateOverrides() of a missing file expected an error")
+	}
+}
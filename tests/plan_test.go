@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+func TestPlanImport(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	existingRel := filepath.Join("2023", "10", "2023-10-27-153000.png")
+	createTestFiles(t, targetDir, []fileSpec{{Path: existingRel, Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "dup.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},                  // Same as the existing target
+		{Path: "collision.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},            // Different file, same target name
+		{Path: "new.png", Content: pngMinimal_4x4_C, ModTime: sortFileTime.Add(time.Hour)},   // Free target name
+		{Path: "other.png", Content: pngMinimal_4x4_A, ModTime: sortFileTime.Add(time.Hour)}, // Target name taken by new.png's planned copy
+	})
+	existing := filepath.Join(targetDir, existingRel)
+
+	plan, err := pkg.PlanImport(sourceDir, targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("PlanImport() error = %v", err)
+	}
+	want := map[string]struct {
+		action pkg.PlanAction
+		target string
+		reason pkg.Reason
+	}{
+		"collision.png": {pkg.PlanCollision, existing, pkg.ReasonNameCollision},
+		"dup.png":       {pkg.PlanSkipDuplicate, existing, pkg.ReasonPixelHashMatch},
+		"new.png":       {pkg.PlanCopy, filepath.Join(targetDir, "2023", "10", "2023-10-27-163000.png"), ""},
+		"other.png":     {pkg.PlanCollision, filepath.Join(targetDir, "2023", "10", "2023-10-27-163000.png"), pkg.ReasonNameCollision},
+	}
+	if len(plan.Entries) != len(want) {
+		t.Fatalf("PlanImport() has %d entries, want %d: %+v", len(plan.Entries), len(want), plan.Entries)
+	}
+	for _, entry := range plan.Entries {
+		w := want[filepath.Base(entry.SourcePath)]
+		if entry.Action != w.action || entry.TargetPath != w.target || entry.Reason != w.reason {
+			t.Errorf("plan for %s = %s to %q (%s), want %s to %q (%s)", filepath.Base(entry.SourcePath), entry.Action, entry.TargetPath, entry.Reason, w.action, w.target, w.reason)
+		}
+		if entry.DateSource != pkg.DateSourceFileModTime {
+			t.Errorf("plan for %s has date source %q, want %q", filepath.Base(entry.SourcePath), entry.DateSource, pkg.DateSourceFileModTime)
+		}
+	}
+
+	// Nothing was written: the target only holds the existing file.
+	if entries, _ := os.ReadDir(filepath.Join(targetDir, "2023", "10")); len(entries) != 1 {
+		t.Errorf("target directory has %d entries after planning, want 1", len(entries))
+	}
+}
+
+func TestPlanImport_PlannedTargets(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},
+	})
+
+	plan, err := pkg.PlanImport(sourceDir, targetDir, pkg.Options{ConflictStrategy: pkg.ConflictVersion})
+	if err != nil {
+		t.Fatalf("PlanImport() error = %v", err)
+	}
+	if len(plan.Entries) != 2 || plan.Count(pkg.PlanCopy) != 1 || plan.Count(pkg.PlanVersion) != 1 {
+		t.Fatalf("PlanImport() = %+v, want one copy and one version", plan.Entries)
+	}
+	wantVersion := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000-1.png")
+	if plan.Entries[1].TargetPath != wantVersion {
+		t.Errorf("version planned at %q, want %q", plan.Entries[1].TargetPath, wantVersion)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "2023")); !os.IsNotExist(err) {
+		t.Errorf("PlanImport() created target directories (stat error %v)", err)
+	}
+}
+
+func TestPlanImport_CollapseBursts(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(x / 8 % 2 * 255)})
+		}
+	}
+	shot := func(date string) []byte {
+		return jpegWithExif(t, img, exifSpec{Exif: []exifTag{{exifTagDateTimeOriginal, date}}})
+	}
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "IMG_0001.jpg", Content: shot("2023:07:01 10:00:00"), ModTime: sortFileTime},
+		{Path: "IMG_0002.jpg", Content: shot("2023:07:01 10:00:01"), ModTime: sortFileTime},
+	})
+
+	plan, err := pkg.PlanImport(sourceDir, targetDir, pkg.Options{CollapseBursts: true})
+	if err != nil {
+		t.Fatalf("PlanImport() error = %v", err)
+	}
+	if len(plan.Entries) != 2 || plan.Count(pkg.PlanCopy) != 1 || plan.Count(pkg.PlanSkipDuplicate) != 1 {
+		t.Fatalf("PlanImport() = %+v, want one copy and one collapsed shot", plan.Entries)
+	}
+	collapsed := plan.Entries[1]
+	if collapsed.Action == pkg.PlanCopy {
+		collapsed = plan.Entries[0]
+	}
+	if collapsed.Reason != pkg.ReasonBurstCollapsed {
+		t.Errorf("collapsed shot planned with reason %q, want %q", collapsed.Reason, pkg.ReasonBurstCollapsed)
+	}
+
+	plan, err = pkg.PlanImport(sourceDir, targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("PlanImport() error = %v", err)
+	}
+	if plan.Count(pkg.PlanCopy) != 2 {
+		t.Errorf("PlanImport() without CollapseBursts = %+v, want two copies", plan.Entries)
+	}
+}
+
+// TestPlanImport_NoOverwrite tests that a better duplicate is planned like a run with NoOverwrite sorts it:
+// discarded, or copied to a new version with ConflictVersion, instead of replacing the target.
+func TestPlanImport_NoOverwrite(t *testing.T) {
+	targetRel := filepath.Join("2023", "10", "2023-10-27-153000.gif")
+	for _, tt := range []struct {
+		conflictStrategy string
+		wantAction       pkg.PlanAction
+		wantTarget       string
+	}{
+		{"", pkg.PlanSkipDuplicate, targetRel},
+		{pkg.ConflictVersion, pkg.PlanVersion, filepath.Join("2023", "10", "2023-10-27-153000-1.gif")},
+	} {
+		sourceDir, targetDir := setupTestDirs(t)
+		createTestFiles(t, targetDir, []fileSpec{{Path: targetRel, Content: scaledImage(7, 1), ModTime: sortFileTime}})
+		createTestFiles(t, sourceDir, []fileSpec{{Path: "big.gif", Content: scaledImage(7, 4), ModTime: sortFileTime}})
+
+		plan, err := pkg.PlanImport(sourceDir, targetDir, pkg.Options{NoOverwrite: true, ConflictStrategy: tt.conflictStrategy})
+		if err != nil {
+			t.Fatalf("PlanImport() error = %v", err)
+		}
+		if len(plan.Entries) != 1 {
+			t.Fatalf("PlanImport() = %+v, want one entry", plan.Entries)
+		}
+		entry := plan.Entries[0]
+		if wantTarget := filepath.Join(targetDir, tt.wantTarget); entry.Action != tt.wantAction || entry.TargetPath != wantTarget {
+			t.Errorf("plan with conflict strategy %q = %s to %q, want %s to %q", tt.conflictStrategy, entry.Action, entry.TargetPath, tt.wantAction, wantTarget)
+		}
+		if tt.wantAction == pkg.PlanSkipDuplicate && entry.Detail != "existing target kept - no overwrite" {
+			t.Errorf("plan detail = %q, want the target kept because of no overwrite", entry.Detail)
+		}
+	}
+}
+
+// TestPlanImport_HistogramThreshold tests that a source with a similar color histogram is planned as a
+// duplicate that keeps the target, as a run discards it, rather than as a version of the taken name.
+func TestPlanImport_HistogramThreshold(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	targetPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+	createTestFiles(t, targetDir, []fileSpec{{Path: filepath.Join("2023", "10", "2023-10-27-153000.png"), Content: histogramTestImage(t, histogramColors, 0), ModTime: sortFileTime}})
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "edit.png", Content: histogramTestImage(t, histogramColors, 3), ModTime: sortFileTime}})
+
+	plan, err := pkg.PlanImport(sourceDir, targetDir, pkg.Options{HistogramThreshold: 0.9, ConflictStrategy: pkg.ConflictVersion})
+	if err != nil {
+		t.Fatalf("PlanImport() error = %v", err)
+	}
+	if len(plan.Entries) != 1 {
+		t.Fatalf("PlanImport() = %+v, want one entry", plan.Entries)
+	}
+	if entry := plan.Entries[0]; entry.Action != pkg.PlanSkipDuplicate || entry.Reason != pkg.ReasonHistogramMatch || entry.TargetPath != targetPath {
+		t.Errorf("plan = %s to %q (%s), want %s to %q (%s)", entry.Action, entry.TargetPath, entry.Reason, pkg.PlanSkipDuplicate, targetPath, pkg.ReasonHistogramMatch)
+	}
+}
+
+func TestPlanImport_PreferExtensionOrder(t *testing.T) {
+	order := []string{"jpg", "jpeg"}
+	t.Run("identical sources", func(t *testing.T) {
+		sourceDir, targetDir := setupTestDirs(t)
+		createTestFiles(t, sourceDir, []fileSpec{
+			{Path: "photo.jpeg", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+			{Path: "photo.jpg", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		})
+
+		plan, err := pkg.PlanImport(sourceDir, targetDir, pkg.Options{PreferExtensionOrder: order})
+		if err != nil {
+			t.Fatalf("PlanImport() error = %v", err)
+		}
+		keptTarget := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.jpg")
+		want := map[string]pkg.PlanAction{"photo.jpg": pkg.PlanCopy, "photo.jpeg": pkg.PlanSkipDuplicate}
+		if len(plan.Entries) != len(want) {
+			t.Fatalf("PlanImport() = %+v, want %d entries", plan.Entries, len(want))
+		}
+		for _, entry := range plan.Entries {
+			if name := filepath.Base(entry.SourcePath); entry.Action != want[name] || entry.TargetPath != keptTarget {
+				t.Errorf("plan for %s = %s to %q, want %s to %q", name, entry.Action, entry.TargetPath, want[name], keptTarget)
+			}
+		}
+	})
+
+	t.Run("identical file in target index", func(t *testing.T) {
+		sourceDir, targetDir := setupTestDirs(t)
+		existing := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.jpg")
+		createTestFiles(t, targetDir, []fileSpec{{Path: filepath.Join("2023", "10", "2023-10-27-153000.jpg"), Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+		index, err := pkg.BuildTargetIndex(targetDir)
+		if err != nil {
+			t.Fatalf("BuildTargetIndex() error = %v", err)
+		}
+		if err := index.Save(filepath.Join(targetDir, pkg.IndexFileName)); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		createTestFiles(t, sourceDir, []fileSpec{{Path: "photo.jpeg", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+
+		plan, err := pkg.PlanImport(sourceDir, targetDir, pkg.Options{PreferExtensionOrder: order})
+		if err != nil {
+			t.Fatalf("PlanImport() error = %v", err)
+		}
+		if len(plan.Entries) != 1 {
+			t.Fatalf("PlanImport() = %+v, want one entry", plan.Entries)
+		}
+		if entry := plan.Entries[0]; entry.Action != pkg.PlanSkipDuplicate || entry.TargetPath != existing {
+			t.Errorf("plan = %s to %q, want %s to %q", entry.Action, entry.TargetPath, pkg.PlanSkipDuplicate, existing)
+		}
+	})
+}
+
+func TestImportPlan_WriteTree(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	existingRel := filepath.Join("2023", "10", "2023-10-27-153000.png")
+	createTestFiles(t, targetDir, []fileSpec{{Path: existingRel, Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "dup.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}, // Not counted: duplicate of the existing target
+		{Path: "a.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime.Add(time.Hour)},
+		{Path: "b.png", Content: pngMinimal_4x4_A, ModTime: sortFileTime.Add(2 * time.Hour)},
+		{Path: "c.png", Content: pngMinimal_4x4_C, ModTime: time.Date(2023, 11, 5, 10, 0, 0, 0, time.UTC)},
+		{Path: "d.png", Content: duplicates_pngMinimal_1x1_Red, ModTime: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+	})
+
+	plan, err := pkg.PlanImport(sourceDir, targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("PlanImport() error = %v", err)
+	}
+	var tree bytes.Buffer
+	if err := plan.WriteTree(&tree, targetDir); err != nil {
+		t.Fatalf("WriteTree() error = %v", err)
+	}
+	want := targetDir + " (4)\n" +
+		"├── 2023/ (3)\n" +
+		"│   ├── 10/ (2)\n" +
+		"│   └── 11/ (1)\n" +
+		"└── 2024/ (1)\n" +
+		"    └── 01/ (1)\n"
+	if tree.String() != want {
+		t.Errorf("WriteTree() =\n%s\nwant\n%s", tree.String(), want)
+	}
+}
@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/user/photo-sorter/cmd/photocp/lib"
+)
+
+func TestStartProfiles(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	profileDir := t.TempDir()
+	cpuProfile := filepath.Join(profileDir, "cpu.pprof")
+	memProfile := filepath.Join(profileDir, "mem.pprof")
+
+	stop, err := photocp.StartProfiles(cpuProfile, memProfile)
+	if err != nil {
+		t.Fatalf("StartProfiles() error = %v", err)
+	}
+	_, runErr := photocp.RunApplicationLogicWithOptions(sourceDir, targetDir, photocp.Options{})
+	if err := stop(); err != nil {
+		t.Fatalf("stop() error = %v", err)
+	}
+	if runErr != nil {
+		t.Fatalf("RunApplicationLogicWithOptions() error = %v", runErr)
+	}
+
+	for _, path := range []string{cpuProfile, memProfile} {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() == 0 {
+			t.Errorf("profile %s is missing or empty: %v", path, err)
+		}
+	}
+
+	if _, err := photocp.StartProfiles(filepath.Join(profileDir, "missing", "cpu.pprof"), ""); err == nil {
+		t.Error("StartProfiles() with an uncreatable path succeeded, want error")
+	}
+}
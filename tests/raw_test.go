@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// rawLikeTIFF builds a minimal TIFF-structured RAW file with the tags of spec, in the way of a CR2 file:
+// IFD0 links to a next IFD (the RAW image data) at an offset goexif cannot read, so only a RAW-aware
+// reader finds the date. magic replaces the TIFF header, e.g. with the "IIRO" of ORF files.
+func rawLikeTIFF(t *testing.T, spec exifSpec, magic string) []byte {
+	t.Helper()
+	data := buildTIFFExif(t, spec)
+	copy(data, magic)
+	ifd0Entries := len(spec.IFD0)
+	if len(spec.Exif) > 0 {
+		ifd0Entries++ // The EXIF IFD pointer
+	}
+	binary.LittleEndian.PutUint32(data[8+2+12*ifd0Entries:], 0x7FFFFF00)
+	return append(data, make([]byte, 256)...) // Sensor data
+}
+
+func TestGetRawCreationDate(t *testing.T) {
+	spec := exifSpec{
+		IFD0: []exifTag{{ID: exifTagMake, Value: "Canon"}, {ID: exifTagDateTime, Value: "2024:01:01 10:00:00"}},
+		Exif: []exifTag{
+			{ID: exifTagDateTimeOriginal, Value: "2023:10:27 15:30:00"},
+			{ID: exifTagSubSecTimeOriginal, Value: "25"},
+		},
+	}
+	want := time.Date(2023, 10, 27, 15, 30, 0, 250_000_000, time.UTC)
+	embedded := append([]byte("FUJIFILMCCD-RAW 0201\xff\xd8\xff\xe1\x00\x00Exif\x00\x00"), buildTIFFExif(t, spec)...)
+
+	tests := []struct {
+		name    string
+		file    string
+		content []byte
+	}{
+		{"CR2-like TIFF", "IMG_0001.CR2", rawLikeTIFF(t, spec, "II*\x00")},
+		{"ORF header", "P1010001.ORF", rawLikeTIFF(t, spec, "IIRO")},
+		{"RW2 header", "P1010001.RW2", rawLikeTIFF(t, spec, "IIU\x00")},
+		{"embedded EXIF block", "DSCF0001.raw", embedded},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			date, err := pkg.GetRawCreationDate(path)
+			if err != nil || !date.Equal(want) {
+				t.Errorf("GetRawCreationDate() = %v, %v, want %v", date, err, want)
+			}
+			date, tag, err := pkg.GetPhotoCreationDateWithTag(path)
+			if err != nil || !date.Equal(want) || tag != "DateTimeOriginal" {
+				t.Errorf("GetPhotoCreationDateWithTag() = %v, %q, %v, want %v from DateTimeOriginal", date, tag, err, want)
+			}
+		})
+	}
+
+	t.Run("no date", func(t *testing.T) {
+		path := filepath.Join(dir, "nodate.nef")
+		if err := os.WriteFile(path, rawLikeTIFF(t, exifSpec{IFD0: []exifTag{{ID: exifTagMake, Value: "Nikon"}}}, "II*\x00"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if _, err := pkg.GetRawCreationDate(path); !errors.Is(err, pkg.ErrNoExifDate) {
+			t.Errorf("GetRawCreationDate() error = %v, want ErrNoExifDate", err)
+		}
+	})
+
+	t.Run("not a RAW file", func(t *testing.T) {
+		path := filepath.Join(dir, "garbage.cr2")
+		if err := os.WriteFile(path, []byte("not a raw file at all"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if _, err := pkg.GetRawCreationDate(path); err == nil || errors.Is(err, pkg.ErrNoExifDate) {
+			t.Errorf("GetRawCreationDate() error = %v, want a decoding error", err)
+		}
+	})
+}
+
+func TestSortFile_RawExifDate(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	content := rawLikeTIFF(t, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2021:06:15 08:00:00"}}}, "II*\x00")
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "IMG_0001.cr2", Content: content, ModTime: sortFileTime}})
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "IMG_0001.cr2"), targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	want := filepath.Join(targetDir, "2021", "06", "2021-06-15-080000.cr2")
+	if !outcome.Copied || outcome.TargetPath != want {
+		t.Errorf("SortFile() = %+v, want the file copied to %s by its EXIF date", outcome, want)
+	}
+}
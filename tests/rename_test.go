@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+func TestValidateRenameTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{"", false},
+		{"{date}", false},
+		{"{date:2006-01-02}_{make}_{model}_{orig}{seq}", false},
+		{"{orig}", false},
+		{"{make}_{model}", true},
+		{"{date}_{lens}", true},
+		{"{date}_{make:short}", true},
+		{"{date}{seq}_{orig}", true},
+		{"{date}_{orig", true},
+		{"{date}/{orig}", true},
+		{"{date:2006/01}", true},
+	}
+	for _, tt := range tests {
+		if err := pkg.ValidateRenameTemplate(tt.template); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRenameTemplate(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+		}
+	}
+	if err := (pkg.Options{RenameTemplate: "{date}_{lens}"}).Validate(); err == nil {
+		t.Errorf("Validate() with an unknown rename template token: expected an error")
+	}
+}
+
+func TestRenderRenameTemplate(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	withCamera := filepath.Join(dir, "IMG_0042.jpg")
+	noCamera := filepath.Join(dir, "scan:01.png")
+	if err := os.WriteFile(withCamera, jpegWithExif(t, img, exifSpec{IFD0: []exifTag{{ID: exifTagMake, Value: "Canon "}, {ID: exifTagModel, Value: "EOS R5/II"}}}), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	createTestFiles(t, dir, []fileSpec{{Path: "scan:01.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+
+	tests := []struct {
+		template string
+		source   string
+		want     string
+	}{
+		{"{date:2006-01-02}_{make}_{model}_{orig}{seq}", withCamera, "2023-10-27_Canon_EOS R5_II_IMG_0042"},
+		{"{date}", withCamera, "2023-10-27-153000"},
+		{"{make}-{orig}", noCamera, "unknown-scan_01"},
+	}
+	for _, tt := range tests {
+		got, err := pkg.RenderRenameTemplate(tt.template, sortFileTime, tt.source)
+		if err != nil {
+			t.Errorf("RenderRenameTemplate(%q, %s) error = %v", tt.template, filepath.Base(tt.source), err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("RenderRenameTemplate(%q, %s) = %q, want %q", tt.template, filepath.Base(tt.source), got, tt.want)
+		}
+	}
+	if _, err := pkg.RenderRenameTemplate("{make}", sortFileTime, withCamera); err == nil {
+		t.Errorf("RenderRenameTemplate() with an invalid template: expected an error")
+	}
+}
+
+func TestSortFile_RenameTemplate(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	content := jpegWithExif(t, img, exifSpec{
+		IFD0: []exifTag{{ID: exifTagMake, Value: "Canon"}, {ID: exifTagModel, Value: "EOS R5"}},
+		Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2023:10:27 15:30:00"}},
+	})
+	fillImage(img, color.White)
+	other := jpegWithExif(t, img, exifSpec{
+		IFD0: []exifTag{{ID: exifTagMake, Value: "Canon"}, {ID: exifTagModel, Value: "EOS R5"}},
+		Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2023:10:27 15:30:00"}},
+	})
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: filepath.Join("a", "IMG_0001.jpg"), Content: content, ModTime: time.Now()},
+		{Path: filepath.Join("b", "IMG_0001.jpg"), Content: other, ModTime: time.Now()},
+	})
+	opts := pkg.Options{RenameTemplate: "{date:2006-01-02}_{make}_{model}_{orig}{seq}", ConflictStrategy: pkg.ConflictVersion}
+
+	for _, source := range []string{"a", "b"} {
+		if _, err := pkg.SortFile(filepath.Join(sourceDir, source, "IMG_0001.jpg"), targetDir, opts); err != nil {
+			t.Fatalf("SortFile(%s) error = %v", source, err)
+		}
+	}
+	for _, name := range []string{"2023-10-27_Canon_EOS R5_IMG_0001.jpg", "2023-10-27_Canon_EOS R5_IMG_0001-1.jpg"} {
+		if _, err := os.Stat(filepath.Join(targetDir, "2023", "10", name)); err != nil {
+			t.Errorf("expected target file %s: %v", name, err)
+		}
+	}
+}
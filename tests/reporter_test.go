@@ -30,8 +30,8 @@ func TestGenerateReport(t *testing.T) {
 	}
 
 	duplicateEntries := []pkg.DuplicateInfo{
-		{KeptFile: "path/to/kept1.jpg", DiscardedFile: "path/to/discarded1.jpg", Reason: "Higher resolution"},
-		{KeptFile: "path/to/kept2.png", DiscardedFile: "path/to/discarded2.png", Reason: "Duplicate content"},
+		{KeptFile: "path/to/kept1.jpg", DiscardedFile: "path/to/discarded1.jpg", Reason: pkg.ReasonPixelHashMatch, Detail: "source is better resolution"},
+		{KeptFile: "path/to/kept2.png", DiscardedFile: "path/to/discarded2.png", Reason: pkg.ReasonFileHashMatch},
 	}
 
 	tests := []struct {
@@ -62,10 +62,10 @@ func TestGenerateReport(t *testing.T) {
 				"Image files where pixel hashing was not supported (fallback to file hash): 1",
 				"Kept: path/to/kept1.jpg",
 				"Discarded: path/to/discarded1.jpg",
-				"Reason: Higher resolution",
+				"Reason: pixel_hash_match (source is better resolution)",
 				"Kept: path/to/kept2.png",
 				"Discarded: path/to/discarded2.png",
-				"Reason: Duplicate content",
+				"Reason: file_hash_match",
 			},
 		},
 		{
@@ -125,3 +125,165 @@ func TestGenerateReport(t *testing.T) {
 		})
 	}
 }
+
+// TestGenerateSummaryReport_ProcessingTime tests the formatting of the processing time line.
+func TestGenerateSummaryReport_ProcessingTime(t *testing.T) {
+	reportPath := filepath.Join(t.TempDir(), "report.txt")
+	summary := pkg.ReportSummary{ProcessedFilesCount: 10, ElapsedSeconds: 2.5, FilesPerSecond: 4}
+	if err := pkg.GenerateSummaryReport(reportPath, summary); err != nil {
+		t.Fatalf("pkg.GenerateSummaryReport() error = %v", err)
+	}
+	content, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report file %s: %v", reportPath, err)
+	}
+	if want := "  - Processing time: 2.50s (4.0 files/sec)\n"; !strings.Contains(string(content), want) {
+		t.Errorf("report does not contain %q:\n%s", want, content)
+	}
+}
+
+func TestGenerateSummaryReport_Replacements(t *testing.T) {
+	reportPath := filepath.Join(t.TempDir(), "report.txt")
+	summary := pkg.ReportSummary{ProcessedFilesCount: 3, CopiedFilesCount: 1, Duplicates: []pkg.DuplicateInfo{
+		{KeptFile: "source/big.jpg", DiscardedFile: "target/2023/10/a.jpg", Reason: pkg.ReasonPixelHashMatch, Detail: "source is better resolution", Replaced: true},
+		{KeptFile: "target/2023/10/a.jpg", DiscardedFile: "source/small.jpg", Reason: pkg.ReasonPixelHashMatch, Detail: "existing target kept - resolution"},
+		{KeptFile: "target/2023/10/b.jpg", DiscardedFile: "source/b.jpg", Reason: pkg.ReasonFileHashMatch},
+	}}
+	if got := summary.ReplacedCount(); got != 1 {
+		t.Errorf("ReplacedCount() = %d, want 1", got)
+	}
+	if err := pkg.GenerateSummaryReport(reportPath, summary); err != nil {
+		t.Fatalf("pkg.GenerateSummaryReport() error = %v", err)
+	}
+	content, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report file %s: %v", reportPath, err)
+	}
+	for _, want := range []string{
+		"  - Duplicate files found and discarded/skipped: 2\n",
+		"  - Targets replaced by higher-resolution source: 1\n",
+		"Reason: pixel_hash_match (source is better resolution)",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("report does not contain %q:\n%s", want, content)
+		}
+	}
+}
+
+// TestGenerateGroupedReport tests that duplicates sharing a kept file are listed once under that
+// keeper, while the flat report still lists one pair per duplicate.
+func TestGenerateGroupedReport(t *testing.T) {
+	tmpDir := t.TempDir()
+	summary := pkg.ReportSummary{
+		ProcessedFilesCount: 6,
+		CopiedFilesCount:    2,
+		Duplicates: []pkg.DuplicateInfo{
+			{KeptFile: "target/a.jpg", DiscardedFile: "src/a1.jpg", Reason: pkg.ReasonFileHashMatch, Detail: "existing target kept"},
+			{KeptFile: "target/b.png", DiscardedFile: "src/b1.png", Reason: pkg.ReasonNameCollision, Detail: "content different, existing target preserved"},
+			{KeptFile: "target/a.jpg", DiscardedFile: "src/a2.jpg", Reason: pkg.ReasonPixelHashMatch, Detail: "existing target kept - resolution"},
+			{KeptFile: "target/a.jpg", DiscardedFile: "src/a3.jpg", Reason: pkg.ReasonFileHashMatch, Detail: "existing target kept"},
+		},
+	}
+
+	groups := pkg.GroupDuplicates(summary.Duplicates)
+	if len(groups) != 2 || groups[0].KeptFile != "target/a.jpg" || len(groups[0].Duplicates) != 3 || groups[1].KeptFile != "target/b.png" || len(groups[1].Duplicates) != 1 {
+		t.Fatalf("GroupDuplicates() = %+v, want target/a.jpg with 3 duplicates then target/b.png with 1", groups)
+	}
+
+	groupedPath := filepath.Join(tmpDir, "grouped.txt")
+	if err := pkg.GenerateGroupedReport(groupedPath, summary); err != nil {
+		t.Fatalf("GenerateGroupedReport() error = %v", err)
+	}
+	grouped, err := os.ReadFile(groupedPath)
+	if err != nil {
+		t.Fatalf("Failed to read grouped report: %v", err)
+	}
+	expectedGroups := "Duplicate Groups:\n" +
+		"  - Kept: target/a.jpg (3 discarded)\n" +
+		"    - Discarded: src/a1.jpg\n" +
+		"      Reason: file_hash_match (existing target kept)\n" +
+		"    - Discarded: src/a2.jpg\n" +
+		"      Reason: pixel_hash_match (existing target kept - resolution)\n" +
+		"    - Discarded: src/a3.jpg\n" +
+		"      Reason: file_hash_match (existing target kept)\n" +
+		"\n" +
+		"  - Kept: target/b.png (1 discarded)\n" +
+		"    - Discarded: src/b1.png\n" +
+		"      Reason: name_collision (content different, existing target preserved)\n"
+	if !strings.Contains(string(grouped), expectedGroups) {
+		t.Errorf("Grouped report does not contain the expected groups.\nGot:\n%s\nWant section:\n%s", grouped, expectedGroups)
+	}
+	if strings.Contains(string(grouped), "Duplicate Details:") {
+		t.Errorf("Grouped report should not contain the flat duplicate list")
+	}
+	if strings.Count(string(grouped), "Kept: target/a.jpg") != 1 {
+		t.Errorf("Grouped report should mention the keeper target/a.jpg exactly once")
+	}
+
+	flatPath := filepath.Join(tmpDir, "flat.txt")
+	if err := pkg.GenerateSummaryReport(flatPath, summary); err != nil {
+		t.Fatalf("GenerateSummaryReport() error = %v", err)
+	}
+	flat, err := os.ReadFile(flatPath)
+	if err != nil {
+		t.Fatalf("Failed to read flat report: %v", err)
+	}
+	if strings.Count(string(flat), "Kept: target/a.jpg") != 3 {
+		t.Errorf("Flat report should list the keeper target/a.jpg once per duplicate")
+	}
+}
+
+func TestGenerateHTMLReport(t *testing.T) {
+	dir := t.TempDir()
+	createTestFiles(t, dir, []fileSpec{
+		{Path: "kept.png", Content: pngMinimal_2x2_A},
+		{Path: "discarded.png", Content: pngMinimal_2x2_A},
+		{Path: "kept.mp4", Content: []byte("not an image")},
+		{Path: "discarded.mp4", Content: []byte("not an image")},
+	})
+	duplicates := []pkg.DuplicateInfo{
+		{KeptFile: filepath.Join(dir, "kept.png"), DiscardedFile: filepath.Join(dir, "discarded.png"), Reason: pkg.ReasonPixelHashMatch},
+		{KeptFile: filepath.Join(dir, "kept.mp4"), DiscardedFile: filepath.Join(dir, "discarded.mp4"), Reason: pkg.ReasonFileHashMatch, Detail: "<identical>"},
+	}
+	reportPath := filepath.Join(dir, "reports", pkg.HTMLReportFileName)
+	if err := pkg.GenerateHTMLReport(reportPath, duplicates, pkg.Options{}); err != nil {
+		t.Fatalf("GenerateHTMLReport() error = %v", err)
+	}
+	content, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	report := string(content)
+
+	if count := strings.Count(report, `<img src="data:image/png;base64,`); count != 2 {
+		t.Errorf("report has %d thumbnails, want 2 for the image pair:\n%s", count, report)
+	}
+	for _, want := range []string{filepath.Join(dir, "kept.mp4"), filepath.Join(dir, "discarded.mp4"), "&lt;identical&gt;", "2 duplicate(s)"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not contain %q:\n%s", want, report)
+		}
+	}
+
+	// A replaced target holds the source now, so it has no thumbnail of its own.
+	replaced := []pkg.DuplicateInfo{{KeptFile: filepath.Join(dir, "kept.png"), DiscardedFile: filepath.Join(dir, "discarded.png"), Reason: pkg.ReasonPixelHashMatch, Replaced: true}}
+	if err := pkg.GenerateHTMLReport(reportPath, replaced, pkg.Options{}); err != nil {
+		t.Fatalf("GenerateHTMLReport() error = %v", err)
+	}
+	if content, err = os.ReadFile(reportPath); err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	report = string(content)
+	if count := strings.Count(report, `<img src="data:image/png;base64,`); count != 1 {
+		t.Errorf("report has %d thumbnails, want 1 for the kept file of a replaced target:\n%s", count, report)
+	}
+	if !strings.Contains(report, "replaced, original not available") {
+		t.Errorf("report does not mark the replaced target:\n%s", report)
+	}
+
+	if got := pkg.HTMLReportPath(filepath.Join("target", pkg.ReportFileName)); got != filepath.Join("target", "report.html") {
+		t.Errorf("HTMLReportPath() = %s, want target/report.html", got)
+	}
+	if err := pkg.ValidateReportFormat("pdf"); err == nil {
+		t.Error("ValidateReportFormat(pdf) succeeded, expected an error")
+	}
+}
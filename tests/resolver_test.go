@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"errors"
+	"image"
+	"path/filepath"
+	"testing"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// aspectRatioResolver sorts images into "landscape" and "portrait" directories below a year directory.
+var aspectRatioResolver = pkg.PathResolverFunc(func(file pkg.FileMeta) (string, error) {
+	if file.Width >= file.Height {
+		return file.Date.Format("2006") + "/landscape", nil
+	}
+	return file.Date.Format("2006") + "/portrait", nil
+})
+
+func TestSortFile_PathResolver(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	wide, err := encodePNG(image.NewRGBA(image.Rect(0, 0, 4, 2)))
+	if err != nil {
+		t.Fatalf("encodePNG() error = %v", err)
+	}
+	tall, err := encodePNG(image.NewRGBA(image.Rect(0, 0, 2, 4)))
+	if err != nil {
+		t.Fatalf("encodePNG() error = %v", err)
+	}
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "wide.png", Content: wide, ModTime: sortFileTime},
+		{Path: "tall.png", Content: tall, ModTime: sortFileTime},
+	})
+	opts := pkg.NewOptions(pkg.WithPathResolver(aspectRatioResolver))
+
+	for name, dir := range map[string]string{"wide.png": "landscape", "tall.png": "portrait"} {
+		outcome, err := pkg.SortFile(filepath.Join(sourceDir, name), targetDir, opts)
+		if err != nil {
+			t.Fatalf("SortFile(%s) error = %v", name, err)
+		}
+		want := filepath.Join(targetDir, "2023", dir, "2023-10-27-153000.png")
+		if !outcome.Copied || outcome.TargetPath != want {
+			t.Errorf("SortFile(%s) = %+v, want the file copied to %s", name, outcome, want)
+		}
+	}
+}
+
+func TestSortFile_PathResolverErrors(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "photo.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	errResolver := errors.New("no directory for this file")
+
+	tests := []struct {
+		name     string
+		resolver pkg.PathResolver
+	}{
+		{"escaping path", pkg.PathResolverFunc(func(pkg.FileMeta) (string, error) { return "../outside", nil })},
+		{"absolute path", pkg.PathResolverFunc(func(pkg.FileMeta) (string, error) { return filepath.Join(targetDir, "abs"), nil })},
+		{"resolver error", pkg.PathResolverFunc(func(pkg.FileMeta) (string, error) { return "", errResolver })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome, err := pkg.SortFile(filepath.Join(sourceDir, "photo.png"), targetDir, pkg.Options{PathResolver: tt.resolver})
+			if err == nil || outcome.Copied {
+				t.Errorf("SortFile() = %+v, %v, want an error", outcome, err)
+			}
+		})
+	}
+}
+
+func TestBuiltinPathResolver(t *testing.T) {
+	resolver, err := pkg.BuiltinPathResolver("orientation")
+	if err != nil {
+		t.Fatalf("BuiltinPathResolver(orientation) error = %v", err)
+	}
+	for _, tc := range []struct {
+		width, height int
+		want          string
+	}{{4, 2, "landscape"}, {2, 4, "portrait"}, {3, 3, "square"}, {0, 0, "unknown"}} {
+		if got, err := resolver.Resolve(pkg.FileMeta{Width: tc.width, Height: tc.height}); err != nil || got != tc.want {
+			t.Errorf("orientation.Resolve(%dx%d) = %q, %v, want %q", tc.width, tc.height, got, err, tc.want)
+		}
+	}
+
+	resolver, err = pkg.BuiltinPathResolver("camera")
+	if err != nil {
+		t.Fatalf("BuiltinPathResolver(camera) error = %v", err)
+	}
+	if got, err := resolver.Resolve(pkg.FileMeta{CameraMake: "Canon", CameraModel: "EOS R5"}); err != nil || got != "Canon/EOS R5" {
+		t.Errorf("camera.Resolve() = %q, %v, want %q", got, err, "Canon/EOS R5")
+	}
+	if got, _ := resolver.Resolve(pkg.FileMeta{}); got != pkg.UnknownCameraName+"/"+pkg.UnknownCameraName {
+		t.Errorf("camera.Resolve() without EXIF = %q, want unknown/unknown", got)
+	}
+
+	if resolver, err := pkg.BuiltinPathResolver(""); resolver != nil || err != nil {
+		t.Errorf("BuiltinPathResolver(\"\") = %v, %v, want nil, nil", resolver, err)
+	}
+	if _, err := pkg.BuiltinPathResolver("bogus"); err == nil {
+		t.Error("BuiltinPathResolver(bogus) succeeded, expected an error")
+	}
+}
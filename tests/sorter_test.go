@@ -0,0 +1,1076 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/user/photo-sorter/pkg"
+)
+
+var sortFileTime = time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+
+func TestSortFile_Copy(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	wantPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+	if !outcome.Copied || outcome.TargetPath != wantPath {
+		t.Errorf("SortFile() = copied %v to %q, want copied to %q", outcome.Copied, outcome.TargetPath, wantPath)
+	}
+	if outcome.Duplicate != nil {
+		t.Errorf("SortFile() Duplicate = %+v, want nil", outcome.Duplicate)
+	}
+	if outcome.DateSource != "FileModTime" {
+		t.Errorf("SortFile() DateSource = %q, want FileModTime", outcome.DateSource)
+	}
+	if _, err := os.Stat(wantPath); err != nil {
+		t.Errorf("copied file missing: %v", err)
+	}
+}
+
+func TestSortFile_Duplicate(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	targetPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+	createTestFiles(t, targetDir, []fileSpec{{Path: filepath.Join("2023", "10", "2023-10-27-153000.png"), Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	if outcome.Copied {
+		t.Error("SortFile() copied a duplicate of the existing target")
+	}
+	if outcome.Duplicate == nil || outcome.Duplicate.KeptFile != targetPath {
+		t.Errorf("SortFile() Duplicate = %+v, want the existing target kept", outcome.Duplicate)
+	}
+}
+
+func TestSortFile_Collision(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	targetPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+	createTestFiles(t, targetDir, []fileSpec{{Path: filepath.Join("2023", "10", "2023-10-27-153000.png"), Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime}})
+	sourcePath := filepath.Join(sourceDir, "b.png")
+
+	outcome, err := pkg.SortFile(sourcePath, targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	if outcome.Copied || outcome.Duplicate == nil || outcome.Duplicate.DiscardedFile != sourcePath {
+		t.Errorf("SortFile() = %+v, want the source discarded in favor of the existing target", outcome)
+	}
+
+	outcome, err = pkg.SortFile(sourcePath, targetDir, pkg.Options{ConflictStrategy: pkg.ConflictVersion})
+	if err != nil {
+		t.Fatalf("SortFile() with version strategy error = %v", err)
+	}
+	wantPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000-1.png")
+	if !outcome.Copied || outcome.TargetPath != wantPath {
+		t.Errorf("SortFile() with version strategy = copied %v to %q, want copied to %q", outcome.Copied, outcome.TargetPath, wantPath)
+	}
+	if content, _ := os.ReadFile(targetPath); !bytes.Equal(content, pngMinimal_2x2_A) {
+		t.Error("existing target was modified")
+	}
+}
+
+func TestSortFile_EmptyTarget(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	targetPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+	createTestFiles(t, targetDir, []fileSpec{{Path: filepath.Join("2023", "10", "2023-10-27-153000.png"), Content: []byte{}, ModTime: sortFileTime}})
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	sourcePath := filepath.Join(sourceDir, "a.png")
+
+	// An empty target, e.g. from an interrupted copy, is replaced rather than kept over a real source.
+	outcome, err := pkg.SortFile(sourcePath, targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	if !outcome.Copied || outcome.TargetPath != targetPath || outcome.Duplicate == nil || !outcome.Duplicate.Replaced {
+		t.Errorf("SortFile() = %+v, want the empty target replaced by the source", outcome)
+	}
+	if content, _ := os.ReadFile(targetPath); !bytes.Equal(content, pngMinimal_2x2_A) {
+		t.Error("empty target does not hold the source after sorting")
+	}
+	if _, err := os.Stat(sourcePath); err != nil {
+		t.Errorf("source is gone: %v", err)
+	}
+}
+
+func TestSortFile_FilePrefixAndSuffix(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime}, // Same timestamp, different content
+	})
+	opts := pkg.Options{FilePrefix: "import2023_", FileSuffix: "_phone", ConflictStrategy: pkg.ConflictVersion}
+
+	for _, want := range []struct{ source, target string }{
+		{"a.png", "import2023_2023-10-27-153000_phone.png"},
+		{"b.png", "import2023_2023-10-27-153000_phone-1.png"},
+	} {
+		outcome, err := pkg.SortFile(filepath.Join(sourceDir, want.source), targetDir, opts)
+		if err != nil {
+			t.Fatalf("SortFile(%s) error = %v", want.source, err)
+		}
+		if wantPath := filepath.Join(targetDir, "2023", "10", want.target); !outcome.Copied || outcome.TargetPath != wantPath {
+			t.Errorf("SortFile(%s) = copied %v to %q, want copied to %q", want.source, outcome.Copied, outcome.TargetPath, wantPath)
+		}
+	}
+
+	for _, invalid := range []pkg.Options{{FilePrefix: "a/"}, {FileSuffix: `\b`}, {FileSuffix: "?"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Validate() of prefix %q and suffix %q succeeded, want error", invalid.FilePrefix, invalid.FileSuffix)
+		}
+	}
+}
+
+// TestSortFile_SuffixIsExtension tests that a file suffix equal to the extension does not trip over a target
+// named without the suffix when the target directory is searched for versions of the name.
+func TestSortFile_SuffixIsExtension(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	createTestFiles(t, targetDir, []fileSpec{{Path: filepath.Join("2023", "10", "2023-10-27-153000.png"), Content: pngMinimal_2x2_B, ModTime: sortFileTime}})
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, pkg.Options{FileSuffix: ".png", Hardlink: true})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	if wantPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png.png"); !outcome.Copied || outcome.TargetPath != wantPath {
+		t.Errorf("SortFile() = copied %v to %q, want copied to %q", outcome.Copied, outcome.TargetPath, wantPath)
+	}
+}
+
+func TestSortFile_SkipExistingTargetFast(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	targetRel := filepath.Join("2023", "10", "2023-10-27-153000.png")
+	targetPath := filepath.Join(targetDir, targetRel)
+	// The source cannot be decoded, and the target has different content of the same size.
+	undecodable := bytes.Repeat([]byte("x"), len(pngMinimal_2x2_A))
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "same.png", Content: undecodable, ModTime: sortFileTime},
+		{Path: "other.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+	})
+	createTestFiles(t, targetDir, []fileSpec{{Path: targetRel, Content: bytes.Repeat([]byte("y"), len(undecodable)), ModTime: sortFileTime}})
+	opts := pkg.Options{SkipExistingTargetFast: true}
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "same.png"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of a same-size source error = %v", err)
+	}
+	if outcome.SkipReason != pkg.ExistingSizeMatchSkipReason || outcome.TargetPath != targetPath || outcome.Copied || outcome.Duplicate != nil {
+		t.Errorf("SortFile() of a same-size source = %+v, want it skipped as %q", outcome, pkg.ExistingSizeMatchSkipReason)
+	}
+	if outcome.PixelHashUnsupported || outcome.CorruptReason != "" {
+		t.Errorf("SortFile() of a same-size source decoded it: %+v", outcome)
+	}
+
+	// A target of another size is compared as usual, and found to be different.
+	if err := os.WriteFile(targetPath, append(undecodable, 'y'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outcome, err = pkg.SortFile(filepath.Join(sourceDir, "other.png"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of a different-size source error = %v", err)
+	}
+	if outcome.SkipReason != "" || outcome.Duplicate == nil || outcome.Duplicate.Reason != pkg.ReasonNameCollision {
+		t.Errorf("SortFile() of a different-size source = %+v, want a name collision", outcome)
+	}
+}
+
+func TestSortFile_KnownHashes(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime.Add(time.Hour)},
+	})
+	hashA, err := pkg.CalculateFileHash(filepath.Join(sourceDir, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := pkg.Options{KnownHashes: map[string]bool{hashA: true}}
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, opts)
+	if err != nil || outcome.Copied || outcome.Duplicate == nil {
+		t.Errorf("SortFile() of a known file = %+v, %v, want it skipped as a duplicate", outcome, err)
+	}
+
+	outcome, err = pkg.SortFile(filepath.Join(sourceDir, "b.png"), targetDir, opts)
+	if err != nil || !outcome.Copied {
+		t.Fatalf("SortFile() of a new file = %+v, %v, want it copied", outcome, err)
+	}
+	if len(opts.KnownHashes) != 2 {
+		t.Errorf("KnownHashes has %d entries after copying, want 2", len(opts.KnownHashes))
+	}
+}
+
+func TestSortFile_InvalidOptions(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+
+	if _, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, pkg.Options{ConflictStrategy: "rename"}); err == nil {
+		t.Error("SortFile() with an unknown conflict strategy succeeded, want error")
+	}
+	if entries, _ := os.ReadDir(targetDir); len(entries) != 0 {
+		t.Errorf("target has %d entries after a rejected SortFile, want 0", len(entries))
+	}
+}
+
+func TestSortFile_MonthNameFormat(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime},
+	})
+	opts := pkg.Options{MonthNameFormat: pkg.MonthNameLong, ConflictStrategy: pkg.ConflictVersion}
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, opts)
+	wantPath := filepath.Join(targetDir, "2023-October", "2023-10-27-153000.png")
+	if err != nil || !outcome.Copied || outcome.TargetPath != wantPath {
+		t.Fatalf("SortFile() = %+v, %v, want copied to %s", outcome, err, wantPath)
+	}
+	// A different file with the same date is versioned within the named month directory.
+	outcome, err = pkg.SortFile(filepath.Join(sourceDir, "b.png"), targetDir, opts)
+	wantPath = filepath.Join(targetDir, "2023-October", "2023-10-27-153000-1.png")
+	if err != nil || !outcome.Copied || outcome.TargetPath != wantPath {
+		t.Errorf("SortFile() = %+v, %v, want copied to %s", outcome, err, wantPath)
+	}
+
+	if _, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, pkg.Options{MonthNameFormat: pkg.MonthNameShort, Layout: "2006-01"}); err == nil {
+		t.Error("SortFile() with a month name format and a custom layout succeeded, want error")
+	}
+}
+
+func TestSortFile_AlreadyInPlace(t *testing.T) {
+	targetDir := t.TempDir()
+	relPath := filepath.Join("2023", "10", "2023-10-27-153000.png")
+	versionRelPath := filepath.Join("2023", "10", "2023-10-27-153000-1.png")
+	createTestFiles(t, targetDir, []fileSpec{
+		{Path: relPath, Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: versionRelPath, Content: pngMinimal_2x2_B, ModTime: sortFileTime},
+	})
+
+	for _, path := range []string{relPath, versionRelPath} {
+		sourcePath := filepath.Join(targetDir, path)
+		outcome, err := pkg.SortFile(sourcePath, targetDir, pkg.Options{Move: true})
+		if err != nil {
+			t.Fatalf("SortFile(%s) error = %v", path, err)
+		}
+		if outcome.Copied || outcome.Duplicate != nil || outcome.SkipReason != pkg.AlreadyInPlaceSkipReason {
+			t.Errorf("SortFile(%s) = %+v, want it skipped as already in place", path, outcome)
+		}
+		if outcome.TargetPath != sourcePath {
+			t.Errorf("SortFile(%s) TargetPath = %q, want %q", path, outcome.TargetPath, sourcePath)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(targetDir, relPath)); !bytes.Equal(content, pngMinimal_2x2_A) {
+		t.Error("file already in place was modified")
+	}
+}
+
+func TestSortFile_Move(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	sourcePath := filepath.Join(sourceDir, "a.png")
+
+	outcome, err := pkg.SortFile(sourcePath, targetDir, pkg.Options{Move: true})
+	wantPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+	if err != nil || !outcome.Copied || outcome.TargetPath != wantPath {
+		t.Fatalf("SortFile() = %+v, %v, want moved to %s", outcome, err, wantPath)
+	}
+	if _, err := os.Stat(sourcePath); !os.IsNotExist(err) {
+		t.Errorf("source still exists after a move: %v", err)
+	}
+	if content, _ := os.ReadFile(wantPath); !bytes.Equal(content, pngMinimal_2x2_A) {
+		t.Error("moved file has the wrong content")
+	}
+}
+
+func TestSortFile_MoveAcrossFilesystemsKeepsTimes(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir, err := os.MkdirTemp("/dev/shm", "photo-sorter-target")
+	if err != nil {
+		t.Skip("no second filesystem to move files to")
+	}
+	t.Cleanup(func() { os.RemoveAll(targetDir) })
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	sourcePath := filepath.Join(sourceDir, "a.png")
+	if err := os.Link(sourcePath, filepath.Join(targetDir, "probe")); !errors.Is(err, syscall.EXDEV) {
+		t.Skip("the temporary directories are on the same filesystem")
+	}
+
+	outcome, err := pkg.SortFile(sourcePath, targetDir, pkg.Options{Move: true})
+	if err != nil || !outcome.Copied {
+		t.Fatalf("SortFile() = %+v, %v, want moved", outcome, err)
+	}
+	info, err := os.Stat(outcome.TargetPath)
+	if err != nil {
+		t.Fatalf("stat moved file: %v", err)
+	}
+	if !info.ModTime().Equal(sortFileTime) {
+		t.Errorf("file moved across filesystems has modification time %v, want %v", info.ModTime(), sortFileTime)
+	}
+	if _, err := os.Stat(sourcePath); !os.IsNotExist(err) {
+		t.Errorf("source still exists after a move: %v", err)
+	}
+}
+
+func TestSortFile_ConflictReasons(t *testing.T) {
+	existing := filepath.Join("2023", "10", "2023-10-27-153000.png")
+	tests := []struct {
+		name       string
+		source     []byte
+		targets    map[string][]byte
+		strategy   string
+		wantReason pkg.Reason
+		wantDetail string
+	}{
+		{
+			name:       "identical image",
+			source:     pngMinimal_2x2_A,
+			targets:    map[string][]byte{existing: pngMinimal_2x2_A},
+			wantReason: pkg.ReasonPixelHashMatch,
+			wantDetail: "existing target kept - resolution",
+		},
+		{
+			name:       "different content kept target",
+			source:     pngMinimal_2x2_B,
+			targets:    map[string][]byte{existing: pngMinimal_2x2_A},
+			wantReason: pkg.ReasonNameCollision,
+			wantDetail: "content different, existing target preserved",
+		},
+		{
+			name:       "different content kept source",
+			source:     pngMinimal_2x2_B,
+			targets:    map[string][]byte{existing: pngMinimal_2x2_A},
+			strategy:   pkg.ConflictKeepSource,
+			wantReason: pkg.ReasonNameCollision,
+			wantDetail: "content different, existing target overwritten",
+		},
+		{
+			name:   "identical existing version",
+			source: pngMinimal_2x2_B,
+			targets: map[string][]byte{
+				existing: pngMinimal_2x2_A,
+				filepath.Join("2023", "10", "2023-10-27-153000-1.png"): pngMinimal_2x2_B,
+			},
+			strategy:   pkg.ConflictVersion,
+			wantReason: pkg.ReasonPixelHashMatch,
+			wantDetail: "existing version kept",
+		},
+		{
+			name:       "unreadable target",
+			source:     pngMinimal_2x2_A,
+			targets:    map[string][]byte{existing: []byte("not a png")},
+			wantReason: pkg.ReasonNameCollision,
+			wantDetail: "content different, existing target preserved",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir, targetDir := setupTestDirs(t)
+			createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: tt.source, ModTime: sortFileTime}})
+			for path, content := range tt.targets {
+				createTestFiles(t, targetDir, []fileSpec{{Path: path, Content: content, ModTime: sortFileTime}})
+			}
+
+			outcome, _ := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, pkg.Options{ConflictStrategy: tt.strategy})
+			if outcome.Duplicate == nil {
+				t.Fatalf("SortFile() = %+v, want a duplicate", outcome)
+			}
+			if outcome.Duplicate.Reason != tt.wantReason || outcome.Duplicate.Detail != tt.wantDetail {
+				t.Errorf("SortFile() duplicate = %q (%q), want %q (%q)", outcome.Duplicate.Reason, outcome.Duplicate.Detail, tt.wantReason, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestSortFile_KnownHashReason(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	hash, err := pkg.CalculateFileHash(filepath.Join(sourceDir, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, pkg.Options{KnownHashes: map[string]bool{hash: true}})
+	if err != nil || outcome.Duplicate == nil {
+		t.Fatalf("SortFile() = %+v, %v, want a duplicate", outcome, err)
+	}
+	if got := outcome.Duplicate.ReasonText(); got != "known_hash (already archived)" {
+		t.Errorf("ReasonText() = %q, want %q", got, "known_hash (already archived)")
+	}
+}
+
+func TestSortFile_ConcurrentSameTarget(t *testing.T) {
+	const workers = 32
+	sourceDir, targetDir := setupTestDirs(t)
+	specs := make([]fileSpec, workers)
+	for i := range specs {
+		specs[i] = fileSpec{Path: fmt.Sprintf("copy%02d.png", i), Content: pngMinimal_2x2_A, ModTime: sortFileTime}
+	}
+	createTestFiles(t, sourceDir, specs)
+	wantPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png")
+
+	outcomes := make([]pkg.FileOutcome, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range specs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outcomes[i], errs[i] = pkg.SortFile(filepath.Join(sourceDir, specs[i].Path), targetDir, pkg.Options{})
+		}(i)
+	}
+	wg.Wait()
+
+	copies := 0
+	for i, outcome := range outcomes {
+		if errs[i] != nil {
+			t.Fatalf("SortFile(%s) error = %v", specs[i].Path, errs[i])
+		}
+		switch {
+		case outcome.Copied:
+			copies++
+		case outcome.Duplicate == nil || outcome.Duplicate.KeptFile != wantPath:
+			t.Errorf("SortFile(%s) = %+v, want a copy or a duplicate of %s", specs[i].Path, outcome, wantPath)
+		}
+	}
+	if copies != 1 {
+		t.Errorf("%d of %d identical sources were copied, want exactly 1", copies, workers)
+	}
+	entries, err := os.ReadDir(filepath.Dir(wantPath))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("target directory holds %d entries (error %v), want 1", len(entries), err)
+	}
+}
+
+func TestSortFile_MaxFilesPerDir(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "b.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime.Add(time.Hour)},
+		{Path: "c.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime.Add(2 * time.Hour)},
+	})
+	opts := pkg.Options{MaxFilesPerDir: 2}
+
+	wantPaths := []string{
+		filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png"),
+		filepath.Join(targetDir, "2023", "10", "2023-10-27-163000.png"),
+		filepath.Join(targetDir, "2023", "10-2", "2023-10-27-173000.png"),
+	}
+	for i, name := range []string{"a.png", "b.png", "c.png"} {
+		outcome, err := pkg.SortFile(filepath.Join(sourceDir, name), targetDir, opts)
+		if err != nil {
+			t.Fatalf("SortFile(%s) error = %v", name, err)
+		}
+		if !outcome.Copied || outcome.TargetPath != wantPaths[i] {
+			t.Errorf("SortFile(%s) = copied %v to %q, want copied to %q", name, outcome.Copied, outcome.TargetPath, wantPaths[i])
+		}
+	}
+
+	// A re-import is compared with the file in the overflow directory instead of starting a third one.
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "c.png"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of a re-imported file error = %v", err)
+	}
+	if outcome.Copied || outcome.Duplicate == nil || outcome.Duplicate.KeptFile != wantPaths[2] {
+		t.Errorf("SortFile() of a re-imported file = %+v, want a duplicate of %s", outcome, wantPaths[2])
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "2023", "10-3")); !os.IsNotExist(err) {
+		t.Errorf("third overflow directory exists (stat error %v), want none", err)
+	}
+
+	if _, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, pkg.Options{MaxFilesPerDir: 2, Flatten: true}); err == nil {
+		t.Error("SortFile() with max files per directory and a flat layout succeeded, want an error")
+	}
+}
+
+func TestSortFile_MaxFilesPerDirConcurrent(t *testing.T) {
+	const workers, maxFiles = 24, 3
+	sourceDir, targetDir := setupTestDirs(t)
+	specs := make([]fileSpec, workers)
+	for i := range specs {
+		specs[i] = fileSpec{Path: fmt.Sprintf("photo%02d.png", i), Content: pngMinimal_2x2_A, ModTime: sortFileTime.Add(time.Duration(i) * time.Minute)}
+	}
+	createTestFiles(t, sourceDir, specs)
+
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range specs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = pkg.SortFile(filepath.Join(sourceDir, specs[i].Path), targetDir, pkg.Options{MaxFilesPerDir: maxFiles})
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("SortFile(%s) error = %v", specs[i].Path, err)
+		}
+	}
+
+	buckets, err := os.ReadDir(filepath.Join(targetDir, "2023"))
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(buckets) != workers/maxFiles {
+		t.Errorf("sources were sorted into %d directories, want %d", len(buckets), workers/maxFiles)
+	}
+	for _, bucket := range buckets {
+		if entries, _ := os.ReadDir(filepath.Join(targetDir, "2023", bucket.Name())); len(entries) > maxFiles {
+			t.Errorf("directory %s holds %d files, want at most %d", bucket.Name(), len(entries), maxFiles)
+		}
+	}
+}
+
+func TestSortFile_XMPSidecarDate(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "IMG_0001.CR2", Content: []byte("raw data without EXIF"), ModTime: sortFileTime},
+		{Path: "IMG_0001.xmp", Content: []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description xmp:CreateDate="2019-07-14T18:30:05"/></rdf:RDF></x:xmpmeta>`), ModTime: sortFileTime},
+	})
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "IMG_0001.CR2"), targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	if want := filepath.Join(targetDir, "2019", "07", "2019-07-14-183005.CR2"); !outcome.Copied || outcome.TargetPath != want {
+		t.Errorf("SortFile() = copied %v to %q, want copied to %q", outcome.Copied, outcome.TargetPath, want)
+	}
+	if outcome.DateSource != pkg.DateSourceXMP {
+		t.Errorf("SortFile() date source = %q, want %q", outcome.DateSource, pkg.DateSourceXMP)
+	}
+}
+
+func TestSortFile_Hardlink(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	srcPath := filepath.Join(sourceDir, "a.png")
+
+	outcome, err := pkg.SortFile(srcPath, targetDir, pkg.Options{Hardlink: true})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	if want := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png"); !outcome.Copied || outcome.TargetPath != want {
+		t.Fatalf("SortFile() = copied %v to %q, want copied to %q", outcome.Copied, outcome.TargetPath, want)
+	}
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		t.Fatalf("source file is gone: %v", err)
+	}
+	targetInfo, err := os.Stat(outcome.TargetPath)
+	if err != nil {
+		t.Fatalf("stat target: %v", err)
+	}
+	if !os.SameFile(srcInfo, targetInfo) {
+		t.Errorf("target is not a hard link to the source")
+	}
+}
+
+func TestSortFile_ConvertHeicToJpeg(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	// The counting format stands in for HEIC, so no HEIF decoder is needed.
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "IMG_0001.HEIC", Content: countingImageWithExif(t, 200, "2021:06:06 06:06:06", 0), ModTime: sortFileTime},
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+	})
+	opts := pkg.Options{ConvertHeicToJpeg: true}
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "IMG_0001.HEIC"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of the HEIC file error = %v", err)
+	}
+	wantPath := filepath.Join(targetDir, "2021", "06", "2021-06-06-060606.jpg")
+	if !outcome.Copied || outcome.TargetPath != wantPath {
+		t.Fatalf("SortFile() of the HEIC file = copied %v to %q, want copied to %q", outcome.Copied, outcome.TargetPath, wantPath)
+	}
+	converted, err := os.ReadFile(wantPath)
+	if err != nil {
+		t.Fatalf("reading the converted file: %v", err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(converted))
+	if err != nil || format != "jpeg" || img.Bounds().Dx() != 1 {
+		t.Errorf("converted file decodes as %s %v (error %v), want a 1x1 jpeg", format, img.Bounds(), err)
+	}
+	if date, err := pkg.GetPhotoCreationDate(wantPath); err != nil || !date.Equal(time.Date(2021, 6, 6, 6, 6, 6, 0, time.UTC)) {
+		t.Errorf("EXIF date of the converted file = %v (error %v), want the source's date", date, err)
+	}
+
+	outcome, err = pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of the PNG file error = %v", err)
+	}
+	if want := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.png"); outcome.TargetPath != want {
+		t.Errorf("SortFile() of the PNG file target = %q, want %q", outcome.TargetPath, want)
+	}
+	if content, err := os.ReadFile(outcome.TargetPath); err != nil || !bytes.Equal(content, pngMinimal_2x2_A) {
+		t.Errorf("PNG file was not copied unchanged (error %v)", err)
+	}
+}
+
+func TestSortFile_ConvertHeicToJpegKeepsOriginal(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "IMG_0001.HEIC", Content: countingImageWithExif(t, 200, "2021:06:06 06:06:06", 0), ModTime: sortFileTime}})
+	opts := pkg.Options{ConvertHeicToJpeg: true, Move: true}
+
+	if err := opts.Validate(); err == nil {
+		t.Errorf("Validate() with HEIC conversion and Move: expected an error")
+	}
+	if _, err := pkg.SortFile(filepath.Join(sourceDir, "IMG_0001.HEIC"), targetDir, opts); err == nil {
+		t.Errorf("SortFile() with HEIC conversion and Move: expected an error")
+	}
+	if _, err := os.Stat(filepath.Join(sourceDir, "IMG_0001.HEIC")); err != nil {
+		t.Errorf("HEIC original is gone: %v", err)
+	}
+}
+
+func TestSortFile_AutoRotate(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	// A 16x8 image, red on the left and blue on the right, stored lying on its side (orientation 6).
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 8 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	spec := exifSpec{
+		IFD0: []exifTag{{exifTagOrientation, uint16(6)}, {exifTagMake, "Canon"}},
+		Exif: []exifTag{{exifTagDateTimeOriginal, "2021:06:06 06:06:06"}, {exifTagPixelXDimension, uint16(16)}, {exifTagPixelYDimension, uint32(8)}},
+	}
+	// An ICC profile in an APP2 segment after the EXIF data.
+	iccSegment := append([]byte("\xFF\xE2\x00\x1BICC_PROFILE\x00\x01\x01"), "fakeprofile"...)
+	rotatedJPEG := jpegWithExif(t, img, spec)
+	app1End := 4 + int(binary.BigEndian.Uint16(rotatedJPEG[4:]))
+	rotatedJPEG = slices.Concat(rotatedJPEG[:app1End], iccSegment, rotatedJPEG[app1End:])
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "rotated.jpg", Content: rotatedJPEG, ModTime: sortFileTime},
+		{Path: "rotated.png", Content: pngWithExif(t, img, spec), ModTime: sortFileTime.Add(time.Hour)},
+		{Path: "a.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+	})
+	opts := pkg.Options{AutoRotate: true}
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "rotated.jpg"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of the rotated image error = %v", err)
+	}
+	if width, height, err := pkg.GetImageResolution(outcome.TargetPath); err != nil || width != 8 || height != 16 {
+		t.Errorf("rotated target is %dx%d (error %v), want 8x16", width, height, err)
+	}
+	file, err := os.Open(outcome.TargetPath)
+	if err != nil {
+		t.Fatalf("opening the rotated target: %v", err)
+	}
+	defer file.Close()
+	x, err := exif.Decode(file)
+	if err != nil {
+		t.Fatalf("decoding the EXIF data of the rotated target: %v", err)
+	}
+	if tag, err := x.Get(exif.Orientation); err != nil || tag.String() != "1" {
+		t.Errorf("orientation of the rotated target = %v (error %v), want 1", tag, err)
+	}
+	if tag, err := x.Get(exif.Make); err != nil || tag.String() != `"Canon"` {
+		t.Errorf("make of the rotated target = %v (error %v), want the source's", tag, err)
+	}
+	if tag, err := x.Get(exif.PixelXDimension); err != nil || tag.String() != "8" {
+		t.Errorf("PixelXDimension of the rotated target = %v (error %v), want 8", tag, err)
+	}
+	if tag, err := x.Get(exif.PixelYDimension); err != nil || tag.String() != "16" {
+		t.Errorf("PixelYDimension of the rotated target = %v (error %v), want 16", tag, err)
+	}
+	content, err := os.ReadFile(outcome.TargetPath)
+	if err != nil {
+		t.Fatalf("reading the rotated target: %v", err)
+	}
+	hasICC := false
+	for offset := 2; offset+4 <= len(content) && content[offset+1] != 0xDA; { // Up to the start of scan
+		end := offset + 2 + int(binary.BigEndian.Uint16(content[offset+2:]))
+		hasICC = hasICC || bytes.Equal(content[offset:min(end, len(content))], iccSegment)
+		offset = end
+	}
+	if !hasICC {
+		t.Errorf("rotated target lost the source's ICC profile segment")
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		t.Fatalf("rewinding the rotated target: %v", err)
+	}
+	rotated, _, err := image.Decode(file)
+	if err != nil {
+		t.Fatalf("decoding the rotated target: %v", err)
+	}
+	// Turning the image clockwise brings its left side to the top.
+	if r, _, b, _ := rotated.At(4, 2).RGBA(); r < 0xC000 || b > 0x4000 {
+		t.Errorf("top of the rotated target is not red: r=%#x b=%#x", r, b)
+	}
+	if r, _, b, _ := rotated.At(4, 13).RGBA(); b < 0xC000 || r > 0x4000 {
+		t.Errorf("bottom of the rotated target is not blue: r=%#x b=%#x", r, b)
+	}
+
+	// The PNG's EXIF data is in an eXIf chunk, which PNG decoders skip.
+	outcome, err = pkg.SortFile(filepath.Join(sourceDir, "rotated.png"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of the rotated PNG error = %v", err)
+	}
+	pngTarget, err := os.ReadFile(outcome.TargetPath)
+	if err != nil {
+		t.Fatalf("reading the rotated PNG target: %v", err)
+	}
+	rotated, err = png.Decode(bytes.NewReader(pngTarget))
+	if err != nil || rotated.Bounds().Dx() != 8 || rotated.Bounds().Dy() != 16 {
+		t.Fatalf("rotated PNG target decodes as %v (error %v), want 8x16", rotated.Bounds(), err)
+	}
+	if r, _, _, _ := rotated.At(4, 2).RGBA(); r != 0xFFFF {
+		t.Errorf("top of the rotated PNG target is not red")
+	}
+	if i := bytes.Index(pngTarget, []byte("eXIf")); i < 0 || bytes.Index(pngTarget, []byte("IDAT")) < i {
+		t.Errorf("rotated PNG target has no eXIf chunk before its image data")
+	}
+
+	outcome, err = pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of the upright image error = %v", err)
+	}
+	if content, err := os.ReadFile(outcome.TargetPath); err != nil || !bytes.Equal(content, pngMinimal_2x2_A) {
+		t.Errorf("upright image was not copied unchanged (error %v)", err)
+	}
+
+	for _, invalid := range []pkg.Options{{AutoRotate: true, Move: true}, {AutoRotate: true, Hardlink: true}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Validate() accepted %+v", invalid)
+		}
+	}
+}
+
+func TestSortFile_NormalizeUnicode(t *testing.T) {
+	const nfdName, nfcName = "cafe\u0301.png", "caf\u00e9.png"
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: filepath.Join("mac", nfdName), Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: filepath.Join("linux", nfcName), Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+	})
+	wantPath := filepath.Join(targetDir, "undated", nfcName)
+
+	opts := pkg.Options{UnknownDateDir: "undated", NormalizeUnicode: true}
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "mac", nfdName), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of the NFD name error = %v", err)
+	}
+	if !outcome.Copied || outcome.TargetPath != wantPath {
+		t.Errorf("SortFile() of the NFD name = copied %v to %q, want copied to %q", outcome.Copied, outcome.TargetPath, wantPath)
+	}
+	outcome, err = pkg.SortFile(filepath.Join(sourceDir, "linux", nfcName), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() of the NFC name error = %v", err)
+	}
+	if outcome.Copied || outcome.Duplicate == nil || outcome.Duplicate.KeptFile != wantPath {
+		t.Errorf("SortFile() of the NFC name = %+v, want a duplicate of %s", outcome, wantPath)
+	}
+
+	// Without normalization the NFD name is kept as is.
+	_, otherTargetDir := setupTestDirs(t)
+	outcome, err = pkg.SortFile(filepath.Join(sourceDir, "mac", nfdName), otherTargetDir, pkg.Options{UnknownDateDir: "undated"})
+	if err != nil {
+		t.Fatalf("SortFile() without normalization error = %v", err)
+	}
+	if want := filepath.Join(otherTargetDir, "undated", nfdName); outcome.TargetPath != want {
+		t.Errorf("SortFile() without normalization target = %q, want %q", outcome.TargetPath, want)
+	}
+}
+
+func TestSortFile_PreferNewer(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	fillImage(img, color.RGBA{B: 255, A: 255})
+	older := jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2020:05:05 05:05:05"}}})
+	newer := jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2021:06:06 06:06:06"}}})
+	targetRel := filepath.Join("2023", "10", "2023-10-27-153000.jpg")
+
+	tests := []struct {
+		name        string
+		source      []byte
+		target      []byte
+		preferNewer bool
+		wantCopied  bool
+	}{
+		{"newer source replaces target", newer, older, true, true},
+		{"older source keeps target", older, newer, true, false},
+		{"without preferNewer target kept", newer, older, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir, targetDir := setupTestDirs(t)
+			createTestFiles(t, sourceDir, []fileSpec{{Path: "a.jpg", Content: tt.source, ModTime: sortFileTime}})
+			createTestFiles(t, targetDir, []fileSpec{{Path: targetRel, Content: tt.target, ModTime: sortFileTime}})
+			sourcePath, targetPath := filepath.Join(sourceDir, "a.jpg"), filepath.Join(targetDir, targetRel)
+			// Both files share a target name, as they would with a date override or a file name date.
+			opts := pkg.Options{PreferNewer: tt.preferNewer, DateOverrides: map[string]time.Time{"a.jpg": sortFileTime}}
+
+			outcome, err := pkg.SortFile(sourcePath, targetDir, opts)
+			if err != nil {
+				t.Fatalf("SortFile() error = %v", err)
+			}
+			if outcome.Copied != tt.wantCopied || outcome.Duplicate == nil {
+				t.Fatalf("SortFile() = %+v, want copied %v with a duplicate", outcome, tt.wantCopied)
+			}
+			content, _ := os.ReadFile(targetPath)
+			if tt.wantCopied {
+				if outcome.Duplicate.KeptFile != sourcePath || outcome.Duplicate.Detail != "source is newer - later EXIF date" {
+					t.Errorf("SortFile() duplicate = %+v, want the source kept as newer", outcome.Duplicate)
+				}
+				if !bytes.Equal(content, tt.source) {
+					t.Error("target was not replaced by the newer source")
+				}
+			} else if !bytes.Equal(content, tt.target) {
+				t.Error("existing target was modified")
+			}
+		})
+	}
+}
+
+func TestSortFile_NoOverwrite(t *testing.T) {
+	targetRel := filepath.Join("2023", "10", "2023-10-27-153000.gif")
+	tests := []struct {
+		name             string
+		conflictStrategy string
+		wantVersion      bool
+	}{
+		{"higher resolution duplicate discarded", "", false},
+		{"higher resolution duplicate versioned", pkg.ConflictVersion, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir, targetDir := setupTestDirs(t)
+			createTestFiles(t, targetDir, []fileSpec{{Path: targetRel, Content: scaledImage(7, 1), ModTime: sortFileTime}})
+			createTestFiles(t, sourceDir, []fileSpec{{Path: "big.gif", Content: scaledImage(7, 4), ModTime: sortFileTime}})
+			targetPath := filepath.Join(targetDir, targetRel)
+
+			outcome, err := pkg.SortFile(filepath.Join(sourceDir, "big.gif"), targetDir, pkg.Options{NoOverwrite: true, ConflictStrategy: tt.conflictStrategy})
+			if err != nil {
+				t.Fatalf("SortFile() error = %v", err)
+			}
+			if content, _ := os.ReadFile(targetPath); !bytes.Equal(content, scaledImage(7, 1)) {
+				t.Error("existing target was modified")
+			}
+			versionPath := filepath.Join(targetDir, "2023", "10", "2023-10-27-153000-1.gif")
+			if tt.wantVersion {
+				if !outcome.Copied || outcome.TargetPath != versionPath {
+					t.Errorf("SortFile() = %+v, want the source copied to %s", outcome, versionPath)
+				}
+				return
+			}
+			if outcome.Copied || outcome.Duplicate == nil || outcome.Duplicate.KeptFile != targetPath || outcome.Duplicate.Replaced {
+				t.Errorf("SortFile() = %+v, want the source discarded and the target kept", outcome)
+			}
+			if _, err := os.Stat(versionPath); !os.IsNotExist(err) {
+				t.Errorf("unexpected version %s: %v", versionPath, err)
+			}
+		})
+	}
+
+	for _, opts := range []pkg.Options{{NoOverwrite: true, ConflictStrategy: pkg.ConflictKeepSource}, {NoOverwrite: true, PreferNewer: true}} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("Validate() with NoOverwrite and conflict strategy %q, prefer newer %v: expected an error", opts.ConflictStrategy, opts.PreferNewer)
+		}
+	}
+}
+
+func TestSortFile_PreferLargerFile(t *testing.T) {
+	// The same pixels at the same resolution, stored uncompressed and compressed.
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	var large, small bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&large, img); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	if err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&small, img); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	if large.Len() <= small.Len() {
+		t.Fatalf("uncompressed PNG (%d bytes) is not larger than compressed PNG (%d bytes)", large.Len(), small.Len())
+	}
+	targetRel := filepath.Join("2023", "10", "2023-10-27-153000.png")
+
+	tests := []struct {
+		name             string
+		source, target   []byte
+		preferLargerFile bool
+		wantReplaced     bool
+	}{
+		{"larger source replaces target", large.Bytes(), small.Bytes(), true, true},
+		{"smaller source discarded", small.Bytes(), large.Bytes(), true, false},
+		{"larger source discarded without option", large.Bytes(), small.Bytes(), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir, targetDir := setupTestDirs(t)
+			createTestFiles(t, targetDir, []fileSpec{{Path: targetRel, Content: tt.target, ModTime: sortFileTime}})
+			createTestFiles(t, sourceDir, []fileSpec{{Path: "a.png", Content: tt.source, ModTime: sortFileTime}})
+			targetPath := filepath.Join(targetDir, targetRel)
+
+			outcome, err := pkg.SortFile(filepath.Join(sourceDir, "a.png"), targetDir, pkg.Options{PreferLargerFile: tt.preferLargerFile})
+			if err != nil {
+				t.Fatalf("SortFile() error = %v", err)
+			}
+			if outcome.Duplicate == nil || outcome.Duplicate.Reason != pkg.ReasonPixelHashMatch || outcome.Duplicate.Replaced != tt.wantReplaced {
+				t.Fatalf("SortFile() duplicate = %+v, want a pixel hash match with Replaced %v", outcome.Duplicate, tt.wantReplaced)
+			}
+			want := tt.target
+			if tt.wantReplaced {
+				want = tt.source
+			}
+			if content, _ := os.ReadFile(targetPath); !bytes.Equal(content, want) {
+				t.Errorf("target has %d bytes, want the %d byte file kept", len(content), len(want))
+			}
+		})
+	}
+}
+
+func TestComputeTargetPath(t *testing.T) {
+	sourceDir, _ := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{{Path: "IMG_0001.JPG", Content: pngMinimal_2x2_A, ModTime: sortFileTime}})
+	source := filepath.Join(sourceDir, "IMG_0001.JPG")
+	targetDir := filepath.Join(t.TempDir(), "not-yet-created")
+	date := time.Date(2023, 10, 27, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		opts pkg.Options
+		want string
+	}{
+		{"default layout", pkg.Options{}, filepath.Join(targetDir, "2023", "10", "2023-10-27-153000.JPG")},
+		{"flatten", pkg.Options{Flatten: true}, filepath.Join(targetDir, "2023-10-27-153000.JPG")},
+		{"custom filename format", pkg.Options{FilenameFormat: "20060102_150405"}, filepath.Join(targetDir, "2023", "10", "20231027_153000.JPG")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pkg.ComputeTargetPath(targetDir, date, source, tt.opts)
+			if err != nil || got != tt.want {
+				t.Errorf("ComputeTargetPath() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := pkg.ComputeTargetPath(targetDir, date, source, pkg.Options{Layout: "2006", Flatten: true}); err == nil {
+		t.Error("ComputeTargetPath() with conflicting layout options succeeded, expected an error")
+	}
+	if _, err := os.Stat(targetDir); !os.IsNotExist(err) {
+		t.Errorf("ComputeTargetPath() wrote to the target (stat error %v)", err)
+	}
+}
+
+func TestSortFile_PreserveSubdir(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: filepath.Join("Birthday", "a.png"), Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: filepath.Join("Birthday", "b.png"), Content: pngMinimal_2x2_B, ModTime: sortFileTime}, // Same name as a.png
+		{Path: filepath.Join("Trips", "Rome", "c.png"), Content: pngMinimal_4x4_A, ModTime: sortFileTime},
+		{Path: "top.png", Content: pngMinimal_4x4_C, ModTime: sortFileTime},
+	})
+	opts := pkg.Options{PreserveSubdir: true, SourceDirs: []string{sourceDir}, ConflictStrategy: pkg.ConflictVersion}
+	monthDir := filepath.Join(targetDir, "2023", "10")
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{filepath.Join("Birthday", "a.png"), filepath.Join(monthDir, "Birthday", "2023-10-27-153000.png")},
+		{filepath.Join("Birthday", "b.png"), filepath.Join(monthDir, "Birthday", "2023-10-27-153000-1.png")},
+		{filepath.Join("Trips", "Rome", "c.png"), filepath.Join(monthDir, "Trips", "Rome", "2023-10-27-153000.png")},
+		{"top.png", filepath.Join(monthDir, "2023-10-27-153000.png")},
+	}
+	for _, tt := range tests {
+		outcome, err := pkg.SortFile(filepath.Join(sourceDir, tt.source), targetDir, opts)
+		if err != nil {
+			t.Fatalf("SortFile(%s) error = %v", tt.source, err)
+		}
+		if !outcome.Copied || outcome.TargetPath != tt.want {
+			t.Errorf("SortFile(%s) = %+v, want the file copied to %s", tt.source, outcome, tt.want)
+		}
+	}
+}
+
+func TestSortFile_CopySidecars(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	createTestFiles(t, sourceDir, []fileSpec{
+		{Path: "IMG_0001.png", Content: pngMinimal_2x2_A, ModTime: sortFileTime},
+		{Path: "IMG_0001.xmp", Content: []byte("<x:xmpmeta/>"), ModTime: sortFileTime},
+		{Path: "IMG_0001.png.json", Content: []byte("{}"), ModTime: sortFileTime},
+		{Path: "IMG_0002.png", Content: pngMinimal_2x2_B, ModTime: sortFileTime}, // Same target name, no sidecar
+	})
+	opts := pkg.Options{CopySidecars: true, ConflictStrategy: pkg.ConflictVersion}
+	monthDir := filepath.Join(targetDir, "2023", "10")
+
+	outcome, err := pkg.SortFile(filepath.Join(sourceDir, "IMG_0001.png"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	want := []string{filepath.Join(monthDir, "2023-10-27-153000.xmp"), filepath.Join(monthDir, "2023-10-27-153000.png.json")}
+	if !outcome.Copied || !slices.Equal(outcome.Sidecars, want) {
+		t.Fatalf("SortFile() = %+v, want the image copied with sidecars %v", outcome, want)
+	}
+	if content, err := os.ReadFile(want[0]); err != nil || string(content) != "<x:xmpmeta/>" {
+		t.Errorf("sidecar target content = %q, %v", content, err)
+	}
+
+	outcome, err = pkg.SortFile(filepath.Join(sourceDir, "IMG_0002.png"), targetDir, opts)
+	if err != nil {
+		t.Fatalf("SortFile(IMG_0002.png) error = %v", err)
+	}
+	if outcome.TargetPath != filepath.Join(monthDir, "2023-10-27-153000-1.png") || len(outcome.Sidecars) != 0 {
+		t.Errorf("SortFile(IMG_0002.png) = %+v, want a -1 version without sidecars", outcome)
+	}
+	if _, err := os.Stat(filepath.Join(monthDir, "2023-10-27-153000-1.xmp")); !os.IsNotExist(err) {
+		t.Errorf("a sidecar was copied for IMG_0002.png: %v", err)
+	}
+}
+
+// TestSortFileContext_CancelStopsCopyRetries tests that a cancelled context cuts the wait for a copy
+// retry short instead of sleeping through it.
+func TestSortFileContext_CancelStopsCopyRetries(t *testing.T) {
+	sourceDir, targetDir := setupTestDirs(t)
+	// Reading a directory fails with an error that looks transient, so the copy is retried.
+	sourcePath := filepath.Join(sourceDir, "photo.png")
+	if err := os.Mkdir(sourcePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := pkg.SortFileContext(ctx, sourcePath, targetDir, pkg.Options{CopyRetries: 3, CopyRetryDelay: time.Hour})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("SortFileContext() error = nil, want the copy error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("SortFileContext() still waits for a retry after the context was cancelled")
+	}
+}
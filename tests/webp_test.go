@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/user/photo-sorter/pkg"
+)
+
+// 1x1 WebP images: a transparent lossless one and a grey lossy one.
+var (
+	webpLossless1x1, _ = base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	webpLossy1x1, _    = base64.StdEncoding.DecodeString("UklGRiIAAABXRUJQVlA4IBYAAAAwAQCdASoBAAEADsD+JaQAA3AAAAAA")
+)
+
+// TestWebP tests that .webp files are scanned, their resolution is read and they are pixel hashed,
+// and that their date falls back to the file modification time.
+func TestWebP(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	files := map[string][]byte{"lossless.webp": webpLossless1x1, "lossy.WEBP": webpLossy1x1}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time for %s: %v", name, err)
+		}
+	}
+
+	scanned, err := pkg.ScanSourceDirectory(dir, 0, false)
+	if err != nil {
+		t.Fatalf("pkg.ScanSourceDirectory() unexpected error: %v", err)
+	}
+	if len(scanned) != len(files) {
+		t.Errorf("pkg.ScanSourceDirectory() files = %v, expected both WebP files", scanned)
+	}
+
+	hashes := make(map[string]bool)
+	for name := range files {
+		path := filepath.Join(dir, name)
+		width, height, err := pkg.GetImageResolution(path)
+		if err != nil || width != 1 || height != 1 {
+			t.Errorf("GetImageResolution(%s) = %dx%d, %v, want 1x1", name, width, height, err)
+		}
+		hash, err := pkg.CalculatePixelDataHash(path)
+		if err != nil {
+			t.Errorf("CalculatePixelDataHash(%s) unexpected error: %v", name, err)
+		}
+		hashes[hash] = true
+		if _, err := pkg.GetPhotoCreationDate(path); err == nil {
+			t.Errorf("GetPhotoCreationDate(%s) succeeded, expected no EXIF date", name)
+		}
+	}
+	if len(hashes) != 2 {
+		t.Errorf("expected different pixel hashes for the two WebP images, got %d distinct", len(hashes))
+	}
+
+	targetDir := t.TempDir()
+	outcome, err := pkg.SortFile(filepath.Join(dir, "lossy.WEBP"), targetDir, pkg.Options{})
+	if err != nil {
+		t.Fatalf("SortFile() error = %v", err)
+	}
+	wantPath := filepath.Join(targetDir, "2024", "05", "2024-05-06-070809.WEBP")
+	if !outcome.Copied || outcome.TargetPath != wantPath || outcome.DateSource != "FileModTime" {
+		t.Errorf("SortFile() = %+v, want copied to %s using FileModTime", outcome, wantPath)
+	}
+}
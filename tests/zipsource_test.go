@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/user/photo-sorter/cmd/photocp/lib"
+	"github.com/user/photo-sorter/pkg"
+)
+
+type zipEntrySpec struct {
+	Name     string
+	Content  []byte
+	Modified time.Time
+}
+
+// writeTestZip builds a ZIP archive in memory from entries and writes it to dir/name.
+func writeTestZip(t *testing.T, dir, name string, entries []zipEntrySpec) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.Name, Method: zip.Deflate, Modified: entry.Modified})
+		require.NoError(t, err)
+		_, err = w.Write(entry.Content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	zipPath := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(zipPath, buf.Bytes(), 0644))
+	return zipPath
+}
+
+func TestScanZipSource(t *testing.T) {
+	modTime := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	zipPath := writeTestZip(t, t.TempDir(), "export.zip", []zipEntrySpec{
+		{Name: "Photos/b.png", Content: pngMinimal_2x2_B, Modified: modTime},
+		{Name: "a.PNG", Content: pngMinimal_2x2_A, Modified: modTime},
+		{Name: "notes.txt", Content: []byte("not an image"), Modified: modTime},
+		{Name: "Photos/", Modified: modTime},
+		{Name: "__MACOSX/Photos/._b.png", Content: []byte("resource fork"), Modified: modTime},
+		{Name: "../escape.png", Content: pngMinimal_2x2_A, Modified: modTime},
+	})
+
+	entries, err := pkg.ScanZipSource(zipPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Photos/b.png", "a.PNG"}, entries)
+	assert.True(t, pkg.IsZipSource(zipPath))
+	assert.False(t, pkg.IsZipSource(filepath.Dir(zipPath)))
+
+	_, err = pkg.ScanZipSource(filepath.Join(t.TempDir(), "missing.zip"))
+	assert.Error(t, err)
+}
+
+func TestRunApplicationLogic_ZipSource(t *testing.T) {
+	targetDir := t.TempDir()
+	entryTime := time.Date(2021, 8, 9, 10, 11, 12, 0, time.UTC)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	fillImage(img, color.RGBA{R: 200, A: 255})
+	withExif := jpegWithExif(t, img, exifSpec{Exif: []exifTag{{ID: exifTagDateTimeOriginal, Value: "2019:01:02 03:04:05"}}})
+	zipPath := writeTestZip(t, t.TempDir(), "takeout.zip", []zipEntrySpec{
+		{Name: "Takeout/IMG_0001.jpg", Content: withExif, Modified: entryTime},
+		{Name: "Takeout/IMG_0002.png", Content: pngMinimal_2x2_A, Modified: entryTime},
+		{Name: "Takeout/IMG_0002 (1).png", Content: pngMinimal_2x2_A, Modified: entryTime},
+	})
+
+	summary, err := photocp.RunApplicationLogicWithOptions(zipPath, targetDir, photocp.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.ProcessedFilesCount)
+	assert.Equal(t, 2, summary.CopiedFilesCount)
+
+	jpgPath := filepath.Join(targetDir, "2019", "01", "2019-01-02-030405.jpg")
+	copied, err := os.ReadFile(jpgPath)
+	require.NoError(t, err, "The EXIF date is read from the archive entry")
+	assert.Equal(t, withExif, copied)
+	assert.FileExists(t, filepath.Join(targetDir, "2021", "08", "2021-08-09-101112.png"), "Entries without EXIF use their modification time in the archive")
+
+	require.Len(t, summary.Duplicates, 1)
+	assert.Equal(t, filepath.Join(zipPath, "Takeout", "IMG_0002.png"), summary.Duplicates[0].DiscardedFile, "Duplicates are reported by their path in the archive")
+	report, err := os.ReadFile(filepath.Join(targetDir, pkg.ReportFileName))
+	require.NoError(t, err)
+	assert.Contains(t, string(report), filepath.Join(zipPath, "Takeout", "IMG_0002.png"))
+}